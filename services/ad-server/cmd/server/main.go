@@ -9,52 +9,115 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
 	"github.com/fanwu/ad-server/internal/handlers"
+	"github.com/fanwu/ad-server/internal/metrics"
 	"github.com/fanwu/ad-server/internal/redis"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	// Load configuration
-	port := getEnv("PORT", "8080")
-	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
-	redisPassword := getEnv("REDIS_PASSWORD", "")
-
-	// Initialize Redis client
-	redisClient, err := redis.NewClient(redisAddr, redisPassword)
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+
+	// Initialize Redis client. Connectivity is established in the background
+	// so probes can see why the server isn't ready instead of a crash loop.
+	// With REDIS_SENTINEL_ADDRS set, the primary is discovered through
+	// Sentinel (master REDIS_MASTER_NAME) and REDIS_ADDR is ignored; an
+	// optional read replica keeps campaign reads serving through a primary
+	// failover.
+	redisClient := redis.NewFromConfig(cfg)
 	defer redisClient.Close()
 
+	healthHandler := handlers.NewHealthHandler(redisClient)
+	redisCtx, stopRedisRetry := context.WithCancel(context.Background())
+	defer stopRedisRetry()
+	go healthHandler.WaitForRedis(redisCtx, 2*time.Second)
+
 	// Initialize Gin router
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	// Client IPs (for rate limits and logs) only come from X-Forwarded-For
+	// sent by a trusted proxy, so clients can't pick their own
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 	router.Use(gin.Recovery())
 	router.Use(loggerMiddleware())
 
 	// Initialize handlers
-	adHandler := handlers.NewAdHandler(redisClient)
-
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"service": "ad-server",
-			"timestamp": time.Now().Unix(),
-		})
-	})
-
-	// Ad serving endpoints
+	adHandler := handlers.NewAdHandler(redisClient, cfg)
+
+	// Optional StatsD/DogStatsD export of request counters and timers
+	if cfg.StatsDAddr != "" {
+		statsd, err := metrics.NewStatsD(cfg.StatsDAddr, cfg.StatsDPrefix, cfg.StatsDDogStatsD)
+		if err != nil {
+			log.Printf("StatsD disabled: %v", err)
+		} else {
+			defer statsd.Close()
+			adHandler.SetMetrics(statsd)
+		}
+	}
+
+	// Health check endpoints
+	router.GET("/health", healthHandler.HandleHealth)
+	router.GET("/readyz", healthHandler.HandleReady)
+	router.GET("/livez", healthHandler.HandleLive)
+
+	// API endpoints, only once Redis is reachable
 	v1 := router.Group("/api/v1")
+	v1.Use(healthHandler.RequireReady())
+
+	// Ad serving endpoints, throttled per client IP
+	api := v1.Group("", adHandler.RateLimit())
+	{
+		api.POST("/ad-request", adHandler.HandleAdRequest)
+		api.GET("/ad-request", adHandler.HandleAdRequest)
+		api.POST("/ad-pod", adHandler.HandleAdPod)
+		api.POST("/creative-error", adHandler.HandleCreativeError)
+	}
+
+	// Tracking beacons are billable and often share an IP, so they have
+	// their own per-IP limit
+	tracking := v1.Group("", adHandler.TrackingRateLimit())
+	{
+		tracking.POST("/impression", adHandler.HandleImpression)
+		tracking.POST("/click", adHandler.HandleClick)
+		tracking.POST("/track-event", adHandler.HandleTrackEvent)
+	}
+
+	// Admin endpoints: the operator key sees every tenant, tenant keys only
+	// their own campaigns and creatives
+	admin := api.Group("/admin")
+	admin.Use(handlers.RequireTenantKey(cfg.AdminAPIKeys, handlers.ParseTenantKeys(cfg.TenantAPIKeys)))
 	{
-		v1.POST("/ad-request", adHandler.HandleAdRequest)
-		v1.POST("/impression", adHandler.HandleImpression)
+		admin.POST("/campaigns", adHandler.HandleCreateCampaign)
+		admin.GET("/campaigns/:id", adHandler.HandleGetCampaign)
+		admin.PUT("/campaigns/:id", adHandler.HandleUpdateCampaign)
+		admin.DELETE("/campaigns/:id", adHandler.HandleDeleteCampaign)
+		admin.GET("/campaigns/:id/pacing", adHandler.HandleCampaignPacing)
+		admin.GET("/campaigns/:id/stats", adHandler.HandleCampaignStats)
+		admin.POST("/creatives", adHandler.HandleCreateCreative)
+		admin.GET("/creatives/:id", adHandler.HandleGetCreative)
+		admin.PUT("/creatives/:id", adHandler.HandleUpdateCreative)
+		admin.DELETE("/creatives/:id", adHandler.HandleDeleteCreative)
+		admin.GET("/creatives/:id/stats", adHandler.HandleCreativeStats)
+		admin.POST("/preview", adHandler.HandlePreview)
+		admin.GET("/latency", adHandler.HandleLatency)
+		admin.GET("/devices/:id/history", adHandler.HandleDeviceHistory)
 	}
 
+	// Background maintenance
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	adHandler.StartBackgroundJobs(jobsCtx)
+
 	// Create HTTP server
 	srv := &http.Server{
-		Addr:         ":" + port,
+		Addr:         ":" + cfg.Port,
 		Handler:      router,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -63,7 +126,7 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("🚀 Ad Server starting on port %s", port)
+		log.Printf("🚀 Ad Server starting on port %s", cfg.Port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
@@ -77,21 +140,18 @@ func main() {
 	log.Println("Shutting down server...")
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
-	log.Println("Server exited")
-}
+	// Finish queued impression work and persist buffered spend before exiting
+	stopJobs()
+	adHandler.Drain()
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+	log.Println("Server exited")
 }
 
 func loggerMiddleware() gin.HandlerFunc {
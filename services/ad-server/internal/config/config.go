@@ -0,0 +1,211 @@
+// Package config loads the server's settings from the environment once at
+// startup, so the rest of the code receives them explicitly instead of
+// reading environment variables on its own.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server's settings. The connection settings have typed
+// fields; the many tuning knobs of ad selection are read by name through
+// String, Bool, Int, Float and List from the same snapshot of the environment.
+type Config struct {
+	Port string
+
+	// Redis connection. With RedisSentinelAddrs set, the primary is
+	// discovered through Sentinel (master RedisMasterName) and RedisAddr is
+	// ignored.
+	RedisAddr            string
+	RedisPassword        string
+	RedisSentinelAddrs   []string
+	RedisMasterName      string
+	RedisReplicaAddr     string
+	RedisReplicaPassword string
+	RedisDialTimeout     time.Duration
+	RedisReadTimeout     time.Duration
+	RedisWriteTimeout    time.Duration
+
+	// API gateway impressions and tracking events are forwarded to
+	APIGatewayURL  string
+	GatewayTimeout time.Duration
+
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration
+
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For is believed when
+	// resolving client IPs; with none, the peer address is the client
+	TrustedProxies []string
+
+	StatsDAddr      string
+	StatsDPrefix    string
+	StatsDDogStatsD bool
+
+	// Operator keys for the admin endpoints: ADMIN_API_KEYS plus the single
+	// ADMIN_API_KEY, so a new key can be rolled out before the old one is
+	// retired
+	AdminAPIKeys  []string
+	TenantAPIKeys string
+
+	env map[string]string
+}
+
+// Load reads the configuration from the process environment
+func Load() (*Config, error) {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+	return LoadFrom(env)
+}
+
+// LoadFrom reads the configuration from a map of environment variables,
+// applying defaults for unset ones, and validates it
+func LoadFrom(env map[string]string) (*Config, error) {
+	cfg := &Config{env: env}
+
+	cfg.Port = cfg.String("PORT", "8080")
+	cfg.RedisAddr = cfg.String("REDIS_ADDR", "localhost:6379")
+	cfg.RedisPassword = cfg.Get("REDIS_PASSWORD")
+	cfg.RedisSentinelAddrs = cfg.List("REDIS_SENTINEL_ADDRS", nil)
+	cfg.RedisMasterName = cfg.String("REDIS_MASTER_NAME", "mymaster")
+	cfg.RedisReplicaAddr = cfg.Get("REDIS_REPLICA_ADDR")
+	cfg.RedisReplicaPassword = cfg.String("REDIS_REPLICA_PASSWORD", cfg.RedisPassword)
+	cfg.APIGatewayURL = cfg.String("API_GATEWAY_URL", "http://localhost:3000")
+	cfg.StatsDAddr = cfg.Get("STATSD_ADDR")
+	cfg.StatsDPrefix = cfg.String("STATSD_PREFIX", "ad_server")
+	cfg.StatsDDogStatsD = cfg.Get("STATSD_DOGSTATSD") == "true"
+	cfg.AdminAPIKeys = cfg.List("ADMIN_API_KEYS", nil)
+	if key := strings.TrimSpace(cfg.Get("ADMIN_API_KEY")); key != "" {
+		cfg.AdminAPIKeys = append(cfg.AdminAPIKeys, key)
+	}
+	cfg.TenantAPIKeys = cfg.Get("TENANT_API_KEYS")
+	cfg.TrustedProxies = cfg.List("TRUSTED_PROXIES", nil)
+
+	var err error
+	durations := []struct {
+		field        *time.Duration
+		key          string
+		defaultValue int
+		unit         time.Duration
+	}{
+		{&cfg.RedisDialTimeout, "REDIS_DIAL_TIMEOUT_MS", 5000, time.Millisecond},
+		{&cfg.RedisReadTimeout, "REDIS_READ_TIMEOUT_MS", 3000, time.Millisecond},
+		{&cfg.RedisWriteTimeout, "REDIS_WRITE_TIMEOUT_MS", 3000, time.Millisecond},
+		{&cfg.GatewayTimeout, "GATEWAY_TIMEOUT_MS", 5000, time.Millisecond},
+		{&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT_SECONDS", 5, time.Second},
+	}
+	for _, d := range durations {
+		if *d.field, err = cfg.duration(d.key, d.defaultValue, d.unit); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the typed settings
+func (c *Config) Validate() error {
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid PORT %q: must be a number from 1 to 65535", c.Port)
+	}
+	if strings.TrimSpace(c.RedisAddr) == "" && len(c.RedisSentinelAddrs) == 0 {
+		return fmt.Errorf("REDIS_ADDR or REDIS_SENTINEL_ADDRS must be set")
+	}
+	if u, err := url.Parse(c.APIGatewayURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid API_GATEWAY_URL %q: must be an http or https URL", c.APIGatewayURL)
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES entry %q: must be an IP or CIDR", proxy)
+		}
+	}
+	return nil
+}
+
+// duration parses a positive whole number of units, rejecting invalid values
+// rather than silently using the default
+func (c *Config) duration(key string, defaultValue int, unit time.Duration) (time.Duration, error) {
+	value := c.Get(key)
+	if value == "" {
+		return time.Duration(defaultValue) * unit, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", key, value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Get returns the raw value of an environment variable, empty when unset
+func (c *Config) Get(key string) string {
+	return c.env[key]
+}
+
+// String returns the value of an environment variable or a default
+func (c *Config) String(key, defaultValue string) string {
+	if value := c.Get(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Bool parses a boolean environment variable ("true", "1", "yes")
+func (c *Config) Bool(key string, defaultValue bool) bool {
+	value := strings.ToLower(strings.TrimSpace(c.Get(key)))
+	switch value {
+	case "":
+		return defaultValue
+	case "true", "1", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// Int parses a positive integer environment variable, falling back to the
+// default when unset or invalid
+func (c *Config) Int(key string, defaultValue int) int {
+	value, err := strconv.Atoi(c.Get(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// Float parses a non-negative float environment variable, falling back to
+// the default when unset or invalid
+func (c *Config) Float(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(c.Get(key), 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// List parses a comma-separated environment variable into a list, trimming
+// whitespace and dropping empty entries
+func (c *Config) List(key string, defaultValue []string) []string {
+	value := c.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadFrom_Defaults(t *testing.T) {
+	cfg, err := LoadFrom(map[string]string{})
+	if err != nil {
+		t.Fatalf("Expected defaults to be valid, got %v", err)
+	}
+
+	if cfg.Port != "8080" {
+		t.Errorf("Expected port 8080, got %s", cfg.Port)
+	}
+	if cfg.RedisAddr != "localhost:6379" || cfg.RedisPassword != "" {
+		t.Errorf("Unexpected Redis settings: %s %q", cfg.RedisAddr, cfg.RedisPassword)
+	}
+	if cfg.RedisSentinelAddrs != nil || cfg.RedisMasterName != "mymaster" || cfg.RedisReplicaAddr != "" {
+		t.Errorf("Unexpected Sentinel or replica settings: %+v", cfg)
+	}
+	if cfg.APIGatewayURL != "http://localhost:3000" {
+		t.Errorf("Expected default gateway URL, got %s", cfg.APIGatewayURL)
+	}
+	if cfg.RedisDialTimeout != 5*time.Second || cfg.RedisReadTimeout != 3*time.Second || cfg.RedisWriteTimeout != 3*time.Second {
+		t.Errorf("Unexpected Redis timeouts: %v %v %v", cfg.RedisDialTimeout, cfg.RedisReadTimeout, cfg.RedisWriteTimeout)
+	}
+	if cfg.GatewayTimeout != 5*time.Second || cfg.ShutdownTimeout != 5*time.Second {
+		t.Errorf("Unexpected gateway or shutdown timeout: %v %v", cfg.GatewayTimeout, cfg.ShutdownTimeout)
+	}
+	if cfg.StatsDAddr != "" || cfg.StatsDPrefix != "ad_server" || cfg.StatsDDogStatsD {
+		t.Errorf("Unexpected StatsD settings: %+v", cfg)
+	}
+	if len(cfg.AdminAPIKeys) != 0 {
+		t.Errorf("Expected no admin keys, got %v", cfg.AdminAPIKeys)
+	}
+	if cfg.TrustedProxies != nil {
+		t.Errorf("Expected no trusted proxies, got %v", cfg.TrustedProxies)
+	}
+}
+
+func TestLoadFrom_Overrides(t *testing.T) {
+	cfg, err := LoadFrom(map[string]string{
+		"PORT":                  "9090",
+		"REDIS_PASSWORD":        "secret",
+		"REDIS_SENTINEL_ADDRS":  "s1:26379, s2:26379,",
+		"REDIS_REPLICA_ADDR":    "replica:6379",
+		"API_GATEWAY_URL":       "https://gateway.example.com",
+		"GATEWAY_TIMEOUT_MS":    "750",
+		"REDIS_READ_TIMEOUT_MS": "250",
+		"STATSD_DOGSTATSD":      "true",
+		"MAX_POD_ADS":           "3",
+		"BOT_FILTER_ENABLED":    "yes",
+		"ADMIN_API_KEYS":        "new-key, other-key",
+		"ADMIN_API_KEY":         "old-key",
+		"TRUSTED_PROXIES":       "10.0.0.0/8, 192.0.2.10",
+	})
+	if err != nil {
+		t.Fatalf("Expected overrides to be valid, got %v", err)
+	}
+
+	if cfg.Port != "9090" {
+		t.Errorf("Expected port 9090, got %s", cfg.Port)
+	}
+	if want := []string{"s1:26379", "s2:26379"}; !reflect.DeepEqual(cfg.RedisSentinelAddrs, want) {
+		t.Errorf("Expected sentinel addrs %v, got %v", want, cfg.RedisSentinelAddrs)
+	}
+	// The replica shares the primary's password unless given its own
+	if cfg.RedisReplicaAddr != "replica:6379" || cfg.RedisReplicaPassword != "secret" {
+		t.Errorf("Unexpected replica settings: %s %q", cfg.RedisReplicaAddr, cfg.RedisReplicaPassword)
+	}
+	if cfg.APIGatewayURL != "https://gateway.example.com" || cfg.GatewayTimeout != 750*time.Millisecond {
+		t.Errorf("Unexpected gateway settings: %s %v", cfg.APIGatewayURL, cfg.GatewayTimeout)
+	}
+	if cfg.RedisReadTimeout != 250*time.Millisecond || cfg.RedisWriteTimeout != 3*time.Second {
+		t.Errorf("Unexpected Redis timeouts: %v %v", cfg.RedisReadTimeout, cfg.RedisWriteTimeout)
+	}
+	if !cfg.StatsDDogStatsD {
+		t.Error("Expected DogStatsD to be enabled")
+	}
+	if want := []string{"new-key", "other-key", "old-key"}; !reflect.DeepEqual(cfg.AdminAPIKeys, want) {
+		t.Errorf("Expected admin keys %v, got %v", want, cfg.AdminAPIKeys)
+	}
+
+	if want := []string{"10.0.0.0/8", "192.0.2.10"}; !reflect.DeepEqual(cfg.TrustedProxies, want) {
+		t.Errorf("Expected trusted proxies %v, got %v", want, cfg.TrustedProxies)
+	}
+
+	// Untyped settings are read from the same snapshot
+	if got := cfg.Int("MAX_POD_ADS", 5); got != 3 {
+		t.Errorf("Expected MAX_POD_ADS 3, got %d", got)
+	}
+	if !cfg.Bool("BOT_FILTER_ENABLED", false) {
+		t.Error("Expected BOT_FILTER_ENABLED to be true")
+	}
+	if got := cfg.Float("LOSS_SAMPLE_RATE", 0.5); got != 0.5 {
+		t.Errorf("Expected unset float to default, got %v", got)
+	}
+}
+
+func TestLoadFrom_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"non-numeric port", map[string]string{"PORT": "http"}},
+		{"port out of range", map[string]string{"PORT": "70000"}},
+		{"empty Redis address", map[string]string{"REDIS_ADDR": " "}},
+		{"gateway without scheme", map[string]string{"API_GATEWAY_URL": "gateway:3000"}},
+		{"gateway with other scheme", map[string]string{"API_GATEWAY_URL": "ftp://gateway"}},
+		{"non-numeric timeout", map[string]string{"GATEWAY_TIMEOUT_MS": "5s"}},
+		{"zero timeout", map[string]string{"REDIS_DIAL_TIMEOUT_MS": "0"}},
+		{"trusted proxy hostname", map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,lb.internal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadFrom(tt.env); err == nil {
+				t.Errorf("Expected %v to be rejected", tt.env)
+			}
+		})
+	}
+}
@@ -1,32 +1,118 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
+	"github.com/fanwu/ad-server/internal/metrics"
 	"github.com/fanwu/ad-server/internal/models"
 	"github.com/fanwu/ad-server/internal/redis"
+	"github.com/fanwu/ad-server/internal/render"
 	"github.com/fanwu/ad-server/internal/services"
+	"github.com/fanwu/ad-server/internal/tracing"
+	"github.com/fanwu/ad-server/internal/vast"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type AdHandler struct {
 	adService *services.AdService
+
+	// Answer VAST no-fills with an empty <VAST> document (200) instead of 204
+	vastEmptyNoFill bool
+
+	// Answer JSON no-fills with a 200 {"filled": false} envelope instead of
+	// 204, for clients that can't handle 204 (also ?nofill_as_200=true)
+	noFillAs200 bool
+
+	// Expose how each ad was chosen in X-Campaign-Weight,
+	// X-Creative-Rotation-Mode and X-Selection-Strategy headers
+	debugHeaders bool
+
+	tracer  tracing.Tracer
+	metrics metrics.Metrics
+
+	// Recent selection latencies, for the admin latency endpoint
+	latency *metrics.LatencyWindow
 }
 
-func NewAdHandler(redisClient *redis.Client) *AdHandler {
+// defaultLatencyWindowSize is how many recent selection latencies are kept
+const defaultLatencyWindowSize = 1000
+
+// NewAdHandler creates the handler and its ad service with settings from cfg
+func NewAdHandler(redisClient *redis.Client, cfg *config.Config) *AdHandler {
 	return &AdHandler{
-		adService: services.NewAdService(redisClient),
+		adService:       services.NewAdService(redisClient, cfg),
+		vastEmptyNoFill: cfg.Get("VAST_EMPTY_NOFILL") != "false",
+		debugHeaders:    cfg.Get("DEBUG_HEADERS") == "true",
+		noFillAs200:     cfg.Get("NOFILL_AS_200") == "true",
+		tracer:          tracing.Noop(),
+		metrics:         metrics.Noop(),
+		latency:         metrics.NewLatencyWindow(cfg.Int("LATENCY_WINDOW_SIZE", defaultLatencyWindowSize)),
+	}
+}
+
+// SetTracer sets the tracer ad-request spans are recorded with
+func (h *AdHandler) SetTracer(tracer tracing.Tracer) {
+	h.tracer = tracer
+}
+
+// SetMetrics sets the backend request counters and timers are recorded with
+func (h *AdHandler) SetMetrics(m metrics.Metrics) {
+	h.metrics = m
+}
+
+// StartBackgroundJobs starts periodic maintenance (tombstone reaping, spend
+// flushing) until ctx is cancelled
+func (h *AdHandler) StartBackgroundJobs(ctx context.Context) {
+	h.adService.StartTombstoneReaper(ctx, 10*time.Minute)
+	h.adService.StartActiveCampaignReconciler(ctx, 5*time.Minute)
+	h.adService.StartSpendFlusher(ctx)
+}
+
+// Drain finishes queued impression work and flushes buffered state to Redis;
+// call after the HTTP server has stopped accepting requests
+func (h *AdHandler) Drain() {
+	h.adService.DrainImpressions()
+	if err := h.adService.FlushSpend(context.Background()); err != nil {
+		log.Printf("Failed to flush campaign spend on shutdown: %v", err)
+	}
+}
+
+// setDebugHeaders summarizes the selection decision in response headers, a
+// lighter alternative to ?transparency=true for live debugging
+func setDebugHeaders(c *gin.Context, decision *models.Decision) {
+	if decision == nil {
+		return
 	}
+	c.Header("X-Campaign-Weight", strconv.FormatFloat(decision.SelectionWeight, 'f', -1, 64))
+	c.Header("X-Creative-Rotation-Mode", decision.RotationMode)
+	c.Header("X-Selection-Strategy", decision.Strategy)
 }
 
-// HandleAdRequest handles POST /api/v1/ad-request
+// HandleAdRequest handles POST /api/v1/ad-request, and GET for SDKs that
+// can only issue GETs (see bindAdRequest)
 func (h *AdHandler) HandleAdRequest(c *gin.Context) {
 	start := time.Now()
 
+	ctx, span := h.tracer.Start(c.Request.Context(), "ad-request")
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	// One line per request, whatever the outcome, is the canonical serving log
 	var req models.AdRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	serving := newServingLog(c, start, &req)
+	defer func() { serving.write(c.Writer.Status()) }()
+
+	if err := bindAdRequest(c, &req); err != nil {
+		serving.noFill("invalid_request", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request",
 			"details": err.Error(),
@@ -34,31 +120,313 @@ func (h *AdHandler) HandleAdRequest(c *gin.Context) {
 		return
 	}
 
-	// Add IP address from request
-	req.IPAddress = c.ClientIP()
+	if !h.admitRequest(c, &req) {
+		return
+	}
+
+	// A retry carrying the same Idempotency-Key gets the ad originally
+	// selected for it rather than a second selection
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey != "" {
+		if cached, ok := h.adService.CachedAdResponse(c.Request.Context(), req.DeviceID, idempotencyKey); ok {
+			span.SetAttribute("ad.idempotent_replay", true)
+			h.metrics.Count("ad_requests.replayed", 1)
+			serving.served(cached, true)
+			h.renderAd(c, req.Platform, cached)
+			return
+		}
+	}
 
 	// Select ad
-	adResponse, err := h.adService.SelectAd(&req)
+	selectStart := time.Now()
+	adResponse, err := h.adService.SelectAd(c.Request.Context(), &req)
+	h.latency.Record(time.Since(selectStart))
 	if err != nil {
-		log.Printf("Failed to select ad: %v", err)
-		c.JSON(http.StatusNoContent, gin.H{
-			"error": "No ads available",
-		})
+		serving.noFill(services.NoFillReason(err), err)
+		span.SetAttribute("ad.filled", false)
+		span.SetAttribute("ad.no_fill_reason", services.NoFillReason(err))
+		span.SetAttribute("ad.latency_ms", time.Since(start).Milliseconds())
+		h.metrics.Count("ad_requests", 1, "filled:false", "reason:"+services.NoFillReason(err))
+		h.metrics.Timing("ad_request.latency", time.Since(start), "filled:false")
+
+		// A Redis outage is a server error, not a lack of inventory
+		if errors.Is(err, services.ErrBackendUnavailable) {
+			respondBackendUnavailable(c)
+			return
+		}
+
+		// Ask clients on a no-fill streak to back off progressively
+		retryAfter := h.adService.RecordNoFill(c.Request.Context(), req.DeviceID)
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+
+		if h.vastEmptyNoFill && wantsVAST(c) {
+			h.respondVAST(c, vast.Empty())
+			return
+		}
+		h.respondNoFill(c, services.NoFillReason(err))
 		return
 	}
 
-	// Log response time
-	elapsed := time.Since(start)
-	log.Printf("Ad request served in %v - Campaign: %s, Creative: %s",
-		elapsed, adResponse.CampaignID, adResponse.CreativeID)
+	go h.adService.ResetNoFills(context.WithoutCancel(c.Request.Context()), req.DeviceID)
+
+	// Record the decision on the span so traces are queryable by it
+	span.SetAttribute("ad.filled", true)
+	span.SetAttribute("ad.campaign_id", adResponse.CampaignID)
+	span.SetAttribute("ad.creative_id", adResponse.CreativeID)
+	if decision := adResponse.Decision; decision != nil {
+		span.SetAttribute("ad.eligible_count", decision.EligibleCount)
+		span.SetAttribute("ad.strategy", decision.Strategy)
+		span.SetAttribute("ad.creative_strategy", decision.CreativeStrategy)
+	}
+	span.SetAttribute("ad.latency_ms", time.Since(start).Milliseconds())
+
+	if idempotencyKey != "" {
+		h.adService.CacheAdResponse(c.Request.Context(), req.DeviceID, idempotencyKey, adResponse)
+	}
+
+	// House fills are tagged apart so fill rate isn't inflated by them
+	fillTags := []string{"filled:true"}
+	if adResponse.CampaignID == services.HouseCampaignID {
+		fillTags = append(fillTags, "house:true")
+	}
+	h.metrics.Count("ad_requests", 1, fillTags...)
+	h.metrics.Timing("ad_request.latency", time.Since(start), fillTags...)
+	serving.served(adResponse, false)
+
+	h.renderAd(c, req.Platform, adResponse)
+}
+
+// renderAd writes a filled ad in the request platform's format, or else as
+// VAST, the minimal profile or full JSON, as the client asked
+func (h *AdHandler) renderAd(c *gin.Context, platform string, adResponse *models.AdResponse) {
+	if h.debugHeaders {
+		setDebugHeaders(c, adResponse.Decision)
+	}
+
+	// The decision audit is only returned when explicitly requested
+	if c.Query("transparency") != "true" {
+		adResponse.Decision = nil
+	}
+
+	// A platform hint picks a registered renderer (json-v1 when unknown)
+	if platform != "" {
+		renderer := render.For(platform)
+		body, err := renderer.Render(adResponse)
+		if err != nil {
+			log.Printf("Failed to render ad for platform %s: %v", platform, err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, renderer.ContentType(), body)
+		return
+	}
 
+	if wantsVAST(c) {
+		h.respondVAST(c, vast.FromAdResponse(adResponse))
+		return
+	}
+	if c.Query("profile") == "minimal" {
+		c.JSON(http.StatusOK, adResponse.Minimal())
+		return
+	}
 	c.JSON(http.StatusOK, adResponse)
 }
 
+// bindAdRequest reads the ad request from the JSON body, or from the query
+// string for GET requests, and applies the model's binding rules to both
+func bindAdRequest(c *gin.Context, req *models.AdRequest) error {
+	if c.Request.Method == http.MethodGet {
+		if err := adRequestFromQuery(c, req); err != nil {
+			return err
+		}
+		return binding.Validator.ValidateStruct(req)
+	}
+	return c.ShouldBindJSON(req)
+}
+
+// adRequestFromQuery maps query parameters onto an AdRequest using the JSON
+// field names. deal_ids is comma-separated and context entries are passed as
+// context[key]=value.
+func adRequestFromQuery(c *gin.Context, req *models.AdRequest) error {
+	req.DeviceID = c.Query("device_id")
+	if req.DeviceID == "" {
+		return errors.New("device_id is required")
+	}
+
+	req.DeviceType = c.Query("device_type")
+	req.AppID = c.Query("app_id")
+	req.PlacementID = c.Query("placement_id")
+	req.Platform = c.Query("platform")
+	req.UserAgent = c.Query("user_agent")
+	req.IPAddress = c.Query("ip_address")
+	req.PreferredFormat = c.Query("preferred_format")
+	req.HouseholdID = c.Query("household_id")
+	req.LocationCountry = c.Query("location_country")
+
+	if contextParams := c.QueryMap("context"); len(contextParams) > 0 {
+		req.Context = contextParams
+	}
+	for _, id := range strings.Split(c.Query("deal_ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			req.DealIDs = append(req.DealIDs, id)
+		}
+	}
+	for _, id := range strings.Split(c.Query("exclude_creatives"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			req.ExcludeCreatives = append(req.ExcludeCreatives, id)
+		}
+	}
+	if value := c.Query("sound_on"); value != "" {
+		soundOn, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("sound_on must be a boolean: %w", err)
+		}
+		req.SoundOn = &soundOn
+	}
+	if value := c.Query("max_duration"); value != "" {
+		maxDuration, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("max_duration must be a whole number of seconds: %w", err)
+		}
+		req.MaxDuration = maxDuration
+	}
+	return nil
+}
+
+// admitRequest validates the device, fills in the client IP and user agent,
+// and enforces rate limits. It writes the error response and returns false
+// if the request should not be served.
+func (h *AdHandler) admitRequest(c *gin.Context, req *models.AdRequest) bool {
+	// Reject placeholder device IDs that would waste budget and pollute reach
+	if err := h.adService.ValidateDeviceID(req.DeviceID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request",
+			"details": err.Error(),
+		})
+		return false
+	}
+
+	// Add IP address from request
+	req.IPAddress = c.ClientIP()
+
+	// Enforce per-device and per-IP request limits
+	if err := h.adService.CheckRateLimit(c.Request.Context(), req.DeviceID, req.IPAddress); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many requests",
+			"details": err.Error(),
+		})
+		return false
+	}
+
+	// Fall back to the User-Agent header when the client didn't send one
+	if req.UserAgent == "" {
+		req.UserAgent = c.Request.UserAgent()
+	}
+	return true
+}
+
+// HandleAdPod handles POST /api/v1/ad-pod
+func (h *AdHandler) HandleAdPod(c *gin.Context) {
+	start := time.Now()
+
+	var req models.AdPodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Out-of-range pods are rejected before any selection work
+	if _, err := h.adService.ValidatePod(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.admitRequest(c, &req.AdRequest) {
+		return
+	}
+
+	pod, err := h.adService.SelectAdPod(c.Request.Context(), &req)
+	if err != nil || len(pod.Ads) == 0 {
+		log.Printf("Failed to fill ad pod: %v", err)
+		h.metrics.Count("ad_pods", 1, "filled:false")
+		h.metrics.Timing("ad_pod.latency", time.Since(start), "filled:false")
+		if errors.Is(err, services.ErrBackendUnavailable) {
+			respondBackendUnavailable(c)
+			return
+		}
+		h.respondNoFill(c, services.NoFillReason(err))
+		return
+	}
+
+	// The decision audit is only returned when explicitly requested
+	if c.Query("transparency") != "true" {
+		for i := range pod.Ads {
+			pod.Ads[i].Decision = nil
+		}
+	}
+
+	h.metrics.Count("ad_pods", 1, "filled:true")
+	h.metrics.Count("ad_pod.ads", int64(len(pod.Ads)))
+	h.metrics.Timing("ad_pod.latency", time.Since(start), "filled:true")
+	c.JSON(http.StatusOK, pod)
+}
+
+// respondNoFill answers a JSON no-fill: 204 by default, or a 200 envelope
+// naming the reason when configured or asked for with ?nofill_as_200=true
+func (h *AdHandler) respondNoFill(c *gin.Context, reason string) {
+	if h.noFillAs200 || c.Query("nofill_as_200") == "true" {
+		c.JSON(http.StatusOK, models.NoFillResponse{Filled: false, Reason: reason})
+		return
+	}
+	// A 204 must not carry a body
+	c.Status(http.StatusNoContent)
+}
+
+// respondBackendUnavailable answers a request that failed because Redis
+// couldn't be read with a 503, so clients and load balancers can tell an
+// outage from a no-fill
+func respondBackendUnavailable(c *gin.Context) {
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": "Ad backend unavailable",
+		"dependencies": gin.H{
+			"redis": "unreachable",
+		},
+	})
+}
+
+// wantsVAST reports whether the client asked for a VAST XML response via
+// ?format=vast or an XML Accept header
+func wantsVAST(c *gin.Context) bool {
+	if strings.EqualFold(c.Query("format"), "vast") {
+		return true
+	}
+	accept := c.GetHeader("Accept")
+	return strings.Contains(accept, "application/xml") || strings.Contains(accept, "text/xml")
+}
+
+// respondVAST writes a VAST document with a 200 status
+func (h *AdHandler) respondVAST(c *gin.Context, doc *vast.VAST) {
+	body, err := vast.Marshal(doc)
+	if err != nil {
+		log.Printf("Failed to marshal VAST: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, vast.ContentType, body)
+}
+
 // HandleImpression handles POST /api/v1/impression
 func (h *AdHandler) HandleImpression(c *gin.Context) {
+	// Decode the body as JSON regardless of Content-Type: navigator.sendBeacon
+	// posts JSON as text/plain (or with no content type at all)
 	var req models.ImpressionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request",
 			"details": err.Error(),
@@ -72,7 +440,8 @@ func (h *AdHandler) HandleImpression(c *gin.Context) {
 	}
 
 	// Track impression
-	if err := h.adService.TrackImpression(&req); err != nil {
+	result, err := h.adService.TrackImpression(c.Request.Context(), &req)
+	if err != nil {
 		log.Printf("Failed to track impression: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to track impression",
@@ -80,8 +449,146 @@ func (h *AdHandler) HandleImpression(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	if !result.Duplicate {
+		h.metrics.Count("impressions", 1)
+	}
+	if result.Dropped {
+		h.metrics.Count("impressions.dropped", 1)
+	}
+	h.reportGatewayBreaker()
+
+	response := gin.H{
 		"status": "success",
 		"message": "Impression tracked",
+	}
+	if result.DurationCheck != nil {
+		response["duration_check"] = result.DurationCheck
+	}
+	if result.MakeGood {
+		response["make_good"] = true
+	}
+	if result.Duplicate {
+		response["duplicate"] = true
+	}
+	if result.Sequence > 0 {
+		response["sequence"] = result.Sequence
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// reportGatewayBreaker publishes whether tracking forwards to the API Gateway
+// are being short-circuited (1) or not (0)
+func (h *AdHandler) reportGatewayBreaker() {
+	open := 0.0
+	if h.adService.GatewayBreakerOpen() {
+		open = 1
+	}
+	h.metrics.Gauge("gateway.breaker_open", open)
+}
+
+// HandleClick handles POST /api/v1/click
+func (h *AdHandler) HandleClick(c *gin.Context) {
+	var req models.ClickRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if req.Timestamp.IsZero() {
+		req.Timestamp = time.Now()
+	}
+
+	if err := h.adService.TrackClick(c.Request.Context(), &req); err != nil {
+		log.Printf("Failed to track click: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to track click",
+		})
+		return
+	}
+
+	h.metrics.Count("clicks", 1)
+	h.reportGatewayBreaker()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Click tracked",
+	})
+}
+
+// HandleTrackEvent handles POST /api/v1/track-event
+func (h *AdHandler) HandleTrackEvent(c *gin.Context) {
+	var req models.TrackEventRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.adService.TrackEvent(c.Request.Context(), &req); err != nil {
+		if errors.Is(err, services.ErrInvalidEvent) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid event",
+				"details": err.Error(),
+			})
+			return
+		}
+		log.Printf("Failed to track event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to track event",
+		})
+		return
+	}
+
+	h.metrics.Count("events", 1, "event:"+req.Event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Event tracked",
+	})
+}
+
+// HandleCreativeError handles POST /api/v1/creative-error
+func (h *AdHandler) HandleCreativeError(c *gin.Context) {
+	var req models.CreativeErrorRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Reports are counted per client IP, so one client can't quarantine a
+	// creative on its own
+	quarantined, err := h.adService.ReportCreativeError(c.Request.Context(), req.CreativeID, c.ClientIP(), req.ErrorCode)
+	switch {
+	case errors.Is(err, redis.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Creative not found",
+		})
+		return
+	case errors.Is(err, services.ErrRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":   "Too many requests",
+			"details": err.Error(),
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to record error for creative %s: %v", req.CreativeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to report creative error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"message":     "Creative error recorded",
+		"quarantined": quarantined,
 	})
 }
@@ -2,19 +2,47 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
+	"github.com/fanwu/ad-server/internal/metrics"
 	"github.com/fanwu/ad-server/internal/models"
 	"github.com/fanwu/ad-server/internal/redis"
+	"github.com/fanwu/ad-server/internal/services"
+	"github.com/fanwu/ad-server/internal/tracing"
+	"github.com/fanwu/ad-server/internal/vast"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// ctx is used for Redis and service calls in tests
+var ctx = context.Background()
+
+// testConfig loads the configuration from the environment, so tests can
+// change settings with t.Setenv before building a service
+func testConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
 // setupTestRedis creates a real Redis connection for testing
 func setupTestRedis(t *testing.T) *redis.Client {
 	redisURL := os.Getenv("REDIS_TEST_URL")
@@ -22,7 +50,9 @@ func setupTestRedis(t *testing.T) *redis.Client {
 		redisURL = "localhost:6380" // Test Redis on port 6380
 	}
 
-	client, err := redis.NewClient(redisURL)
+	cfg := testConfig()
+	cfg.RedisAddr = redisURL
+	client, err := redis.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -50,7 +80,7 @@ func seedTestData(t *testing.T, redisClient *redis.Client) (string, string) {
 		"end_date":     endDate,
 	}
 
-	if err := redisClient.SetCampaign(campaignID, campaignData); err != nil {
+	if err := redisClient.SetCampaign(ctx, campaignID, campaignData); err != nil {
 		t.Fatalf("Failed to set campaign: %v", err)
 	}
 
@@ -63,14 +93,17 @@ func seedTestData(t *testing.T, redisClient *redis.Client) (string, string) {
 		"duration":    "30",
 		"format":      "mp4",
 		"status":      "active",
+		"click_url":   "https://advertiser.example.com/landing",
+		"title":       "Test Creative Title",
+		"advertiser":  "Test Advertiser",
 	}
 
-	if err := redisClient.SetCreative(creativeID, campaignID, creativeData); err != nil {
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, creativeData); err != nil {
 		t.Fatalf("Failed to set creative: %v", err)
 	}
 
 	// Add to active campaigns sorted set
-	if err := redisClient.AddActiveCampaign(campaignID, 9000.0); err != nil {
+	if err := redisClient.AddActiveCampaign(ctx, campaignID, 9000.0); err != nil {
 		t.Fatalf("Failed to add active campaign: %v", err)
 	}
 
@@ -79,9 +112,9 @@ func seedTestData(t *testing.T, redisClient *redis.Client) (string, string) {
 
 // cleanupTestData removes test data from Redis
 func cleanupTestData(t *testing.T, redisClient *redis.Client, campaignID, creativeID string) {
-	redisClient.DeleteCampaign(campaignID)
-	redisClient.DeleteCreative(creativeID, campaignID)
-	redisClient.RemoveActiveCampaign(campaignID)
+	redisClient.DeleteCampaign(ctx, campaignID)
+	redisClient.DeleteCreative(ctx, creativeID, campaignID)
+	redisClient.RemoveActiveCampaign(ctx, campaignID)
 }
 
 func TestHandleAdRequest_Integration(t *testing.T) {
@@ -98,7 +131,7 @@ func TestHandleAdRequest_Integration(t *testing.T) {
 	campaignID, creativeID := seedTestData(t, redisClient)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	handler := NewAdHandler(redisClient)
+	handler := NewAdHandler(redisClient, testConfig())
 
 	// Create test request
 	reqBody := models.AdRequest{
@@ -145,82 +178,319 @@ func TestHandleAdRequest_Integration(t *testing.T) {
 	if response.Format != "mp4" {
 		t.Errorf("Expected format mp4, got %s", response.Format)
 	}
+	if response.ClickURL != "https://advertiser.example.com/landing" {
+		t.Errorf("Expected click_url https://advertiser.example.com/landing, got %s", response.ClickURL)
+	}
+	if response.Title != "Test Creative Title" || response.Advertiser != "Test Advertiser" {
+		t.Errorf("Expected title and advertiser from the creative, got %q %q", response.Title, response.Advertiser)
+	}
 }
 
-func TestHandleAdRequest_NoActiveCampaigns(t *testing.T) {
+func TestHandleAdRequest_ServingLog(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test in short mode")
 	}
 
 	gin.SetMode(gin.TestMode)
 
-	// Setup with empty Redis
+	// Setup
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	handler := NewAdHandler(redisClient)
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	// Create test request
-	reqBody := models.AdRequest{
-		DeviceID:   "device-123",
-		DeviceType: "ctv",
-		AppID:      "app-456",
+	handler := NewAdHandler(redisClient, testConfig())
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	var w *httptest.ResponseRecorder
+	lines := captureServingLog(t, func() {
+		body, _ := json.Marshal(models.AdRequest{DeviceID: "device-123", DeviceType: "ctv", AppID: "app-456"})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if len(lines) != 1 {
+		t.Fatalf("Expected one serving log line, got %d: %q", len(lines), lines)
 	}
 
+	// The decision is logged even though the response omits it
+	for _, want := range []string{
+		"request_id=" + w.Header().Get("X-Request-ID"), "status=200", "filled=true",
+		"campaign_id=" + campaignID, "creative_id=" + creativeID,
+		"eligible=", "strategy=", "latency_ms=", "device_id=device-123", "app_id=app-456",
+	} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("Expected %q in %s", want, lines[0])
+		}
+	}
+}
+
+func TestHandleAdRequest_Transparency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	reqBody := models.AdRequest{
+		DeviceID:        "device-123",
+		DeviceType:      "ctv",
+		AppID:           "app-456",
+		PreferredFormat: "mp4",
+	}
 	body, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
 
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request?transparency=true", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var response models.AdResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response.Decision == nil {
+		t.Fatal("Expected decision object with ?transparency=true")
+	}
+	if response.Decision.Strategy != "weighted_random" {
+		t.Errorf("Expected strategy weighted_random, got %s", response.Decision.Strategy)
+	}
+	if response.Decision.EligibleCount != 1 {
+		t.Errorf("Expected eligible_count 1, got %d", response.Decision.EligibleCount)
+	}
+	if response.Decision.SelectionWeight != 1 {
+		t.Errorf("Expected selection_weight 1, got %v", response.Decision.SelectionWeight)
+	}
+	if response.Decision.CreativeStrategy != "preferred_format" {
+		t.Errorf("Expected creative_strategy preferred_format, got %s", response.Decision.CreativeStrategy)
+	}
+
+	// Without the flag the decision is omitted
+	req, _ = http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if strings.Contains(w.Body.String(), `"decision"`) {
+		t.Errorf("Expected no decision without ?transparency=true, got: %s", w.Body.String())
+	}
+}
+
+func TestHandleAdRequest_MinimalProfile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
 	router := gin.New()
 	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
-	router.ServeHTTP(w, req)
 
-	// Should return 204 No Content when no ads available
-	if w.Code != http.StatusNoContent {
-		t.Errorf("Expected status 204, got %d", w.Code)
+	body, _ := json.Marshal(models.AdRequest{DeviceID: "device-123", DeviceType: "ctv"})
+	omitted := []string{"campaign_id", "creative_id", "format", "click_url", "timestamp"}
+	kept := []string{"ad_id", "video_url", "duration", "tracking_url"}
+
+	fields := func(url string) map[string]json.RawMessage {
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+		var response map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response
+	}
+
+	minimal := fields("/api/v1/ad-request?profile=minimal")
+	if len(minimal) != len(kept) {
+		t.Errorf("Expected only %v in the minimal profile, got %d fields", kept, len(minimal))
+	}
+	for _, field := range kept {
+		if _, ok := minimal[field]; !ok {
+			t.Errorf("Expected %s in the minimal profile", field)
+		}
+	}
+	for _, field := range omitted {
+		if _, ok := minimal[field]; ok {
+			t.Errorf("Expected %s omitted from the minimal profile", field)
+		}
+	}
+
+	full := fields("/api/v1/ad-request")
+	for _, field := range append(kept, omitted...) {
+		if _, ok := full[field]; !ok {
+			t.Errorf("Expected %s in the default profile", field)
+		}
 	}
 }
 
-func TestHandleAdRequest_MissingFields(t *testing.T) {
+func TestHandleAdRequest_DebugHeaders(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
 	gin.SetMode(gin.TestMode)
 
+	// Setup
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	handler := NewAdHandler(redisClient)
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"rotation_mode": "sequential"})
+
+	body, _ := json.Marshal(models.AdRequest{DeviceID: "device-123", DeviceType: "ctv"})
+	serve := func(handler *AdHandler) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
 
-	// Create request with missing required fields
-	reqBody := map[string]interface{}{
-		"device_id": "device-123",
-		// Missing device_type and app_id
+	// Off by default
+	w := serve(NewAdHandler(redisClient, testConfig()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Selection-Strategy"); got != "" {
+		t.Errorf("Expected no debug headers without DEBUG_HEADERS, got X-Selection-Strategy %q", got)
 	}
 
-	body, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	t.Setenv("DEBUG_HEADERS", "true")
+	w = serve(NewAdHandler(redisClient, testConfig()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	expected := map[string]string{
+		"X-Campaign-Weight":        "1",
+		"X-Creative-Rotation-Mode": "sequential",
+		"X-Selection-Strategy":     "weighted_random",
+	}
+	for header, want := range expected {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("Expected %s %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestHandleAdRequest_SpanAttributes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+	t.Setenv("BOT_FILTER_ENABLED", "true")
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+	recorder := tracing.NewRecorder()
+	handler.SetTracer(recorder)
 
-	w := httptest.NewRecorder()
 	router := gin.New()
 	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
-	router.ServeHTTP(w, req)
 
-	// The Gin binding validation happens inside the handler
-	// If validation fails, it returns 400
-	// If validation passes but no ads available, it returns 204
-	// Since we're not using `binding:"required"` tags in AdRequest model,
-	// empty fields are allowed and it returns 204 (no ads)
-	if w.Code != http.StatusBadRequest && w.Code != http.StatusNoContent {
-		t.Errorf("Expected status 400 or 204, got %d", w.Code)
+	send := func(userAgent string) int {
+		body, _ := json.Marshal(models.AdRequest{DeviceID: "device-123", UserAgent: userAgent})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Served request
+	if code := send("Roku/DVP-9.10"); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	spans := recorder.Spans()
+	if len(spans) != 1 || spans[0].Name != "ad-request" {
+		t.Fatalf("Expected one ad-request span, got %+v", spans)
+	}
+	served := spans[0].Attributes
+	if served["ad.filled"] != true {
+		t.Errorf("Expected ad.filled true, got %v", served["ad.filled"])
+	}
+	for _, key := range []string{"ad.campaign_id", "ad.creative_id", "ad.eligible_count", "ad.strategy", "ad.latency_ms"} {
+		if _, ok := served[key]; !ok {
+			t.Errorf("Expected served span attribute %s, got %v", key, served)
+		}
+	}
+	if served["ad.strategy"] != "weighted_random" {
+		t.Errorf("Expected ad.strategy weighted_random, got %v", served["ad.strategy"])
+	}
+
+	// No-fill (bot traffic)
+	send("curl/8.4.0")
+	spans = recorder.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a second span, got %d", len(spans))
+	}
+	noFill := spans[1].Attributes
+	if noFill["ad.filled"] != false || noFill["ad.no_fill_reason"] != "invalid_traffic" {
+		t.Errorf("Expected invalid_traffic no-fill attributes, got %v", noFill)
+	}
+	if _, ok := noFill["ad.campaign_id"]; ok {
+		t.Errorf("Expected no campaign on a no-fill span, got %v", noFill)
 	}
 }
 
-func TestHandleImpression_Integration(t *testing.T) {
+func TestHandleAdRequest_NoFillRetryAfter(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test in short mode")
 	}
 
 	gin.SetMode(gin.TestMode)
+	t.Setenv("BOT_FILTER_ENABLED", "true")
 
 	// Setup
 	redisClient := setupTestRedis(t)
@@ -229,99 +499,2240 @@ func TestHandleImpression_Integration(t *testing.T) {
 	campaignID, creativeID := seedTestData(t, redisClient)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	handler := NewAdHandler(redisClient)
+	handler := NewAdHandler(redisClient, testConfig())
 
-	// Create impression request
-	reqBody := models.ImpressionRequest{
-		AdID:       uuid.New().String(),
-		CampaignID: campaignID,
-		CreativeID: creativeID,
-		DeviceID:   "device-123",
-		DeviceType: "ctv",
-		Timestamp:  time.Now(),
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	deviceID := uuid.New().String()
+	send := func(userAgent string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.AdRequest{DeviceID: deviceID, UserAgent: userAgent})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
 	}
 
-	body, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	// Bot user agents always no-fill; the delay doubles each time
+	for _, want := range []string{"1", "2", "4"} {
+		w := send("curl/8.4.0")
+		if got := w.Header().Get("Retry-After"); got != want {
+			t.Errorf("Expected Retry-After %s, got %q", want, got)
+		}
+	}
 
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.POST("/api/v1/impression", handler.HandleImpression)
-	router.ServeHTTP(w, req)
+	// A fill resets the streak
+	if w := send("Roku/DVP-9.10"); w.Code != http.StatusOK {
+		t.Fatalf("Expected a fill, got %d", w.Code)
+	}
+	if w := send("Roku/DVP-9.10"); w.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After on a fill, got %q", w.Header().Get("Retry-After"))
+	}
+	time.Sleep(50 * time.Millisecond) // Reset is async
 
-	// Assertions
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	if got := send("curl/8.4.0").Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After to reset to 1 after a fill, got %q", got)
 	}
+}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
-		t.Fatalf("Failed to parse response: %v", err)
+func TestHandleAdRequest_NoFillEnvelope(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
 	}
 
-	if response["status"] != "success" {
-		t.Errorf("Expected status 'success', got '%v'", response["status"])
+	gin.SetMode(gin.TestMode)
+	t.Setenv("BOT_FILTER_ENABLED", "true")
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	send := func(handler *AdHandler, url string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+		// A bot user agent always no-fills
+		body, _ := json.Marshal(models.AdRequest{DeviceID: uuid.New().String(), UserAgent: "curl/8.4.0"})
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assertEnvelope := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var response models.NoFillResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if response.Filled || response.Reason != "invalid_traffic" {
+			t.Errorf("Expected filled false with reason invalid_traffic, got %+v", response)
+		}
 	}
+
+	handler := NewAdHandler(redisClient, testConfig())
+	if w := send(handler, "/api/v1/ad-request"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 by default, got %d", w.Code)
+	}
+	assertEnvelope(send(handler, "/api/v1/ad-request?nofill_as_200=true"))
+
+	t.Setenv("NOFILL_AS_200", "true")
+	assertEnvelope(send(NewAdHandler(redisClient, testConfig()), "/api/v1/ad-request"))
 }
 
-func TestHandleImpression_MissingFields(t *testing.T) {
+func TestHandleAdRequest_NoActiveCampaigns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
 	gin.SetMode(gin.TestMode)
 
+	// Setup with empty Redis
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	handler := NewAdHandler(redisClient)
+	handler := NewAdHandler(redisClient, testConfig())
 
-	reqBody := map[string]interface{}{
-		"ad_id": "ad-123",
-		// Missing required fields
+	// Create test request
+	reqBody := models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
 	}
 
 	body, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
 	w := httptest.NewRecorder()
 	router := gin.New()
-	router.POST("/api/v1/impression", handler.HandleImpression)
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
 	router.ServeHTTP(w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status 400, got %d", w.Code)
+	// Should return 204 No Content, with no body, when no ads available
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204 body, got %q", w.Body.String())
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "" && cl != "0" {
+		t.Errorf("Expected zero Content-Length, got %s", cl)
 	}
 }
 
-func TestHealthCheck(t *testing.T) {
+func TestHandleAdRequest_RedisDownIs503(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
+	// Nothing listens on port 1, so every Redis call fails
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	handler := NewAdHandler(client, testConfig())
 
 	router := gin.New()
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"service": "ad-server",
-		})
-	})
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+	router.POST("/api/v1/ad-pod", handler.HandleAdPod)
+
+	for path, body := range map[string]string{
+		"/api/v1/ad-request": `{"device_id": "device-123"}`,
+		"/api/v1/ad-pod":     `{"device_id": "device-123", "pod_duration": 60}`,
+	} {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status 503 while Redis is down, got %d. Body: %s", path, w.Code, w.Body.String())
+			continue
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		deps, _ := response["dependencies"].(map[string]interface{})
+		if deps["redis"] != "unreachable" {
+			t.Errorf("%s: expected redis dependency unreachable, got %v", path, response)
+		}
+	}
+}
 
-	router.ServeHTTP(w, req)
+func TestRenderAd_Platform(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &AdHandler{}
+
+	tests := []struct {
+		platform    string
+		contentType string
+	}{
+		{"vast-4", vast.ContentType},
+		{"json-v1", "application/json; charset=utf-8"},
+		{"unknown-tv", "application/json; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/v1/ad-request", nil)
+
+		handler.renderAd(c, tt.platform, &models.AdResponse{AdID: "ad-1", Duration: 30})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", tt.platform, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tt.platform, tt.contentType, got)
+		}
+	}
+}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+func TestHandleCreateCampaign_Invalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &AdHandler{adService: services.NewAdService(redis.New("127.0.0.1:1"), testConfig())}
+
+	for name, body := range map[string]string{
+		"malformed":     `{"name":`,
+		"missing name":  `{"status":"active","budget_total":100,"start_date":"2025-10-01T00:00:00Z","end_date":"2025-11-01T00:00:00Z"}`,
+		"bad status":    `{"name":"c","status":"deleted","budget_total":100,"start_date":"2025-10-01T00:00:00Z","end_date":"2025-11-01T00:00:00Z"}`,
+		"ends too soon": `{"name":"c","status":"active","budget_total":100,"start_date":"2025-10-01T00:00:00Z","end_date":"2025-09-01T00:00:00Z"}`,
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/v1/admin/campaigns", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		handler.HandleCreateCampaign(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
 	}
+}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
-		t.Errorf("Failed to parse response: %v", err)
+// captureServingLog runs fn with the standard logger redirected and returns
+// the ad_request lines it wrote
+func captureServingLog(t *testing.T, fn func()) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	fn()
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "ad_request ") {
+			lines = append(lines, line)
+		}
 	}
+	return lines
+}
 
-	if response["status"] != "ok" {
-		t.Errorf("Expected status 'ok', got '%v'", response["status"])
+func TestServingLog_Format(t *testing.T) {
+	req := &models.AdRequest{DeviceID: "device-123", DeviceType: "ctv", AppID: "app-456", LocationCountry: "US"}
+
+	served := &servingLog{requestID: "req-1", req: req}
+	served.served(&models.AdResponse{
+		CampaignID: "campaign-1",
+		CreativeID: "creative-1",
+		Decision:   &models.Decision{Strategy: "weighted", EligibleCount: 3, CreativeStrategy: "random"},
+	}, false)
+	line := served.format(http.StatusOK, 1500*time.Microsecond)
+	for _, want := range []string{
+		"ad_request request_id=req-1 status=200 filled=true",
+		"campaign_id=campaign-1", "creative_id=creative-1",
+		"eligible=3", "strategy=weighted", "creative_strategy=random",
+		"latency_ms=1.500", "device_id=device-123", "device_type=ctv", "app_id=app-456", "country=US",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in %s", want, line)
+		}
+	}
+	if strings.Contains(line, "no_fill_reason") || strings.Contains(line, "replayed") {
+		t.Errorf("Expected no no-fill or replay fields on a fresh fill, got %s", line)
+	}
+	if strings.Contains(line, "house") {
+		t.Errorf("Expected no house field on a campaign fill, got %s", line)
 	}
 
-	if response["service"] != "ad-server" {
-		t.Errorf("Expected service 'ad-server', got '%v'", response["service"])
+	house := &servingLog{requestID: "req-3", req: req}
+	house.served(&models.AdResponse{CampaignID: services.HouseCampaignID, CreativeID: services.HouseCampaignID}, false)
+	if line := house.format(http.StatusOK, time.Millisecond); !strings.Contains(line, "filled=true campaign_id=house creative_id=house house=true") {
+		t.Errorf("Expected a house fill to be tagged, got %s", line)
+	}
+
+	unfilled := &servingLog{requestID: "req-2", req: req}
+	unfilled.noFill("no_eligible_campaigns", services.ErrNoEligibleCampaigns)
+	line = unfilled.format(http.StatusNoContent, time.Millisecond)
+	for _, want := range []string{
+		"status=204 filled=false", "no_fill_reason=no_eligible_campaigns",
+		`error="no eligible campaigns found"`, "device_id=device-123",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in %s", want, line)
+		}
+	}
+	if strings.Contains(line, "campaign_id") {
+		t.Errorf("Expected no campaign on a no-fill, got %s", line)
+	}
+}
+
+func TestHandleAdRequest_ServingLogRedisDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Nothing listens on port 1, so selection fails as a no-fill
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	handler := NewAdHandler(client, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	var w *httptest.ResponseRecorder
+	lines := captureServingLog(t, func() {
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBufferString(`{"device_id": "device-123", "device_type": "ctv"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", "req-123")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	})
+
+	if len(lines) != 1 {
+		t.Fatalf("Expected one serving log line, got %d: %q", len(lines), lines)
+	}
+	for _, want := range []string{
+		"request_id=req-123", "status=503", "filled=false",
+		"no_fill_reason=backend_unavailable", "latency_ms=", "device_id=device-123", "device_type=ctv",
+	} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("Expected %q in %s", want, lines[0])
+		}
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Errorf("Expected the request ID echoed, got %q", got)
+	}
+}
+
+func TestHandleCreateCreative_UnknownFormatRedisDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The format is rejected before Redis is touched
+	handler := &AdHandler{adService: services.NewAdService(redis.New("127.0.0.1:1"), testConfig())}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"campaign_id":"campaign-1","video_url":"https://example.com/a.flv","duration":30,"format":"flv","status":"active"}`
+	c.Request, _ = http.NewRequest("POST", "/api/v1/admin/creatives", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleCreateCreative(c)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not supported") {
+		t.Errorf("Expected 400 for an unsupported format, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleAdRequest_DeviceTypeRedisDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Binding rejects bad device types before Redis is touched; valid ones
+	// get as far as selection, which fails with Redis down
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	handler := NewAdHandler(client, testConfig())
+
+	router := gin.New()
+	router.GET("/api/v1/ad-request", handler.HandleAdRequest)
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+	router.POST("/api/v1/ad-pod", handler.HandleAdPod)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/api/v1/ad-request", `{"device_id": "device-123", "device_type": "roku"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/ad-request", `{"device_id": "device-123", "device_type": "CTV "}`, http.StatusBadRequest},
+		{"GET", "/api/v1/ad-request?device_id=device-123&device_type=roku", "", http.StatusBadRequest},
+		{"POST", "/api/v1/ad-pod", `{"device_id": "device-123", "device_type": "roku", "pod_duration": 60}`, http.StatusBadRequest},
+		{"POST", "/api/v1/ad-request", `{"device_id": "device-123", "device_type": "ctv"}`, http.StatusServiceUnavailable},
+		{"POST", "/api/v1/ad-request", `{"device_id": "device-123"}`, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s %s %s: expected %d, got %d: %s", tt.method, tt.path, tt.body, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleAdRequest_GETMatchesPOSTRedisDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Pixel-style GETs answer like the JSON POST: a missing device_id is a
+	// 400 before Redis is touched, anything else reaches selection
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	handler := NewAdHandler(client, testConfig())
+
+	router := gin.New()
+	router.GET("/api/v1/ad-request", handler.HandleAdRequest)
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	serve := func(method, path, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	postCode, postBody := serve("POST", "/api/v1/ad-request", `{"device_type": "ctv", "app_id": "app-456"}`)
+	getCode, getBody := serve("GET", "/api/v1/ad-request?device_type=ctv&app_id=app-456", "")
+	if postCode != http.StatusBadRequest || getCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without device_id from POST and GET, got %d and %d", postCode, getCode)
+	}
+	if postBody["error"] != getBody["error"] {
+		t.Errorf("Expected the same error from POST and GET, got %v and %v", postBody["error"], getBody["error"])
+	}
+
+	postCode, _ = serve("POST", "/api/v1/ad-request", `{"device_id": "device-123", "device_type": "ctv", "app_id": "app-456"}`)
+	getCode, _ = serve("GET", "/api/v1/ad-request?device_id=device-123&device_type=ctv&app_id=app-456", "")
+	if postCode != http.StatusServiceUnavailable || getCode != postCode {
+		t.Errorf("Expected GET and POST to reach selection alike, got %d and %d", getCode, postCode)
+	}
+}
+
+func TestRateLimit_RedisDownFailsOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("API_RATE_LIMIT", "1")
+
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	handler := NewAdHandler(client, testConfig())
+
+	router := gin.New()
+	router.Use(handler.RateLimit())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected counter errors to let requests through, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestHandleAdRequest_HouseAd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup with empty Redis, so no campaign fills
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	send := func(handler *AdHandler) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+		body, _ := json.Marshal(models.AdRequest{DeviceID: uuid.New().String(), DeviceType: "ctv"})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Setenv("HOUSE_AD_VIDEO_URL", "https://cdn.example.com/house.mp4")
+	w := send(NewAdHandler(redisClient, testConfig()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the house ad with status 200, got %d", w.Code)
+	}
+	var response models.AdResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.CampaignID != services.HouseCampaignID || response.VideoURL != "https://cdn.example.com/house.mp4" {
+		t.Errorf("Expected the house ad, got %+v", response)
+	}
+	if response.Duration != 30 || response.Format != "mp4" {
+		t.Errorf("Expected default house ad duration and format, got %d %s", response.Duration, response.Format)
+	}
+
+	// Disabling the fallback restores the 204
+	t.Setenv("HOUSE_AD_ENABLED", "false")
+	if w := send(NewAdHandler(redisClient, testConfig())); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 with the house ad disabled, got %d", w.Code)
+	}
+}
+
+func TestRespondNoFill(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := &AdHandler{}
+	router := gin.New()
+	router.GET("/nofill", func(c *gin.Context) { handler.respondNoFill(c, "no_eligible_campaigns") })
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/nofill")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength > 0 || len(body) != 0 {
+		t.Errorf("Expected no body, got Content-Length %d and %q", resp.ContentLength, body)
+	}
+
+	// The opt-in envelope still carries a JSON body
+	resp, err = http.Get(server.URL + "/nofill?nofill_as_200=true")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var noFill models.NoFillResponse
+	if err := json.NewDecoder(resp.Body).Decode(&noFill); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a 200 no-fill envelope, got %d (err %v)", resp.StatusCode, err)
+	}
+	if noFill.Filled || noFill.Reason != "no_eligible_campaigns" {
+		t.Errorf("Unexpected envelope: %+v", noFill)
+	}
+}
+
+func TestHandleAdRequest_GETMatchesPOST(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// Same device, app and time bucket draw the same ad
+	t.Setenv("DETERMINISTIC_SELECTION", "true")
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+	router.GET("/api/v1/ad-request", handler.HandleAdRequest)
+
+	serve := func(req *http.Request) models.AdResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d. Body: %s", req.Method, w.Code, w.Body.String())
+		}
+		var response models.AdResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response
+	}
+
+	body, _ := json.Marshal(models.AdRequest{DeviceID: "device-get", DeviceType: "ctv", AppID: "app-456"})
+	postReq, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+	postReq.Header.Set("Content-Type", "application/json")
+	posted := serve(postReq)
+
+	getReq, _ := http.NewRequest("GET", "/api/v1/ad-request?device_id=device-get&device_type=ctv&app_id=app-456", nil)
+	got := serve(getReq)
+	if got.AdID == "" || got.VideoURL == "" || got.TrackingURL == "" {
+		t.Errorf("Expected a filled ad from GET, got %+v", got)
+	}
+
+	if got.CampaignID != posted.CampaignID || got.CreativeID != posted.CreativeID {
+		t.Errorf("Expected GET to select %s/%s like POST, got %s/%s",
+			posted.CampaignID, posted.CreativeID, got.CampaignID, got.CreativeID)
+	}
+}
+
+func TestHandleAdRequest_IdempotencyKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	deviceID := "device-" + uuid.New().String()
+	serve := func(key string) string {
+		body, _ := json.Marshal(models.AdRequest{DeviceID: deviceID, DeviceType: "ctv"})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	key := uuid.New().String()
+	first := serve(key)
+	if retried := serve(key); retried != first {
+		t.Errorf("Expected a retry with the same key to replay the response\nfirst: %s\nretry: %s", first, retried)
+	}
+
+	// A new key, or no key, is a new selection with its own ad ID
+	if other := serve(uuid.New().String()); other == first {
+		t.Error("Expected a different idempotency key to select afresh")
+	}
+	if unkeyed := serve(""); unkeyed == first {
+		t.Error("Expected a request without a key to select afresh")
+	}
+}
+
+func TestAdRequestFromQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	bind := func(query string) (models.AdRequest, error) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/api/v1/ad-request?"+query, nil)
+
+		var req models.AdRequest
+		err := bindAdRequest(c, &req)
+		return req, err
+	}
+
+	req, err := bind("device_id=device-123&device_type=ctv&app_id=app-456&preferred_format=webm" +
+		"&deal_ids=deal-a,deal-b&sound_on=false&context[genre]=news&location_country=US")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if req.DeviceID != "device-123" || req.DeviceType != "ctv" || req.AppID != "app-456" || req.PreferredFormat != "webm" {
+		t.Errorf("Unexpected request fields: %+v", req)
+	}
+	if req.LocationCountry != "US" {
+		t.Errorf("Expected location_country US, got %q", req.LocationCountry)
+	}
+	if len(req.DealIDs) != 2 || req.DealIDs[0] != "deal-a" || req.DealIDs[1] != "deal-b" {
+		t.Errorf("Expected deal_ids [deal-a deal-b], got %v", req.DealIDs)
+	}
+	if !req.Muted() {
+		t.Error("Expected sound_on=false to mark the request muted")
+	}
+	if req.Context["genre"] != "news" {
+		t.Errorf("Expected context genre news, got %v", req.Context)
+	}
+
+	if _, err := bind("device_type=ctv"); err == nil {
+		t.Error("Expected an error without device_id")
+	}
+	if _, err := bind("device_id=device-123&sound_on=maybe"); err == nil {
+		t.Error("Expected an error for a non-boolean sound_on")
+	}
+
+	req, err = bind("device_id=device-123&max_duration=15")
+	if err != nil || req.MaxDuration != 15 {
+		t.Errorf("Expected max_duration 15, got %d (%v)", req.MaxDuration, err)
+	}
+	for _, query := range []string{"device_id=device-123&max_duration=15s", "device_id=device-123&max_duration=-1"} {
+		if _, err := bind(query); err == nil {
+			t.Errorf("Expected an error for %s", query)
+		}
+	}
+
+	req, err = bind("device_id=device-123&exclude_creatives=creative-a,%20creative-b")
+	if err != nil || len(req.ExcludeCreatives) != 2 || req.ExcludeCreatives[1] != "creative-b" {
+		t.Errorf("Expected exclude_creatives [creative-a creative-b], got %v (%v)", req.ExcludeCreatives, err)
+	}
+}
+
+func TestHandleAdRequest_VAST(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	body, _ := json.Marshal(models.AdRequest{DeviceID: "device-123", DeviceType: "ctv"})
+
+	for _, accept := range []string{"", "application/xml"} {
+		url := "/api/v1/ad-request"
+		if accept == "" {
+			url += "?format=vast"
+		}
+		req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != vast.ContentType {
+			t.Errorf("Expected Content-Type %s, got %s", vast.ContentType, ct)
+		}
+
+		var doc vast.VAST
+		if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Failed to parse VAST: %v. Body: %s", err, w.Body.String())
+		}
+		if len(doc.Ads) != 1 || len(doc.Ads[0].InLine.Creatives) == 0 {
+			t.Fatalf("Expected one inline ad, got: %s", w.Body.String())
+		}
+		linear := doc.Ads[0].InLine.Creatives[0].Linear
+		if linear == nil || linear.Duration != "00:00:30" || linear.MediaFiles[0].URL != "https://example.com/test-video.mp4" {
+			t.Errorf("Expected the seeded 30s creative, got: %s", w.Body.String())
+		}
+	}
+
+	// JSON remains the default
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response models.AdResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON response by default: %v", err)
+	}
+}
+
+func TestHandleAdRequest_VASTNoFill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup with empty Redis
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	reqBody := models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request?format=vast", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+	router.ServeHTTP(w, req)
+
+	// VAST no-fill is an empty <VAST> document, not a 204
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != vast.ContentType {
+		t.Errorf("Expected Content-Type %s, got %s", vast.ContentType, ct)
+	}
+
+	var doc vast.VAST
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v. Body: %s", err, w.Body.String())
+	}
+
+	if doc.Version != vast.Version {
+		t.Errorf("Expected VAST version %s, got %s", vast.Version, doc.Version)
+	}
+
+	if strings.Contains(w.Body.String(), "<Ad") {
+		t.Errorf("No-fill VAST should not contain an <Ad>, got: %s", w.Body.String())
+	}
+}
+
+func TestHandleAdRequest_MissingFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	// Create request with missing required fields
+	reqBody := map[string]interface{}{
+		"device_id": "device-123",
+		// Missing device_type and app_id
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+	router.ServeHTTP(w, req)
+
+	// The Gin binding validation happens inside the handler
+	// If validation fails, it returns 400
+	// If validation passes but no ads available, it returns 204
+	// Since we're not using `binding:"required"` tags in AdRequest model,
+	// empty fields are allowed and it returns 204 (no ads)
+	if w.Code != http.StatusBadRequest && w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 400 or 204, got %d", w.Code)
+	}
+}
+
+func TestHandleAdRequest_MalformedDeviceID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("DEVICE_ID_VALIDATION", "true")
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	for _, deviceID := range []string{"null", "undefined"} {
+		body, _ := json.Marshal(models.AdRequest{
+			DeviceID:   deviceID,
+			DeviceType: "ctv",
+			AppID:      "app-456",
+		})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Device ID %q: expected status 400, got %d", deviceID, w.Code)
+		}
+	}
+
+	// A real UUID passes validation (served or no-fill, but not rejected)
+	body, _ := json.Marshal(models.AdRequest{
+		DeviceID:   uuid.New().String(),
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	})
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code == http.StatusBadRequest {
+		t.Errorf("Expected valid UUID device ID to pass validation, got 400")
+	}
+}
+
+func TestHandleAdRequest_IPRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+	t.Setenv("IP_RATE_LIMIT", "5")
+	t.Setenv("DEVICE_RATE_LIMIT", "100")
+	t.Setenv("RATE_LIMIT_WINDOW_SECONDS", "3600")
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-request", handler.HandleAdRequest)
+
+	// A fresh address per run so earlier runs' counters don't interfere
+	id := uuid.New()
+	ip := fmt.Sprintf("10.%d.%d.%d", id[0], id[1], id[2])
+
+	for i := 0; i < 8; i++ {
+		// Rotate device IDs; only the IP limit can trip
+		body, _ := json.Marshal(models.AdRequest{
+			DeviceID:   uuid.New().String(),
+			DeviceType: "ctv",
+			AppID:      "app-456",
+		})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":12345"
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if i < 5 && w.Code == http.StatusTooManyRequests {
+			t.Errorf("Request %d: expected to be under the IP limit, got 429", i+1)
+		}
+		if i >= 5 && w.Code != http.StatusTooManyRequests {
+			t.Errorf("Request %d: expected 429 from IP limit, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestRateLimit_ClientIP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+	t.Setenv("API_RATE_LIMIT", "5")
+	t.Setenv("TRACKING_RATE_LIMIT", "10")
+	t.Setenv("RATE_LIMIT_WINDOW_SECONDS", "3600")
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	cfg := testConfig()
+	handler := NewAdHandler(redisClient, cfg)
+
+	// Routed like main: serving and tracking have separate limits, /health
+	// has none, and no proxy is trusted
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		t.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	v1 := router.Group("/api/v1")
+	v1.Group("", handler.RateLimit()).POST("/ad-request", func(c *gin.Context) { c.Status(http.StatusOK) })
+	v1.Group("", handler.TrackingRateLimit()).POST("/impression", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// A fresh address per run so earlier runs' counters don't interfere
+	id := uuid.New()
+	ip := fmt.Sprintf("10.%d.%d.%d", id[0], id[1], id[2])
+	serve := func(path string) *httptest.ResponseRecorder {
+		method := "POST"
+		if path == "/health" {
+			method = "GET"
+		}
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":12345"
+		// A forged header must not give the client a fresh bucket
+		req.Header.Set("X-Forwarded-For", uuid.New().String()[:8])
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 5; i++ {
+		if w := serve("/api/v1/ad-request"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected to be under the limit, got %d", i+1, w.Code)
+		}
+	}
+
+	w := serve("/api/v1/ad-request")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request 6: expected 429, got %d", w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 3600 {
+		t.Errorf("Expected Retry-After within the window, got %q", w.Header().Get("Retry-After"))
+	}
+
+	if w := serve("/health"); w.Code != http.StatusOK {
+		t.Errorf("Expected /health not to be rate limited, got %d", w.Code)
+	}
+
+	// Tracking beacons count against their own limit
+	for i := 0; i < 10; i++ {
+		if w := serve("/api/v1/impression"); w.Code != http.StatusOK {
+			t.Fatalf("Impression %d: expected to be under the tracking limit, got %d", i+1, w.Code)
+		}
+	}
+	if w := serve("/api/v1/impression"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Impression 11: expected 429, got %d", w.Code)
+	}
+}
+
+func TestHandleAdPod_OversizedRejected(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-pod", handler.HandleAdPod)
+
+	for _, body := range []string{
+		`{"device_id":"device-123","pod_duration":10000,"max_ads":4}`,
+		`{"device_id":"device-123","pod_duration":120,"max_ads":10000}`,
+		`{"device_id":"device-123"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/v1/ad-pod", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestHandleAdPod_FitsPodDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Three campaigns with one 30s creative each, isolated by a deal
+	dealID := "deal-" + uuid.New().String()
+	for i := 0; i < 3; i++ {
+		campaignID, creativeID := seedTestData(t, redisClient)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/ad-pod", handler.HandleAdPod)
+
+	podRequest := func(podDuration int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.AdPodRequest{
+			AdRequest:   models.AdRequest{DeviceID: uuid.New().String(), DeviceType: "ctv", DealIDs: []string{dealID}},
+			PodDuration: podDuration,
+			MaxAds:      4,
+		})
+		req, _ := http.NewRequest("POST", "/api/v1/ad-pod", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 75 seconds holds two 30s ads but not a third
+	w := podRequest(75)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var pod models.AdPodResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &pod); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	sum := 0
+	campaigns := make(map[string]bool)
+	for _, ad := range pod.Ads {
+		sum += ad.Duration
+		if campaigns[ad.CampaignID] {
+			t.Errorf("Campaign %s appears twice in the pod", ad.CampaignID)
+		}
+		campaigns[ad.CampaignID] = true
+	}
+	if sum > 75 {
+		t.Errorf("Expected durations to sum to at most 75, got %d", sum)
+	}
+	if len(pod.Ads) != 2 || pod.TotalDuration != sum {
+		t.Errorf("Expected 2 ads totalling %d, got %d ads totalling %d", sum, len(pod.Ads), pod.TotalDuration)
+	}
+
+	// No 30s creative fits a 20 second break
+	if w := podRequest(20); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 when no ads fit, got %d", w.Code)
+	}
+}
+
+func TestHandleLatency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := &AdHandler{latency: metrics.NewLatencyWindow(100)}
+	for i := 1; i <= 100; i++ {
+		handler.latency.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/admin/latency", handler.HandleLatency)
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/latency", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var summary models.LatencySummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if summary.Samples != 100 || summary.WindowSize != 100 {
+		t.Errorf("Expected 100 samples in a window of 100, got %d in %d", summary.Samples, summary.WindowSize)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"p50", summary.P50Ms, 50},
+		{"p90", summary.P90Ms, 90},
+		{"p99", summary.P99Ms, 99},
+	} {
+		if math.Abs(tt.got-tt.want) > 1 {
+			t.Errorf("Expected %s ~%vms, got %vms", tt.name, tt.want, tt.got)
+		}
+	}
+}
+
+func TestHandleImpression_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	// Create impression request
+	reqBody := models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		Timestamp:  time.Now(),
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/impression", handler.HandleImpression)
+	router.ServeHTTP(w, req)
+
+	// Assertions
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response["status"] != "success" {
+		t.Errorf("Expected status 'success', got '%v'", response["status"])
+	}
+}
+
+func TestHandleImpression_BeaconContentType(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/impression", handler.HandleImpression)
+
+	// sendBeacon posts JSON as text/plain, or with no content type
+	for _, contentType := range []string{"text/plain;charset=UTF-8", ""} {
+		reqBody := models.ImpressionRequest{
+			AdID:       uuid.New().String(),
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			DeviceID:   "device-123",
+			DeviceType: "web",
+		}
+
+		body, _ := json.Marshal(reqBody)
+		req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Content-Type %q: expected status 200, got %d. Body: %s", contentType, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleImpression_Duplicate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/impression", handler.HandleImpression)
+
+	body, _ := json.Marshal(models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	})
+	post := func() map[string]interface{} {
+		req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return response
+	}
+
+	if response := post(); response["duplicate"] != nil {
+		t.Errorf("Expected the first impression not to be a duplicate, got %v", response)
+	} else if seq, _ := response["sequence"].(float64); seq < 1 {
+		t.Errorf("Expected a sequence number on the confirmation, got %v", response["sequence"])
+	}
+	if response := post(); response["duplicate"] != true {
+		t.Errorf("Expected the retry to be flagged duplicate, got %v", response)
+	}
+}
+
+func TestHandleImpression_MissingFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	reqBody := map[string]interface{}{
+		"ad_id": "ad-123",
+		// Missing required fields
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/v1/impression", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/impression", handler.HandleImpression)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleClick_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	// Create click request
+	reqBody := models.ClickRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	}
+
+	body, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/api/v1/click", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/click", handler.HandleClick)
+	router.ServeHTTP(w, req)
+
+	// Assertions
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response["status"] != "success" {
+		t.Errorf("Expected status 'success', got '%v'", response["status"])
+	}
+}
+
+func TestHandleClick_MissingFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Validation fails before the service is reached
+	handler := &AdHandler{}
+
+	body, _ := json.Marshal(map[string]interface{}{"ad_id": "ad-123"})
+	req, _ := http.NewRequest("POST", "/api/v1/click", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.POST("/api/v1/click", handler.HandleClick)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleTrackEvent_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/track-event", handler.HandleTrackEvent)
+
+	creativeID := uuid.New().String()
+	for _, event := range []string{"start", "firstQuartile", "midpoint", "thirdQuartile", "complete"} {
+		body, _ := json.Marshal(models.TrackEventRequest{AdID: uuid.New().String(), CreativeID: creativeID, Event: event})
+		req, _ := http.NewRequest("POST", "/api/v1/track-event", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d. Body: %s", event, w.Code, w.Body.String())
+		}
+		if count, err := redisClient.GetCreativeEventCount(ctx, creativeID, event); err != nil || count != 1 {
+			t.Errorf("%s: expected counter at 1, got %d (err %v)", event, count, err)
+		}
+	}
+}
+
+func TestHandleTrackEvent_UnknownEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Unknown events are rejected before Redis is touched
+	handler := &AdHandler{adService: &services.AdService{}}
+
+	router := gin.New()
+	router.POST("/api/v1/track-event", handler.HandleTrackEvent)
+
+	body, _ := json.Marshal(models.TrackEventRequest{AdID: "ad-123", CreativeID: "creative-123", Event: "rewind"})
+	req, _ := http.NewRequest("POST", "/api/v1/track-event", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleCreativeError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CREATIVE_ERROR_THRESHOLD", "2")
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/creative-error", handler.HandleCreativeError)
+
+	report := func(creativeID, remoteAddr string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CreativeErrorRequest{CreativeID: creativeID, ErrorCode: "405"})
+		req, _ := http.NewRequest("POST", "/api/v1/creative-error", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	isQuarantined := func() bool {
+		quarantined, err := redisClient.GetQuarantinedCreatives(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get quarantined creatives: %v", err)
+		}
+		return quarantined[creativeID]
+	}
+
+	// Repeats from one client don't quarantine
+	for i := 0; i < 2; i++ {
+		if w := report(creativeID, "203.0.113.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if isQuarantined() {
+		t.Fatal("Expected one client's reports not to quarantine the creative")
+	}
+
+	// A second client reaches the threshold
+	w := report(creativeID, "203.0.113.2:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response["quarantined"] != true || !isQuarantined() {
+		t.Errorf("Expected the creative to be quarantined, got %v", response)
+	}
+
+	// Unknown creatives can't be reported
+	if w := report(uuid.New().String(), "203.0.113.3:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown creative, got %d", w.Code)
+	}
+
+	// creative_id is required
+	req, _ := http.NewRequest("POST", "/api/v1/creative-error", strings.NewReader(`{"error_code":"405"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without creative_id, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteCampaign_SoftDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.DELETE("/api/v1/admin/campaigns/:id", handler.HandleDeleteCampaign)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/admin/campaigns/"+campaignID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	// Campaign data is retained with a deleted status
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Expected campaign data to remain, got: %v", err)
+	}
+	if campaign["status"] != "deleted" {
+		t.Errorf("Expected status deleted, got %s", campaign["status"])
+	}
+
+	// Unknown campaigns return 404
+	req, _ = http.NewRequest("DELETE", "/api/v1/admin/campaigns/"+uuid.New().String(), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestCampaignCRUD(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	admin := router.Group("/api/v1/admin")
+	admin.Use(RequireTenantKey([]string{"operator"}, ParseTenantKeys("key-a:tenant-a,key-b:tenant-b")))
+	admin.POST("/campaigns", handler.HandleCreateCampaign)
+	admin.GET("/campaigns/:id", handler.HandleGetCampaign)
+	admin.PUT("/campaigns/:id", handler.HandleUpdateCampaign)
+	admin.DELETE("/campaigns/:id", handler.HandleDeleteCampaign)
+
+	send := func(method, path, key string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	isActive := func(campaignID string) bool {
+		ids, err := redisClient.GetActiveCampaigns(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get active campaigns: %v", err)
+		}
+		for _, id := range ids {
+			if id == campaignID {
+				return true
+			}
+		}
+		return false
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	campaign := models.Campaign{
+		Name:        "CRUD Campaign",
+		Status:      "active",
+		BudgetTotal: 5000,
+		StartDate:   now.Add(-time.Hour),
+		EndDate:     now.Add(24 * time.Hour),
+		CPM:         20,
+	}
+
+	// Create
+	w := send("POST", "/api/v1/admin/campaigns", "key-a", campaign)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.Campaign
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	defer cleanupTestData(t, redisClient, created.ID, "")
+	if created.ID == "" || created.TenantID != "tenant-a" || created.BudgetSpent != 0 {
+		t.Errorf("Expected a new tenant-a campaign with nothing spent, got %+v", created)
+	}
+	if !isActive(created.ID) {
+		t.Error("Expected active campaign with budget left to join active_campaigns")
+	}
+
+	// Read
+	path := "/api/v1/admin/campaigns/" + created.ID
+	w = send("GET", path, "key-a", nil)
+	var read models.Campaign
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &read) != nil {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if read.Name != campaign.Name || read.BudgetTotal != 5000 || read.CPM != 20 || !read.EndDate.Equal(campaign.EndDate) {
+		t.Errorf("Expected the created campaign back, got %+v", read)
+	}
+	if w := send("GET", path, "key-b", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 reading another tenant's campaign, got %d", w.Code)
+	}
+
+	// Update: pausing leaves the active set, reactivating rejoins it
+	campaign.Status = "paused"
+	campaign.Name = "Renamed"
+	if w := send("PUT", path, "key-a", campaign); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if isActive(created.ID) {
+		t.Error("Expected paused campaign to leave active_campaigns")
+	}
+	campaign.Status = "active"
+	w = send("PUT", path, "key-a", campaign)
+	var updated models.Campaign
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &updated) != nil {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if updated.Name != "Renamed" || updated.TenantID != "tenant-a" || !isActive(created.ID) {
+		t.Errorf("Expected renamed, still owned and active campaign, got %+v", updated)
+	}
+
+	// Invalid updates are rejected
+	campaign.BudgetTotal = 0
+	if w := send("PUT", path, "key-a", campaign); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a zero budget, got %d", w.Code)
+	}
+
+	// Delete
+	if w := send("DELETE", path, "key-a", nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if isActive(created.ID) {
+		t.Error("Expected deleted campaign to leave active_campaigns")
+	}
+	if w := send("GET", path, "key-a", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 reading a deleted campaign, got %d", w.Code)
+	}
+	campaign.BudgetTotal = 5000
+	if w := send("PUT", path, "key-a", campaign); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 updating a deleted campaign, got %d", w.Code)
+	}
+}
+
+func TestCreativeCRUD(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, seededID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, seededID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	admin := router.Group("/api/v1/admin")
+	admin.Use(RequireTenantKey([]string{"secret"}, nil))
+	admin.POST("/creatives", handler.HandleCreateCreative)
+	admin.GET("/creatives/:id", handler.HandleGetCreative)
+	admin.PUT("/creatives/:id", handler.HandleUpdateCreative)
+	admin.DELETE("/creatives/:id", handler.HandleDeleteCreative)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	inCampaignSet := func(creativeID string) bool {
+		ids, err := redisClient.GetCampaignCreatives(ctx, campaignID)
+		if err != nil {
+			t.Fatalf("Failed to get campaign creatives: %v", err)
+		}
+		for _, id := range ids {
+			if id == creativeID {
+				return true
+			}
+		}
+		return false
+	}
+
+	creative := models.Creative{
+		CampaignID: campaignID,
+		Name:       "CRUD Creative",
+		VideoURL:   "https://example.com/crud.mov",
+		Duration:   15,
+		Format:     "mov",
+		Status:     "active",
+	}
+
+	// Unknown formats are rejected
+	if w := send("POST", "/api/v1/admin/creatives", creative); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for format mov, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Create
+	creative.Format = "webm"
+	creative.VideoURL = "https://example.com/crud.webm"
+	w := send("POST", "/api/v1/admin/creatives", creative)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.Creative
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	defer redisClient.DeleteCreative(ctx, created.ID, campaignID)
+	if created.ID == "" || created.CampaignID != campaignID || created.Duration != 15 {
+		t.Errorf("Expected the new creative back, got %+v", created)
+	}
+	if !inCampaignSet(created.ID) {
+		t.Error("Expected created creative in the campaign's creative set")
+	}
+
+	// Read
+	path := "/api/v1/admin/creatives/" + created.ID
+	w = send("GET", path, nil)
+	var read models.Creative
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &read) != nil {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if read != created {
+		t.Errorf("Expected %+v, got %+v", created, read)
+	}
+
+	// Update, without a campaign_id it keeps its campaign
+	creative.CampaignID = ""
+	creative.Duration = 30
+	w = send("PUT", path, creative)
+	var updated models.Creative
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &updated) != nil {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if updated.Duration != 30 || updated.CampaignID != campaignID {
+		t.Errorf("Expected a 30s creative still in campaign %s, got %+v", campaignID, updated)
+	}
+	creative.Duration = 0
+	if w := send("PUT", path, creative); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a zero duration, got %d", w.Code)
+	}
+
+	// Delete
+	if w := send("DELETE", path, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if inCampaignSet(created.ID) {
+		t.Error("Expected deleted creative to leave the campaign's creative set")
+	}
+	if w := send("GET", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 reading a deleted creative, got %d", w.Code)
+	}
+	if w := send("DELETE", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting it again, got %d", w.Code)
+	}
+}
+
+func TestHandleCreateCreative_DeletedCampaign(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, seededID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, seededID)
+
+	handler := NewAdHandler(redisClient, testConfig())
+	if err := handler.adService.DeleteCampaign(ctx, campaignID); err != nil {
+		t.Fatalf("Failed to delete campaign: %v", err)
+	}
+	before, err := redisClient.GetCampaignCreatives(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign creatives: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/api/v1/admin/creatives", RequireTenantKey([]string{"secret"}, nil), handler.HandleCreateCreative)
+
+	payload, _ := json.Marshal(models.Creative{
+		CampaignID: campaignID,
+		Name:       "Orphan Creative",
+		VideoURL:   "https://example.com/orphan.mp4",
+		Duration:   15,
+		Format:     "mp4",
+		Status:     "active",
+	})
+	req, _ := http.NewRequest("POST", "/api/v1/admin/creatives", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a deleted campaign, got %d: %s", w.Code, w.Body.String())
+	}
+	after, err := redisClient.GetCampaignCreatives(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign creatives: %v", err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("Expected the creative set to stay %v, got %v", before, after)
+	}
+}
+
+func TestHandlePreview(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"status": "paused"})
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	router.POST("/api/v1/admin/preview", RequireTenantKey([]string{"secret"}, nil), handler.HandlePreview)
+
+	preview := func(key, accept string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.PreviewRequest{CreativeID: creativeID})
+		req, _ := http.NewRequest("POST", "/api/v1/admin/preview", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := preview("wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a valid key, got %d", w.Code)
+	}
+
+	w := preview("secret", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response models.AdResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.CreativeID != creativeID {
+		t.Errorf("Expected creative %s for paused campaign, got %s", creativeID, response.CreativeID)
+	}
+
+	w = preview("secret", "application/xml")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<MediaFile") {
+		t.Errorf("Expected VAST preview with a MediaFile, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminTenantIsolation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"tenant_id": "tenant-a"})
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	admin := router.Group("/api/v1/admin")
+	admin.Use(RequireTenantKey([]string{"operator"}, ParseTenantKeys("key-a:tenant-a,key-b:tenant-b")))
+	admin.GET("/campaigns/:id/pacing", handler.HandleCampaignPacing)
+	admin.DELETE("/campaigns/:id", handler.HandleDeleteCampaign)
+	admin.POST("/preview", handler.HandlePreview)
+
+	send := func(method, path, key string, body []byte) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	pacingPath := "/api/v1/admin/campaigns/" + campaignID + "/pacing"
+	previewBody, _ := json.Marshal(models.PreviewRequest{CreativeID: creativeID})
+
+	if code := send("GET", pacingPath, "key-b", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 reading another tenant's campaign, got %d", code)
+	}
+	if code := send("POST", "/api/v1/admin/preview", "key-b", previewBody); code != http.StatusNotFound {
+		t.Errorf("Expected 404 previewing another tenant's creative, got %d", code)
+	}
+	if code := send("DELETE", "/api/v1/admin/campaigns/"+campaignID, "key-b", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting another tenant's campaign, got %d", code)
+	}
+
+	if code := send("GET", pacingPath, "key-a", nil); code != http.StatusOK {
+		t.Errorf("Expected 200 reading own campaign, got %d", code)
+	}
+	if code := send("POST", "/api/v1/admin/preview", "key-a", previewBody); code != http.StatusOK {
+		t.Errorf("Expected 200 previewing own creative, got %d", code)
+	}
+	if code := send("GET", pacingPath, "operator", nil); code != http.StatusOK {
+		t.Errorf("Expected 200 for the operator key, got %d", code)
+	}
+
+	// The rejected delete left the campaign untouched
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil || campaign["status"] == "deleted" {
+		t.Errorf("Expected campaign to survive another tenant's delete, got %v (err %v)", campaign["status"], err)
+	}
+}
+
+func TestHandleDeviceHistory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestData(t, redisClient)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"tenant_id": "tenant-a"})
+
+	// Seed three serves, oldest first, and two impressions
+	deviceID := "device-" + uuid.New().String()
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	var adIDs []string
+	for i := 0; i < 3; i++ {
+		adID := uuid.New().String()
+		adIDs = append(adIDs, adID)
+		payload, _ := json.Marshal(models.ServedAd{
+			AdID:       adID,
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			ServedAt:   base.Add(time.Duration(i) * time.Minute),
+		})
+		if err := redisClient.PushDeviceServe(ctx, deviceID, payload, 50, time.Hour); err != nil {
+			t.Fatalf("Failed to seed device serve: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		redisClient.IncrementFrequencyCount(ctx, "device:"+deviceID, campaignID)
+	}
+
+	handler := NewAdHandler(redisClient, testConfig())
+
+	router := gin.New()
+	admin := router.Group("/api/v1/admin")
+	admin.Use(RequireTenantKey([]string{"operator"}, ParseTenantKeys("key-a:tenant-a,key-b:tenant-b")))
+	admin.GET("/devices/:id/history", handler.HandleDeviceHistory)
+
+	history := func(key string) (int, models.DeviceHistory) {
+		req, _ := http.NewRequest("GET", "/api/v1/admin/devices/"+deviceID+"/history", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response models.DeviceHistory
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	if code, _ := history(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key, got %d", code)
+	}
+
+	code, response := history("operator")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(response.Served) != 3 {
+		t.Fatalf("Expected 3 served ads, got %d", len(response.Served))
+	}
+	for i, served := range response.Served {
+		if want := adIDs[len(adIDs)-1-i]; served.AdID != want {
+			t.Errorf("Expected served[%d] to be %s (newest first), got %s", i, want, served.AdID)
+		}
+	}
+	if len(response.Impressions) != 1 || response.Impressions[0].CampaignID != campaignID {
+		t.Fatalf("Expected impressions for campaign %s, got %+v", campaignID, response.Impressions)
+	}
+	if response.Impressions[0].Hour != 2 || response.Impressions[0].Day != 2 {
+		t.Errorf("Expected 2 impressions this hour and day, got %+v", response.Impressions[0])
+	}
+
+	// Tenants only see their own campaigns
+	if _, response := history("key-a"); len(response.Served) != 3 {
+		t.Errorf("Expected owning tenant to see 3 serves, got %d", len(response.Served))
+	}
+	if _, response := history("key-b"); len(response.Served) != 0 || len(response.Impressions) != 0 {
+		t.Errorf("Expected another tenant to see nothing, got %+v", response)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+
+	router := gin.New()
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "ok",
+			"service": "ad-server",
+		})
+	})
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Errorf("Failed to parse response: %v", err)
+	}
+
+	if response["status"] != "ok" {
+		t.Errorf("Expected status 'ok', got '%v'", response["status"])
+	}
+
+	if response["service"] != "ad-server" {
+		t.Errorf("Expected service 'ad-server', got '%v'", response["service"])
+	}
+}
+
+// flakyPinger fails until up is set
+type flakyPinger struct {
+	up atomic.Bool
+}
+
+func (p *flakyPinger) Ping(ctx context.Context) error {
+	if !p.up.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestHealthHandler_StartsNotReadyUntilRedisRecovers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pinger := &flakyPinger{}
+	health := NewHealthHandler(pinger)
+
+	router := gin.New()
+	router.GET("/health", health.HandleHealth)
+	router.GET("/readyz", health.HandleReady)
+	router.POST("/api/v1/ad-request", health.RequireReady(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go health.WaitForRedis(ctx, 5*time.Millisecond)
+
+	// Redis is down: probes and ad requests get a structured 503
+	for _, path := range []string{"/health", "/readyz"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status 503 while Redis is down, got %d", path, w.Code)
+		}
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		deps, _ := response["dependencies"].(map[string]interface{})
+		if deps["redis"] != "unreachable" {
+			t.Errorf("%s: expected redis dependency unreachable, got %v", path, response["dependencies"])
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/ad-request", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected ad requests to get 503 while Redis is down, got %d", w.Code)
+	}
+
+	// Redis recovers: the background retry marks the service ready
+	pinger.up.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for !health.Ready() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz 200 after Redis recovers, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/ad-request", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected ad requests to pass once ready, got %d", w.Code)
+	}
+}
+
+func TestHealthHandler_ReadyzFailsWhenRedisCloses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Readiness was reached at startup, then the client goes away
+	client := redis.New("127.0.0.1:1")
+	health := NewHealthHandler(client)
+	health.ready.Store(true)
+	client.Close()
+
+	router := gin.New()
+	router.GET("/health", health.HandleHealth)
+	router.GET("/readyz", health.HandleReady)
+	router.GET("/livez", health.HandleLive)
+
+	get := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz 503 with Redis closed, got %d", code)
+	}
+	if code := get("/health"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /health to match /readyz, got %d", code)
+	}
+	if code := get("/livez"); code != http.StatusOK {
+		t.Errorf("Expected /livez 200 regardless of Redis, got %d", code)
+	}
+}
+
+func TestHealthHandler_ReadyzTracksRedis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pinger := &flakyPinger{}
+	pinger.up.Store(true)
+	health := NewHealthHandler(pinger)
+	health.WaitForRedis(context.Background(), time.Millisecond)
+
+	router := gin.New()
+	router.GET("/readyz", health.HandleReady)
+
+	get := func() int {
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Errorf("Expected 200 while Redis is up, got %d", code)
+	}
+	pinger.up.Store(false)
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once Redis goes down, got %d", code)
+	}
+	pinger.up.Store(true)
+	if code := get(); code != http.StatusOK {
+		t.Errorf("Expected 200 once Redis is back, got %d", code)
 	}
 }
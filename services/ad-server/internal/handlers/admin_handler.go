@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/redis"
+	"github.com/fanwu/ad-server/internal/services"
+	"github.com/fanwu/ad-server/internal/vast"
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeTenant runs check for the caller's tenant, answering 404 for
+// resources outside the tenant (or missing) and 500 on lookup failures.
+// It reports whether the handler may proceed.
+func (h *AdHandler) authorizeTenant(c *gin.Context, resource string, check func(tenantID string) error) bool {
+	err := check(TenantFromContext(c))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, redis.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": resource + " not found",
+		})
+		return false
+	}
+	log.Printf("Failed to authorize tenant for %s: %v", strings.ToLower(resource), err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to authorize request",
+	})
+	return false
+}
+
+// HandleCreateCampaign handles POST /api/v1/admin/campaigns
+func (h *AdHandler) HandleCreateCampaign(c *gin.Context) {
+	var req models.Campaign
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	campaign, err := h.adService.CreateCampaign(c.Request.Context(), TenantFromContext(c), &req)
+	if err != nil {
+		h.respondCampaignWriteError(c, "create", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// HandleGetCampaign handles GET /api/v1/admin/campaigns/:id
+func (h *AdHandler) HandleGetCampaign(c *gin.Context) {
+	campaignID := c.Param("id")
+	if !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, campaignID)
+	}) {
+		return
+	}
+
+	campaign, err := h.adService.GetCampaign(c.Request.Context(), campaignID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Campaign not found",
+			})
+			return
+		}
+		log.Printf("Failed to get campaign %s: %v", campaignID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get campaign",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// HandleUpdateCampaign handles PUT /api/v1/admin/campaigns/:id
+func (h *AdHandler) HandleUpdateCampaign(c *gin.Context) {
+	campaignID := c.Param("id")
+	var req models.Campaign
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, campaignID)
+	}) {
+		return
+	}
+
+	campaign, err := h.adService.UpdateCampaign(c.Request.Context(), campaignID, &req)
+	if err != nil {
+		h.respondCampaignWriteError(c, "update", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// respondCampaignWriteError answers a failed campaign create or update: 400
+// for validation failures, 404 for unknown campaigns, otherwise 500
+func (h *AdHandler) respondCampaignWriteError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCampaign):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid campaign",
+			"details": err.Error(),
+		})
+	case errors.Is(err, redis.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Campaign not found",
+		})
+	default:
+		log.Printf("Failed to %s campaign: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to " + action + " campaign",
+		})
+	}
+}
+
+// HandleCreateCreative handles POST /api/v1/admin/creatives
+func (h *AdHandler) HandleCreateCreative(c *gin.Context) {
+	var req models.Creative
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if req.CampaignID != "" && !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, req.CampaignID)
+	}) {
+		return
+	}
+
+	creative, err := h.adService.CreateCreative(c.Request.Context(), &req)
+	if err != nil {
+		h.respondCreativeWriteError(c, "create", "Campaign", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, creative)
+}
+
+// HandleGetCreative handles GET /api/v1/admin/creatives/:id
+func (h *AdHandler) HandleGetCreative(c *gin.Context) {
+	creativeID := c.Param("id")
+	if !h.authorizeTenant(c, "Creative", func(tenantID string) error {
+		return h.adService.AuthorizeCreative(c.Request.Context(), tenantID, creativeID)
+	}) {
+		return
+	}
+
+	creative, err := h.adService.GetCreative(c.Request.Context(), creativeID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Creative not found",
+			})
+			return
+		}
+		log.Printf("Failed to get creative %s: %v", creativeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get creative",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, creative)
+}
+
+// HandleUpdateCreative handles PUT /api/v1/admin/creatives/:id
+func (h *AdHandler) HandleUpdateCreative(c *gin.Context) {
+	creativeID := c.Param("id")
+	var req models.Creative
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.authorizeTenant(c, "Creative", func(tenantID string) error {
+		return h.adService.AuthorizeCreative(c.Request.Context(), tenantID, creativeID)
+	}) {
+		return
+	}
+
+	creative, err := h.adService.UpdateCreative(c.Request.Context(), creativeID, &req)
+	if err != nil {
+		h.respondCreativeWriteError(c, "update", "Creative", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, creative)
+}
+
+// HandleDeleteCreative handles DELETE /api/v1/admin/creatives/:id
+func (h *AdHandler) HandleDeleteCreative(c *gin.Context) {
+	creativeID := c.Param("id")
+	if !h.authorizeTenant(c, "Creative", func(tenantID string) error {
+		return h.adService.AuthorizeCreative(c.Request.Context(), tenantID, creativeID)
+	}) {
+		return
+	}
+
+	if err := h.adService.DeleteCreative(c.Request.Context(), creativeID); err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Creative not found",
+			})
+			return
+		}
+		log.Printf("Failed to delete creative %s: %v", creativeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete creative",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"creative_id": creativeID,
+		"message":     "Creative deleted",
+	})
+}
+
+// respondCreativeWriteError answers a failed creative create or update: 400
+// for validation failures, 404 when the creative (or, on create, its
+// campaign) doesn't exist, otherwise 500
+func (h *AdHandler) respondCreativeWriteError(c *gin.Context, action, missing string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCreative):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid creative",
+			"details": err.Error(),
+		})
+	case errors.Is(err, redis.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": missing + " not found",
+		})
+	default:
+		log.Printf("Failed to %s creative: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to " + action + " creative",
+		})
+	}
+}
+
+// HandleDeleteCampaign handles DELETE /api/v1/admin/campaigns/:id
+func (h *AdHandler) HandleDeleteCampaign(c *gin.Context) {
+	campaignID := c.Param("id")
+	if !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, campaignID)
+	}) {
+		return
+	}
+
+	if err := h.adService.DeleteCampaign(c.Request.Context(), campaignID); err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Campaign not found",
+			})
+			return
+		}
+		log.Printf("Failed to delete campaign %s: %v", campaignID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete campaign",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"campaign_id": campaignID,
+		"message":     "Campaign deleted",
+	})
+}
+
+// HandleCampaignPacing handles GET /api/v1/admin/campaigns/:id/pacing
+func (h *AdHandler) HandleCampaignPacing(c *gin.Context) {
+	campaignID := c.Param("id")
+	if !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, campaignID)
+	}) {
+		return
+	}
+
+	pacing, err := h.adService.GetCampaignPacing(c.Request.Context(), campaignID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Campaign not found",
+			})
+			return
+		}
+		log.Printf("Failed to get pacing for campaign %s: %v", campaignID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get campaign pacing",
+		})
+		return
+	}
+
+	// Money is raw numbers for machines unless a locale is asked for, via
+	// ?locale= or Accept-Language (?format=raw forces raw)
+	if c.Query("format") != "raw" {
+		locale := c.Query("locale")
+		if locale == "" {
+			locale = services.PreferredLocale(c.GetHeader("Accept-Language"))
+		}
+		if locale != "" && locale != "*" {
+			services.LocalizePacing(pacing, locale)
+		}
+	}
+
+	c.JSON(http.StatusOK, pacing)
+}
+
+// HandleCampaignStats handles GET /api/v1/admin/campaigns/:id/stats. It sits
+// under /admin rather than at /api/v1/campaigns/:id/stats so it takes the
+// admin key and is tenant-scoped like the other campaign reads.
+func (h *AdHandler) HandleCampaignStats(c *gin.Context) {
+	campaignID := c.Param("id")
+	if !h.authorizeTenant(c, "Campaign", func(tenantID string) error {
+		return h.adService.AuthorizeCampaign(c.Request.Context(), tenantID, campaignID)
+	}) {
+		return
+	}
+
+	stats, err := h.adService.GetCampaignStats(c.Request.Context(), campaignID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Campaign not found",
+			})
+			return
+		}
+		log.Printf("Failed to get stats for campaign %s: %v", campaignID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get campaign stats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleCreativeStats handles GET /api/v1/admin/creatives/:id/stats, under
+// /admin rather than /api/v1/creatives/:id/stats for the same reason
+func (h *AdHandler) HandleCreativeStats(c *gin.Context) {
+	creativeID := c.Param("id")
+	if !h.authorizeTenant(c, "Creative", func(tenantID string) error {
+		return h.adService.AuthorizeCreative(c.Request.Context(), tenantID, creativeID)
+	}) {
+		return
+	}
+
+	stats, err := h.adService.GetCreativeStats(c.Request.Context(), creativeID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Creative not found",
+			})
+			return
+		}
+		log.Printf("Failed to get stats for creative %s: %v", creativeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get creative stats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleDeviceHistory handles GET /api/v1/admin/devices/:id/history
+func (h *AdHandler) HandleDeviceHistory(c *gin.Context) {
+	deviceID := c.Param("id")
+	history, err := h.adService.DeviceHistory(c.Request.Context(), TenantFromContext(c), deviceID)
+	if err != nil {
+		log.Printf("Failed to get history for device %s: %v", deviceID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get device history",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// HandleLatency handles GET /api/v1/admin/latency
+func (h *AdHandler) HandleLatency(c *gin.Context) {
+	percentiles, samples := h.latency.Percentiles(50, 90, 99)
+	c.JSON(http.StatusOK, models.LatencySummary{
+		WindowSize: h.latency.Size(),
+		Samples:    samples,
+		P50Ms:      milliseconds(percentiles[0]),
+		P90Ms:      milliseconds(percentiles[1]),
+		P99Ms:      milliseconds(percentiles[2]),
+	})
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// HandlePreview handles POST /api/v1/admin/preview
+func (h *AdHandler) HandlePreview(c *gin.Context) {
+	var req models.PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.authorizeTenant(c, "Creative", func(tenantID string) error {
+		return h.adService.AuthorizeCreative(c.Request.Context(), tenantID, req.CreativeID)
+	}) {
+		return
+	}
+
+	adResponse, err := h.adService.PreviewCreative(c.Request.Context(), req.CreativeID, req.DeviceID)
+	if err != nil {
+		if errors.Is(err, redis.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Creative not found",
+			})
+			return
+		}
+		log.Printf("Failed to preview creative %s: %v", req.CreativeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to preview creative",
+		})
+		return
+	}
+
+	if wantsVAST(c) {
+		h.respondVAST(c, vast.FromAdResponse(adResponse))
+		return
+	}
+	c.JSON(http.StatusOK, adResponse)
+}
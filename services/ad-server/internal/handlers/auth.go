@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantContextKey is the gin context key holding the caller's tenant ID
+const tenantContextKey = "tenant_id"
+
+// matchesAnyKey reports whether provided is one of keys. Every key is
+// compared in constant time so timing doesn't reveal which one, if any,
+// matched. Empty keys never match.
+func matchesAnyKey(provided []byte, keys []string) bool {
+	matched := 0
+	for _, key := range keys {
+		if key != "" {
+			matched |= subtle.ConstantTimeCompare(provided, []byte(key))
+		}
+	}
+	return matched == 1
+}
+
+// ParseTenantKeys parses "key1:tenant-a,key2:tenant-b" into a map of API key
+// to tenant ID, skipping malformed entries
+func ParseTenantKeys(value string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		key, tenant, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		key, tenant = strings.TrimSpace(key), strings.TrimSpace(tenant)
+		if ok && key != "" && tenant != "" {
+			keys[key] = tenant
+		}
+	}
+	return keys
+}
+
+// RequireTenantKey authenticates X-API-Key against the operator keys and the
+// per-tenant keys, rejecting anything else with 401. A tenant key attaches its
+// tenant to the context so handlers scope reads and writes to it; the
+// operator keys attach none and see every tenant.
+func RequireTenantKey(adminKeys []string, tenantKeys map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := []byte(c.GetHeader("X-API-Key"))
+		if len(provided) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized",
+			})
+			return
+		}
+
+		// Compare against every key so timing doesn't reveal which matched
+		operator := matchesAnyKey(provided, adminKeys)
+		var tenant string
+		for key, tenantID := range tenantKeys {
+			if subtle.ConstantTimeCompare(provided, []byte(key)) == 1 {
+				tenant = tenantID
+			}
+		}
+
+		if !operator && tenant == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized",
+			})
+			return
+		}
+		if !operator {
+			c.Set(tenantContextKey, tenant)
+		}
+		c.Next()
+	}
+}
+
+// TenantFromContext returns the tenant attached by RequireTenantKey, or ""
+// for the operator
+func TenantFromContext(c *gin.Context) string {
+	return c.GetString(tenantContextKey)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireTenantKey_OperatorKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		configured []string
+		provided   string
+		wantStatus int
+	}{
+		{"missing key", []string{"secret"}, "", http.StatusUnauthorized},
+		{"wrong key", []string{"secret"}, "guess", http.StatusUnauthorized},
+		{"valid key", []string{"secret"}, "secret", http.StatusOK},
+		{"no key configured", nil, "", http.StatusUnauthorized},
+		{"empty key configured", []string{""}, "", http.StatusUnauthorized},
+		{"first of several keys", []string{"old-secret", "new-secret"}, "old-secret", http.StatusOK},
+		{"second of several keys", []string{"old-secret", "new-secret"}, "new-secret", http.StatusOK},
+		{"prefix of a key", []string{"old-secret", "new-secret"}, "new-", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/admin/preview", RequireTenantKey(tt.configured, nil), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest("POST", "/admin/preview", nil)
+			if tt.provided != "" {
+				req.Header.Set("X-API-Key", tt.provided)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireTenantKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tenantKeys := ParseTenantKeys("key-a:tenant-a, key-b:tenant-b, malformed")
+
+	tests := []struct {
+		name       string
+		adminKeys  []string
+		provided   string
+		wantStatus int
+		wantTenant string
+	}{
+		{"missing key", []string{"secret"}, "", http.StatusUnauthorized, ""},
+		{"unknown key", []string{"secret"}, "guess", http.StatusUnauthorized, ""},
+		{"operator key", []string{"secret"}, "secret", http.StatusOK, ""},
+		{"second operator key", []string{"secret", "rotated"}, "rotated", http.StatusOK, ""},
+		{"tenant key", []string{"secret"}, "key-b", http.StatusOK, "tenant-b"},
+		{"tenant key, no operator configured", nil, "key-a", http.StatusOK, "tenant-a"},
+		{"nothing configured", nil, "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			router := gin.New()
+			router.GET("/admin/campaigns/:id/pacing", RequireTenantKey(tt.adminKeys, tenantKeys), func(c *gin.Context) {
+				tenant = TenantFromContext(c)
+				c.Status(http.StatusOK)
+			})
+
+			req, _ := http.NewRequest("GET", "/admin/campaigns/c1/pacing", nil)
+			if tt.provided != "" {
+				req.Header.Set("X-API-Key", tt.provided)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tenant != tt.wantTenant {
+				t.Errorf("Expected tenant %q, got %q", tt.wantTenant, tenant)
+			}
+		})
+	}
+}
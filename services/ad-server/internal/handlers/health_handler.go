@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pinger checks a dependency's reachability
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// readinessPingTimeout bounds the Redis ping behind each readiness probe
+const readinessPingTimeout = time.Second
+
+// HealthHandler reports service health and gates traffic until Redis has
+// been reached at least once
+type HealthHandler struct {
+	redis Pinger
+	ready atomic.Bool
+}
+
+func NewHealthHandler(redis Pinger) *HealthHandler {
+	return &HealthHandler{
+		redis: redis,
+	}
+}
+
+// WaitForRedis pings Redis every interval until it responds, then marks the
+// service ready. It blocks, so callers run it in a goroutine.
+func (h *HealthHandler) WaitForRedis(ctx context.Context, interval time.Duration) {
+	for {
+		err := h.redis.Ping(ctx)
+		if err == nil {
+			h.ready.Store(true)
+			log.Println("Connected to Redis")
+			return
+		}
+		log.Printf("Redis unreachable, retrying in %v: %v", interval, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Ready reports whether Redis has been reached
+func (h *HealthHandler) Ready() bool {
+	return h.ready.Load()
+}
+
+// HandleHealth handles GET /health, kept as an alias of /readyz
+func (h *HealthHandler) HandleHealth(c *gin.Context) {
+	h.HandleReady(c)
+}
+
+// HandleReady handles GET /readyz. It pings Redis on every probe, so a pod
+// that loses Redis is taken out of rotation until it comes back.
+func (h *HealthHandler) HandleReady(c *gin.Context) {
+	if !h.Ready() {
+		h.respondUnavailable(c)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessPingTimeout)
+	defer cancel()
+	if err := h.redis.Ping(ctx); err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		h.respondUnavailable(c)
+		return
+	}
+	h.respond(c)
+}
+
+// HandleLive handles GET /livez: the process is up and serving HTTP, so it
+// is always 200 regardless of dependencies
+func (h *HealthHandler) HandleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"service":   "ad-server",
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// RequireReady rejects requests with 503 until Redis has been reached
+func (h *HealthHandler) RequireReady() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.Ready() {
+			h.respondUnavailable(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *HealthHandler) respond(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"service":   "ad-server",
+		"timestamp": time.Now().Unix(),
+		"dependencies": gin.H{
+			"redis": "ok",
+		},
+	})
+}
+
+func (h *HealthHandler) respondUnavailable(c *gin.Context) {
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"status":    "unavailable",
+		"service":   "ad-server",
+		"timestamp": time.Now().Unix(),
+		"dependencies": gin.H{
+			"redis": "unreachable",
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit throttles requests by client IP against API_RATE_LIMIT per rate
+// limit window. A client over the limit gets 429 with Retry-After set to the
+// seconds left in the window. With no limit configured, or Redis
+// unavailable, requests pass.
+func (h *AdHandler) RateLimit() gin.HandlerFunc {
+	return rateLimit(h.adService.CheckClientRateLimit)
+}
+
+// TrackingRateLimit is RateLimit for the tracking beacons (impressions,
+// clicks and events), against the separate TRACKING_RATE_LIMIT
+func (h *AdHandler) TrackingRateLimit() gin.HandlerFunc {
+	return rateLimit(h.adService.CheckTrackingRateLimit)
+}
+
+// rateLimit answers 429 when check reports the client IP over its limit.
+// The client IP only comes from X-Forwarded-For when the peer is one of
+// the router's trusted proxies.
+func rateLimit(check func(ctx context.Context, ipAddress string) (time.Duration, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		retryAfter, err := check(c.Request.Context(), c.ClientIP())
+		if err != nil {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":   "Too many requests",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the caller's request ID, or the one generated for
+// it, so the serving log line can be matched to a client report
+const requestIDHeader = "X-Request-ID"
+
+// servingLog collects what HandleAdRequest decided, for the single canonical
+// log line written when the request finishes
+type servingLog struct {
+	requestID string
+	start     time.Time
+	req       *models.AdRequest
+
+	filled       bool
+	house        bool
+	replayed     bool
+	campaignID   string
+	creativeID   string
+	decision     *models.Decision
+	noFillReason string
+	err          error
+}
+
+// newServingLog starts the log for a request, reusing its X-Request-ID or
+// generating one, and echoes the ID on the response
+func newServingLog(c *gin.Context, start time.Time, req *models.AdRequest) *servingLog {
+	requestID := c.GetHeader(requestIDHeader)
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	c.Header(requestIDHeader, requestID)
+	return &servingLog{requestID: requestID, start: start, req: req}
+}
+
+// served records the ad the request was answered with. It must be called
+// before rendering, which drops the decision unless transparency is asked for.
+func (l *servingLog) served(adResponse *models.AdResponse, replayed bool) {
+	l.filled = true
+	l.house = adResponse.CampaignID == services.HouseCampaignID
+	l.replayed = replayed
+	l.campaignID = adResponse.CampaignID
+	l.creativeID = adResponse.CreativeID
+	l.decision = adResponse.Decision
+}
+
+// noFill records why the request went unfilled
+func (l *servingLog) noFill(reason string, err error) {
+	l.noFillReason = reason
+	l.err = err
+}
+
+// write emits the line as logfmt key=value pairs
+func (l *servingLog) write(status int) {
+	log.Print(l.format(status, time.Since(l.start)))
+}
+
+// format renders the line. Fields that don't apply to the outcome are left
+// out; string values are quoted when they need it.
+func (l *servingLog) format(status int, latency time.Duration) string {
+	var b strings.Builder
+	b.WriteString("ad_request")
+	field := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if strings.ContainsAny(value, " \"=\t\n") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + key + "=" + value)
+	}
+
+	field("request_id", l.requestID)
+	field("status", strconv.Itoa(status))
+	field("filled", strconv.FormatBool(l.filled))
+	field("campaign_id", l.campaignID)
+	field("creative_id", l.creativeID)
+	if decision := l.decision; decision != nil {
+		field("eligible", strconv.Itoa(decision.EligibleCount))
+		field("strategy", decision.Strategy)
+		field("creative_strategy", decision.CreativeStrategy)
+	}
+	if l.house {
+		field("house", "true")
+	}
+	if l.replayed {
+		field("replayed", "true")
+	}
+	field("no_fill_reason", l.noFillReason)
+	if l.err != nil {
+		field("error", l.err.Error())
+	}
+	field("latency_ms", strconv.FormatFloat(float64(latency.Microseconds())/1000, 'f', 3, 64))
+	if req := l.req; req != nil {
+		field("device_id", req.DeviceID)
+		field("device_type", req.DeviceType)
+		field("app_id", req.AppID)
+		field("placement_id", req.PlacementID)
+		field("country", req.LocationCountry)
+		field("platform", req.Platform)
+	}
+	return b.String()
+}
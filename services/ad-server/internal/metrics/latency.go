@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyWindow keeps the most recent latency samples in a fixed-size ring
+// buffer, so percentiles can be reported in-process by deployments without a
+// metrics backend
+type LatencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int  // Index the next sample overwrites
+	full    bool // Whether the buffer has wrapped
+}
+
+// NewLatencyWindow returns a window holding the last size samples
+func NewLatencyWindow(size int) *LatencyWindow {
+	if size <= 0 {
+		size = 1
+	}
+	return &LatencyWindow{samples: make([]time.Duration, size)}
+}
+
+// Record adds a sample, evicting the oldest once the window is full
+func (w *LatencyWindow) Record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// Size returns the window's capacity
+func (w *LatencyWindow) Size() int {
+	return len(w.samples)
+}
+
+// Percentiles returns the nearest-rank percentile (0-100) of the current
+// samples for each p, and the number of samples they were computed over.
+// With no samples every percentile is zero.
+func (w *LatencyWindow) Percentiles(ps ...float64) ([]time.Duration, int) {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	result := make([]time.Duration, len(ps))
+	if n == 0 {
+		return result, 0
+	}
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(n)))
+		rank = min(max(rank, 1), n)
+		result[i] = sorted[rank-1]
+	}
+	return result, n
+}
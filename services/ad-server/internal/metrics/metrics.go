@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics records counters, timers and gauges. Backends implement this so request
+// handling is instrumented once; the default is Noop.
+type Metrics interface {
+	// Count adds value to a counter. Tags are "key:value" pairs.
+	Count(name string, value int64, tags ...string)
+	// Timing records a duration
+	Timing(name string, d time.Duration, tags ...string)
+	// Gauge sets the current value of a gauge
+	Gauge(name string, value float64, tags ...string)
+}
+
+// Noop returns metrics that discard everything
+func Noop() Metrics {
+	return noopMetrics{}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(name string, value int64, tags ...string)      {}
+func (noopMetrics) Timing(name string, d time.Duration, tags ...string) {}
+func (noopMetrics) Gauge(name string, value float64, tags ...string)    {}
+
+// Multi fans every measurement out to each backend, so backends can run side
+// by side
+func Multi(backends ...Metrics) Metrics {
+	return multiMetrics(backends)
+}
+
+type multiMetrics []Metrics
+
+func (m multiMetrics) Count(name string, value int64, tags ...string) {
+	for _, backend := range m {
+		backend.Count(name, value, tags...)
+	}
+}
+
+func (m multiMetrics) Timing(name string, d time.Duration, tags ...string) {
+	for _, backend := range m {
+		backend.Timing(name, d, tags...)
+	}
+}
+
+func (m multiMetrics) Gauge(name string, value float64, tags ...string) {
+	for _, backend := range m {
+		backend.Gauge(name, value, tags...)
+	}
+}
+
+// StatsD sends measurements as StatsD lines over UDP. With DogStatsD tags
+// enabled, tags are appended in the "|#key:value" extension; otherwise they
+// are dropped.
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+}
+
+// NewStatsD returns a StatsD exporter sending to addr (host:port). A
+// non-empty prefix is prepended to every metric name with a dot.
+func NewStatsD(addr, prefix string, dogStatsD bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial StatsD: %w", err)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsD{conn: conn, prefix: prefix, dogStatsD: dogStatsD}, nil
+}
+
+// Count sends a "|c" counter line
+func (s *StatsD) Count(name string, value int64, tags ...string) {
+	s.send(fmt.Sprintf("%s%s:%d|c", s.prefix, name, value), tags)
+}
+
+// Timing sends a "|ms" timer line
+func (s *StatsD) Timing(name string, d time.Duration, tags ...string) {
+	s.send(fmt.Sprintf("%s%s:%d|ms", s.prefix, name, d.Milliseconds()), tags)
+}
+
+// Gauge sends a "|g" gauge line
+func (s *StatsD) Gauge(name string, value float64, tags ...string) {
+	s.send(fmt.Sprintf("%s%s:%s|g", s.prefix, name, strconv.FormatFloat(value, 'f', -1, 64)), tags)
+}
+
+// Close closes the UDP socket
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// send writes one line. UDP is fire and forget: a lost metric must never
+// slow down or fail a request, so write errors are ignored.
+func (s *StatsD) send(line string, tags []string) {
+	if s.dogStatsD && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	s.conn.Write([]byte(line))
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listenUDP starts a fake StatsD server and returns its address and a
+// function reading the next packet
+func listenUDP(t *testing.T) (string, func() string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	next := func() string {
+		t.Helper()
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read StatsD packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+	return conn.LocalAddr().String(), next
+}
+
+func TestStatsD_Lines(t *testing.T) {
+	addr, next := listenUDP(t)
+
+	statsd, err := NewStatsD(addr, "ad_server", false)
+	if err != nil {
+		t.Fatalf("Failed to create StatsD: %v", err)
+	}
+	defer statsd.Close()
+
+	statsd.Count("ad_requests", 1, "filled:true")
+	if got, want := next(), "ad_server.ad_requests:1|c"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	statsd.Timing("ad_request.latency", 42*time.Millisecond)
+	if got, want := next(), "ad_server.ad_request.latency:42|ms"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	statsd.Gauge("gateway.breaker_open", 1)
+	if got, want := next(), "ad_server.gateway.breaker_open:1|g"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestStatsD_DogStatsDTags(t *testing.T) {
+	addr, next := listenUDP(t)
+
+	statsd, err := NewStatsD(addr, "", true)
+	if err != nil {
+		t.Fatalf("Failed to create StatsD: %v", err)
+	}
+	defer statsd.Close()
+
+	statsd.Count("ad_requests", 3, "filled:false", "reason:no_eligible_campaigns")
+	if got, want := next(), "ad_requests:3|c|#filled:false,reason:no_eligible_campaigns"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// countingMetrics counts calls, for checking fan-out
+type countingMetrics struct {
+	mu      sync.Mutex
+	counts  int
+	timings int
+	gauges  int
+}
+
+func (c *countingMetrics) Count(name string, value int64, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts++
+}
+
+func (c *countingMetrics) Timing(name string, d time.Duration, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timings++
+}
+
+func (c *countingMetrics) Gauge(name string, value float64, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gauges++
+}
+
+func TestMulti(t *testing.T) {
+	a, b := &countingMetrics{}, &countingMetrics{}
+	m := Multi(a, b, Noop())
+
+	m.Count("impressions", 1)
+	m.Timing("ad_request.latency", time.Millisecond)
+	m.Gauge("gateway.breaker_open", 0)
+
+	for i, backend := range []*countingMetrics{a, b} {
+		if backend.counts != 1 || backend.timings != 1 || backend.gauges != 1 {
+			t.Errorf("Backend %d: expected 1 count, 1 timing and 1 gauge, got %d, %d and %d", i, backend.counts, backend.timings, backend.gauges)
+		}
+	}
+}
+
+func TestLatencyWindow_Percentiles(t *testing.T) {
+	window := NewLatencyWindow(1000)
+	for i := 1000; i >= 1; i-- {
+		window.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	got, n := window.Percentiles(50, 90, 99)
+	if n != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", n)
+	}
+	for i, want := range []time.Duration{500 * time.Millisecond, 900 * time.Millisecond, 990 * time.Millisecond} {
+		if diff := got[i] - want; diff < -5*time.Millisecond || diff > 5*time.Millisecond {
+			t.Errorf("Percentile %d: expected ~%v, got %v", i, want, got[i])
+		}
+	}
+}
+
+func TestLatencyWindow_RollsOver(t *testing.T) {
+	window := NewLatencyWindow(10)
+	for i := 0; i < 10; i++ {
+		window.Record(time.Second)
+	}
+	// Newer fast samples fully replace the slow ones
+	for i := 0; i < 10; i++ {
+		window.Record(time.Millisecond)
+	}
+
+	got, n := window.Percentiles(99)
+	if n != 10 {
+		t.Errorf("Expected the window to hold 10 samples, got %d", n)
+	}
+	if got[0] != time.Millisecond {
+		t.Errorf("Expected old samples evicted, got p99 %v", got[0])
+	}
+}
+
+func TestLatencyWindow_Empty(t *testing.T) {
+	got, n := NewLatencyWindow(10).Percentiles(50)
+	if n != 0 || got[0] != 0 {
+		t.Errorf("Expected no samples and zero percentile, got %d and %v", n, got[0])
+	}
+}
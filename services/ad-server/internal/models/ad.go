@@ -5,11 +5,77 @@ import "time"
 // AdRequest represents an incoming ad request
 type AdRequest struct {
 	DeviceID   string            `json:"device_id" binding:"required"`
-	DeviceType string            `json:"device_type"` // ctv, mobile, web
+	DeviceType string            `json:"device_type" binding:"omitempty,oneof=ctv mobile web"` // Optional: exactly ctv, mobile or web
 	AppID      string            `json:"app_id"`
 	UserAgent  string            `json:"user_agent"`
 	IPAddress  string            `json:"ip_address"`
 	Context    map[string]string `json:"context"` // Additional context
+
+	PreferredFormat string `json:"preferred_format"` // Optional: mp4, webm, etc
+	HouseholdID     string `json:"household_id"`     // Optional: shares frequency caps across devices
+	LocationCountry string `json:"location_country"` // Optional: ISO country, overrides IP geo resolution
+
+	DealIDs []string `json:"deal_ids"` // Optional: restricts fill to matching PMP deals
+	PMP     *PMP     `json:"pmp"`      // Optional: OpenRTB-style private marketplace object
+
+	SoundOn *bool `json:"sound_on"` // Optional: false for muted placements
+
+	PlacementID string `json:"placement_id"` // Optional: slot within the app, for viewability history
+	Platform    string `json:"platform"`     // Optional: response format, e.g. json-v1 or vast-4
+
+	MaxDuration int `json:"max_duration" binding:"gte=0"` // Optional: longest creative the slot plays, in seconds
+
+	ExcludeCreatives []string `json:"exclude_creatives"` // Optional: creatives that failed to play, on a retry
+}
+
+// Placement identifies where in an app an ad plays, for viewability scoring.
+// Requests without an app have no placement.
+func Placement(appID, placementID string) string {
+	if appID == "" {
+		return ""
+	}
+	if placementID == "" {
+		return appID
+	}
+	return appID + ":" + placementID
+}
+
+// PMP mirrors the OpenRTB imp.pmp object
+type PMP struct {
+	Deals []Deal `json:"deals"`
+}
+
+// Deal mirrors an OpenRTB pmp.deals entry
+type Deal struct {
+	ID string `json:"id"`
+}
+
+// Muted reports whether the request explicitly plays without sound
+func (r *AdRequest) Muted() bool {
+	return r.SoundOn != nil && !*r.SoundOn
+}
+
+// RequestedDeals returns the request's deal IDs from both deal_ids and
+// pmp.deals, de-duplicated
+func (r *AdRequest) RequestedDeals() []string {
+	seen := make(map[string]bool)
+	var deals []string
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			deals = append(deals, id)
+		}
+	}
+
+	for _, id := range r.DealIDs {
+		add(id)
+	}
+	if r.PMP != nil {
+		for _, deal := range r.PMP.Deals {
+			add(deal.ID)
+		}
+	}
+	return deals
 }
 
 // AdResponse represents the ad decision response
@@ -23,6 +89,53 @@ type AdResponse struct {
 	ClickURL    string    `json:"click_url"`   // Optional
 	TrackingURL string    `json:"tracking_url"` // For impression tracking
 	Timestamp   time.Time `json:"timestamp"`
+	Warnings    []string  `json:"warnings,omitempty"` // Fallbacks or degraded conditions
+	Decision    *Decision `json:"decision,omitempty"` // Only with ?transparency=true
+
+	PosterURL string `json:"poster_url,omitempty"` // Optional poster frame shown before playback
+	BrandID   string `json:"brand_id,omitempty"`   // Creative's brand, unique within a pod
+
+	// Ad metadata for players to display; empty when the creative has none
+	Title      string `json:"title"`
+	Advertiser string `json:"advertiser"`
+
+	ClearedCPM float64 `json:"cleared_cpm,omitempty"` // Second-price auction clearing price
+}
+
+// NoFillResponse is the 200 no-fill envelope for clients that can't handle
+// 204
+type NoFillResponse struct {
+	Filled bool   `json:"filled"` // Always false
+	Reason string `json:"reason"` // no_active_campaigns, no_eligible_campaigns, etc
+}
+
+// MinimalAdResponse is the ?profile=minimal rendering of an AdResponse for
+// clients on constrained networks: just enough to play and track the ad
+type MinimalAdResponse struct {
+	AdID        string `json:"ad_id"`
+	VideoURL    string `json:"video_url"`
+	Duration    int    `json:"duration"`
+	TrackingURL string `json:"tracking_url"`
+}
+
+// Minimal returns the minimal profile of the response
+func (r *AdResponse) Minimal() MinimalAdResponse {
+	return MinimalAdResponse{
+		AdID:        r.AdID,
+		VideoURL:    r.VideoURL,
+		Duration:    r.Duration,
+		TrackingURL: r.TrackingURL,
+	}
+}
+
+// Decision describes why an ad was selected, for transparency logs
+type Decision struct {
+	Strategy         string  `json:"strategy"`          // Campaign selection strategy
+	CandidateCount   int     `json:"candidate_count"`   // Active campaigns considered
+	EligibleCount    int     `json:"eligible_count"`    // Campaigns passing eligibility
+	SelectionWeight  float64 `json:"selection_weight"`  // Winner's share of the draw
+	CreativeStrategy string  `json:"creative_strategy"` // How the creative was chosen
+	RotationMode     string  `json:"rotation_mode"`     // Campaign's creative rotation mode
 }
 
 // ImpressionRequest represents an impression tracking request
@@ -31,6 +144,7 @@ type ImpressionRequest struct {
 	CampaignID      string    `json:"campaign_id" binding:"required"`
 	CreativeID      string    `json:"creative_id" binding:"required"`
 	DeviceID        string    `json:"device_id" binding:"required"`
+	HouseholdID     string    `json:"household_id"`
 	DeviceType      string    `json:"device_type"`
 	LocationCountry string    `json:"location_country"`
 	LocationRegion  string    `json:"location_region"`
@@ -40,9 +154,14 @@ type ImpressionRequest struct {
 	Timestamp       time.Time `json:"timestamp"`
 	Duration        int       `json:"duration"`  // How long the ad was watched (seconds)
 	Completed       bool      `json:"completed"` // Did the user watch the full ad?
+
+	AppID       string `json:"app_id"`       // Optional: with placement_id, the placement viewable is scored against
+	PlacementID string `json:"placement_id"` // Optional
+	Viewable    *bool  `json:"viewable"`     // Optional: viewability measurement, omitted when unmeasured
 }
 
-// Campaign represents campaign data in Redis
+// Campaign represents campaign data in Redis. ID, BudgetSpent and TenantID
+// are set by the server and ignored on writes.
 type Campaign struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -51,14 +170,180 @@ type Campaign struct {
 	BudgetSpent float64   `json:"budget_spent"`
 	StartDate   time.Time `json:"start_date"`
 	EndDate     time.Time `json:"end_date"`
+
+	BudgetDaily float64 `json:"budget_daily,omitempty"` // Optional: daily spend cap
+	BudgetUnit  string  `json:"budget_unit,omitempty"`  // Optional: dollars or cents
+	Currency    string  `json:"currency,omitempty"`     // Optional: defaults to the base currency
+	CPM         float64 `json:"cpm,omitempty"`          // Optional: charge per thousand impressions
+	Pacing      string  `json:"pacing,omitempty"`       // Optional: asap or even
+	TenantID    string  `json:"tenant_id,omitempty"`
 }
 
-// Creative represents creative data in Redis
+// Creative represents creative data in Redis. ID is set by the server, and
+// CampaignID only on creation.
 type Creative struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	VideoURL string `json:"video_url"`
-	Duration int    `json:"duration"`
-	Format   string `json:"format"`
-	Status   string `json:"status"`
+	ID         string `json:"id"`
+	CampaignID string `json:"campaign_id"`
+	Name       string `json:"name"`
+	VideoURL   string `json:"video_url"`
+	Duration   int    `json:"duration"`
+	Format     string `json:"format"`
+	Status     string `json:"status"`
+
+	ClickURL   string `json:"click_url,omitempty"`  // Optional: click-through landing page
+	PosterURL  string `json:"poster_url,omitempty"` // Optional: still shown before playback
+	Title      string `json:"title,omitempty"`      // Optional: title shown by players
+	Advertiser string `json:"advertiser,omitempty"` // Optional: advertiser name shown by players
+
+	// Optional: relative share of the campaign's rotation; unset counts as 1,
+	// while an explicit 0 keeps the creative out of the draw
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// CampaignPacing compares a campaign's delivery today against its even-pacing
+// daily target
+type CampaignPacing struct {
+	CampaignID            string  `json:"campaign_id"`
+	Date                  string  `json:"date"` // YYYY-MM-DD
+	ImpressionsToday      int64   `json:"impressions_today"`
+	SpendToday            float64 `json:"spend_today"`
+	DailyTarget           float64 `json:"daily_target"`                      // Spend target for today
+	DailyImpressionTarget int64   `json:"daily_impression_target,omitempty"` // From cpm, when set
+	ExpectedToDate        float64 `json:"expected_to_date"`                  // Target prorated to now
+	PaceRatio             float64 `json:"pace_ratio"`                        // 1.0 is on pace
+
+	Currency  string            `json:"currency"`
+	Formatted map[string]string `json:"formatted,omitempty"` // Localized amounts, when requested
+}
+
+// CampaignStats reports a campaign's live counters over a recent window
+type CampaignStats struct {
+	CampaignID  string `json:"campaign_id"`
+	WindowHours int    `json:"window_hours"`
+	Requests    int64  `json:"requests"`
+}
+
+// CreativeStats reports a creative's live counters over a recent window
+type CreativeStats struct {
+	CreativeID  string `json:"creative_id"`
+	CampaignID  string `json:"campaign_id"`
+	WindowHours int    `json:"window_hours"`
+	Impressions int64  `json:"impressions"`
+	Clicks      int64  `json:"clicks"`
+}
+
+// LatencySummary reports selection latency percentiles over the recent
+// in-process window
+type LatencySummary struct {
+	WindowSize int     `json:"window_size"` // Most recent requests kept
+	Samples    int     `json:"samples"`     // Requests currently in the window
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+}
+
+// DeviceHistory is a device's recent serves and current frequency counts,
+// for support investigations into ad repetition
+type DeviceHistory struct {
+	DeviceID    string              `json:"device_id"`
+	Served      []ServedAd          `json:"served"`      // Newest first, bounded
+	Impressions []DeviceImpressions `json:"impressions"` // Per campaign in Served
+}
+
+// ServedAd records one ad served to a device
+type ServedAd struct {
+	AdID       string    `json:"ad_id"`
+	CampaignID string    `json:"campaign_id"`
+	CreativeID string    `json:"creative_id"`
+	ServedAt   time.Time `json:"served_at"`
+}
+
+// DeviceImpressions is a device's impression count on a campaign in the
+// current frequency windows
+type DeviceImpressions struct {
+	CampaignID string `json:"campaign_id"`
+	Hour       int64  `json:"hour"`
+	Day        int64  `json:"day"`
+}
+
+// ClickRequest represents a click tracking request
+type ClickRequest struct {
+	AdID       string    `json:"ad_id" binding:"required"`
+	CampaignID string    `json:"campaign_id" binding:"required"`
+	CreativeID string    `json:"creative_id" binding:"required"`
+	DeviceID   string    `json:"device_id" binding:"required"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// TrackEventRequest reports a VAST playback event for a served ad
+type TrackEventRequest struct {
+	AdID       string `json:"ad_id" binding:"required"`
+	CreativeID string `json:"creative_id" binding:"required"`
+	Event      string `json:"event" binding:"required"` // start, firstQuartile, midpoint, thirdQuartile, complete
+}
+
+// CreativeErrorRequest reports a creative that failed to play
+type CreativeErrorRequest struct {
+	CreativeID string `json:"creative_id" binding:"required"`
+	AdID       string `json:"ad_id"`
+	ErrorCode  string `json:"error_code"` // VAST error code, e.g. 405
+}
+
+// AdPodRequest asks for an ad break of several ads
+type AdPodRequest struct {
+	AdRequest
+	PodDuration int `json:"pod_duration"` // Seconds available in the break
+	MaxAds      int `json:"max_ads"`      // Optional: defaults to the server maximum
+}
+
+// AdPodResponse is an ordered ad break
+type AdPodResponse struct {
+	Ads           []AdResponse `json:"ads"`
+	TotalDuration int          `json:"total_duration"` // seconds
+}
+
+// PreviewRequest asks for a creative's served response for QA
+type PreviewRequest struct {
+	CreativeID string `json:"creative_id" binding:"required"`
+	DeviceID   string `json:"device_id"` // Optional: fills the {device_id} tracking macro
+}
+
+// ImpressionResult is the outcome of tracking an impression
+type ImpressionResult struct {
+	DurationCheck *DurationCheck // Only with duration validation enabled
+	MakeGood      bool           // Campaign was exhausted; recorded against the make-good bucket
+	Duplicate     bool           // Ad ID already tracked; nothing was counted
+	Dropped       bool           // Queue full: charged, but counters and forward dropped
+	Sequence      int64          // Per-device confirmation number, the original's for a duplicate; 0 if unassigned
+}
+
+// DurationCheck is the outcome of validating an impression's reported watch
+// duration against the served creative
+type DurationCheck struct {
+	Reported         int    `json:"reported"`
+	CreativeDuration int    `json:"creative_duration"`
+	Accepted         int    `json:"accepted"` // Duration recorded after clamping
+	Flagged          bool   `json:"flagged"`
+	Reason           string `json:"reason,omitempty"` // exceeds_creative, negative
+}
+
+// LossEvent records an eligible campaign that lost a selection, for
+// competitive reporting
+type LossEvent struct {
+	AdID              string    `json:"ad_id"` // The winning ad
+	CampaignID        string    `json:"campaign_id"`
+	WinningCampaignID string    `json:"winning_campaign_id"`
+	Reason            string    `json:"reason"`                 // outbid or lower_priority
+	BidCPM            float64   `json:"bid_cpm,omitempty"`      // Loser's bid, in auctions
+	ClearingCPM       float64   `json:"clearing_cpm,omitempty"` // Winner's price, in auctions
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// LedgerEvent records one budget decrement for reconciliation
+type LedgerEvent struct {
+	CampaignID string    `json:"campaign_id"`
+	AdID       string    `json:"ad_id"`
+	Amount     float64   `json:"amount"`    // In currency units, whatever the budget unit
+	NewSpent   float64   `json:"new_spent"` // Campaign budget_spent after this decrement, in currency units
+	Timestamp  time.Time `json:"timestamp"`
 }
@@ -2,18 +2,71 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrNotFound is wrapped by lookups of campaigns or creatives that don't exist
+var ErrNotFound = errors.New("not found")
+
 type Client struct {
 	rdb *redis.Client
-	ctx context.Context
+
+	// Optional read replica for catalog reads; nil reads from the primary
+	replica *redis.Client
+
+	// Connection timeouts, shared by the replica
+	timeouts timeouts
 }
 
-func NewClient(addrAndPassword ...string) (*Client, error) {
+// timeouts are the dial, read and write timeouts of Redis connections
+type timeouts struct {
+	dial, read, write time.Duration
+}
+
+// defaultTimeouts are used by clients not built from a Config
+var defaultTimeouts = timeouts{dial: 5 * time.Second, read: 3 * time.Second, write: 3 * time.Second}
+
+// NewClient creates a client from cfg, checking connectivity first
+func NewClient(cfg *config.Config) (*Client, error) {
+	client := NewFromConfig(cfg)
+
+	// Test connection
+	if err := client.Ping(context.Background()); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// NewFromConfig creates a client from cfg without checking connectivity. With
+// sentinel addresses set the primary is discovered through Sentinel, and a
+// replica address routes catalog reads to a read replica.
+func NewFromConfig(cfg *config.Config) *Client {
+	t := timeouts{dial: cfg.RedisDialTimeout, read: cfg.RedisReadTimeout, write: cfg.RedisWriteTimeout}
+	client := &Client{timeouts: t}
+	if len(cfg.RedisSentinelAddrs) > 0 {
+		client.rdb = newFailoverRedisClient(failoverOptions(cfg.RedisMasterName, cfg.RedisSentinelAddrs, cfg.RedisPassword, t))
+	} else {
+		client.rdb = newRedisClient(cfg.RedisAddr, cfg.RedisPassword, t)
+	}
+	if cfg.RedisReplicaAddr != "" {
+		client.SetReplica(cfg.RedisReplicaAddr, cfg.RedisReplicaPassword)
+	}
+	return client
+}
+
+// New creates a client without checking connectivity; connections are made
+// lazily, so the caller can start up while Redis is still unreachable
+func New(addrAndPassword ...string) *Client {
 	addr := "localhost:6379"
 	password := ""
 
@@ -24,114 +77,1175 @@ func NewClient(addrAndPassword ...string) (*Client, error) {
 		password = addrAndPassword[1]
 	}
 
-	rdb := redis.NewClient(&redis.Options{
+	return &Client{
+		rdb:      newRedisClient(addr, password, defaultTimeouts),
+		timeouts: defaultTimeouts,
+	}
+}
+
+func newRedisClient(addr, password string, t timeouts) *redis.Client {
+	return redis.NewClient(&redis.Options{
 		Addr:         addr,
 		Password:     password,
 		DB:           0,
 		PoolSize:     100,
 		MinIdleConns: 10,
 		MaxRetries:   3,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
+		DialTimeout:  t.dial,
+		ReadTimeout:  t.read,
+		WriteTimeout: t.write,
 	})
+}
 
-	ctx := context.Background()
+// newFailoverRedisClient builds the Sentinel-backed client; tests swap it to
+// inspect the options without a running Sentinel
+var newFailoverRedisClient = redis.NewFailoverClient
 
-	// Test connection
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to ping Redis: %w", err)
+// NewFailoverClient creates a client that discovers the primary through Redis
+// Sentinel and follows it across failovers, checking connectivity first
+func NewFailoverClient(masterName string, sentinelAddrs []string, password string) (*Client, error) {
+	client := NewFailover(masterName, sentinelAddrs, password)
+
+	if err := client.Ping(context.Background()); err != nil {
+		client.Close()
+		return nil, err
 	}
 
+	return client, nil
+}
+
+// NewFailover creates a Sentinel-backed client without checking connectivity,
+// like New. Sentinel resolves to a regular client, so every method behaves
+// the same as against a single primary.
+func NewFailover(masterName string, sentinelAddrs []string, password string) *Client {
 	return &Client{
-		rdb: rdb,
-		ctx: ctx,
-	}, nil
+		rdb:      newFailoverRedisClient(failoverOptions(masterName, sentinelAddrs, password, defaultTimeouts)),
+		timeouts: defaultTimeouts,
+	}
+}
+
+// failoverOptions mirrors newRedisClient's pool and timeout settings for a
+// Sentinel-managed primary
+func failoverOptions(masterName string, sentinelAddrs []string, password string, t timeouts) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: sentinelAddrs,
+		Password:      password,
+		DB:            0,
+		PoolSize:      100,
+		MinIdleConns:  10,
+		MaxRetries:    3,
+		DialTimeout:   t.dial,
+		ReadTimeout:   t.read,
+		WriteTimeout:  t.write,
+	}
+}
+
+// SetReplica routes catalog reads (active campaigns, campaigns, creatives) to
+// a read replica. Writes always go to the primary. Reads fall back to the
+// primary when the replica fails, and the replica keeps (possibly stale)
+// reads serving while the primary is down.
+func (c *Client) SetReplica(addr, password string) {
+	c.replica = newRedisClient(addr, password, c.timeouts)
+}
+
+// read runs a catalog read against the replica, falling back to the primary
+// if the replica errors. redis.Nil is an answer, not a failure.
+func (c *Client) read(fn func(rdb *redis.Client) error) error {
+	if c.replica == nil {
+		return fn(c.rdb)
+	}
+	err := fn(c.replica)
+	if err == nil || errors.Is(err, redis.Nil) {
+		return err
+	}
+	log.Printf("Redis replica read failed, falling back to primary: %v", err)
+	return fn(c.rdb)
+}
+
+// Ping checks that Redis is reachable: the primary, or failing that the
+// replica, which can serve reads on its own
+func (c *Client) Ping(ctx context.Context) error {
+	err := c.rdb.Ping(ctx).Err()
+	if err != nil && c.replica != nil && c.replica.Ping(ctx).Err() == nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ping Redis: %w", err)
+	}
+	return nil
 }
 
 func (c *Client) Close() error {
+	if c.replica != nil {
+		c.replica.Close()
+	}
 	return c.rdb.Close()
 }
 
-func (c *Client) GetActiveCampaigns() ([]string, error) {
+func (c *Client) GetActiveCampaigns(ctx context.Context) ([]string, error) {
 	// Get all active campaigns from sorted set
 	// Sorted by remaining budget (score)
-	result, err := c.rdb.ZRange(c.ctx, "active_campaigns", 0, -1).Result()
+	var result []string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.ZRange(ctx, "active_campaigns", 0, -1).Result()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get active campaigns: %w", err)
 	}
 	return result, nil
 }
 
-func (c *Client) GetCampaign(campaignID string) (map[string]string, error) {
+// GetActiveCampaignBudgets returns the active campaign IDs in score order
+// with their remaining-budget scores
+func (c *Client) GetActiveCampaignBudgets(ctx context.Context) ([]string, map[string]float64, error) {
+	var result []redis.Z
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.ZRangeWithScores(ctx, "active_campaigns", 0, -1).Result()
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get active campaigns: %w", err)
+	}
+
+	campaignIDs := make([]string, 0, len(result))
+	budgets := make(map[string]float64, len(result))
+	for _, z := range result {
+		campaignID, _ := z.Member.(string)
+		campaignIDs = append(campaignIDs, campaignID)
+		budgets[campaignID] = z.Score
+	}
+	return campaignIDs, budgets, nil
+}
+
+// activeSnapshotScript reads active_campaigns with scores and every listed
+// campaign's hash in one atomic step. KEYS: active_campaigns. ARGV: campaign
+// key prefix. Returns flat {id, score, fields, ...} triples. The hash keys are
+// derived in the script, so this needs a non-cluster deployment.
+var activeSnapshotScript = redis.NewScript(`
+local entries = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+local result = {}
+for i = 1, #entries, 2 do
+	result[#result + 1] = entries[i]
+	result[#result + 1] = entries[i + 1]
+	result[#result + 1] = redis.call('HGETALL', ARGV[1] .. entries[i])
+end
+return result
+`)
+
+// GetActiveCampaignSnapshot returns the active campaign IDs in score order,
+// their remaining-budget scores and their campaign hashes, all read at the
+// same instant. A concurrent SpendBudget is seen either fully or not at all,
+// so scores and budget_spent always agree. Campaigns whose hash is gone are
+// omitted from the hashes.
+func (c *Client) GetActiveCampaignSnapshot(ctx context.Context) ([]string, map[string]float64, map[string]map[string]string, error) {
+	var result []interface{}
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = activeSnapshotScript.RunRO(ctx, rdb, []string{"active_campaigns"}, "campaign:").Slice()
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to snapshot active campaigns: %w", err)
+	}
+
+	n := len(result) / 3
+	campaignIDs := make([]string, 0, n)
+	budgets := make(map[string]float64, n)
+	campaigns := make(map[string]map[string]string, n)
+	for i := 0; i+2 < len(result); i += 3 {
+		campaignID, _ := result[i].(string)
+		score, _ := result[i+1].(string)
+		budgets[campaignID], _ = strconv.ParseFloat(score, 64)
+		campaignIDs = append(campaignIDs, campaignID)
+
+		pairs, _ := result[i+2].([]interface{})
+		if len(pairs) == 0 {
+			continue
+		}
+		fields := make(map[string]string, len(pairs)/2)
+		for j := 0; j+1 < len(pairs); j += 2 {
+			key, _ := pairs[j].(string)
+			value, _ := pairs[j+1].(string)
+			fields[key] = value
+		}
+		campaigns[campaignID] = fields
+	}
+	return campaignIDs, budgets, campaigns, nil
+}
+
+func (c *Client) GetCampaign(ctx context.Context, campaignID string) (map[string]string, error) {
 	key := fmt.Sprintf("campaign:%s", campaignID)
-	result, err := c.rdb.HGetAll(c.ctx, key).Result()
+	var result map[string]string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.HGetAll(ctx, key).Result()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get campaign: %w", err)
 	}
 	if len(result) == 0 {
-		return nil, fmt.Errorf("campaign not found: %s", campaignID)
+		return nil, fmt.Errorf("campaign %w: %s", ErrNotFound, campaignID)
+	}
+	return result, nil
+}
+
+// GetCampaigns fetches several campaign hashes in a single pipeline round
+// trip. Campaigns that don't exist are omitted from the result.
+func (c *Client) GetCampaigns(ctx context.Context, campaignIDs []string) (map[string]map[string]string, error) {
+	var result map[string]map[string]string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = c.getHashes(ctx, rdb, "campaign:%s", campaignIDs)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaigns: %w", err)
 	}
 	return result, nil
 }
 
-func (c *Client) GetCampaignCreatives(campaignID string) ([]string, error) {
+func (c *Client) GetCampaignCreatives(ctx context.Context, campaignID string) ([]string, error) {
 	key := fmt.Sprintf("campaign:%s:creatives", campaignID)
-	result, err := c.rdb.SMembers(c.ctx, key).Result()
+	var result []string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.SMembers(ctx, key).Result()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get campaign creatives: %w", err)
 	}
 	return result, nil
 }
 
-func (c *Client) GetRandomCreative(campaignID string) (string, error) {
+func (c *Client) GetRandomCreative(ctx context.Context, campaignID string) (string, error) {
 	key := fmt.Sprintf("campaign:%s:creatives", campaignID)
-	result, err := c.rdb.SRandMember(c.ctx, key).Result()
+	result, err := c.rdb.SRandMember(ctx, key).Result()
 	if err != nil {
 		return "", fmt.Errorf("failed to get random creative: %w", err)
 	}
 	return result, nil
 }
 
-func (c *Client) GetCreative(creativeID string) (map[string]string, error) {
+// GetRandomCreatives returns up to count distinct random creative IDs from the
+// campaign's creative set without loading the whole set
+func (c *Client) GetRandomCreatives(ctx context.Context, campaignID string, count int) ([]string, error) {
+	key := fmt.Sprintf("campaign:%s:creatives", campaignID)
+	var result []string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.SRandMemberN(ctx, key, int64(count)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get random creatives: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) GetCreative(ctx context.Context, creativeID string) (map[string]string, error) {
 	key := fmt.Sprintf("creative:%s", creativeID)
-	result, err := c.rdb.HGetAll(c.ctx, key).Result()
+	var result map[string]string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = rdb.HGetAll(ctx, key).Result()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get creative: %w", err)
 	}
 	if len(result) == 0 {
-		return nil, fmt.Errorf("creative not found: %s", creativeID)
+		return nil, fmt.Errorf("creative %w: %s", ErrNotFound, creativeID)
+	}
+	return result, nil
+}
+
+// GetCreatives fetches several creative hashes in a single pipeline round trip.
+// Creatives that don't exist are omitted from the result.
+func (c *Client) GetCreatives(ctx context.Context, creativeIDs []string) (map[string]map[string]string, error) {
+	var result map[string]map[string]string
+	err := c.read(func(rdb *redis.Client) (err error) {
+		result, err = c.getHashes(ctx, rdb, "creative:%s", creativeIDs)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get creatives: %w", err)
+	}
+	return result, nil
+}
+
+// GetCreativesPerformance fetches lifetime impression/completion counters for
+// several creatives in a single pipeline round trip. Creatives with no
+// recorded performance are omitted from the result.
+func (c *Client) GetCreativesPerformance(ctx context.Context, creativeIDs []string) (map[string]map[string]string, error) {
+	result, err := c.getHashes(ctx, c.rdb, "creative:%s:performance", creativeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get creatives performance: %w", err)
+	}
+	return result, nil
+}
+
+// getHashes pipelines HGETALL for each ID formatted into keyFormat, keyed by
+// ID and omitting empty hashes
+func (c *Client) getHashes(ctx context.Context, rdb *redis.Client, keyFormat string, ids []string) (map[string]map[string]string, error) {
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf(keyFormat, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]map[string]string, len(ids))
+	for i, cmd := range cmds {
+		if data := cmd.Val(); len(data) > 0 {
+			result[ids[i]] = data
+		}
 	}
 	return result, nil
 }
 
-func (c *Client) IncrementCampaignRequests(campaignID string) error {
+func (c *Client) IncrementCampaignRequests(ctx context.Context, campaignID string) error {
+	return c.IncrementCampaignRequestsBy(ctx, campaignID, 1)
+}
+
+// IncrementCampaignRequestsBy adds n to the hourly request counter, used when
+// counters are sampled and each counted event stands in for several
+func (c *Client) IncrementCampaignRequestsBy(ctx context.Context, campaignID string, n int64) error {
 	// Increment hourly request counter
 	hour := time.Now().Format("2006010215")
 	key := fmt.Sprintf("campaign:%s:requests:%s", campaignID, hour)
-	if err := c.rdb.Incr(c.ctx, key).Err(); err != nil {
+	if err := c.rdb.IncrBy(ctx, key, n).Err(); err != nil {
 		return fmt.Errorf("failed to increment campaign requests: %w", err)
 	}
 	// Set expiry to 25 hours to keep last 24 hours
-	c.rdb.Expire(c.ctx, key, 25*time.Hour)
+	c.rdb.Expire(ctx, key, 25*time.Hour)
 	return nil
 }
 
-func (c *Client) IncrementCreativeImpressions(creativeID string) error {
+func (c *Client) IncrementCreativeImpressions(ctx context.Context, creativeID string) error {
+	return c.IncrementCreativeImpressionsBy(ctx, creativeID, 1)
+}
+
+// IncrementCreativeImpressionsBy adds n to the hourly impression counter, used
+// when counters are sampled and each counted event stands in for several
+func (c *Client) IncrementCreativeImpressionsBy(ctx context.Context, creativeID string, n int64) error {
 	// Increment hourly impression counter
 	hour := time.Now().Format("2006010215")
 	key := fmt.Sprintf("creative:%s:impressions:%s", creativeID, hour)
-	if err := c.rdb.Incr(c.ctx, key).Err(); err != nil {
+	if err := c.rdb.IncrBy(ctx, key, n).Err(); err != nil {
 		return fmt.Errorf("failed to increment creative impressions: %w", err)
 	}
 	// Set expiry to 25 hours to keep last 24 hours
-	c.rdb.Expire(c.ctx, key, 25*time.Hour)
+	c.rdb.Expire(ctx, key, 25*time.Hour)
+	return nil
+}
+
+// IncrementCreativeClicks increments the creative's hourly click counter
+func (c *Client) IncrementCreativeClicks(ctx context.Context, creativeID string) error {
+	key := fmt.Sprintf("creative:%s:clicks:%s", creativeID, time.Now().Format("2006010215"))
+	if err := c.rdb.Incr(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to increment creative clicks: %w", err)
+	}
+	// Set expiry to 25 hours to keep last 24 hours
+	c.rdb.Expire(ctx, key, 25*time.Hour)
+	return nil
+}
+
+// IncrementCreativeEvent increments the creative's hourly counter for a
+// playback event (start, firstQuartile, ...)
+func (c *Client) IncrementCreativeEvent(ctx context.Context, creativeID, event string) error {
+	key := creativeEventKey(creativeID, event, time.Now())
+	if err := c.rdb.Incr(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to increment creative event: %w", err)
+	}
+	// Set expiry to 25 hours to keep last 24 hours
+	c.rdb.Expire(ctx, key, 25*time.Hour)
+	return nil
+}
+
+// GetCreativeEventCount returns the creative's count of a playback event for
+// the current hour
+func (c *Client) GetCreativeEventCount(ctx context.Context, creativeID, event string) (int64, error) {
+	count, err := c.rdb.Get(ctx, creativeEventKey(creativeID, event, time.Now())).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get creative event count: %w", err)
+	}
+	return count, nil
+}
+
+func creativeEventKey(creativeID, event string, now time.Time) string {
+	return fmt.Sprintf("creative:%s:event:%s:%s", creativeID, event, now.Format("2006010215"))
+}
+
+// GetCreativeClicks returns the creative's click count for the current hour
+func (c *Client) GetCreativeClicks(ctx context.Context, creativeID string) (int64, error) {
+	key := fmt.Sprintf("creative:%s:clicks:%s", creativeID, time.Now().Format("2006010215"))
+	count, err := c.rdb.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get creative clicks: %w", err)
+	}
+	return count, nil
+}
+
+// GetCampaignRequestCount sums the campaign's hourly request counters over
+// the last hours hours, the hour of now included
+func (c *Client) GetCampaignRequestCount(ctx context.Context, campaignID string, hours int, now time.Time) (int64, error) {
+	return c.sumHourlyCounters(ctx, "campaign:%s:requests:%s", campaignID, hours, now)
+}
+
+// GetCreativeImpressionCount sums the creative's hourly impression counters
+// over the last hours hours, the hour of now included
+func (c *Client) GetCreativeImpressionCount(ctx context.Context, creativeID string, hours int, now time.Time) (int64, error) {
+	return c.sumHourlyCounters(ctx, "creative:%s:impressions:%s", creativeID, hours, now)
+}
+
+// GetCreativeClickCount sums the creative's hourly click counters over the
+// last hours hours, the hour of now included
+func (c *Client) GetCreativeClickCount(ctx context.Context, creativeID string, hours int, now time.Time) (int64, error) {
+	return c.sumHourlyCounters(ctx, "creative:%s:clicks:%s", creativeID, hours, now)
+}
+
+// sumHourlyCounters reads the hourly buckets of a counter key format (taking
+// the entity ID and the YYYYMMDDHH hour) in one round trip and sums them.
+// Missing buckets count as zero.
+func (c *Client) sumHourlyCounters(ctx context.Context, format, id string, hours int, now time.Time) (int64, error) {
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, hours)
+	for _, key := range hourlyKeys(format, id, hours, now) {
+		cmds = append(cmds, pipe.Get(ctx, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to get hourly counters: %w", err)
+	}
+
+	var total int64
+	for _, cmd := range cmds {
+		if value, err := cmd.Int64(); err == nil {
+			total += value
+		}
+	}
+	return total, nil
+}
+
+// hourlyKeys returns the keys of the last hours hourly buckets, newest first
+func hourlyKeys(format, id string, hours int, now time.Time) []string {
+	keys := make([]string, 0, hours)
+	for i := 0; i < hours; i++ {
+		hour := now.Add(-time.Duration(i) * time.Hour).Format("2006010215")
+		keys = append(keys, fmt.Sprintf(format, id, hour))
+	}
+	return keys
+}
+
+// RecordCreativePerformance increments the creative's lifetime impression
+// counter and, for completed views, its completion counter
+func (c *Client) RecordCreativePerformance(ctx context.Context, creativeID string, completed bool) error {
+	key := fmt.Sprintf("creative:%s:performance", creativeID)
+	pipe := c.rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, "impressions", 1)
+	if completed {
+		pipe.HIncrBy(ctx, key, "completions", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record creative performance: %w", err)
+	}
+	return nil
+}
+
+// RecordPlacementViewability increments the placement's measured impression
+// counter and, for viewable impressions, its viewable counter
+func (c *Client) RecordPlacementViewability(ctx context.Context, placement string, viewable bool) error {
+	key := fmt.Sprintf("placement:%s:viewability", placement)
+	pipe := c.rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, "measured", 1)
+	if viewable {
+		pipe.HIncrBy(ctx, key, "viewable", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record placement viewability: %w", err)
+	}
+	return nil
+}
+
+// GetPlacementViewability returns a placement's measured and viewable
+// impression counts, both 0 when it has none
+func (c *Client) GetPlacementViewability(ctx context.Context, placement string) (measured, viewable int64, err error) {
+	var values []interface{}
+	err = c.read(func(rdb *redis.Client) error {
+		var err error
+		values, err = rdb.HMGet(ctx, fmt.Sprintf("placement:%s:viewability", placement), "measured", "viewable").Result()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get placement viewability: %w", err)
+	}
+	if s, ok := values[0].(string); ok {
+		measured, _ = strconv.ParseInt(s, 10, 64)
+	}
+	if s, ok := values[1].(string); ok {
+		viewable, _ = strconv.ParseInt(s, 10, 64)
+	}
+	return measured, viewable, nil
+}
+
+// Frequency cap windows, set per campaign via its frequency_window field
+const (
+	FrequencyWindowHour = "hour"
+	FrequencyWindowDay  = "day"
+)
+
+// frequencyKey returns the counter key for a subject's impressions on a
+// campaign in the window containing now
+func frequencyKey(subject, campaignID, window string, now time.Time) string {
+	bucket := now.Format("2006010215")
+	if window == FrequencyWindowDay {
+		bucket = now.Format("20060102")
+	}
+	return fmt.Sprintf("%s:campaign:%s:count:%s", subject, campaignID, bucket)
+}
+
+// GetFrequencyCount returns the current window's impression count for a
+// subject ("device:<id>" or "household:<id>") on a campaign
+func (c *Client) GetFrequencyCount(ctx context.Context, subject, campaignID, window string) (int64, error) {
+	key := frequencyKey(subject, campaignID, window, time.Now())
+	result, err := c.rdb.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get frequency count: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) IncrementFrequencyCount(ctx context.Context, subject, campaignID string) error {
+	// Increment the hourly and daily frequency counters for the device or
+	// household, so either window can be read without knowing the campaign's
+	now := time.Now()
+	hourKey := frequencyKey(subject, campaignID, FrequencyWindowHour, now)
+	dayKey := frequencyKey(subject, campaignID, FrequencyWindowDay, now)
+
+	pipe := c.rdb.TxPipeline()
+	pipe.Incr(ctx, hourKey)
+	pipe.Incr(ctx, dayKey)
+	// Only the current window is ever read
+	pipe.Expire(ctx, hourKey, 2*time.Hour)
+	pipe.Expire(ctx, dayKey, 48*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to increment frequency count: %w", err)
+	}
+	return nil
+}
+
+// GetCreativeServeCounts returns how many times the campaign has served each
+// creative, for even rotation. Creatives never served count as 0.
+func (c *Client) GetCreativeServeCounts(ctx context.Context, campaignID string, creativeIDs []string) (map[string]int64, error) {
+	key := fmt.Sprintf("campaign:%s:creative_serves", campaignID)
+	values, err := c.rdb.HMGet(ctx, key, creativeIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get creative serve counts: %w", err)
+	}
+
+	counts := make(map[string]int64, len(creativeIDs))
+	for i, value := range values {
+		if str, ok := value.(string); ok {
+			count, _ := strconv.ParseInt(str, 10, 64)
+			counts[creativeIDs[i]] = count
+		}
+	}
+	return counts, nil
+}
+
+func (c *Client) IncrementCreativeServes(ctx context.Context, campaignID, creativeID string) error {
+	key := fmt.Sprintf("campaign:%s:creative_serves", campaignID)
+	if err := c.rdb.HIncrBy(ctx, key, creativeID, 1).Err(); err != nil {
+		return fmt.Errorf("failed to increment creative serves: %w", err)
+	}
+	return nil
+}
+
+// NextCreativeSequence advances and returns the campaign's sequential
+// rotation counter (starting at 1)
+func (c *Client) NextCreativeSequence(ctx context.Context, campaignID string) (int64, error) {
+	key := fmt.Sprintf("campaign:%s:sequence", campaignID)
+	result, err := c.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to advance creative sequence: %w", err)
+	}
+	return result, nil
+}
+
+// spendBudgetScript atomically charges a campaign, capping the charge at the
+// remaining budget so budget_spent never passes budget_total, then updates
+// or (once exhausted) removes its active_campaigns entry and adds the charge
+// to today's delivery. KEYS: campaign hash, active_campaigns, daily hash.
+// ARGV: amount, campaign ID. Returns {budget_spent, charged} as strings.
+var spendBudgetScript = redis.NewScript(`
+local total = tonumber(redis.call('HGET', KEYS[1], 'budget_total') or '')
+if not total then
+	return redis.error_reply('campaign has no budget_total')
+end
+local spent = tonumber(redis.call('HGET', KEYS[1], 'budget_spent') or '0')
+local charged = math.min(tonumber(ARGV[1]), math.max(total - spent, 0))
+if charged > 0 then
+	spent = tonumber(redis.call('HINCRBYFLOAT', KEYS[1], 'budget_spent', charged))
+	redis.call('HINCRBYFLOAT', KEYS[3], 'spend', charged)
+	redis.call('EXPIRE', KEYS[3], 172800)
+end
+local remaining = total - spent
+if remaining <= 1e-9 then
+	redis.call('ZREM', KEYS[2], ARGV[2])
+else
+	redis.call('ZADD', KEYS[2], 'XX', remaining, ARGV[2])
+end
+return {tostring(spent), tostring(charged)}
+`)
+
+// SpendBudget charges amount against a campaign's budget in one atomic round
+// trip. It returns the new budget_spent and how much was actually charged,
+// which is less than amount when the budget ran out; an exhausted campaign
+// is removed from the active set.
+func (c *Client) SpendBudget(ctx context.Context, campaignID string, amount float64) (float64, float64, error) {
+	keys := []string{
+		fmt.Sprintf("campaign:%s", campaignID),
+		"active_campaigns",
+		campaignDailyKey(campaignID, time.Now()),
+	}
+	result, err := spendBudgetScript.Run(ctx, c.rdb, keys, amount, campaignID).StringSlice()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to spend campaign budget: %w", err)
+	}
+	spent, err := strconv.ParseFloat(result[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse budget_spent: %w", err)
+	}
+	charged, err := strconv.ParseFloat(result[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse charge: %w", err)
+	}
+	return spent, charged, nil
+}
+
+// campaignDailyKey is the hash holding a campaign's delivery for one day
+func campaignDailyKey(campaignID string, day time.Time) string {
+	return fmt.Sprintf("campaign:%s:daily:%s", campaignID, day.Format("20060102"))
+}
+
+// IncrementCampaignDailyImpressions increments today's impression count for a
+// campaign, for pacing
+func (c *Client) IncrementCampaignDailyImpressions(ctx context.Context, campaignID string) error {
+	key := campaignDailyKey(campaignID, time.Now())
+	if err := c.rdb.HIncrBy(ctx, key, "impressions", 1).Err(); err != nil {
+		return fmt.Errorf("failed to increment daily impressions: %w", err)
+	}
+	// Only today is ever read
+	c.rdb.Expire(ctx, key, 48*time.Hour)
+	return nil
+}
+
+// GetCampaignDailyDelivery returns a campaign's impressions and spend for the
+// given day. Days with no delivery return zeros.
+func (c *Client) GetCampaignDailyDelivery(ctx context.Context, campaignID string, day time.Time) (int64, float64, error) {
+	values, err := c.rdb.HMGet(ctx, campaignDailyKey(campaignID, day), "impressions", "spend").Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get daily delivery: %w", err)
+	}
+
+	var impressions int64
+	var spend float64
+	if v, ok := values[0].(string); ok {
+		impressions, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v, ok := values[1].(string); ok {
+		spend, _ = strconv.ParseFloat(v, 64)
+	}
+	return impressions, spend, nil
+}
+
+// GetCampaignsDailySpend returns each campaign's spend on the given day in
+// one round trip. Campaigns with no spend that day are omitted.
+func (c *Client) GetCampaignsDailySpend(ctx context.Context, campaignIDs []string, day time.Time) (map[string]float64, error) {
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(campaignIDs))
+	for i, campaignID := range campaignIDs {
+		cmds[i] = pipe.HGet(ctx, campaignDailyKey(campaignID, day), "spend")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get daily spend: %w", err)
+	}
+
+	spend := make(map[string]float64, len(campaignIDs))
+	for i, cmd := range cmds {
+		if value, err := cmd.Float64(); err == nil {
+			spend[campaignIDs[i]] = value
+		}
+	}
+	return spend, nil
+}
+
+func (c *Client) IncrementInvalidTraffic(ctx context.Context, reason string) error {
+	// Increment hourly invalid traffic (IVT) counter
+	hour := time.Now().Format("2006010215")
+	key := fmt.Sprintf("ivt:%s:%s", reason, hour)
+	if err := c.rdb.Incr(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to increment invalid traffic: %w", err)
+	}
+	// Set expiry to 25 hours to keep last 24 hours
+	c.rdb.Expire(ctx, key, 25*time.Hour)
 	return nil
 }
 
+// IncrementImpressionAnomaly counts impressions with implausible reported
+// measurements, by reason, per hour
+func (c *Client) IncrementImpressionAnomaly(ctx context.Context, reason string) error {
+	hour := time.Now().Format("2006010215")
+	key := fmt.Sprintf("anomaly:impression:%s:%s", reason, hour)
+	if err := c.rdb.Incr(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to increment impression anomaly: %w", err)
+	}
+	c.rdb.Expire(ctx, key, 25*time.Hour)
+	return nil
+}
+
+// GetImpressionAnomalies returns the current hour's anomaly count for reason
+func (c *Client) GetImpressionAnomalies(ctx context.Context, reason string) (int64, error) {
+	hour := time.Now().Format("2006010215")
+	key := fmt.Sprintf("anomaly:impression:%s:%s", reason, hour)
+	count, err := c.rdb.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get impression anomalies: %w", err)
+	}
+	return count, nil
+}
+
+// reserveBudgetScript atomically drops expired reservations and adds one for
+// the ad if spent + pending + all live reservations still fit the budget.
+// KEYS: campaign hash, reservations zset. ARGV: ad ID, cost, pending spend,
+// now (ms), expiry (ms), ttl (ms).
+var reserveBudgetScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[4])
+local total = tonumber(redis.call('HGET', KEYS[1], 'budget_total') or '0')
+local spent = tonumber(redis.call('HGET', KEYS[1], 'budget_spent') or '0')
+local held = redis.call('ZCARD', KEYS[2])
+local cost = tonumber(ARGV[2])
+if spent + tonumber(ARGV[3]) + (held + 1) * cost > total + 1e-9 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[5], ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[6])
+return 1
+`)
+
+// ReserveBudget holds cost against the campaign's budget for adID until the
+// impression arrives or ttl passes. It returns false if the budget can't
+// cover the reservation.
+func (c *Client) ReserveBudget(ctx context.Context, campaignID, adID string, cost, pending float64, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	keys := []string{
+		fmt.Sprintf("campaign:%s", campaignID),
+		fmt.Sprintf("campaign:%s:reservations", campaignID),
+	}
+	reserved, err := reserveBudgetScript.Run(ctx, c.rdb, keys,
+		adID, cost, pending, now.UnixMilli(), now.Add(ttl).UnixMilli(), ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve budget: %w", err)
+	}
+	return reserved == 1, nil
+}
+
+// ReleaseReservation drops an ad's budget reservation, once its impression
+// has been charged or the ad wasn't served
+func (c *Client) ReleaseReservation(ctx context.Context, campaignID, adID string) error {
+	key := fmt.Sprintf("campaign:%s:reservations", campaignID)
+	if err := c.rdb.ZRem(ctx, key, adID).Err(); err != nil {
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+	return nil
+}
+
+// QuarantineCreative excludes a creative from selection until ttl passes.
+// Reporting an already-quarantined creative extends its window.
+func (c *Client) QuarantineCreative(ctx context.Context, creativeID string, ttl time.Duration) error {
+	now := time.Now()
+	pipe := c.rdb.Pipeline()
+	pipe.ZRemRangeByScore(ctx, "quarantined_creatives", "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.ZAdd(ctx, "quarantined_creatives", redis.Z{
+		Score:  float64(now.Add(ttl).UnixMilli()),
+		Member: creativeID,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to quarantine creative: %w", err)
+	}
+	return nil
+}
+
+// RecordCreativeErrorReport records a reporter's failure report for a
+// creative and returns how many distinct reporters reported it within window.
+// A reporter reporting again only refreshes its own entry.
+func (c *Client) RecordCreativeErrorReport(ctx context.Context, creativeID, reporter string, window time.Duration) (int64, error) {
+	key := fmt.Sprintf("creative:%s:error_reports", creativeID)
+	now := time.Now()
+	pipe := c.rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: reporter})
+	reporters := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to record creative error report: %w", err)
+	}
+	return reporters.Val(), nil
+}
+
+// GetQuarantinedCreatives returns the creatives whose quarantine hasn't
+// expired
+func (c *Client) GetQuarantinedCreatives(ctx context.Context) (map[string]bool, error) {
+	ids, err := c.rdb.ZRangeByScore(ctx, "quarantined_creatives", &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined creatives: %w", err)
+	}
+
+	quarantined := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		quarantined[id] = true
+	}
+	return quarantined, nil
+}
+
+// IncrementNoFills counts a consecutive no-fill for a device and returns the
+// streak length. The streak lapses after window without requests.
+func (c *Client) IncrementNoFills(ctx context.Context, deviceID string, window time.Duration) (int64, error) {
+	key := fmt.Sprintf("device:%s:nofills", deviceID)
+	count, err := c.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment no-fills: %w", err)
+	}
+	c.rdb.Expire(ctx, key, window)
+	return count, nil
+}
+
+// ResetNoFills clears a device's no-fill streak after a fill
+func (c *Client) ResetNoFills(ctx context.Context, deviceID string) error {
+	return c.rdb.Del(ctx, fmt.Sprintf("device:%s:nofills", deviceID)).Err()
+}
+
+// NextImpressionSequence returns the device's next impression sequence
+// number, starting at 1. The counter restarts after ttl without impressions.
+func (c *Client) NextImpressionSequence(ctx context.Context, deviceID string, ttl time.Duration) (int64, error) {
+	key := fmt.Sprintf("device:%s:impression_seq", deviceID)
+	pipe := c.rdb.TxPipeline()
+	seq := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to increment impression sequence: %w", err)
+	}
+	return seq.Val(), nil
+}
+
+// MarkImpressionSeen records an impression's ad ID for ttl and reports
+// whether this is the first time it was seen. Otherwise it returns the
+// sequence stored for it by SetImpressionSequence, or 0 if none is yet.
+func (c *Client) MarkImpressionSeen(ctx context.Context, adID string, ttl time.Duration) (bool, int64, error) {
+	key := fmt.Sprintf("impression:%s:seen", adID)
+	first, err := c.rdb.SetNX(ctx, key, 0, ttl).Result()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to mark impression seen: %w", err)
+	}
+	if first {
+		return true, 0, nil
+	}
+
+	// The claim may expire between the two calls; it is still a duplicate
+	seq, err := c.rdb.Get(ctx, key).Int64()
+	if err != nil && err != redis.Nil {
+		return false, 0, fmt.Errorf("failed to get impression sequence: %w", err)
+	}
+	return false, seq, nil
+}
+
+// SetImpressionSequence stores the sequence assigned to a claimed impression,
+// keeping the claim's TTL. It does nothing once the claim is gone.
+func (c *Client) SetImpressionSequence(ctx context.Context, adID string, seq int64) error {
+	if err := c.rdb.SetXX(ctx, fmt.Sprintf("impression:%s:seen", adID), seq, redis.KeepTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set impression sequence: %w", err)
+	}
+	return nil
+}
+
+// ClearImpressionSeen forgets an impression's ad ID, so it can be tracked
+// again
+func (c *Client) ClearImpressionSeen(ctx context.Context, adID string) error {
+	if err := c.rdb.Del(ctx, fmt.Sprintf("impression:%s:seen", adID)).Err(); err != nil {
+		return fmt.Errorf("failed to clear impression seen: %w", err)
+	}
+	return nil
+}
+
+// idempotencyKey scopes a client idempotency key to the device that sent it
+func idempotencyKey(deviceID, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", deviceID, key)
+}
+
+// SetIdempotentResponse stores the response to an idempotent ad request,
+// keeping the first one stored if a concurrent request raced it
+func (c *Client) SetIdempotentResponse(ctx context.Context, deviceID, key string, payload []byte, ttl time.Duration) error {
+	if err := c.rdb.SetNX(ctx, idempotencyKey(deviceID, key), payload, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// GetIdempotentResponse returns the stored response for an idempotency key,
+// or "" when there is none
+func (c *Client) GetIdempotentResponse(ctx context.Context, deviceID, key string) (string, error) {
+	payload, err := c.rdb.Get(ctx, idempotencyKey(deviceID, key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get idempotent response: %w", err)
+	}
+	return payload, nil
+}
+
+// RecordMakeGood counts an impression diverted from an exhausted campaign to
+// a make-good bucket, with the value it would have been charged
+func (c *Client) RecordMakeGood(ctx context.Context, bucket, campaignID string, amount float64) error {
+	key := fmt.Sprintf("makegood:%s", bucket)
+	pipe := c.rdb.TxPipeline()
+	pipe.HIncrBy(ctx, key, campaignID+":impressions", 1)
+	pipe.HIncrByFloat(ctx, key, campaignID+":value", amount)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record make-good: %w", err)
+	}
+	return nil
+}
+
+// GetMakeGoodImpressions returns how many of a campaign's impressions were
+// diverted to the make-good bucket
+func (c *Client) GetMakeGoodImpressions(ctx context.Context, bucket, campaignID string) (int64, error) {
+	count, err := c.rdb.HGet(ctx, fmt.Sprintf("makegood:%s", bucket), campaignID+":impressions").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get make-good impressions: %w", err)
+	}
+	return count, nil
+}
+
+// PushDeviceServe prepends a served-ad record to a device's history, keeping
+// only the newest limit entries. The history lapses after ttl without serves.
+func (c *Client) PushDeviceServe(ctx context.Context, deviceID string, payload []byte, limit int64, ttl time.Duration) error {
+	key := fmt.Sprintf("device:%s:served", deviceID)
+	pipe := c.rdb.TxPipeline()
+	pipe.LPush(ctx, key, payload)
+	pipe.LTrim(ctx, key, 0, limit-1)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record device serve: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceServes returns a device's served-ad records, newest first
+func (c *Client) GetDeviceServes(ctx context.Context, deviceID string) ([]string, error) {
+	payloads, err := c.rdb.LRange(ctx, fmt.Sprintf("device:%s:served", deviceID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device serves: %w", err)
+	}
+	return payloads, nil
+}
+
+// CategoryServe is when a device was last served a competitive category,
+// and by which campaign
+type CategoryServe struct {
+	At         time.Time
+	CampaignID string
+}
+
+// MarkDeviceCategory records when, and by which campaign, a device was last
+// served a competitive category. The record lapses after ttl without serves.
+func (c *Client) MarkDeviceCategory(ctx context.Context, deviceID, category, campaignID string, at time.Time, ttl time.Duration) error {
+	key := fmt.Sprintf("device:%s:competitive_categories", deviceID)
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, key, category, fmt.Sprintf("%d:%s", at.Unix(), campaignID))
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to mark device category: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceCategories returns the last serve of each competitive category to
+// a device, keyed by category. Records written before the campaign was
+// stored have an empty CampaignID.
+func (c *Client) GetDeviceCategories(ctx context.Context, deviceID string) (map[string]CategoryServe, error) {
+	values, err := c.rdb.HGetAll(ctx, fmt.Sprintf("device:%s:competitive_categories", deviceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device categories: %w", err)
+	}
+	categories := make(map[string]CategoryServe, len(values))
+	for category, value := range values {
+		at, campaignID, _ := strings.Cut(value, ":")
+		if unix, err := strconv.ParseInt(at, 10, 64); err == nil {
+			categories[category] = CategoryServe{At: time.Unix(unix, 0), CampaignID: campaignID}
+		}
+	}
+	return categories, nil
+}
+
+// ImpressionStream is the Redis stream every tracked impression is appended
+// to, for consumers that need to replay them reliably
+const ImpressionStream = "impressions"
+
+// impressionStreamMaxLen bounds the stream; older entries are trimmed
+// (approximately, which is cheaper) once it grows past this
+const impressionStreamMaxLen = 1_000_000
+
+// PublishImpression appends an impression to the impressions stream,
+// returning its entry ID
+func (c *Client) PublishImpression(ctx context.Context, fields map[string]interface{}) (string, error) {
+	id, err := c.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: ImpressionStream,
+		MaxLen: impressionStreamMaxLen,
+		Approx: true,
+		Values: fields,
+	}).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to publish impression: %w", err)
+	}
+	return id, nil
+}
+
+// StreamEntry is an entry read back from a Redis stream
+type StreamEntry struct {
+	ID     string
+	Fields map[string]string
+}
+
+// ReadImpressions returns up to count impressions-stream entries from start
+// (an entry ID, or "-" for the oldest), oldest first
+func (c *Client) ReadImpressions(ctx context.Context, start string, count int64) ([]StreamEntry, error) {
+	messages, err := c.rdb.XRangeN(ctx, ImpressionStream, start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read impressions: %w", err)
+	}
+
+	entries := make([]StreamEntry, len(messages))
+	for i, message := range messages {
+		fields := make(map[string]string, len(message.Values))
+		for k, v := range message.Values {
+			fields[k] = fmt.Sprint(v)
+		}
+		entries[i] = StreamEntry{ID: message.ID, Fields: fields}
+	}
+	return entries, nil
+}
+
+// PushDeadLetter appends a payload that couldn't be delivered to the named
+// dead-letter queue for later replay
+func (c *Client) PushDeadLetter(ctx context.Context, queue string, payload []byte) error {
+	if err := c.rdb.RPush(ctx, "deadletter:"+queue, payload).Err(); err != nil {
+		return fmt.Errorf("failed to push dead letter: %w", err)
+	}
+	return nil
+}
+
+// GetDeadLetters returns the payloads in the named dead-letter queue
+func (c *Client) GetDeadLetters(ctx context.Context, queue string) ([]string, error) {
+	payloads, err := c.rdb.LRange(ctx, "deadletter:"+queue, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	return payloads, nil
+}
+
+// IncrementRateLimit counts a request against a fixed-window rate limit for
+// a scope ("ip", "device") and identifier, returning the count so far in the
+// current window
+func (c *Client) IncrementRateLimit(ctx context.Context, scope, id string, window time.Duration) (int64, error) {
+	bucket := time.Now().UnixNano() / int64(window)
+	key := fmt.Sprintf("ratelimit:%s:%s:%d", scope, id, bucket)
+	count, err := c.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment rate limit: %w", err)
+	}
+	// Only the current window is ever read
+	c.rdb.Expire(ctx, key, 2*window)
+	return count, nil
+}
+
+// SoftDeleteCampaign marks a campaign deleted, removes it from the active set
+// and records a tombstone so the reaper purges it after the retention window
+func (c *Client) SoftDeleteCampaign(ctx context.Context, campaignID string, retention time.Duration) error {
+	key := fmt.Sprintf("campaign:%s", campaignID)
+	exists, err := c.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check campaign: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("campaign %w: %s", ErrNotFound, campaignID)
+	}
+
+	now := time.Now()
+	pipe := c.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "status", "deleted", "deleted_at", now.Format(time.RFC3339))
+	pipe.ZRem(ctx, "active_campaigns", campaignID)
+	pipe.ZAdd(ctx, "campaign_tombstones", redis.Z{
+		Score:  float64(now.Add(retention).Unix()),
+		Member: campaignID,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to soft-delete campaign: %w", err)
+	}
+	return nil
+}
+
+// ReapTombstones permanently removes soft-deleted campaigns whose retention
+// window ended before now, along with their creative set and counters
+func (c *Client) ReapTombstones(ctx context.Context, now time.Time) ([]string, error) {
+	campaignIDs, err := c.rdb.ZRangeByScore(ctx, "campaign_tombstones", &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", now.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired tombstones: %w", err)
+	}
+
+	for _, campaignID := range campaignIDs {
+		keys := []string{fmt.Sprintf("campaign:%s", campaignID)}
+		iter := c.rdb.Scan(ctx, 0, fmt.Sprintf("campaign:%s:*", campaignID), 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign keys: %w", err)
+		}
+
+		if err := c.rdb.Del(ctx, keys...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to delete campaign: %w", err)
+		}
+		c.rdb.ZRem(ctx, "campaign_tombstones", campaignID)
+	}
+
+	return campaignIDs, nil
+}
+
 // Test helper methods
 
-func (c *Client) SetCampaign(campaignID string, data map[string]interface{}) error {
+func (c *Client) SetCampaign(ctx context.Context, campaignID string, data map[string]interface{}) error {
 	key := fmt.Sprintf("campaign:%s", campaignID)
 
 	// Convert map[string]interface{} to map[string]string for HSET
@@ -140,13 +1254,63 @@ func (c *Client) SetCampaign(campaignID string, data map[string]interface{}) err
 		stringData[k] = fmt.Sprintf("%v", v)
 	}
 
-	if err := c.rdb.HSet(c.ctx, key, stringData).Err(); err != nil {
+	if err := c.rdb.HSet(ctx, key, stringData).Err(); err != nil {
 		return fmt.Errorf("failed to set campaign: %w", err)
 	}
 	return nil
 }
 
-func (c *Client) SetCreative(creativeID, campaignID string, data map[string]interface{}) error {
+// saveCampaignScript writes campaign fields and syncs its active_campaigns
+// membership in one step: active campaigns with budget left are scored by
+// their remaining budget, anything else is removed. Soft-deleted campaigns,
+// and on update missing ones, are left untouched. KEYS: campaign hash,
+// active_campaigns. ARGV: campaign ID, "1" for an update, then field/value
+// pairs. Returns 1 if the campaign is in the active set, -1 if refused.
+var saveCampaignScript = redis.NewScript(`
+local exists = redis.call('EXISTS', KEYS[1]) == 1
+if (ARGV[2] == '1' and not exists) or redis.call('HGET', KEYS[1], 'status') == 'deleted' then
+	return -1
+end
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+local total = tonumber(redis.call('HGET', KEYS[1], 'budget_total') or '0') or 0
+local spent = tonumber(redis.call('HGET', KEYS[1], 'budget_spent') or '0') or 0
+if redis.call('HGET', KEYS[1], 'status') == 'active' and total - spent > 1e-9 then
+	redis.call('ZADD', KEYS[2], total - spent, ARGV[1])
+	return 1
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+return 0
+`)
+
+// SaveCampaign writes campaign fields and adds or removes the campaign from
+// active_campaigns to match its status and remaining budget, atomically. It
+// reports whether the campaign is now active. A soft-deleted campaign, or on
+// update one that no longer exists, is not written and wraps ErrNotFound, so
+// an update racing a delete can't resurrect it.
+func (c *Client) SaveCampaign(ctx context.Context, campaignID string, fields map[string]string, update bool) (bool, error) {
+	updateFlag := "0"
+	if update {
+		updateFlag = "1"
+	}
+	args := make([]interface{}, 0, 2+2*len(fields))
+	args = append(args, campaignID, updateFlag)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	keys := []string{fmt.Sprintf("campaign:%s", campaignID), "active_campaigns"}
+	active, err := saveCampaignScript.Run(ctx, c.rdb, keys, args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to save campaign: %w", err)
+	}
+	if active == -1 {
+		return false, fmt.Errorf("campaign %w: %s", ErrNotFound, campaignID)
+	}
+	return active == 1, nil
+}
+
+func (c *Client) SetCreative(ctx context.Context, creativeID, campaignID string, data map[string]interface{}) error {
 	// Set creative hash
 	creativeKey := fmt.Sprintf("creative:%s", creativeID)
 	stringData := make(map[string]string)
@@ -154,21 +1318,29 @@ func (c *Client) SetCreative(creativeID, campaignID string, data map[string]inte
 		stringData[k] = fmt.Sprintf("%v", v)
 	}
 
-	if err := c.rdb.HSet(c.ctx, creativeKey, stringData).Err(); err != nil {
+	if err := c.rdb.HSet(ctx, creativeKey, stringData).Err(); err != nil {
 		return fmt.Errorf("failed to set creative: %w", err)
 	}
 
 	// Add to campaign's creatives set
 	campaignCreativesKey := fmt.Sprintf("campaign:%s:creatives", campaignID)
-	if err := c.rdb.SAdd(c.ctx, campaignCreativesKey, creativeID).Err(); err != nil {
+	if err := c.rdb.SAdd(ctx, campaignCreativesKey, creativeID).Err(); err != nil {
 		return fmt.Errorf("failed to add creative to campaign set: %w", err)
 	}
 
 	return nil
 }
 
-func (c *Client) AddActiveCampaign(campaignID string, score float64) error {
-	if err := c.rdb.ZAdd(c.ctx, "active_campaigns", redis.Z{
+func (c *Client) SetCreativePerformance(ctx context.Context, creativeID string, impressions, completions int64) error {
+	key := fmt.Sprintf("creative:%s:performance", creativeID)
+	if err := c.rdb.HSet(ctx, key, "impressions", impressions, "completions", completions).Err(); err != nil {
+		return fmt.Errorf("failed to set creative performance: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) AddActiveCampaign(ctx context.Context, campaignID string, score float64) error {
+	if err := c.rdb.ZAdd(ctx, "active_campaigns", redis.Z{
 		Score:  score,
 		Member: campaignID,
 	}).Err(); err != nil {
@@ -177,21 +1349,80 @@ func (c *Client) AddActiveCampaign(campaignID string, score float64) error {
 	return nil
 }
 
-func (c *Client) DeleteCampaign(campaignID string) error {
+func (c *Client) DeleteCampaign(ctx context.Context, campaignID string) error {
 	key := fmt.Sprintf("campaign:%s", campaignID)
-	return c.rdb.Del(c.ctx, key).Err()
+	return c.rdb.Del(ctx, key).Err()
 }
 
-func (c *Client) DeleteCreative(creativeID, campaignID string) error {
+// DeleteCreative removes a creative and drops it from its campaign's
+// creative set in one transaction
+func (c *Client) DeleteCreative(ctx context.Context, creativeID, campaignID string) error {
 	creativeKey := fmt.Sprintf("creative:%s", creativeID)
 	campaignCreativesKey := fmt.Sprintf("campaign:%s:creatives", campaignID)
 
-	c.rdb.Del(c.ctx, creativeKey)
-	c.rdb.SRem(c.ctx, campaignCreativesKey, creativeID)
-
+	pipe := c.rdb.TxPipeline()
+	pipe.Del(ctx, creativeKey, creativeKey+":performance")
+	pipe.SRem(ctx, campaignCreativesKey, creativeID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete creative: %w", err)
+	}
 	return nil
 }
 
-func (c *Client) RemoveActiveCampaign(campaignID string) error {
-	return c.rdb.ZRem(c.ctx, "active_campaigns", campaignID).Err()
+// reconcileActiveCampaignScript removes stale active_campaigns members and
+// rescores or removes the canonical one from the campaign hash as it is now,
+// so spend or a status change since the caller's read isn't undone. A missing
+// hash is left alone for the tombstone reaper.
+var reconcileActiveCampaignScript = redis.NewScript(`
+for i = 2, #ARGV do
+	redis.call('ZREM', KEYS[2], ARGV[i])
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {-1, '0'}
+end
+local total = tonumber(redis.call('HGET', KEYS[1], 'budget_total') or '0') or 0
+local spent = tonumber(redis.call('HGET', KEYS[1], 'budget_spent') or '0') or 0
+local remaining = total - spent
+if redis.call('HGET', KEYS[1], 'status') == 'active' and remaining > 1e-9 then
+	redis.call('ZADD', KEYS[2], remaining, ARGV[1])
+	return {1, tostring(remaining)}
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+return {0, tostring(remaining)}
+`)
+
+// ReconcileActiveCampaign removes stale active_campaigns members for a
+// campaign and, atomically with re-reading its hash, scores it by remaining
+// budget if it is active and has budget left or removes it otherwise. It
+// returns whether the campaign is now in the set and its remaining budget.
+func (c *Client) ReconcileActiveCampaign(ctx context.Context, campaignID string, stale []string) (bool, float64, error) {
+	args := make([]interface{}, 0, 1+len(stale))
+	args = append(args, campaignID)
+	for _, member := range stale {
+		args = append(args, member)
+	}
+	keys := []string{fmt.Sprintf("campaign:%s", campaignID), "active_campaigns"}
+	result, err := reconcileActiveCampaignScript.Run(ctx, c.rdb, keys, args...).Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to reconcile active campaign: %w", err)
+	}
+	active, _ := result[0].(int64)
+	remaining, _ := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	return active == 1, remaining, nil
+}
+
+func (c *Client) RemoveActiveCampaign(ctx context.Context, campaignID string) error {
+	return c.rdb.ZRem(ctx, "active_campaigns", campaignID).Err()
+}
+
+// GetCounter reads an integer counter key, returning 0 if it doesn't exist
+func (c *Client) GetCounter(ctx context.Context, key string) (int64, error) {
+	result, err := c.rdb.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get counter: %w", err)
+	}
+	return result, nil
 }
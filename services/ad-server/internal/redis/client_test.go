@@ -0,0 +1,167 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewFailover_PassesSentinelOptions(t *testing.T) {
+	var got *redis.FailoverOptions
+	orig := newFailoverRedisClient
+	newFailoverRedisClient = func(opts *redis.FailoverOptions) *redis.Client {
+		got = opts
+		return orig(opts)
+	}
+	defer func() { newFailoverRedisClient = orig }()
+
+	addrs := []string{"sentinel-1:26379", "sentinel-2:26379"}
+	client := NewFailover("ads-primary", addrs, "secret")
+	defer client.Close()
+
+	if got == nil {
+		t.Fatal("Expected failover client to be constructed")
+	}
+	if got.MasterName != "ads-primary" {
+		t.Errorf("Expected master name ads-primary, got %s", got.MasterName)
+	}
+	if !reflect.DeepEqual(got.SentinelAddrs, addrs) {
+		t.Errorf("Expected sentinel addrs %v, got %v", addrs, got.SentinelAddrs)
+	}
+	if got.Password != "secret" {
+		t.Errorf("Expected password to be passed through, got %q", got.Password)
+	}
+
+	// Pool and timeouts match the single-primary client
+	if got.PoolSize != 100 || got.MinIdleConns != 10 || got.MaxRetries != 3 {
+		t.Errorf("Unexpected pool settings: %+v", got)
+	}
+	if got.DialTimeout != 5*time.Second || got.ReadTimeout != 3*time.Second || got.WriteTimeout != 3*time.Second {
+		t.Errorf("Unexpected timeouts: %+v", got)
+	}
+	if client.rdb == nil {
+		t.Error("Expected client to wrap the failover client")
+	}
+}
+
+func TestNewFromConfig_UsesSentinelAndTimeouts(t *testing.T) {
+	var got *redis.FailoverOptions
+	orig := newFailoverRedisClient
+	newFailoverRedisClient = func(opts *redis.FailoverOptions) *redis.Client {
+		got = opts
+		return orig(opts)
+	}
+	defer func() { newFailoverRedisClient = orig }()
+
+	cfg, err := config.LoadFrom(map[string]string{
+		"REDIS_SENTINEL_ADDRS":   "sentinel-1:26379",
+		"REDIS_MASTER_NAME":      "ads-primary",
+		"REDIS_REPLICA_ADDR":     "127.0.0.1:1",
+		"REDIS_DIAL_TIMEOUT_MS":  "100",
+		"REDIS_READ_TIMEOUT_MS":  "200",
+		"REDIS_WRITE_TIMEOUT_MS": "300",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewFromConfig(cfg)
+	defer client.Close()
+
+	if got == nil || got.MasterName != "ads-primary" {
+		t.Fatalf("Expected a Sentinel client for ads-primary, got %+v", got)
+	}
+	if got.DialTimeout != 100*time.Millisecond || got.ReadTimeout != 200*time.Millisecond || got.WriteTimeout != 300*time.Millisecond {
+		t.Errorf("Expected configured timeouts, got %+v", got)
+	}
+	if client.replica == nil {
+		t.Fatal("Expected the replica to be set")
+	}
+	if opts := client.replica.Options(); opts.ReadTimeout != 200*time.Millisecond {
+		t.Errorf("Expected the replica to share the timeouts, got %v", opts.ReadTimeout)
+	}
+}
+
+func TestHourlyKeys(t *testing.T) {
+	now := time.Date(2025, 10, 1, 1, 30, 0, 0, time.UTC)
+	got := hourlyKeys("campaign:%s:requests:%s", "c1", 3, now)
+	want := []string{
+		"campaign:c1:requests:2025100101",
+		"campaign:c1:requests:2025100100",
+		"campaign:c1:requests:2025093023",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestHourlyCounterSums(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	addr := os.Getenv("REDIS_TEST_URL")
+	if addr == "" {
+		addr = "localhost:6380"
+	}
+	cfg, err := config.LoadFrom(map[string]string{"REDIS_ADDR": addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	id := "stats-test-" + now.Format("150405.000000000")
+
+	// Buckets at 0, 1 and 5 hours ago fall in a 24 hour window; the one 24
+	// hours ago does not
+	seed := map[time.Duration]int64{0: 4, time.Hour: 3, 5 * time.Hour: 2, 24 * time.Hour: 100}
+	var keys []string
+	for _, format := range []string{"campaign:%s:requests:%s", "creative:%s:impressions:%s", "creative:%s:clicks:%s"} {
+		for ago, n := range seed {
+			key := hourlyKeys(format, id, 1, now.Add(-ago))[0]
+			if err := client.rdb.Set(ctx, key, n, time.Minute).Err(); err != nil {
+				t.Fatalf("Failed to seed %s: %v", key, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	defer client.rdb.Del(ctx, keys...)
+
+	getters := map[string]func(context.Context, string, int, time.Time) (int64, error){
+		"requests":    client.GetCampaignRequestCount,
+		"impressions": client.GetCreativeImpressionCount,
+		"clicks":      client.GetCreativeClickCount,
+	}
+	for name, get := range getters {
+		total, err := get(ctx, id, 24, now)
+		if err != nil {
+			t.Fatalf("Expected no error getting %s, got: %v", name, err)
+		}
+		if total != 9 {
+			t.Errorf("Expected 9 %s over 24 hours, got %d", name, total)
+		}
+
+		total, err = get(ctx, id, 2, now)
+		if err != nil {
+			t.Fatalf("Expected no error getting %s, got: %v", name, err)
+		}
+		if total != 7 {
+			t.Errorf("Expected 7 %s over 2 hours, got %d", name, total)
+		}
+	}
+
+	total, err := client.GetCampaignRequestCount(ctx, "missing-"+id, 24, now)
+	if err != nil || total != 0 {
+		t.Errorf("Expected 0 requests without buckets, got %d (err %v)", total, err)
+	}
+}
@@ -0,0 +1,81 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/vast"
+)
+
+// Built-in platforms
+const (
+	PlatformJSONV1 = "json-v1" // The AdResponse as JSON (the fallback)
+	PlatformVAST4  = "vast-4"  // VAST 4.0 XML
+)
+
+// Renderer turns an ad response into the body a platform expects. Supporting
+// a new platform format means implementing it and registering it.
+type Renderer interface {
+	ContentType() string
+	Render(resp *models.AdResponse) ([]byte, error)
+}
+
+var (
+	mu        sync.RWMutex
+	renderers = make(map[string]Renderer)
+)
+
+func init() {
+	Register(PlatformJSONV1, jsonV1{})
+	Register(PlatformVAST4, vast4{})
+}
+
+// Register makes a renderer available for a platform hint (matched
+// case-insensitively). Registering a platform twice panics.
+func Register(platform string, renderer Renderer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := strings.ToLower(strings.TrimSpace(platform))
+	if _, exists := renderers[key]; exists {
+		panic(fmt.Sprintf("render: platform %q registered twice", platform))
+	}
+	renderers[key] = renderer
+}
+
+// For returns the renderer registered for a platform hint, falling back to
+// json-v1 for empty or unknown platforms
+func For(platform string) Renderer {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if renderer, ok := renderers[strings.ToLower(strings.TrimSpace(platform))]; ok {
+		return renderer
+	}
+	return renderers[PlatformJSONV1]
+}
+
+// jsonV1 renders the AdResponse as JSON
+type jsonV1 struct{}
+
+func (jsonV1) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (jsonV1) Render(resp *models.AdResponse) ([]byte, error) {
+	return json.Marshal(resp)
+}
+
+// vast4 renders the AdResponse as a VAST 4.0 document
+type vast4 struct{}
+
+func (vast4) ContentType() string {
+	return vast.ContentType
+}
+
+func (vast4) Render(resp *models.AdResponse) ([]byte, error) {
+	return vast.Marshal(vast.FromAdResponse(resp))
+}
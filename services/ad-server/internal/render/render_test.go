@@ -0,0 +1,86 @@
+package render
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/vast"
+)
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		platform string
+		want     Renderer
+	}{
+		{"json-v1", jsonV1{}},
+		{"vast-4", vast4{}},
+		{" VAST-4 ", vast4{}},
+		{"", jsonV1{}},
+		{"roku-simid", jsonV1{}},
+	}
+	for _, tt := range tests {
+		if got := For(tt.platform); got != tt.want {
+			t.Errorf("Platform %q: expected %T, got %T", tt.platform, tt.want, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	custom := jsonV1{}
+	Register("test-platform", custom)
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(renderers, "test-platform")
+		mu.Unlock()
+	})
+
+	if got := For("Test-Platform"); got != custom {
+		t.Errorf("Expected the registered renderer, got %T", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a platform twice to panic")
+		}
+	}()
+	Register("test-platform", vast4{})
+}
+
+func TestRenderers(t *testing.T) {
+	resp := &models.AdResponse{
+		AdID:        "ad-1",
+		CampaignID:  "campaign-1",
+		CreativeID:  "creative-1",
+		VideoURL:    "https://cdn.example.com/ad.mp4",
+		Duration:    30,
+		Format:      "mp4",
+		TrackingURL: "https://track.example.com/impression",
+	}
+
+	body, err := For(PlatformJSONV1).Render(resp)
+	if err != nil {
+		t.Fatalf("Failed to render json-v1: %v", err)
+	}
+	var decoded models.AdResponse
+	if err := json.Unmarshal(body, &decoded); err != nil || decoded.AdID != "ad-1" {
+		t.Errorf("Expected json-v1 to be the AdResponse as JSON, got %s (%v)", body, err)
+	}
+
+	renderer := For(PlatformVAST4)
+	if renderer.ContentType() != vast.ContentType {
+		t.Errorf("Expected content type %s, got %s", vast.ContentType, renderer.ContentType())
+	}
+	body, err = renderer.Render(resp)
+	if err != nil {
+		t.Fatalf("Failed to render vast-4: %v", err)
+	}
+	var doc vast.VAST
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v", err)
+	}
+	if doc.Version != vast.Version {
+		t.Errorf("Expected VAST %s, got %s", vast.Version, doc.Version)
+	}
+}
@@ -2,178 +2,897 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
 	"github.com/fanwu/ad-server/internal/models"
 	"github.com/fanwu/ad-server/internal/redis"
 	"github.com/google/uuid"
 )
 
+// No-fill outcomes of SelectAd
+var (
+	ErrNoActiveCampaigns   = errors.New("no active campaigns available")
+	ErrNoEligibleCampaigns = errors.New("no eligible campaigns found")
+)
+
+// ErrBackendUnavailable wraps failures reading campaigns from Redis, so an
+// outage isn't mistaken for a no-fill
+var ErrBackendUnavailable = errors.New("ad backend unavailable")
+
+// errNoServableCreative means none of a campaign's creatives can play for the
+// request (inactive, quarantined, too long, needing sound on a muted
+// placement, and so on)
+var errNoServableCreative = errors.New("no servable creative")
+
+// NoFillReason maps a SelectAd error to a short reason for logs and traces
+func NoFillReason(err error) string {
+	switch {
+	case errors.Is(err, ErrBackendUnavailable):
+		return "backend_unavailable"
+	case errors.Is(err, ErrInvalidTraffic):
+		return "invalid_traffic"
+	case errors.Is(err, ErrGeoUnresolved):
+		return "geo_unresolved"
+	case errors.Is(err, ErrNoActiveCampaigns):
+		return "no_active_campaigns"
+	case errors.Is(err, ErrNoEligibleCampaigns):
+		return "no_eligible_campaigns"
+	default:
+		return "error"
+	}
+}
+
 type AdService struct {
 	redis         *redis.Client
 	httpClient    *http.Client
 	apiGatewayURL string
+	rng           *lockedRand
+
+	// Fraction of request/impression events written to the hourly counters.
+	// Sampled increments are scaled up so totals stay approximately correct.
+	counterSampleRate float64
+
+	// Number of creative IDs sampled per selection so very large creative
+	// sets are never loaded in full
+	creativeSampleSize int
+
+	// Campaigns ramp from rampUpMinFraction to full selection weight over
+	// rampUpWindow after start_date. A zero window disables ramp-up.
+	rampUpWindow      time.Duration
+	rampUpMinFraction float64
+
+	// Campaign budgets in other currencies are normalized to baseCurrency
+	// (the currency CPMs are quoted in) using fxRates
+	baseCurrency string
+	fxRates      fxTable
+
+	// Creatives created within freshnessWindow get their weight multiplied by
+	// up to freshnessBoost, decaying linearly. A zero window disables it.
+	freshnessWindow time.Duration
+	freshnessBoost  float64
+
+	// Impression spend is buffered and flushed every spendFlushInterval or
+	// after spendFlushThreshold impressions. A zero interval writes through.
+	spendFlushInterval  time.Duration
+	spendFlushThreshold int
+	spendBuffer         *spendBuffer
+
+	// Receives a ledger event per budget decrement
+	ledgerSink LedgerSink
+
+	// Receives a loss event per losing eligible campaign for lossSampleRate
+	// of selections (0 disables)
+	lossSink       LossSink
+	lossSampleRate float64
+	lossPool       *workerPool
+
+	// Hold budget for selected ads on campaigns near their limit until the
+	// impression arrives or reservationTTL passes
+	budgetReservations bool
+	reservationTTL     time.Duration
+
+	// Retry-After delay for consecutive no-fills, doubling from base to max
+	noFillBackoffBase time.Duration
+	noFillBackoffMax  time.Duration
+
+	// Upper bounds on ad pod requests
+	maxPodDuration int
+	maxPodAds      int
+
+	// How long a creative reported as failing is excluded from selection,
+	// once creativeErrorThreshold distinct reporters reported it within
+	// creativeErrorWindow. Each reporter may send creativeErrorRateLimit
+	// reports per rate limit window.
+	quarantineTTL          time.Duration
+	creativeErrorThreshold int
+	creativeErrorWindow    time.Duration
+	creativeErrorRateLimit int64
+
+	// Fixed-window request limits per device and per client IP (0 disables),
+	// on every other API request per client IP, and on tracking beacons per
+	// client IP
+	deviceRateLimit   int64
+	ipRateLimit       int64
+	clientRateLimit   int64
+	trackingRateLimit int64
+	rateLimitWindow   time.Duration
+
+	// How long soft-deleted campaigns are retained before reaping
+	tombstoneRetention time.Duration
+
+	// video_url schemes allowed at write and serve time
+	creativeURLSchemes []string
+
+	// Creative formats accepted at write time
+	creativeFormats []string
+
+	// Creatives whose completion rate falls below minCompletionRate are
+	// excluded once they have at least minPerformanceSample impressions.
+	// A zero rate disables the check.
+	minCompletionRate    float64
+	minPerformanceSample int64
+
+	// Placements need minViewabilitySample measured impressions before their
+	// viewable rate is checked against campaigns' min_viewability
+	minViewabilitySample int64
+
+	// Geo targeting: request IPs are resolved to countries by geoResolver;
+	// geoFallback decides what unresolved requests may be served
+	geoResolver       GeoResolver
+	geoFallback       string
+	geoDefaultCountry string
+
+	// Reported impression durations are checked against the creative's
+	// length, allowing durationTolerance seconds of overrun (opt-in)
+	durationValidation bool
+	durationTolerance  int
+
+	// How campaigns are chosen among the eligible: weighted_random or
+	// second_price_auction
+	selectionStrategy string
+
+	// Derive each request's random draws from its device, app and time
+	// bucket so every instance selects the same ad (for canary comparison)
+	deterministicSelection bool
+	deterministicBucket    time.Duration
+
+	// Invalid traffic filtering (opt-in)
+	botFilterEnabled   bool
+	botSignatures      []string
+	deviceIDValidation bool
+	deviceIDSentinels  []string
+
+	// Campaign hashes cached in process for CAMPAIGN_CACHE_TTL_MS; zero
+	// disables caching
+	campaignCache *campaignCache
+
+	// Impressions for campaigns exhausted since selection are recorded
+	// against this make-good bucket instead of charged; empty disables it
+	makeGoodBucket string
+
+	// Bounded pool running impression counters and gateway forwards
+	impressionPool *workerPool
+
+	// Short-circuits gateway forwards after consecutive failures
+	gatewayBreaker *circuitBreaker
+
+	// Backoff for gateway forwards failing with network errors or 5xx
+	gatewayRetry retryPolicy
+
+	// A device isn't served two campaigns from the same competitive category
+	// within this window (pods are always separated); zero disables it
+	competitiveSeparationWindow time.Duration
+
+	// How long an ad request's Idempotency-Key replays the selected ad
+	idempotencyTTL time.Duration
+
+	// Unit budget fields are stored in for campaigns without a budget_unit
+	budgetUnit string
+
+	// Served in place of a no-fill; nil keeps the 204
+	houseAd *houseAd
 }
 
-func NewAdService(redisClient *redis.Client) *AdService {
-	apiGatewayURL := os.Getenv("API_GATEWAY_URL")
-	if apiGatewayURL == "" {
-		apiGatewayURL = "http://localhost:3000"
+// NewAdService creates the service with settings from cfg
+func NewAdService(redisClient *redis.Client, cfg *config.Config) *AdService {
+	httpClient := &http.Client{
+		Timeout: cfg.GatewayTimeout,
 	}
 
 	return &AdService{
-		redis: redisClient,
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
+		redis:              redisClient,
+		httpClient:         httpClient,
+		apiGatewayURL:      cfg.APIGatewayURL,
+		rng:                newLockedRand(time.Now().UnixNano()),
+		counterSampleRate:  cfg.Float("COUNTER_SAMPLE_RATE", 1),
+		creativeSampleSize: cfg.Int("CREATIVE_SAMPLE_SIZE", defaultCreativeSampleSize),
+		freshnessWindow:    time.Duration(cfg.Float("CREATIVE_FRESHNESS_HOURS", 0) * float64(time.Hour)),
+		freshnessBoost:     cfg.Float("CREATIVE_FRESHNESS_BOOST", defaultFreshnessBoost),
+		tombstoneRetention: time.Duration(cfg.Int("TOMBSTONE_RETENTION_HOURS", defaultTombstoneRetentionHours)) * time.Hour,
+		creativeURLSchemes: cfg.List("CREATIVE_URL_SCHEMES", defaultCreativeURLSchemes),
+		creativeFormats:    cfg.List("CREATIVE_FORMATS", defaultCreativeFormats),
+		rampUpWindow:       time.Duration(cfg.Float("RAMP_UP_HOURS", 0) * float64(time.Hour)),
+		rampUpMinFraction:  cfg.Float("RAMP_UP_MIN_FRACTION", defaultRampUpMinFraction),
+		baseCurrency:       strings.ToUpper(cfg.String("BASE_CURRENCY", defaultBaseCurrency)),
+		fxRates:            parseFXRates(cfg.Get("FX_RATES")),
+
+		spendFlushInterval:  time.Duration(cfg.Int("SPEND_FLUSH_INTERVAL_MS", 0)) * time.Millisecond,
+		spendFlushThreshold: cfg.Int("SPEND_FLUSH_THRESHOLD", defaultSpendFlushThreshold),
+		spendBuffer:         newSpendBuffer(),
+
+		ledgerSink: &httpLedgerSink{
+			client: httpClient,
+			url:    fmt.Sprintf("%s/api/v1/ledger", cfg.APIGatewayURL),
+		},
+
+		lossSink: &httpLossSink{
+			client: httpClient,
+			url:    fmt.Sprintf("%s/api/v1/loss-events", cfg.APIGatewayURL),
+		},
+		lossSampleRate: cfg.Float("LOSS_SAMPLE_RATE", 0),
+		lossPool:       newWorkerPool(lossWorkers, lossQueueSize, QueuePolicyDrop),
+
+		budgetReservations: cfg.Bool("BUDGET_RESERVATIONS", false),
+		reservationTTL:     time.Duration(cfg.Int("RESERVATION_TTL_SECONDS", defaultReservationTTLSeconds)) * time.Second,
+
+		noFillBackoffBase: time.Duration(cfg.Int("NOFILL_BACKOFF_BASE_SECONDS", defaultNoFillBackoffBaseSeconds)) * time.Second,
+		noFillBackoffMax:  time.Duration(cfg.Int("NOFILL_BACKOFF_MAX_SECONDS", defaultNoFillBackoffMaxSeconds)) * time.Second,
+
+		maxPodDuration: cfg.Int("MAX_POD_DURATION", defaultMaxPodDuration),
+		maxPodAds:      cfg.Int("MAX_POD_ADS", defaultMaxPodAds),
+
+		quarantineTTL:          time.Duration(cfg.Int("CREATIVE_QUARANTINE_SECONDS", defaultQuarantineSeconds)) * time.Second,
+		creativeErrorThreshold: cfg.Int("CREATIVE_ERROR_THRESHOLD", defaultCreativeErrorThreshold),
+		creativeErrorWindow:    time.Duration(cfg.Int("CREATIVE_ERROR_WINDOW_SECONDS", defaultCreativeErrorWindowSeconds)) * time.Second,
+		creativeErrorRateLimit: int64(cfg.Int("CREATIVE_ERROR_RATE_LIMIT", defaultCreativeErrorRateLimit)),
+
+		deviceRateLimit:   int64(cfg.Int("DEVICE_RATE_LIMIT", 0)),
+		ipRateLimit:       int64(cfg.Int("IP_RATE_LIMIT", 0)),
+		clientRateLimit:   int64(cfg.Int("API_RATE_LIMIT", 0)),
+		trackingRateLimit: int64(cfg.Int("TRACKING_RATE_LIMIT", 0)),
+		rateLimitWindow:   time.Duration(cfg.Int("RATE_LIMIT_WINDOW_SECONDS", defaultRateLimitWindowSeconds)) * time.Second,
+
+		minCompletionRate:    cfg.Float("MIN_COMPLETION_RATE", 0),
+		minPerformanceSample: int64(cfg.Int("MIN_PERFORMANCE_SAMPLE", defaultMinPerformanceSample)),
+
+		minViewabilitySample: int64(cfg.Int("MIN_VIEWABILITY_SAMPLE", defaultMinViewabilitySample)),
+
+		geoResolver:       parseGeoRanges(cfg.Get("GEO_IP_RANGES")),
+		geoFallback:       geoFallbackMode(cfg.Get("GEO_FALLBACK")),
+		geoDefaultCountry: strings.ToUpper(cfg.String("GEO_DEFAULT_COUNTRY", "")),
+
+		durationValidation: cfg.Bool("IMPRESSION_DURATION_VALIDATION", false),
+		durationTolerance:  cfg.Int("DURATION_TOLERANCE_SECONDS", defaultDurationToleranceSeconds),
+
+		selectionStrategy: selectionStrategy(cfg.Get("SELECTION_STRATEGY")),
+
+		deterministicSelection: cfg.Bool("DETERMINISTIC_SELECTION", false),
+		deterministicBucket:    time.Duration(cfg.Int("DETERMINISTIC_BUCKET_SECONDS", defaultDeterministicBucketSeconds)) * time.Second,
+
+		botFilterEnabled:   cfg.Bool("BOT_FILTER_ENABLED", false),
+		botSignatures:      cfg.List("BOT_UA_SIGNATURES", defaultBotSignatures),
+		deviceIDValidation: cfg.Bool("DEVICE_ID_VALIDATION", false),
+		deviceIDSentinels:  cfg.List("INVALID_DEVICE_IDS", defaultDeviceIDSentinels),
+
+		campaignCache: newCampaignCache(time.Duration(cfg.Int("CAMPAIGN_CACHE_TTL_MS", 0)) * time.Millisecond),
+
+		makeGoodBucket: cfg.Get("MAKEGOOD_BUCKET"),
+
+		impressionPool: newWorkerPool(
+			cfg.Int("IMPRESSION_WORKERS", defaultImpressionWorkers),
+			cfg.Int("IMPRESSION_QUEUE_SIZE", defaultImpressionQueueSize),
+			cfg.Get("IMPRESSION_QUEUE_POLICY"),
+		),
+
+		gatewayBreaker: newCircuitBreaker(
+			"API Gateway",
+			cfg.Int("GATEWAY_BREAKER_THRESHOLD", defaultGatewayBreakerThreshold),
+			time.Duration(cfg.Int("GATEWAY_BREAKER_COOLDOWN_SECONDS", defaultGatewayBreakerCooldownSeconds))*time.Second,
+		),
+
+		gatewayRetry: retryPolicy{
+			maxAttempts: cfg.Int("GATEWAY_RETRY_ATTEMPTS", defaultGatewayRetryAttempts),
+			baseDelay:   time.Duration(cfg.Int("GATEWAY_RETRY_BASE_MS", defaultGatewayRetryBaseMS)) * time.Millisecond,
+			jitter:      cfg.Float("GATEWAY_RETRY_JITTER", defaultGatewayRetryJitter),
 		},
-		apiGatewayURL: apiGatewayURL,
+
+		competitiveSeparationWindow: time.Duration(cfg.Int("COMPETITIVE_SEPARATION_SECONDS", 0)) * time.Second,
+
+		idempotencyTTL: time.Duration(cfg.Int("IDEMPOTENCY_TTL_SECONDS", defaultIdempotencyTTLSeconds)) * time.Second,
+
+		budgetUnit: parseBudgetUnit(cfg.Get("BUDGET_UNIT")),
+
+		houseAd: newHouseAd(cfg),
 	}
 }
 
-// SelectAd selects an appropriate ad for the request
-func (s *AdService) SelectAd(req *models.AdRequest) (*models.AdResponse, error) {
-	// Get all active campaigns from Redis
-	campaignIDs, err := s.redis.GetActiveCampaigns()
+// SelectAd selects an appropriate ad for the request. Only creatives the
+// request can play (e.g. within max_duration) are considered: campaigns
+// without one are dropped and the draw repeated, and the request goes
+// unfilled once none are left.
+// A no-fill is answered with the house ad when one is configured.
+func (s *AdService) SelectAd(ctx context.Context, req *models.AdRequest) (*models.AdResponse, error) {
+	var slot *podSlot
+	if req.MaxDuration > 0 {
+		slot = &podSlot{maxDuration: req.MaxDuration}
+	}
+	ad, err := s.selectAd(ctx, req, slot)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get active campaigns: %w", err)
+		return s.houseAdFallback(req, slot, err)
 	}
+	return ad, nil
+}
 
-	if len(campaignIDs) == 0 {
-		return nil, fmt.Errorf("no active campaigns available")
+// selectAd selects an ad, constrained to a slot when slot is non-nil
+func (s *AdService) selectAd(ctx context.Context, req *models.AdRequest, slot *podSlot) (*models.AdResponse, error) {
+	if err := s.rejectBot(ctx, req); err != nil {
+		return nil, err
 	}
 
-	now := time.Now()
+	eligible, err := s.eligibleCampaigns(ctx, req)
+	if err != nil {
+		return nil, err
+	}
 
-	// Filter campaigns by date and budget
-	var eligibleCampaigns []string
-	for _, campaignID := range campaignIDs {
-		campaign, err := s.redis.GetCampaign(campaignID)
-		if err != nil {
-			continue // Skip this campaign if we can't fetch it
+	// Each unfilled attempt removes a campaign, so this ends with an ad or
+	// ErrNoEligibleCampaigns
+	for {
+		ad, err := s.selectFromEligible(ctx, req, eligible, slot)
+		if !errors.Is(err, errSlotUnfilled) {
+			return ad, err
 		}
+	}
+}
 
-		// Check status
-		if campaign["status"] != "active" {
-			continue
-		}
+// selectFromEligible selects an ad from a precomputed eligible set. Campaigns
+// that can't reserve budget, or can't fill the pod slot, are removed from the
+// set, so a pod can reuse it across slots.
+func (s *AdService) selectFromEligible(ctx context.Context, req *models.AdRequest, eligible *eligibleSet, slot *podSlot) (*models.AdResponse, error) {
+	if len(eligible.ids) == 0 {
+		return nil, ErrNoEligibleCampaigns
+	}
 
-		// Check date range
-		startDate, err := time.Parse(time.RFC3339, campaign["start_date"])
-		if err != nil || now.Before(startDate) {
-			continue
-		}
+	// Generate ad ID for tracking
+	now := eligible.now
+	adID := uuid.New().String()
+	rng := s.selectionRand(req, now)
 
-		endDate, err := time.Parse(time.RFC3339, campaign["end_date"])
-		if err != nil || now.After(endDate) {
-			continue
+	// Select from eligible campaigns by auction or weighted random draw. A
+	// campaign that can't reserve budget for this ad is dropped and the
+	// selection repeated.
+	var selectedIndex int
+	var clearedCPM float64
+	for {
+		selectedIndex = 0
+		switch {
+		case s.selectionStrategy == StrategyAuction:
+			selectedIndex, clearedCPM = runAuction(eligible.bids)
+		case len(eligible.ids) > 1:
+			selectedIndex = drawWeighted(rng, eligible.weights)
 		}
-
-		// Check budget
-		budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
-		budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
-		if budgetSpent >= budgetTotal {
-			continue
+		campaignID := eligible.ids[selectedIndex]
+		if s.reserveBudget(ctx, adID, campaignID, eligible.campaigns[campaignID]) {
+			break
 		}
 
-		eligibleCampaigns = append(eligibleCampaigns, campaignID)
+		eligible.remove(campaignID)
+		if len(eligible.ids) == 0 {
+			return nil, ErrNoEligibleCampaigns
+		}
 	}
+	selectedCampaignID := eligible.ids[selectedIndex]
+	campaign := eligible.campaigns[selectedCampaignID]
 
-	if len(eligibleCampaigns) == 0 {
-		return nil, fmt.Errorf("no eligible campaigns found")
+	// Get an active creative using the campaign's rotation mode
+	mode := rotationMode(campaign)
+	creativeID, creative, err := s.selectCreative(ctx, selectedCampaignID, mode, req, slot, rng)
+	if err != nil {
+		go s.releaseReservation(context.WithoutCancel(ctx), adID, selectedCampaignID)
+		if errors.Is(err, errNoServableCreative) {
+			eligible.remove(selectedCampaignID)
+			return nil, fmt.Errorf("%w: %w", errSlotUnfilled, err)
+		}
+		// Anything else is a failed Redis read, not a lack of inventory
+		return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	if mode == RotationEven {
+		go s.redis.IncrementCreativeServes(context.WithoutCancel(ctx), selectedCampaignID, creativeID)
 	}
 
-	// For MVP: simple random selection from eligible campaigns
-	// In production, this would use sophisticated targeting and pacing algorithms
-	selectedCampaignID := eligibleCampaigns[0]
-	if len(eligibleCampaigns) > 1 {
-		// Simple round-robin or weighted selection could go here
-		selectedCampaignID = eligibleCampaigns[time.Now().UnixNano()%int64(len(eligibleCampaigns))]
+	// Report the other eligible campaigns as having lost to this ad
+	if s.sampleLosses() {
+		s.emitLosses(lossEvents(adID, eligible.ids, eligible.bids, selectedIndex, s.selectionStrategy, clearedCPM, now))
 	}
 
-	// Get a random creative from the selected campaign
-	creativeID, err := s.redis.GetRandomCreative(selectedCampaignID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get creative: %w", err)
+	// Record the selection path for transparency logs
+	decision := &models.Decision{
+		Strategy:         s.selectionStrategy,
+		CandidateCount:   eligible.candidateCount,
+		EligibleCount:    len(eligible.ids),
+		SelectionWeight:  selectionShare(eligible.weights, selectedIndex),
+		CreativeStrategy: "random_sample",
+		RotationMode:     mode,
+	}
+	servedFormat := normalizeFormat(creative["format"])
+	preferredFormat := normalizeFormat(req.PreferredFormat)
+	if preferredFormat != "" && servedFormat == preferredFormat {
+		decision.CreativeStrategy = "preferred_format"
 	}
 
-	creative, err := s.redis.GetCreative(creativeID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch creative details: %w", err)
+	// Surface fallbacks and degraded conditions to the client
+	var warnings []string
+	if preferredFormat != "" && servedFormat != preferredFormat {
+		warnings = append(warnings, fmt.Sprintf("preferred format %s unavailable, served %s",
+			preferredFormat, servedFormat))
+	}
+	if isBudgetNearlyExhausted(campaign) {
+		warnings = append(warnings, "campaign budget nearly exhausted")
 	}
 
-	// Check creative status
-	if creative["status"] != "active" {
-		return nil, fmt.Errorf("creative is not active")
+	// Increment request counter (async, don't wait for result)
+	go s.incrementCampaignRequests(context.WithoutCancel(ctx), selectedCampaignID)
+
+	response := s.buildResponse(adID, selectedCampaignID, creativeID, creative, req.DeviceID, now)
+	response.Warnings = warnings
+	response.Decision = decision
+	if s.selectionStrategy == StrategyAuction {
+		response.ClearedCPM = clearedCPM
 	}
+	go s.recordServe(context.WithoutCancel(ctx), req.DeviceID, models.ServedAd{
+		AdID:       adID,
+		CampaignID: selectedCampaignID,
+		CreativeID: creativeID,
+		ServedAt:   now,
+	})
+	go s.recordCategory(context.WithoutCancel(ctx), req.DeviceID, selectedCampaignID, competitiveCategory(campaign), now)
+	return response, nil
+}
 
+// buildResponse renders a creative as an ad response: tracking URL macros,
+// duration and the optional poster. It has no side effects, so previews
+// produce exactly what would be served.
+func (s *AdService) buildResponse(adID, campaignID, creativeID string, creative map[string]string, deviceID string, now time.Time) *models.AdResponse {
 	// Parse duration
 	duration, _ := strconv.Atoi(creative["duration"])
 
-	// Increment request counter (async, don't wait for result)
-	go s.redis.IncrementCampaignRequests(selectedCampaignID)
-
-	// Generate ad ID for tracking
-	adID := uuid.New().String()
+	// Creatives measured by other vendors carry their own tracking endpoint
+	trackingURL := defaultTrackingURL
+	if base := creative["tracking_base"]; base != "" {
+		trackingURL = expandTrackingMacros(base, map[string]string{
+			"ad_id":       adID,
+			"campaign_id": campaignID,
+			"creative_id": creativeID,
+			"device_id":   deviceID,
+			"timestamp":   strconv.FormatInt(now.Unix(), 10),
+		})
+	}
 
 	// Build response
 	response := &models.AdResponse{
 		AdID:        adID,
-		CampaignID:  selectedCampaignID,
+		CampaignID:  campaignID,
 		CreativeID:  creativeID,
 		VideoURL:    creative["video_url"],
 		Duration:    duration,
-		Format:      creative["format"],
-		TrackingURL: fmt.Sprintf("/api/v1/impression"), // Client will POST here
+		Format:      normalizeFormat(creative["format"]),
+		ClickURL:    creative["click_url"],
+		TrackingURL: trackingURL, // Client will POST here
 		Timestamp:   now,
+
+		BrandID:    creative["brand_id"],
+		Title:      creative["title"],
+		Advertiser: creative["advertiser"],
 	}
 
-	return response, nil
+	// The poster is optional; an invalid one is dropped rather than failing
+	// the ad
+	if poster := creative["poster_url"]; poster != "" {
+		if err := validatePosterURL(poster, s.creativeURLSchemes); err != nil {
+			log.Printf("Dropping poster for creative %s: %v", creativeID, err)
+		} else {
+			response.PosterURL = poster
+		}
+	}
+
+	return response
+}
+
+// selectCreative picks an active creative from a bounded random sample of the
+// campaign's creative set (the full set for weighted and sequential rotation,
+// so weight splits and the order hold exactly), preferring
+// creatives in the request's preferred format, using the campaign's rotation
+// mode. Muted requests skip audio-required creatives, and a slot excludes
+// creatives longer than its maximum duration or from a brand already in the
+// pod. Random draws use rng.
+func (s *AdService) selectCreative(ctx context.Context, campaignID, mode string, req *models.AdRequest, slot *podSlot, rng *lockedRand) (string, map[string]string, error) {
+	var creativeIDs []string
+	var err error
+	switch {
+	case mode == RotationWeighted || mode == RotationSequential:
+		// Sorted, as SMEMBERS order varies, so seeded draws are reproducible
+		creativeIDs, err = s.redis.GetCampaignCreatives(ctx, campaignID)
+		sort.Strings(creativeIDs)
+	case s.deterministicSelection:
+		// SRANDMEMBER can't be seeded, so sample the full set with rng
+		creativeIDs, err = s.redis.GetCampaignCreatives(ctx, campaignID)
+		creativeIDs = sampleIDs(rng, creativeIDs, s.creativeSampleSize)
+	default:
+		creativeIDs, err = s.redis.GetRandomCreatives(ctx, campaignID, s.creativeSampleSize)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get creative: %w", err)
+	}
+	if len(creativeIDs) == 0 {
+		return "", nil, fmt.Errorf("%w: campaign has no creatives", errNoServableCreative)
+	}
+
+	creatives, err := s.redis.GetCreatives(ctx, creativeIDs)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch creative details: %w", err)
+	}
+
+	// Creatives recently reported as failing to play are skipped
+	quarantined, err := s.redis.GetQuarantinedCreatives(ctx)
+	if err != nil {
+		log.Printf("Failed to get quarantined creatives: %v", err)
+	}
+
+	// Creatives the client failed to play and is retrying without
+	excluded := make(map[string]bool, len(req.ExcludeCreatives))
+	for _, creativeID := range req.ExcludeCreatives {
+		excluded[creativeID] = true
+	}
+
+	// Keep only active creatives with a servable URL from the sample
+	var activeIDs []string
+	for _, creativeID := range creativeIDs {
+		creative, ok := creatives[creativeID]
+		if !ok || creative["status"] != "active" || quarantined[creativeID] || excluded[creativeID] {
+			continue
+		}
+		if !slot.fits(creative) || !matchesAudio(req.Muted(), creative) {
+			continue
+		}
+		if err := validateCreativeURL(creative["video_url"], s.creativeURLSchemes); err != nil {
+			log.Printf("Skipping creative %s: %v", creativeID, err)
+			continue
+		}
+		activeIDs = append(activeIDs, creativeID)
+	}
+
+	if len(activeIDs) == 0 {
+		return "", nil, fmt.Errorf("%w: creative is not active", errNoServableCreative)
+	}
+
+	// Drop creatives with a proven low completion rate
+	if s.minCompletionRate > 0 {
+		activeIDs, err = s.filterUnderperforming(ctx, activeIDs)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(activeIDs) == 0 {
+			return "", nil, fmt.Errorf("%w: no creatives above performance threshold", errNoServableCreative)
+		}
+	}
+
+	// Narrow to the preferred format when any sampled creative has it
+	if preferredFormat := normalizeFormat(req.PreferredFormat); preferredFormat != "" {
+		var preferredIDs []string
+		for _, creativeID := range activeIDs {
+			if normalizeFormat(creatives[creativeID]["format"]) == preferredFormat {
+				preferredIDs = append(preferredIDs, creativeID)
+			}
+		}
+		if len(preferredIDs) > 0 {
+			activeIDs = preferredIDs
+		}
+	}
+
+	creativeID, err := s.pickCreative(ctx, campaignID, mode, activeIDs, creatives, rng)
+	if err != nil {
+		return "", nil, err
+	}
+	return creativeID, creatives[creativeID], nil
 }
 
-// TrackImpression records an impression
-func (s *AdService) TrackImpression(req *models.ImpressionRequest) error {
-	// 1. Increment Redis counters (async, fast)
-	go s.redis.IncrementCreativeImpressions(req.CreativeID)
+// filterUnderperforming removes creatives whose completion rate is below the
+// configured threshold once they have enough impressions to judge
+func (s *AdService) filterUnderperforming(ctx context.Context, creativeIDs []string) ([]string, error) {
+	performance, err := s.redis.GetCreativesPerformance(ctx, creativeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch creative performance: %w", err)
+	}
+
+	var result []string
+	for _, creativeID := range creativeIDs {
+		if isUnderperforming(performance[creativeID], s.minCompletionRate, s.minPerformanceSample) {
+			continue
+		}
+		result = append(result, creativeID)
+	}
+	return result, nil
+}
+
+// isUnderperforming reports whether a creative's completion rate is below
+// minRate with at least minSample impressions recorded
+func isUnderperforming(performance map[string]string, minRate float64, minSample int64) bool {
+	impressions, _ := strconv.ParseInt(performance["impressions"], 10, 64)
+	if impressions == 0 || impressions < minSample {
+		return false
+	}
+	completions, _ := strconv.ParseInt(performance["completions"], 10, 64)
+	return float64(completions)/float64(impressions) < minRate
+}
+
+// isBudgetNearlyExhausted reports whether less than 5% of the campaign's
+// total budget remains
+func isBudgetNearlyExhausted(campaign map[string]string) bool {
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	return budgetTotal > 0 && budgetTotal-budgetSpent < budgetTotal*0.05
+}
+
+// sampledIncrement decides whether this event is counted and by how much.
+// At a sample rate of 0.1 one in ten events is counted, each adding 10.
+func (s *AdService) sampledIncrement() (int64, bool) {
+	if s.counterSampleRate >= 1 || s.counterSampleRate <= 0 {
+		return 1, true
+	}
+	if s.rng.Float64() >= s.counterSampleRate {
+		return 0, false
+	}
+	return int64(math.Round(1 / s.counterSampleRate)), true
+}
+
+func (s *AdService) incrementCampaignRequests(ctx context.Context, campaignID string) error {
+	if n, ok := s.sampledIncrement(); ok {
+		return s.redis.IncrementCampaignRequestsBy(ctx, campaignID, n)
+	}
+	return nil
+}
+
+func (s *AdService) incrementCreativeImpressions(ctx context.Context, creativeID string) error {
+	if n, ok := s.sampledIncrement(); ok {
+		return s.redis.IncrementCreativeImpressionsBy(ctx, creativeID, n)
+	}
+	return nil
+}
+
+// TrackImpression records an impression once per ad ID; repeats are
+// reported as duplicates without being counted. When duration validation is
+// enabled it also returns the duration check, with req.Duration clamped if
+// the reported value was implausible. Failing to read or charge the campaign
+// is returned, with the ad ID left unclaimed so the client's retry counts.
+func (s *AdService) TrackImpression(ctx context.Context, req *models.ImpressionRequest) (*models.ImpressionResult, error) {
+	// The campaign and creative are read once, for the dedup TTL, duration
+	// validation and the charge. A campaign that no longer exists isn't
+	// charged; a missing creative is charged at the campaign rate.
+	var campaign, creative map[string]string
+	if req.CampaignID != HouseCampaignID {
+		var err error
+		campaign, err = s.redis.GetCampaign(ctx, req.CampaignID)
+		if err != nil && !errors.Is(err, redis.ErrNotFound) {
+			return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+		}
+		if req.CreativeID != "" {
+			creative, _ = s.redis.GetCreative(ctx, req.CreativeID)
+		}
+	}
+
+	// Client retries of the same ad are acknowledged, with the original's
+	// sequence, but not counted again
+	first, seq := s.claimImpression(ctx, req.AdID, creative)
+	if !first {
+		return &models.ImpressionResult{Duplicate: true, Sequence: seq}, nil
+	}
+
+	// House ads have no campaign or stored creative, so there is nothing to
+	// charge, cap, count, publish or forward
+	if req.CampaignID == HouseCampaignID {
+		return &models.ImpressionResult{
+			Sequence: s.nextImpressionSequence(context.WithoutCancel(ctx), req.DeviceID, req.AdID),
+		}, nil
+	}
+
+	var durationCheck *models.DurationCheck
+	if s.durationValidation {
+		check, err := s.validateImpressionDuration(ctx, req, creative)
+		if err != nil {
+			log.Printf("Failed to validate impression duration: %v", err)
+		}
+		durationCheck = check
+	}
+
+	// The impression happened whether or not the client is still connected,
+	// so its writes must not be cancelled with the request
+	writeCtx := context.WithoutCancel(ctx)
+
+	// Charge the campaign (synchronously, so buffered spend is never lost to
+	// an in-flight goroutine at shutdown)
+	var makeGood bool
+	if campaign != nil {
+		var err error
+		makeGood, err = s.chargeImpression(writeCtx, req.CampaignID, req.AdID, campaign, creative)
+		if err != nil {
+			s.releaseImpression(writeCtx, req.AdID)
+			return nil, fmt.Errorf("failed to charge impression for campaign %s: %w", req.CampaignID, err)
+		}
+	}
+
+	// The spend is now counted, so the selection-time reservation is confirmed
+	s.releaseReservation(writeCtx, req.AdID, req.CampaignID)
 
-	// 2. Forward to Node.js API Gateway for PostgreSQL persistence
 	impressionData := map[string]interface{}{
 		"ad_id":            req.AdID,
 		"campaign_id":      req.CampaignID,
 		"creative_id":      req.CreativeID,
 		"device_id":        req.DeviceID,
+		"household_id":     req.HouseholdID,
 		"device_type":      req.DeviceType,
 		"location_country": req.LocationCountry,
 		"location_region":  req.LocationRegion,
 		"user_agent":       req.UserAgent,
 		"ip_address":       req.IPAddress,
 		"session_id":       req.SessionID,
+		"duration":         req.Duration,
 	}
 
 	jsonData, err := json.Marshal(impressionData)
 	if err != nil {
-		return fmt.Errorf("failed to marshal impression data: %w", err)
+		return nil, fmt.Errorf("failed to marshal impression data: %w", err)
 	}
 
-	// POST to Node.js API Gateway (fire and forget)
-	go func() {
-		url := fmt.Sprintf("%s/api/v1/track-impression", s.apiGatewayURL)
-		resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Failed to forward impression to API Gateway: %v", err)
-			return
+	// Append to the impressions stream before the fire-and-forget forward, so
+	// a consumer can replay impressions the gateway never received
+	if _, err := s.redis.PublishImpression(writeCtx, impressionStreamFields(req)); err != nil {
+		log.Printf("Failed to publish impression for ad %s: %v", req.AdID, err)
+	}
+
+	// Frequency caps and daily delivery are enforced from these counters, so
+	// they are written here rather than in a job a full queue could drop
+	if err := s.redis.IncrementFrequencyCount(writeCtx, frequencySubject(req.DeviceID, req.HouseholdID), req.CampaignID); err != nil {
+		log.Printf("Failed to increment frequency count for ad %s: %v", req.AdID, err)
+	}
+	if err := s.redis.IncrementCampaignDailyImpressions(writeCtx, req.CampaignID); err != nil {
+		log.Printf("Failed to increment daily impressions for ad %s: %v", req.AdID, err)
+	}
+
+	// Creative counters and the gateway forward run on the bounded impression
+	// pool rather than a goroutine each, so a spike can't exhaust memory
+	impression := *req
+	queued := s.impressionPool.submit(func() {
+		// 1. Increment creative counters
+		s.incrementCreativeImpressions(writeCtx, impression.CreativeID)
+		s.redis.RecordCreativePerformance(writeCtx, impression.CreativeID, impression.Completed)
+		s.recordViewability(writeCtx, &impression)
+
+		// 2. Forward to Node.js API Gateway for PostgreSQL persistence
+		s.forwardToGateway("/api/v1/track-impression", "impression", impression.AdID, jsonData)
+	})
+	if !queued {
+		log.Printf("Impression queue full, dropped creative counters and forward for ad %s", req.AdID)
+	}
+
+	return &models.ImpressionResult{
+		DurationCheck: durationCheck,
+		MakeGood:      makeGood,
+		Dropped:       !queued,
+		Sequence:      s.nextImpressionSequence(writeCtx, req.DeviceID, req.AdID),
+	}, nil
+}
+
+// impressionStreamFields flattens an impression into stream entry fields.
+// Viewable is empty when the impression wasn't measured.
+func impressionStreamFields(req *models.ImpressionRequest) map[string]interface{} {
+	viewable := ""
+	if req.Viewable != nil {
+		viewable = strconv.FormatBool(*req.Viewable)
+	}
+	return map[string]interface{}{
+		"ad_id":            req.AdID,
+		"campaign_id":      req.CampaignID,
+		"creative_id":      req.CreativeID,
+		"device_id":        req.DeviceID,
+		"household_id":     req.HouseholdID,
+		"device_type":      req.DeviceType,
+		"location_country": req.LocationCountry,
+		"location_region":  req.LocationRegion,
+		"user_agent":       req.UserAgent,
+		"ip_address":       req.IPAddress,
+		"session_id":       req.SessionID,
+		"timestamp":        req.Timestamp.UTC().Format(time.RFC3339Nano),
+		"duration":         strconv.Itoa(req.Duration),
+		"completed":        strconv.FormatBool(req.Completed),
+		"app_id":           req.AppID,
+		"placement_id":     req.PlacementID,
+		"viewable":         viewable,
+	}
+}
+
+// TrackClick counts a click on a served creative and forwards it to the API
+// Gateway for persistence, like impressions
+func (s *AdService) TrackClick(ctx context.Context, req *models.ClickRequest) error {
+	// The click happened whether or not the client is still connected
+	writeCtx := context.WithoutCancel(ctx)
+
+	if err := s.redis.IncrementCreativeClicks(writeCtx, req.CreativeID); err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"ad_id":       req.AdID,
+		"campaign_id": req.CampaignID,
+		"creative_id": req.CreativeID,
+		"device_id":   req.DeviceID,
+		"timestamp":   req.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal click data: %w", err)
+	}
+
+	go s.forwardToGateway("/api/v1/track-click", "click", req.AdID, jsonData)
+	return nil
+}
+
+// forwardToGateway POSTs a tracking event to the API Gateway, logging rather
+// than returning failures. Network errors and 5xx responses are retried per
+// the gateway retry policy. While the gateway breaker is open the event is
+// skipped instead of waiting out the HTTP timeout.
+func (s *AdService) forwardToGateway(path, event, adID string, jsonData []byte) {
+	if !s.gatewayBreaker.allow() {
+		return
+	}
+
+	url := s.apiGatewayURL + path
+	attempts := s.gatewayRetry.attempts()
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(s.gatewayRetry.delay(attempt - 1))
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusAccepted {
-			log.Printf("API Gateway returned non-202 status: %d", resp.StatusCode)
+		var retryable bool
+		if retryable, err = s.postToGateway(url, jsonData); err == nil || !retryable {
+			// A 4xx means the gateway is up but rejected this event
+			s.gatewayBreaker.record(true)
+			if err != nil {
+				log.Printf("API Gateway rejected %s %s: %v", event, adID, err)
+			}
+			return
 		}
-	}()
+	}
 
-	return nil
+	s.gatewayBreaker.record(false)
+	log.Printf("ERROR: Failed to forward %s %s to API Gateway after %d attempts: %v", event, adID, attempts, err)
+}
+
+// postToGateway makes one POST to the API Gateway, reporting whether a
+// failure is worth retrying (network errors and 5xx)
+func (s *AdService) postToGateway(url string, jsonData []byte) (bool, error) {
+	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode >= http.StatusBadRequest:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusAccepted:
+		log.Printf("API Gateway returned non-202 status: %d", resp.StatusCode)
+	}
+	return false, nil
 }
@@ -1,23 +1,54 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fanwu/ad-server/internal/config"
 	"github.com/fanwu/ad-server/internal/models"
 	"github.com/fanwu/ad-server/internal/redis"
 	"github.com/google/uuid"
 )
 
+// ctx is used for Redis and service calls in tests
+var ctx = context.Background()
+
+// testConfig loads the configuration from the environment, so tests can
+// change settings with t.Setenv before building a service
+func testConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
 // setupTestRedis creates a real Redis connection for testing
-func setupTestRedis(t *testing.T) *redis.Client {
+func setupTestRedis(t testing.TB) *redis.Client {
 	redisURL := os.Getenv("REDIS_TEST_URL")
 	if redisURL == "" {
 		redisURL = "localhost:6380" // Test Redis on port 6380
 	}
 
-	client, err := redis.NewClient(redisURL)
+	cfg := testConfig()
+	cfg.RedisAddr = redisURL
+	client, err := redis.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -26,7 +57,7 @@ func setupTestRedis(t *testing.T) *redis.Client {
 }
 
 // seedTestCampaign adds a test campaign and creative to Redis
-func seedTestCampaign(t *testing.T, redisClient *redis.Client, startOffset, endOffset time.Duration, budgetTotal, budgetSpent float64) (string, string) {
+func seedTestCampaign(t testing.TB, redisClient *redis.Client, startOffset, endOffset time.Duration, budgetTotal, budgetSpent float64) (string, string) {
 	campaignID := uuid.New().String()
 	creativeID := uuid.New().String()
 
@@ -45,7 +76,7 @@ func seedTestCampaign(t *testing.T, redisClient *redis.Client, startOffset, endO
 		"end_date":     endDate,
 	}
 
-	if err := redisClient.SetCampaign(campaignID, campaignData); err != nil {
+	if err := redisClient.SetCampaign(ctx, campaignID, campaignData); err != nil {
 		t.Fatalf("Failed to set campaign: %v", err)
 	}
 
@@ -60,13 +91,13 @@ func seedTestCampaign(t *testing.T, redisClient *redis.Client, startOffset, endO
 		"status":      "active",
 	}
 
-	if err := redisClient.SetCreative(creativeID, campaignID, creativeData); err != nil {
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, creativeData); err != nil {
 		t.Fatalf("Failed to set creative: %v", err)
 	}
 
 	// Add to active campaigns sorted set
 	remainingBudget := budgetTotal - budgetSpent
-	if err := redisClient.AddActiveCampaign(campaignID, remainingBudget); err != nil {
+	if err := redisClient.AddActiveCampaign(ctx, campaignID, remainingBudget); err != nil {
 		t.Fatalf("Failed to add active campaign: %v", err)
 	}
 
@@ -74,10 +105,10 @@ func seedTestCampaign(t *testing.T, redisClient *redis.Client, startOffset, endO
 }
 
 // cleanupTestData removes test data from Redis
-func cleanupTestData(t *testing.T, redisClient *redis.Client, campaignID, creativeID string) {
-	redisClient.DeleteCampaign(campaignID)
-	redisClient.DeleteCreative(creativeID, campaignID)
-	redisClient.RemoveActiveCampaign(campaignID)
+func cleanupTestData(t testing.TB, redisClient *redis.Client, campaignID, creativeID string) {
+	redisClient.DeleteCampaign(ctx, campaignID)
+	redisClient.DeleteCreative(ctx, creativeID, campaignID)
+	redisClient.RemoveActiveCampaign(ctx, campaignID)
 }
 
 func TestSelectAd_Success(t *testing.T) {
@@ -98,7 +129,7 @@ func TestSelectAd_Success(t *testing.T) {
 	)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	// Create ad request
 	req := &models.AdRequest{
@@ -108,7 +139,7 @@ func TestSelectAd_Success(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Assertions
 	if err != nil {
@@ -162,7 +193,7 @@ func TestSelectAd_ExpiredCampaign(t *testing.T) {
 	)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	req := &models.AdRequest{
 		DeviceID:   "device-123",
@@ -171,7 +202,7 @@ func TestSelectAd_ExpiredCampaign(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Should return error because campaign is expired
 	if err == nil {
@@ -201,7 +232,7 @@ func TestSelectAd_FutureCampaign(t *testing.T) {
 	)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	req := &models.AdRequest{
 		DeviceID:   "device-123",
@@ -210,7 +241,7 @@ func TestSelectAd_FutureCampaign(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Should return error because campaign hasn't started
 	if err == nil {
@@ -240,7 +271,7 @@ func TestSelectAd_BudgetExceeded(t *testing.T) {
 	)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	req := &models.AdRequest{
 		DeviceID:   "device-123",
@@ -249,7 +280,7 @@ func TestSelectAd_BudgetExceeded(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Should return error because budget is exhausted
 	if err == nil {
@@ -288,7 +319,7 @@ func TestSelectAd_InactiveCampaign(t *testing.T) {
 		"end_date":     endDate,
 	}
 
-	if err := redisClient.SetCampaign(campaignID, campaignData); err != nil {
+	if err := redisClient.SetCampaign(ctx, campaignID, campaignData); err != nil {
 		t.Fatalf("Failed to set campaign: %v", err)
 	}
 
@@ -303,18 +334,18 @@ func TestSelectAd_InactiveCampaign(t *testing.T) {
 		"status":      "active",
 	}
 
-	if err := redisClient.SetCreative(creativeID, campaignID, creativeData); err != nil {
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, creativeData); err != nil {
 		t.Fatalf("Failed to set creative: %v", err)
 	}
 
 	// Add to active campaigns sorted set
-	if err := redisClient.AddActiveCampaign(campaignID, 9000.0); err != nil {
+	if err := redisClient.AddActiveCampaign(ctx, campaignID, 9000.0); err != nil {
 		t.Fatalf("Failed to add active campaign: %v", err)
 	}
 
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	req := &models.AdRequest{
 		DeviceID:   "device-123",
@@ -323,7 +354,7 @@ func TestSelectAd_InactiveCampaign(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Should return error because campaign status is not active
 	if err == nil {
@@ -344,7 +375,7 @@ func TestSelectAd_NoActiveCampaigns(t *testing.T) {
 	redisClient := setupTestRedis(t)
 	defer redisClient.Close()
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	req := &models.AdRequest{
 		DeviceID:   "device-123",
@@ -353,7 +384,7 @@ func TestSelectAd_NoActiveCampaigns(t *testing.T) {
 	}
 
 	// Select ad
-	adResp, err := service.SelectAd(req)
+	adResp, err := service.SelectAd(ctx, req)
 
 	// Should return error because no campaigns exist
 	if err == nil {
@@ -382,7 +413,7 @@ func TestTrackImpression_Success(t *testing.T) {
 	)
 	defer cleanupTestData(t, redisClient, campaignID, creativeID)
 
-	service := NewAdService(redisClient)
+	service := NewAdService(redisClient, testConfig())
 
 	// Create impression request
 	req := &models.ImpressionRequest{
@@ -395,7 +426,7 @@ func TestTrackImpression_Success(t *testing.T) {
 	}
 
 	// Track impression
-	err := service.TrackImpression(req)
+	_, err := service.TrackImpression(ctx, req)
 
 	// Should succeed
 	if err != nil {
@@ -406,3 +437,5875 @@ func TestTrackImpression_Success(t *testing.T) {
 	// so we can't reliably test the counter value immediately
 	// The integration test just ensures the API doesn't error
 }
+
+func TestTrackClick_Success(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+
+	before, err := redisClient.GetCreativeClicks(ctx, creativeID)
+	if err != nil {
+		t.Fatalf("Failed to get clicks: %v", err)
+	}
+
+	req := &models.ClickRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+		Timestamp:  time.Now(),
+	}
+	if err := service.TrackClick(ctx, req); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The click counter is written synchronously
+	after, err := redisClient.GetCreativeClicks(ctx, creativeID)
+	if err != nil {
+		t.Fatalf("Failed to get clicks: %v", err)
+	}
+	if after != before+1 {
+		t.Errorf("Expected clicks to go from %d to %d, got %d", before, before+1, after)
+	}
+}
+
+func TestBuildResponse_ClickURL(t *testing.T) {
+	service := &AdService{}
+	creative := map[string]string{"video_url": "https://example.com/v.mp4", "duration": "30", "format": "mp4"}
+
+	resp := service.buildResponse("ad-1", "campaign-1", "creative-1", creative, "device-1", time.Now())
+	if resp.ClickURL != "" {
+		t.Errorf("Expected empty click_url without one on the creative, got %q", resp.ClickURL)
+	}
+
+	creative["click_url"] = "https://advertiser.example.com/landing"
+	resp = service.buildResponse("ad-1", "campaign-1", "creative-1", creative, "device-1", time.Now())
+	if resp.ClickURL != creative["click_url"] {
+		t.Errorf("Expected click_url %q, got %q", creative["click_url"], resp.ClickURL)
+	}
+}
+
+func TestBuildResponse_Metadata(t *testing.T) {
+	service := &AdService{}
+	creative := map[string]string{"video_url": "https://example.com/v.mp4", "duration": "30", "format": "mp4"}
+
+	// Missing metadata is empty, not an error
+	resp := service.buildResponse("ad-1", "campaign-1", "creative-1", creative, "device-1", time.Now())
+	if resp.Title != "" || resp.Advertiser != "" {
+		t.Errorf("Expected empty title and advertiser, got %q %q", resp.Title, resp.Advertiser)
+	}
+	body, _ := json.Marshal(resp)
+	for _, field := range []string{`"title":""`, `"advertiser":""`} {
+		if !strings.Contains(string(body), field) {
+			t.Errorf("Expected %s in %s", field, body)
+		}
+	}
+
+	creative["title"] = "Spring Sale"
+	creative["advertiser"] = "Acme"
+	resp = service.buildResponse("ad-1", "campaign-1", "creative-1", creative, "device-1", time.Now())
+	if resp.Title != "Spring Sale" || resp.Advertiser != "Acme" {
+		t.Errorf("Expected title Spring Sale and advertiser Acme, got %q %q", resp.Title, resp.Advertiser)
+	}
+}
+
+func TestTrackEvent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	service := NewAdService(redisClient, testConfig())
+
+	creativeID := uuid.New().String()
+	for _, event := range []string{"start", "firstQuartile", "midpoint", "thirdQuartile", "complete"} {
+		req := &models.TrackEventRequest{AdID: uuid.New().String(), CreativeID: creativeID, Event: event}
+		if err := service.TrackEvent(ctx, req); err != nil {
+			t.Fatalf("%s: expected no error, got: %v", event, err)
+		}
+		count, err := redisClient.GetCreativeEventCount(ctx, creativeID, event)
+		if err != nil {
+			t.Fatalf("Failed to get event count: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("%s: expected count 1, got %d", event, count)
+		}
+	}
+}
+
+func TestTrackEvent_RejectsUnknownEvent(t *testing.T) {
+	service := &AdService{}
+
+	for _, event := range []string{"rewind", "Complete", ""} {
+		err := service.TrackEvent(ctx, &models.TrackEventRequest{AdID: "ad-1", CreativeID: "creative-1", Event: event})
+		if !errors.Is(err, ErrInvalidEvent) {
+			t.Errorf("%q: expected ErrInvalidEvent, got: %v", event, err)
+		}
+	}
+}
+
+func TestImpressionCPM(t *testing.T) {
+	campaign := map[string]string{"cpm": "20"}
+
+	tests := []struct {
+		name     string
+		creative map[string]string
+		expected float64
+	}{
+		{"no creative", nil, 20},
+		{"no override", map[string]string{}, 20},
+		{"override", map[string]string{"cpm": "30"}, 30},
+		{"invalid override", map[string]string{"cpm": "premium"}, 20},
+		{"zero override", map[string]string{"cpm": "0"}, 20},
+	}
+	for _, tt := range tests {
+		if got := impressionCPM(campaign, tt.creative); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestTrackImpression_CreativeCPMOverride(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, standardID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, standardID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	premiumID := uuid.New().String()
+	if err := redisClient.SetCreative(ctx, premiumID, campaignID, map[string]interface{}{
+		"video_url": "https://example.com/premium.mp4",
+		"duration":  "30",
+		"format":    "mp4",
+		"status":    "active",
+		"cpm":       30,
+	}); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+	defer redisClient.DeleteCreative(ctx, premiumID, campaignID)
+
+	service := NewAdService(redisClient, testConfig())
+
+	// chargeFor tracks one impression and returns how much budget it used
+	chargeFor := func(creativeID string) float64 {
+		spentBefore := func() float64 {
+			campaign, err := redisClient.GetCampaign(ctx, campaignID)
+			if err != nil {
+				t.Fatalf("Failed to get campaign: %v", err)
+			}
+			spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+			return spent
+		}
+		before := spentBefore()
+		if _, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+			AdID:       uuid.New().String(),
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			DeviceID:   "device-123",
+		}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return spentBefore() - before
+	}
+
+	standard := chargeFor(standardID)
+	premium := chargeFor(premiumID)
+
+	if math.Abs(standard-0.02) > 1e-9 {
+		t.Errorf("Expected the campaign's $20 CPM to charge 0.02, got %v", standard)
+	}
+	if math.Abs(premium-0.03) > 1e-9 {
+		t.Errorf("Expected the $30 CPM override to charge 0.03, got %v", premium)
+	}
+	if premium <= standard {
+		t.Errorf("Expected the premium creative to deplete more budget (%v vs %v)", premium, standard)
+	}
+}
+
+func TestTrackImpression_DeduplicatesByAdID(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	req := models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	}
+
+	// The client retries the same impression
+	first, err := service.TrackImpression(ctx, &req)
+	if err != nil || first.Duplicate {
+		t.Fatalf("Expected the first report to be tracked, got %+v (err %v)", first, err)
+	}
+	retry := req
+	second, err := service.TrackImpression(ctx, &retry)
+	if err != nil || !second.Duplicate {
+		t.Fatalf("Expected the retry to be a duplicate, got %+v (err %v)", second, err)
+	}
+
+	// Spend is charged synchronously, so it shows exactly one increment
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	if spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64); math.Abs(spent-0.02) > 1e-9 {
+		t.Errorf("Expected one $0.02 charge, budget_spent is %s", campaign["budget_spent"])
+	}
+}
+
+func TestTrackImpression_RedisDownIsRetryable(t *testing.T) {
+	// Nothing listens on port 1, so the campaign can't be read or charged
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	service := NewAdService(client, testConfig())
+
+	// The impression is neither acknowledged nor claimed, so the client's
+	// retry is charged once Redis is back
+	result, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: "campaign-1",
+		CreativeID: "creative-1",
+		DeviceID:   "device-123",
+	})
+	if err == nil {
+		t.Fatalf("Expected an error with Redis down, got %+v", result)
+	}
+}
+
+func TestWorkerPool_BoundsGoroutines(t *testing.T) {
+	pool := newWorkerPool(4, 10, QueuePolicyDrop)
+
+	release := make(chan struct{})
+	var ran atomic.Int64
+	baseline := runtime.NumGoroutine()
+
+	accepted := 0
+	for i := 0; i < 1000; i++ {
+		if pool.submit(func() { <-release; ran.Add(1) }) {
+			accepted++
+		}
+	}
+
+	// At most the workers' in-flight jobs plus a full queue are accepted
+	if accepted > 14 {
+		t.Errorf("Expected at most 14 jobs accepted, got %d", accepted)
+	}
+	if got := pool.dropped.Load(); got != int64(1000-accepted) {
+		t.Errorf("Expected %d dropped, got %d", 1000-accepted, got)
+	}
+	if grown := runtime.NumGoroutine() - baseline; grown > 4 {
+		t.Errorf("Expected at most 4 new goroutines, got %d", grown)
+	}
+
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for ran.Load() < int64(accepted) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ran.Load() != int64(accepted) {
+		t.Errorf("Expected all %d accepted jobs to run, ran %d", accepted, ran.Load())
+	}
+}
+
+func TestWorkerPool_BlockPolicy(t *testing.T) {
+	pool := newWorkerPool(1, 1, QueuePolicyBlock)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pool.submit(func() { close(started); <-release })
+	<-started
+	pool.submit(func() {}) // Fills the queue
+
+	submitted := make(chan bool)
+	go func() { submitted <- pool.submit(func() {}) }()
+
+	select {
+	case <-submitted:
+		t.Fatal("Expected submit to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-submitted {
+		t.Error("Expected the blocked submit to be accepted once space frees")
+	}
+	if pool.dropped.Load() != 0 {
+		t.Errorf("Expected nothing dropped under the block policy, got %d", pool.dropped.Load())
+	}
+}
+
+func TestWorkerPool_CloseRunsQueuedJobs(t *testing.T) {
+	pool := newWorkerPool(1, 10, QueuePolicyDrop)
+
+	release := make(chan struct{})
+	var ran atomic.Int64
+	for i := 0; i < 5; i++ {
+		if !pool.submit(func() { <-release; ran.Add(1) }) {
+			t.Fatalf("Expected job %d to be queued", i+1)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for queued jobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-closed
+	if ran.Load() != 5 {
+		t.Errorf("Expected all 5 queued jobs to run before Close returned, ran %d", ran.Load())
+	}
+
+	// Closed pools drop new jobs rather than panic
+	if pool.submit(func() {}) {
+		t.Error("Expected submit after Close to be rejected")
+	}
+	pool.Close()
+}
+
+func TestTrackImpression_FloodStaysBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	t.Setenv("IMPRESSION_WORKERS", "4")
+	t.Setenv("IMPRESSION_QUEUE_SIZE", "20")
+	service := NewAdService(redisClient, testConfig())
+
+	baseline := runtime.NumGoroutine()
+	var peak atomic.Int64
+	var wg sync.WaitGroup
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				service.TrackImpression(ctx, &models.ImpressionRequest{
+					AdID:       uuid.New().String(),
+					CampaignID: campaignID,
+					CreativeID: creativeID,
+					DeviceID:   "device-flood",
+				})
+				if n := int64(runtime.NumGoroutine()); n > peak.Load() {
+					peak.Store(n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The 8 flooding goroutines and 4 workers, plus go-redis's own and slack
+	if grown := peak.Load() - int64(baseline); grown > 40 {
+		t.Errorf("Expected goroutines to stay bounded, grew by %d", grown)
+	}
+}
+
+func TestTrackImpression_SequenceIncreasesPerDevice(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+
+	track := func(deviceID, adID string) *models.ImpressionResult {
+		result, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+			AdID:       adID,
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			DeviceID:   deviceID,
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return result
+	}
+
+	deviceID := "device-" + uuid.New().String()
+	var last int64
+	for i := 0; i < 3; i++ {
+		seq := track(deviceID, uuid.New().String()).Sequence
+		if seq <= last {
+			t.Errorf("Impression %d: expected sequence above %d, got %d", i, last, seq)
+		}
+		last = seq
+	}
+	if last != 3 {
+		t.Errorf("Expected a new device's third impression to be sequence 3, got %d", last)
+	}
+
+	// Sequences are per device
+	if seq := track("device-"+uuid.New().String(), uuid.New().String()).Sequence; seq != 1 {
+		t.Errorf("Expected another device to start at 1, got %d", seq)
+	}
+
+	// A duplicate confirmation gets the original's number back without
+	// consuming one
+	adID := uuid.New().String()
+	original := track(deviceID, adID).Sequence
+	if dup := track(deviceID, adID); !dup.Duplicate || dup.Sequence != original {
+		t.Errorf("Expected a duplicate with the original sequence %d, got %+v", original, dup)
+	}
+	if seq := track(deviceID, uuid.New().String()).Sequence; seq != 5 {
+		t.Errorf("Expected sequence 5 after the duplicate, got %d", seq)
+	}
+}
+
+func TestCheckDuration(t *testing.T) {
+	tests := []struct {
+		name         string
+		reported     int
+		wantFlagged  bool
+		wantReason   string
+		wantAccepted int
+	}{
+		{"full watch", 30, false, "", 30},
+		{"partial watch", 12, false, "", 12},
+		{"within tolerance", 31, false, "", 31},
+		{"exceeds creative", 45, true, DurationExceedsCreative, 30},
+		{"negative", -5, true, DurationNegative, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkDuration(tt.reported, 30, 1)
+			if check.Flagged != tt.wantFlagged || check.Reason != tt.wantReason || check.Accepted != tt.wantAccepted {
+				t.Errorf("checkDuration(%d, 30) = %+v, want flagged=%v reason=%q accepted=%d",
+					tt.reported, check, tt.wantFlagged, tt.wantReason, tt.wantAccepted)
+			}
+			if check.Reported != tt.reported || check.CreativeDuration != 30 {
+				t.Errorf("Expected reported %d on 30s creative, got %+v", tt.reported, check)
+			}
+		})
+	}
+}
+
+func TestTrackImpression_DurationValidation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// seedTestCampaign creates a 30s creative
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	t.Setenv("IMPRESSION_DURATION_VALIDATION", "true")
+	service := NewAdService(redisClient, testConfig())
+
+	anomaliesBefore, err := redisClient.GetImpressionAnomalies(ctx, DurationExceedsCreative)
+	if err != nil {
+		t.Fatalf("Failed to get anomalies: %v", err)
+	}
+
+	t.Run("implausible duration is clamped", func(t *testing.T) {
+		req := &models.ImpressionRequest{
+			AdID:       uuid.New().String(),
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			DeviceID:   "device-123",
+			Duration:   45,
+		}
+		result, err := service.TrackImpression(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		check := result.DurationCheck
+		if check == nil || !check.Flagged || check.Reason != DurationExceedsCreative {
+			t.Fatalf("Expected 45s on a 30s creative to be flagged, got %+v", check)
+		}
+		if req.Duration != 30 {
+			t.Errorf("Expected duration clamped to 30, got %d", req.Duration)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			anomalies, err := redisClient.GetImpressionAnomalies(ctx, DurationExceedsCreative)
+			if err != nil {
+				t.Fatalf("Failed to get anomalies: %v", err)
+			}
+			if anomalies > anomaliesBefore {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Error("Expected the anomaly counter to be incremented")
+	})
+
+	t.Run("valid duration passes", func(t *testing.T) {
+		req := &models.ImpressionRequest{
+			AdID:       uuid.New().String(),
+			CampaignID: campaignID,
+			CreativeID: creativeID,
+			DeviceID:   "device-123",
+			Duration:   30,
+		}
+		result, err := service.TrackImpression(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		check := result.DurationCheck
+		if check == nil || check.Flagged {
+			t.Errorf("Expected 30s on a 30s creative to pass, got %+v", check)
+		}
+		if req.Duration != 30 {
+			t.Errorf("Expected duration unchanged, got %d", req.Duration)
+		}
+	})
+}
+
+func TestIsBotUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  bool
+	}{
+		{"Googlebot/2.1 (+http://www.google.com/bot.html)", true},
+		{"curl/8.4.0", true},
+		{"Mozilla/5.0 (X11; Linux x86_64) HeadlessChrome/120.0.0.0 Safari/537.36", true},
+		{"Mozilla/5.0 (SMART-TV; Linux; Tizen 6.0) AppleWebKit/537.36 SamsungBrowser/4.0", false},
+		{"Roku/DVP-12.5 (12.5.0.4178)", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isBotUserAgent(tt.userAgent, defaultBotSignatures); got != tt.expected {
+			t.Errorf("isBotUserAgent(%q) = %v, expected %v", tt.userAgent, got, tt.expected)
+		}
+	}
+}
+
+func TestSelectAd_BotUserAgent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	service.botFilterEnabled = true
+
+	// Known bot user agent should get a no-fill
+	botReq := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+		UserAgent:  "Googlebot/2.1 (+http://www.google.com/bot.html)",
+	}
+
+	adResp, err := service.SelectAd(ctx, botReq)
+	if err != ErrInvalidTraffic {
+		t.Errorf("Expected ErrInvalidTraffic for bot user agent, got: %v", err)
+	}
+	if adResp != nil {
+		t.Error("Expected nil response for bot user agent, got response")
+	}
+
+	// Normal CTV user agent should still be served
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+		UserAgent:  "Roku/DVP-12.5 (12.5.0.4178)",
+	}
+
+	adResp, err = service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error for normal user agent, got: %v", err)
+	}
+	if adResp.CampaignID != campaignID {
+		t.Errorf("Expected campaign_id %s, got %s", campaignID, adResp.CampaignID)
+	}
+}
+
+// seedCreatives adds count active creatives to an existing campaign
+func seedCreatives(t testing.TB, redisClient *redis.Client, campaignID string, count int) []string {
+	creativeIDs := make([]string, count)
+	for i := range creativeIDs {
+		creativeIDs[i] = uuid.New().String()
+		creativeData := map[string]interface{}{
+			"id":          creativeIDs[i],
+			"campaign_id": campaignID,
+			"name":        fmt.Sprintf("Test Creative %d", i),
+			"video_url":   "https://example.com/test-video.mp4",
+			"duration":    "30",
+			"format":      "mp4",
+			"status":      "active",
+		}
+		if err := redisClient.SetCreative(ctx, creativeIDs[i], campaignID, creativeData); err != nil {
+			t.Fatalf("Failed to set creative: %v", err)
+		}
+	}
+	return creativeIDs
+}
+
+func TestSelectAd_LargeCreativeSetSample(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	creativeIDs := append(seedCreatives(t, redisClient, campaignID, 200), creativeID)
+	defer func() {
+		for _, id := range creativeIDs {
+			redisClient.DeleteCreative(ctx, id, campaignID)
+		}
+	}()
+
+	known := make(map[string]bool, len(creativeIDs))
+	for _, id := range creativeIDs {
+		known[id] = true
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	service.creativeSampleSize = 5
+
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	for i := 0; i < 20; i++ {
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !known[adResp.CreativeID] {
+			t.Errorf("Selected creative %s does not belong to campaign", adResp.CreativeID)
+		}
+	}
+}
+
+func BenchmarkSelectAd_LargeCreativeSet(b *testing.B) {
+	redisClient := setupTestRedis(b)
+	defer redisClient.Close()
+
+	campaignID := uuid.New().String()
+	now := time.Now()
+	campaignData := map[string]interface{}{
+		"id":           campaignID,
+		"name":         "Benchmark Campaign",
+		"status":       "active",
+		"budget_total": 10000.0,
+		"budget_spent": 0.0,
+		"start_date":   now.Add(-24 * time.Hour).Format(time.RFC3339),
+		"end_date":     now.Add(24 * time.Hour).Format(time.RFC3339),
+	}
+	if err := redisClient.SetCampaign(ctx, campaignID, campaignData); err != nil {
+		b.Fatalf("Failed to set campaign: %v", err)
+	}
+	if err := redisClient.AddActiveCampaign(ctx, campaignID, 10000.0); err != nil {
+		b.Fatalf("Failed to add active campaign: %v", err)
+	}
+
+	creativeIDs := seedCreatives(b, redisClient, campaignID, 5000)
+	defer func() {
+		for _, id := range creativeIDs {
+			redisClient.DeleteCreative(ctx, id, campaignID)
+		}
+		redisClient.DeleteCampaign(ctx, campaignID)
+		redisClient.RemoveActiveCampaign(ctx, campaignID)
+	}()
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.SelectAd(ctx, req); err != nil {
+			b.Fatalf("SelectAd failed: %v", err)
+		}
+	}
+}
+
+func TestSelectAd_FormatFallbackWarning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Seeded creative is mp4 only
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+
+	req := &models.AdRequest{
+		DeviceID:        "device-123",
+		DeviceType:      "ctv",
+		AppID:           "app-456",
+		PreferredFormat: "webm",
+	}
+
+	adResp, err := service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if adResp.Format != "mp4" {
+		t.Errorf("Expected fallback format mp4, got %s", adResp.Format)
+	}
+
+	if len(adResp.Warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", adResp.Warnings)
+	}
+
+	expected := "preferred format webm unavailable, served mp4"
+	if adResp.Warnings[0] != expected {
+		t.Errorf("Expected warning %q, got %q", expected, adResp.Warnings[0])
+	}
+
+	// Requesting the available format produces no warnings
+	req.PreferredFormat = "mp4"
+	adResp, err = service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(adResp.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", adResp.Warnings)
+	}
+}
+
+func TestSelectAd_PreferredFormatCaseInsensitive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// Creatives written before normalization may carry any casing
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, map[string]interface{}{"format": "MP4"}); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	for _, preferred := range []string{"MP4", "Mp4", "mp4"} {
+		req := &models.AdRequest{
+			DeviceID:        "device-123",
+			DeviceType:      "ctv",
+			AppID:           "app-456",
+			PreferredFormat: preferred,
+		}
+
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", preferred, err)
+		}
+		if adResp.Format != "mp4" {
+			t.Errorf("%s: expected normalized format mp4, got %s", preferred, adResp.Format)
+		}
+		if len(adResp.Warnings) != 0 {
+			t.Errorf("%s: expected no warnings, got %v", preferred, adResp.Warnings)
+		}
+		if adResp.Decision.CreativeStrategy != "preferred_format" {
+			t.Errorf("%s: expected preferred_format strategy, got %s", preferred, adResp.Decision.CreativeStrategy)
+		}
+	}
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	for _, format := range []string{"MP4", "Mp4", "mp4", " mp4 "} {
+		if got := normalizeFormat(format); got != "mp4" {
+			t.Errorf("normalizeFormat(%q) = %q, want mp4", format, got)
+		}
+	}
+
+	// Allowlists match regardless of casing on either side
+	campaign := map[string]string{"allowed_formats": "MP4, webm"}
+	creative := map[string]string{
+		"video_url": "https://example.com/test-video.mp4",
+		"duration":  "30",
+		"format":    "Mp4",
+		"status":    "active",
+	}
+	if err := validateCreative(campaign, creative, defaultCreativeURLSchemes); err != nil {
+		t.Errorf("Expected mixed-case format to be allowed, got: %v", err)
+	}
+}
+
+func TestDeviceHistory_Capped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	service := NewAdService(redisClient, testConfig())
+
+	deviceID := "device-" + uuid.New().String()
+	now := time.Now()
+	for i := 0; i < deviceHistoryLimit+5; i++ {
+		service.recordServe(ctx, deviceID, models.ServedAd{
+			AdID:       strconv.Itoa(i),
+			CampaignID: "campaign-history",
+			CreativeID: "creative-history",
+			ServedAt:   now.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	history, err := service.DeviceHistory(ctx, "", deviceID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(history.Served) != deviceHistoryLimit {
+		t.Fatalf("Expected history capped at %d, got %d", deviceHistoryLimit, len(history.Served))
+	}
+	if history.Served[0].AdID != strconv.Itoa(deviceHistoryLimit+4) {
+		t.Errorf("Expected newest serve first, got %s", history.Served[0].AdID)
+	}
+	if last := history.Served[len(history.Served)-1].AdID; last != "5" {
+		t.Errorf("Expected oldest serves trimmed, last is %s", last)
+	}
+
+	// A device without serves has an empty history, not an error
+	empty, err := service.DeviceHistory(ctx, "", "device-"+uuid.New().String())
+	if err != nil || len(empty.Served) != 0 {
+		t.Errorf("Expected empty history, got %+v (err %v)", empty, err)
+	}
+}
+
+func TestCanonicalCampaignID(t *testing.T) {
+	for _, member := range []string{"abc-123", " abc-123", "abc-123\n", "campaign:abc-123", " campaign:abc-123 "} {
+		if got := canonicalCampaignID(member); got != "abc-123" {
+			t.Errorf("canonicalCampaignID(%q) = %q, want abc-123", member, got)
+		}
+	}
+}
+
+func TestReconcileActiveCampaigns_NormalizesMembership(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// 9000 remaining
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// A misconfigured writer double-adds the campaign under variant members,
+	// and the canonical score has drifted
+	variants := []string{" " + campaignID, "campaign:" + campaignID}
+	for i, member := range variants {
+		if err := redisClient.AddActiveCampaign(ctx, member, float64(100*(i+1))); err != nil {
+			t.Fatalf("Failed to add active campaign: %v", err)
+		}
+		defer redisClient.RemoveActiveCampaign(ctx, member)
+	}
+	if err := redisClient.AddActiveCampaign(ctx, campaignID, 1); err != nil {
+		t.Fatalf("Failed to add active campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	corrected, err := service.ReconcileActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	found := false
+	for _, id := range corrected {
+		found = found || id == campaignID
+	}
+	if !found {
+		t.Errorf("Expected %s among corrected campaigns, got %v", campaignID, corrected)
+	}
+
+	members, scores, err := redisClient.GetActiveCampaignBudgets(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get active campaigns: %v", err)
+	}
+	var matching []string
+	for _, member := range members {
+		if canonicalCampaignID(member) == campaignID {
+			matching = append(matching, member)
+		}
+	}
+	if len(matching) != 1 || matching[0] != campaignID {
+		t.Fatalf("Expected the campaign once under its bare ID, got %q", matching)
+	}
+	if scores[campaignID] != 9000 {
+		t.Errorf("Expected score 9000 (remaining budget), got %v", scores[campaignID])
+	}
+
+	// A second pass finds nothing to fix
+	corrected, err = service.ReconcileActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, id := range corrected {
+		if id == campaignID {
+			t.Error("Expected reconciled membership to be stable")
+		}
+	}
+}
+
+func TestIsBudgetNearlyExhausted(t *testing.T) {
+	tests := []struct {
+		total, spent string
+		expected     bool
+	}{
+		{"10000", "1000", false},
+		{"10000", "9600", true},
+		{"10000", "9500", false},
+		{"0", "0", false},
+	}
+
+	for _, tt := range tests {
+		campaign := map[string]string{"budget_total": tt.total, "budget_spent": tt.spent}
+		if got := isBudgetNearlyExhausted(campaign); got != tt.expected {
+			t.Errorf("isBudgetNearlyExhausted(%s/%s) = %v, expected %v", tt.spent, tt.total, got, tt.expected)
+		}
+	}
+}
+
+func TestIsUnderperforming(t *testing.T) {
+	tests := []struct {
+		name        string
+		performance map[string]string
+		expected    bool
+	}{
+		{"no data", nil, false},
+		{"insufficient sample", map[string]string{"impressions": "50", "completions": "1"}, false},
+		{"low completion rate", map[string]string{"impressions": "2000", "completions": "200"}, true},
+		{"healthy completion rate", map[string]string{"impressions": "2000", "completions": "1600"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isUnderperforming(tt.performance, 0.5, 1000); got != tt.expected {
+			t.Errorf("%s: isUnderperforming = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestSelectAd_MinPerformanceThreshold(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, lowCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, lowCreativeID)
+
+	newCreativeID := seedCreatives(t, redisClient, campaignID, 1)[0]
+	defer redisClient.DeleteCreative(ctx, newCreativeID, campaignID)
+
+	// 10% VCR over 2000 impressions: excluded
+	if err := redisClient.SetCreativePerformance(ctx, lowCreativeID, 2000, 200); err != nil {
+		t.Fatalf("Failed to set creative performance: %v", err)
+	}
+	// Same VCR but only 20 impressions: not enough data to exclude
+	if err := redisClient.SetCreativePerformance(ctx, newCreativeID, 20, 2); err != nil {
+		t.Fatalf("Failed to set creative performance: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	service.minCompletionRate = 0.5
+	service.minPerformanceSample = 1000
+
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	for i := 0; i < 20; i++ {
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if adResp.CreativeID == lowCreativeID {
+			t.Fatal("Underperforming creative should have been excluded")
+		}
+		if adResp.CreativeID != newCreativeID {
+			t.Errorf("Expected creative %s, got %s", newCreativeID, adResp.CreativeID)
+		}
+	}
+}
+
+func TestValidateCreative(t *testing.T) {
+	campaign := map[string]string{"allowed_formats": "mp4, webm"}
+	valid := map[string]string{
+		"video_url": "https://example.com/test-video.mp4",
+		"duration":  "30",
+		"format":    "mp4",
+		"status":    "active",
+	}
+
+	if err := validateCreative(campaign, valid, defaultCreativeURLSchemes); err != nil {
+		t.Errorf("Expected valid creative, got: %v", err)
+	}
+
+	// Campaigns without allowed_formats accept any format
+	mov := map[string]string{"video_url": valid["video_url"], "duration": "30", "format": "mov", "status": "active"}
+	if err := validateCreative(map[string]string{}, mov, defaultCreativeURLSchemes); err != nil {
+		t.Errorf("Expected creative to be valid without allowlist, got: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		creative map[string]string
+	}{
+		{"disallowed format", mov},
+		{"missing video_url", map[string]string{"duration": "30", "format": "mp4", "status": "active"}},
+		{"http video_url", map[string]string{"video_url": "http://example.com/test-video.mp4", "duration": "30", "format": "mp4", "status": "active"}},
+		{"non-numeric duration", map[string]string{"video_url": valid["video_url"], "duration": "abc", "format": "mp4", "status": "active"}},
+		{"negative weight", map[string]string{"video_url": valid["video_url"], "duration": "30", "format": "mp4", "status": "active", "weight": "-1"}},
+		{"non-numeric weight", map[string]string{"video_url": valid["video_url"], "duration": "30", "format": "mp4", "status": "active", "weight": "heavy"}},
+		{"oversized weight", map[string]string{"video_url": valid["video_url"], "duration": "30", "format": "mp4", "status": "active", "weight": "5e12"}},
+		{"NaN weight", map[string]string{"video_url": valid["video_url"], "duration": "30", "format": "mp4", "status": "active", "weight": "NaN"}},
+	}
+
+	for _, tt := range tests {
+		if err := validateCreative(campaign, tt.creative, defaultCreativeURLSchemes); !errors.Is(err, ErrInvalidCreative) {
+			t.Errorf("%s: expected ErrInvalidCreative, got: %v", tt.name, err)
+		}
+	}
+}
+
+func TestSaveCreative_DisallowedFormat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"allowed_formats": "mp4"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	webmID := uuid.New().String()
+	err := service.SaveCreative(ctx, webmID, campaignID, map[string]string{
+		"video_url": "https://example.com/test-video.webm",
+		"duration":  "15",
+		"format":    "webm",
+		"status":    "active",
+	})
+	if !errors.Is(err, ErrInvalidCreative) {
+		t.Fatalf("Expected ErrInvalidCreative, got: %v", err)
+	}
+
+	// Rejected creative must not have been written
+	if _, err := redisClient.GetCreative(ctx, webmID); err == nil {
+		t.Error("Rejected creative should not exist in Redis")
+	}
+}
+
+func TestSampledIncrement_ApproximatesTrueCount(t *testing.T) {
+	service := &AdService{
+		rng:               newLockedRand(42),
+		counterSampleRate: 0.1,
+	}
+
+	const events = 100000
+	var total int64
+	for i := 0; i < events; i++ {
+		if n, ok := service.sampledIncrement(); ok {
+			total += n
+		}
+	}
+
+	// Scaled total should be within 5% of the true count
+	if diff := math.Abs(float64(total-events)) / events; diff > 0.05 {
+		t.Errorf("Expected scaled count near %d, got %d (%.1f%% off)", events, total, diff*100)
+	}
+}
+
+func TestIncrementCampaignRequests_Sampled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	service := NewAdService(redisClient, testConfig())
+	service.rng = newLockedRand(42)
+	service.counterSampleRate = 0.1
+
+	campaignID := uuid.New().String()
+	const events = 5000
+	for i := 0; i < events; i++ {
+		if err := service.incrementCampaignRequests(ctx, campaignID); err != nil {
+			t.Fatalf("Failed to increment campaign requests: %v", err)
+		}
+	}
+
+	key := fmt.Sprintf("campaign:%s:requests:%s", campaignID, time.Now().Format("2006010215"))
+	count, err := redisClient.GetCounter(ctx, key)
+	if err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+
+	if diff := math.Abs(float64(count-events)) / events; diff > 0.15 {
+		t.Errorf("Expected scaled count near %d, got %d", events, count)
+	}
+}
+
+func TestFrequencySubject(t *testing.T) {
+	if got := frequencySubject("device-1", "household-1"); got != "household:household-1" {
+		t.Errorf("Expected household subject, got %s", got)
+	}
+	if got := frequencySubject("device-1", ""); got != "device:device-1" {
+		t.Errorf("Expected device subject, got %s", got)
+	}
+}
+
+func TestSelectAd_HouseholdFrequencyCap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"frequency_cap": 1}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	householdID := uuid.New().String()
+	tvID := uuid.New().String()
+	phoneID := uuid.New().String()
+
+	// The household's CTV has already seen the campaign this hour
+	if err := redisClient.IncrementFrequencyCount(ctx, frequencySubject(tvID, householdID), campaignID); err != nil {
+		t.Fatalf("Failed to increment frequency count: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	// A phone in the same household shares the cap
+	adResp, err := service.SelectAd(ctx, &models.AdRequest{
+		DeviceID:    phoneID,
+		DeviceType:  "mobile",
+		HouseholdID: householdID,
+	})
+	if err == nil || adResp != nil {
+		t.Error("Expected household-capped device to get no ad")
+	}
+
+	// The same devices without a household are capped independently
+	for _, deviceID := range []string{tvID, phoneID} {
+		adResp, err = service.SelectAd(ctx, &models.AdRequest{
+			DeviceID:   deviceID,
+			DeviceType: "ctv",
+		})
+		if err != nil {
+			t.Fatalf("Expected device %s without household to be served, got: %v", deviceID, err)
+		}
+		if adResp.CampaignID != campaignID {
+			t.Errorf("Expected campaign_id %s, got %s", campaignID, adResp.CampaignID)
+		}
+	}
+}
+
+func TestFrequencyWindow(t *testing.T) {
+	tests := []struct {
+		window   string
+		expected string
+	}{
+		{"", "hour"},
+		{"hour", "hour"},
+		{"day", "day"},
+		{"DAY", "day"},
+		{"week", "hour"},
+	}
+
+	for _, tt := range tests {
+		if got := frequencyWindow(map[string]string{"frequency_window": tt.window}); got != tt.expected {
+			t.Errorf("frequencyWindow(%q) = %s, want %s", tt.window, got, tt.expected)
+		}
+	}
+}
+
+func TestSelectAd_DeviceFrequencyCapWindow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+		"frequency_cap":    2,
+		"frequency_window": "day",
+	}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	deviceID := uuid.New().String()
+	subject := frequencySubject(deviceID, "")
+
+	// Served until the device reaches the daily cap
+	for i := 0; i < 2; i++ {
+		adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: deviceID})
+		if err != nil {
+			t.Fatalf("Expected impression %d to be served, got: %v", i+1, err)
+		}
+		if err := redisClient.IncrementFrequencyCount(ctx, subject, adResp.CampaignID); err != nil {
+			t.Fatalf("Failed to increment frequency count: %v", err)
+		}
+	}
+
+	daily, err := redisClient.GetFrequencyCount(ctx, subject, campaignID, "day")
+	if err != nil {
+		t.Fatalf("Failed to get frequency count: %v", err)
+	}
+	if daily != 2 {
+		t.Errorf("Expected daily count 2, got %d", daily)
+	}
+
+	_, err = service.SelectAd(ctx, &models.AdRequest{DeviceID: deviceID})
+	if !errors.Is(err, ErrNoEligibleCampaigns) {
+		t.Errorf("Expected capped device to get ErrNoEligibleCampaigns, got %v", err)
+	}
+
+	// Other devices are unaffected
+	if _, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()}); err != nil {
+		t.Errorf("Expected an uncapped device to be served, got: %v", err)
+	}
+}
+
+func TestValidateCreativeURL(t *testing.T) {
+	withHTTP := []string{"https", "http"}
+	withFile := []string{"https", "file"}
+
+	tests := []struct {
+		url      string
+		schemes  []string
+		expected bool
+	}{
+		{"https://cdn.example.com/ad.mp4", defaultCreativeURLSchemes, true},
+		{"http://cdn.example.com/ad.mp4", defaultCreativeURLSchemes, false},
+		{"http://cdn.example.com/ad.mp4", withHTTP, true},
+		{"HTTPS://cdn.example.com/ad.mp4", defaultCreativeURLSchemes, true},
+		{"file:///etc/passwd", withFile, false},
+		{"gopher://internal:70/_x", withHTTP, false},
+		{"/relative/ad.mp4", withHTTP, false},
+		{"https:///no-host.mp4", withHTTP, false},
+	}
+
+	for _, tt := range tests {
+		err := validateCreativeURL(tt.url, tt.schemes)
+		if got := err == nil; got != tt.expected {
+			t.Errorf("validateCreativeURL(%q, %v) valid = %v, expected %v (err: %v)", tt.url, tt.schemes, got, tt.expected, err)
+		}
+	}
+}
+
+func TestHasBudgetForImpression_Currency(t *testing.T) {
+	service := &AdService{
+		baseCurrency: "USD",
+		fxRates:      parseFXRates("EUR:1.10, GBP:bad"),
+	}
+
+	// EUR 0.95 remaining against a $1000 CPM ($1.00 per impression)
+	eurCampaign := map[string]string{
+		"budget_total": "100.00",
+		"budget_spent": "99.05",
+		"currency":     "EUR",
+		"cpm":          "1000",
+	}
+
+	// EUR 0.95 * 1.10 = USD 1.045, enough for one impression
+	if !service.hasBudgetForImpression("eur-campaign", eurCampaign) {
+		t.Error("Expected EUR campaign to be eligible after FX normalization")
+	}
+
+	// The same remaining amount in USD is not enough
+	usdCampaign := map[string]string{
+		"budget_total": "100.00",
+		"budget_spent": "99.05",
+		"currency":     "USD",
+		"cpm":          "1000",
+	}
+	if service.hasBudgetForImpression("usd-campaign", usdCampaign) {
+		t.Error("Expected USD campaign with $0.95 remaining to be ineligible")
+	}
+
+	// Missing rate: amount is used unconverted
+	gbpCampaign := map[string]string{
+		"budget_total": "100.00",
+		"budget_spent": "99.05",
+		"currency":     "GBP",
+		"cpm":          "1000",
+	}
+	if service.hasBudgetForImpression("gbp-campaign", gbpCampaign) {
+		t.Error("Expected GBP campaign without a valid rate to be evaluated unconverted")
+	}
+
+	// Without a CPM only the raw budget is checked
+	delete(usdCampaign, "cpm")
+	if !service.hasBudgetForImpression("usd-campaign", usdCampaign) {
+		t.Error("Expected campaign without CPM to be eligible while budget remains")
+	}
+}
+
+func TestHasBudgetForImpression_BudgetUnit(t *testing.T) {
+	// "1000" left against a $20000 CPM ($20.00 per impression)
+	campaign := map[string]string{
+		"budget_total": "1000",
+		"budget_spent": "0",
+		"cpm":          "20000",
+	}
+
+	dollars := &AdService{budgetUnit: BudgetUnitDollars}
+	if got := dollars.toCurrencyUnits(1000, campaign); got != 1000 {
+		t.Errorf("Expected 1000 to be $1000 in dollars mode, got %v", got)
+	}
+	if !dollars.hasBudgetForImpression("campaign-1", campaign) {
+		t.Error("Expected $1000 to cover a $20 impression")
+	}
+
+	cents := &AdService{budgetUnit: BudgetUnitCents}
+	if got := cents.toCurrencyUnits(1000, campaign); got != 10 {
+		t.Errorf("Expected 1000 to be $10 in cents mode, got %v", got)
+	}
+	if cents.hasBudgetForImpression("campaign-1", campaign) {
+		t.Error("Expected $10 not to cover a $20 impression")
+	}
+
+	// The campaign's budget_unit overrides the configured default
+	campaign["budget_unit"] = "cents"
+	if dollars.hasBudgetForImpression("campaign-1", campaign) {
+		t.Error("Expected budget_unit cents to override dollars mode")
+	}
+	campaign["budget_unit"] = "dollars"
+	if !cents.hasBudgetForImpression("campaign-1", campaign) {
+		t.Error("Expected budget_unit dollars to override cents mode")
+	}
+}
+
+func TestParseBudgetUnit(t *testing.T) {
+	for value, want := range map[string]string{"": BudgetUnitDollars, "dollars": BudgetUnitDollars, " CENTS ": BudgetUnitCents, "pennies": BudgetUnitDollars} {
+		if got := parseBudgetUnit(value); got != want {
+			t.Errorf("budget unit %q: expected %s, got %s", value, want, got)
+		}
+	}
+}
+
+func TestTrackImpression_ChargesInBudgetUnit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		1000000.0, // $10000 in cents
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20, "budget_unit": "cents"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	req := models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	}
+	if _, err := service.TrackImpression(ctx, &req); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// A $0.02 impression is 2 cents
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	if spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64); math.Abs(spent-2) > 1e-9 {
+		t.Errorf("Expected a 2 cent charge, budget_spent is %s", campaign["budget_spent"])
+	}
+}
+
+func TestWeightedPick(t *testing.T) {
+	weights := []int64{100, 0, 300}
+
+	tests := []struct {
+		draw     int64
+		expected int
+	}{
+		{0, 0},
+		{99, 0},
+		{100, 2},
+		{399, 2},
+	}
+
+	for _, tt := range tests {
+		if got := weightedPick(weights, tt.draw); got != tt.expected {
+			t.Errorf("weightedPick(%v, %v) = %d, expected %d", weights, tt.draw, got, tt.expected)
+		}
+	}
+}
+
+func TestWeightedPick_ExactIntegerDistribution(t *testing.T) {
+	// Enumerating every draw must select each campaign exactly weight times
+	weights := make([]int64, 500)
+	for i := range weights {
+		weights[i] = int64(i % 7) // Includes zero weights
+	}
+
+	counts := make([]int64, len(weights))
+	total := totalWeight(weights)
+	for draw := int64(0); draw < total; draw++ {
+		counts[weightedPick(weights, draw)]++
+	}
+	for i := range weights {
+		if counts[i] != weights[i] {
+			t.Fatalf("Campaign %d: selected %d of %d draws, expected exactly %d", i, counts[i], total, weights[i])
+		}
+	}
+
+	// Budgets in cents beyond float64's 53-bit precision still split exactly
+	large := []int64{1 << 53, 1, 1 << 53}
+	if got := weightedPick(large, 1<<53); got != 1 {
+		t.Errorf("Expected the 1-cent campaign at draw 2^53, got %d", got)
+	}
+	if got := weightedPick(large, 1<<53+1); got != 2 {
+		t.Errorf("Expected the last campaign at draw 2^53+1, got %d", got)
+	}
+}
+
+func TestWeightUnits(t *testing.T) {
+	if got := weightUnits(0.55); got != 550_000 {
+		t.Errorf("Expected 550000 units, got %d", got)
+	}
+	if got := weightUnits(-1); got != 0 {
+		t.Errorf("Expected negative weight to be 0 units, got %d", got)
+	}
+	if got := weightUnits(1e13); got != math.MaxInt64 {
+		t.Errorf("Expected an out-of-range weight to saturate, got %d", got)
+	}
+}
+
+func TestDrawWeighted_HugeWeightsDontOverflow(t *testing.T) {
+	rng := newLockedRand(1)
+
+	// Two 5e12 weights would overflow a plain int64 sum
+	weights := []int64{weightUnits(5e12), weightUnits(5e12)}
+	if total := totalWeight(weights); total != math.MaxInt64 {
+		t.Errorf("Expected the total to saturate, got %d", total)
+	}
+	for i := 0; i < 100; i++ {
+		if got := drawWeighted(rng, weights); got < 0 || got > 1 {
+			t.Fatalf("Expected index 0 or 1, got %d", got)
+		}
+	}
+}
+
+func TestBudgetWeight_HugeBudgetsSaturate(t *testing.T) {
+	// 1e17 dollars is beyond an int64 of cents
+	for _, tt := range []struct {
+		remaining, factor float64
+	}{
+		{1e17, 1},
+		{1e17, 3},
+		{math.Inf(1), 1},
+	} {
+		if got := budgetWeight(tt.remaining, tt.factor); got != math.MaxInt64 {
+			t.Errorf("budgetWeight(%v, %v) = %d, want saturation at %d", tt.remaining, tt.factor, got, int64(math.MaxInt64))
+		}
+	}
+
+	// cents times the boosted factor's units overflows an int64 here, but
+	// the scaled weight fits and stays exact
+	if got, want := budgetWeight(1e13, 3), int64(3e15); got != want {
+		t.Errorf("budgetWeight(1e13, 3) = %d, want %d", got, want)
+	}
+	if got := budgetWeight(math.NaN(), 1); got != 0 {
+		t.Errorf("Expected a NaN budget to weigh 0, got %d", got)
+	}
+}
+
+func TestRampUpFactor(t *testing.T) {
+	window := 4 * time.Hour
+
+	tests := []struct {
+		sinceStart time.Duration
+		expected   float64
+	}{
+		{0, 0.1},
+		{2 * time.Hour, 0.55},
+		{4 * time.Hour, 1},
+		{48 * time.Hour, 1},
+	}
+
+	for _, tt := range tests {
+		if got := rampUpFactor(tt.sinceStart, window, 0.1); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("rampUpFactor(%v) = %v, expected %v", tt.sinceStart, got, tt.expected)
+		}
+	}
+
+	if got := rampUpFactor(0, 0, 0.1); got != 1 {
+		t.Errorf("Expected ramp-up disabled with zero window, got %v", got)
+	}
+}
+
+func TestRampUp_SelectionProbabilityIncreases(t *testing.T) {
+	window := 4 * time.Hour
+	rng := newLockedRand(42)
+
+	// Share of draws won by a launching campaign against an established one
+	share := func(sinceStart time.Duration) float64 {
+		weights := []int64{weightUnits(1), weightUnits(rampUpFactor(sinceStart, window, 0.1))}
+		wins := 0
+		for i := 0; i < 10000; i++ {
+			if weightedPick(weights, rng.Int63n(totalWeight(weights))) == 1 {
+				wins++
+			}
+		}
+		return float64(wins) / 10000
+	}
+
+	justLaunched := share(0)
+	halfway := share(2 * time.Hour)
+	rampedUp := share(window)
+
+	if !(justLaunched < halfway && halfway < rampedUp) {
+		t.Errorf("Expected increasing selection share, got %.3f, %.3f, %.3f", justLaunched, halfway, rampedUp)
+	}
+	if math.Abs(justLaunched-0.1/1.1) > 0.02 {
+		t.Errorf("Expected just-launched share near %.3f, got %.3f", 0.1/1.1, justLaunched)
+	}
+	if math.Abs(rampedUp-0.5) > 0.02 {
+		t.Errorf("Expected ramped-up share near 0.5, got %.3f", rampedUp)
+	}
+}
+
+func TestBudgetWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		remaining  float64
+		rampFactor float64
+		expected   int64
+	}{
+		{"full weight", 9000, 1, 900_000},
+		{"fractional cents", 12.345, 1, 1235},
+		{"ramping up", 9000, 0.1, 90_000},
+		{"tiny budget stays drawable", 0.01, 0.1, 1},
+		{"exhausted", 0, 1, 0},
+		{"negative", -5, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := budgetWeight(tt.remaining, tt.rampFactor); got != tt.expected {
+				t.Errorf("budgetWeight(%v, %v) = %d, want %d", tt.remaining, tt.rampFactor, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBudgetWeight_SeededDrawProportionalToRemaining(t *testing.T) {
+	rng := newLockedRand(42)
+	weights := []int64{budgetWeight(1000, 1), budgetWeight(3000, 1)}
+
+	wins := 0
+	for i := 0; i < 10000; i++ {
+		if drawWeighted(rng, weights) == 1 {
+			wins++
+		}
+	}
+
+	// The seeded draw is reproducible: the same seed gives the same count
+	rng = newLockedRand(42)
+	again := 0
+	for i := 0; i < 10000; i++ {
+		if drawWeighted(rng, weights) == 1 {
+			again++
+		}
+	}
+	if wins != again {
+		t.Errorf("Expected identical seeded draws, got %d and %d wins", wins, again)
+	}
+	if share := float64(wins) / 10000; math.Abs(share-0.75) > 0.02 {
+		t.Errorf("Expected the campaign with 3x the budget to win ~75%%, got %.3f", share)
+	}
+}
+
+func TestSelectAd_WeightedByRemainingBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// $1,000 left vs $9,000 left
+	smallID, smallCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		9000.0,
+	)
+	defer cleanupTestData(t, redisClient, smallID, smallCreativeID)
+
+	largeID, largeCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, largeID, largeCreativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	service.rng = newLockedRand(42)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		counts[adResp.CampaignID]++
+	}
+
+	if share := float64(counts[largeID]) / 1000; math.Abs(share-0.9) > 0.04 {
+		t.Errorf("Expected the campaign with 90%% of the remaining budget to win ~90%%, got %.3f", share)
+	}
+}
+
+func TestExpandTrackingMacros(t *testing.T) {
+	got := expandTrackingMacros("https://vendor.example.com/imp?ad={ad_id}&dev={device_id}&x={unknown}", map[string]string{
+		"ad_id":     "ad-1",
+		"device_id": "device 1&2",
+	})
+
+	expected := "https://vendor.example.com/imp?ad=ad-1&dev=device+1%262&x={unknown}"
+	if got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestSelectAd_CreativeTrackingBase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	// Without tracking_base the default endpoint is used
+	adResp, err := service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if adResp.TrackingURL != defaultTrackingURL {
+		t.Errorf("Expected tracking_url %s, got %s", defaultTrackingURL, adResp.TrackingURL)
+	}
+
+	creativeData := map[string]interface{}{
+		"tracking_base": "https://measure.example.com/imp/{creative_id}?ad={ad_id}",
+	}
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, creativeData); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+
+	adResp, err = service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := fmt.Sprintf("https://measure.example.com/imp/%s?ad=%s", creativeID, adResp.AdID)
+	if adResp.TrackingURL != expected {
+		t.Errorf("Expected tracking_url %s, got %s", expected, adResp.TrackingURL)
+	}
+}
+
+func TestDeleteCampaign_SoftDeleteAndReap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+
+	if err := service.DeleteCampaign(ctx, campaignID); err != nil {
+		t.Fatalf("Failed to delete campaign: %v", err)
+	}
+
+	// Soft-deleted campaign is no longer eligible
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+	if adResp, err := service.SelectAd(ctx, req); err == nil || adResp != nil {
+		t.Error("Expected soft-deleted campaign to be ineligible")
+	}
+
+	activeIDs, err := redisClient.GetActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get active campaigns: %v", err)
+	}
+	for _, id := range activeIDs {
+		if id == campaignID {
+			t.Error("Soft-deleted campaign should be removed from active_campaigns")
+		}
+	}
+
+	// An update that read the campaign before the delete can't reactivate it
+	if _, err := redisClient.SaveCampaign(ctx, campaignID, map[string]string{"status": "active"}, true); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected saving a deleted campaign to be refused, got: %v", err)
+	}
+
+	// Data is retained until the reaper runs
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Expected soft-deleted campaign data to remain, got: %v", err)
+	}
+	if campaign["status"] != "deleted" {
+		t.Errorf("Expected status deleted, got %s", campaign["status"])
+	}
+
+	// Nothing to reap while within the retention window
+	if reaped, _ := service.ReapTombstones(ctx); len(reaped) != 0 {
+		t.Errorf("Expected nothing reaped within retention, got %v", reaped)
+	}
+
+	// With an expired tombstone the reaper purges the campaign
+	service.tombstoneRetention = -time.Second
+	if err := service.DeleteCampaign(ctx, campaignID); err != nil {
+		t.Fatalf("Failed to delete campaign: %v", err)
+	}
+	if _, err := service.ReapTombstones(ctx); err != nil {
+		t.Fatalf("Failed to reap tombstones: %v", err)
+	}
+
+	if _, err := redisClient.GetCampaign(ctx, campaignID); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected reaped campaign to be gone, got: %v", err)
+	}
+	if creatives, _ := redisClient.GetCampaignCreatives(ctx, campaignID); len(creatives) != 0 {
+		t.Errorf("Expected reaped campaign creatives set to be gone, got %v", creatives)
+	}
+
+	// Nor can an update recreate it once reaped
+	if _, err := redisClient.SaveCampaign(ctx, campaignID, map[string]string{"status": "active"}, true); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected updating a reaped campaign to be refused, got: %v", err)
+	}
+	if _, err := redisClient.GetCampaign(ctx, campaignID); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected the refused update not to recreate the campaign, got: %v", err)
+	}
+}
+
+func TestFreshnessFactor(t *testing.T) {
+	window := 24 * time.Hour
+
+	tests := []struct {
+		age      time.Duration
+		expected float64
+	}{
+		{0, 3},
+		{12 * time.Hour, 2},
+		{24 * time.Hour, 1},
+		{72 * time.Hour, 1},
+	}
+
+	for _, tt := range tests {
+		if got := freshnessFactor(tt.age, window, 3); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("freshnessFactor(%v) = %v, expected %v", tt.age, got, tt.expected)
+		}
+	}
+}
+
+func TestCreativeWeight_FreshnessConverges(t *testing.T) {
+	service := &AdService{
+		rng:             newLockedRand(42),
+		freshnessWindow: 24 * time.Hour,
+		freshnessBoost:  3,
+	}
+
+	now := time.Now().Truncate(time.Second)
+	established := map[string]string{"created_at": now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)}
+
+	// Share of draws won by a creative of the given age against an
+	// established creative with the same base weight
+	share := func(age time.Duration) float64 {
+		fresh := map[string]string{"created_at": now.Add(-age).Format(time.RFC3339)}
+		weights := []int64{
+			weightUnits(service.creativeWeight(established, now)),
+			weightUnits(service.creativeWeight(fresh, now)),
+		}
+		wins := 0
+		for i := 0; i < 10000; i++ {
+			if drawWeighted(service.rng, weights) == 1 {
+				wins++
+			}
+		}
+		return float64(wins) / 10000
+	}
+
+	if got := share(0); math.Abs(got-0.75) > 0.02 {
+		t.Errorf("Expected new creative share near 0.75, got %.3f", got)
+	}
+	if got := share(48 * time.Hour); math.Abs(got-0.5) > 0.02 {
+		t.Errorf("Expected creative past the boost window to converge to 0.5, got %.3f", got)
+	}
+
+	// Base weight is combined with the boost
+	weighted := map[string]string{"weight": "2", "created_at": now.Format(time.RFC3339)}
+	if got := service.creativeWeight(weighted, now); got != 6 {
+		t.Errorf("Expected combined weight 6, got %v", got)
+	}
+}
+
+func TestValidateDeviceID(t *testing.T) {
+	service := &AdService{
+		deviceIDValidation: true,
+		deviceIDSentinels:  defaultDeviceIDSentinels,
+	}
+
+	for _, deviceID := range []string{"null", "NULL", "undefined", "00000000-0000-0000-0000-000000000000", "0"} {
+		if err := service.ValidateDeviceID(deviceID); err != ErrMalformedDeviceID {
+			t.Errorf("Expected %q to be rejected, got: %v", deviceID, err)
+		}
+	}
+
+	for _, deviceID := range []string{uuid.New().String(), "device-123", "roku-4f1a0c"} {
+		if err := service.ValidateDeviceID(deviceID); err != nil {
+			t.Errorf("Expected %q to be valid, got: %v", deviceID, err)
+		}
+	}
+
+	// Validation is opt-in
+	service.deviceIDValidation = false
+	if err := service.ValidateDeviceID("null"); err != nil {
+		t.Errorf("Expected no validation when disabled, got: %v", err)
+	}
+}
+
+func TestRotationPickers(t *testing.T) {
+	if got := rotationMode(map[string]string{"rotation_mode": "Even"}); got != RotationEven {
+		t.Errorf("Expected even, got %s", got)
+	}
+	if got := rotationMode(map[string]string{"rotation_mode": "bogus"}); got != RotationWeighted {
+		t.Errorf("Expected unknown mode to default to weighted, got %s", got)
+	}
+
+	ids := []string{"c", "a", "b"}
+
+	if got := leastServed(ids, map[string]int64{"c": 5, "a": 2, "b": 2}); got != "a" {
+		t.Errorf("Expected least served a, got %s", got)
+	}
+
+	performance := map[string]map[string]string{
+		"a": {"impressions": "2000", "completions": "1800"},
+		"b": {"impressions": "2000", "completions": "400"},
+		"c": {"impressions": "2000", "completions": "1000"},
+	}
+	if got := bestPerforming(ids, performance, 1000); got != "a" {
+		t.Errorf("Expected best performing a, got %s", got)
+	}
+
+	// Untested creatives are explored first
+	performance["d"] = map[string]string{"impressions": "10", "completions": "0"}
+	if got := bestPerforming(append(ids, "d"), performance, 1000); got != "d" {
+		t.Errorf("Expected untested creative d, got %s", got)
+	}
+
+	var sequence []string
+	for seq := int64(1); seq <= 4; seq++ {
+		sequence = append(sequence, sequentialPick(ids, seq))
+	}
+	if fmt.Sprint(sequence) != "[a b c a]" {
+		t.Errorf("Expected sequence [a b c a], got %v", sequence)
+	}
+}
+
+func TestSelectAd_RotationModes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	req := &models.AdRequest{
+		DeviceID:   "device-123",
+		DeviceType: "ctv",
+		AppID:      "app-456",
+	}
+
+	// seedRotation seeds a campaign with three creatives in the given mode
+	seedRotation := func(t *testing.T, mode string) (string, []string) {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		creativeIDs := append(seedCreatives(t, redisClient, campaignID, 2), creativeID)
+		t.Cleanup(func() {
+			for _, id := range creativeIDs {
+				redisClient.DeleteCreative(ctx, id, campaignID)
+			}
+			cleanupTestData(t, redisClient, campaignID, creativeID)
+		})
+
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"rotation_mode": mode}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		return campaignID, creativeIDs
+	}
+
+	t.Run("weighted", func(t *testing.T) {
+		campaignID, creativeIDs := seedRotation(t, RotationWeighted)
+		for _, id := range creativeIDs[1:] {
+			redisClient.SetCreative(ctx, id, campaignID, map[string]interface{}{"weight": 0})
+		}
+
+		service := NewAdService(redisClient, testConfig())
+		for i := 0; i < 10; i++ {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if adResp.CreativeID != creativeIDs[0] {
+				t.Errorf("Expected only non-zero weight creative %s, got %s", creativeIDs[0], adResp.CreativeID)
+			}
+		}
+	})
+
+	t.Run("even", func(t *testing.T) {
+		_, creativeIDs := seedRotation(t, RotationEven)
+
+		service := NewAdService(redisClient, testConfig())
+		served := make(map[string]int)
+		for i := 0; i < 6; i++ {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			served[adResp.CreativeID]++
+			time.Sleep(10 * time.Millisecond) // Serve counts are incremented async
+		}
+		for _, id := range creativeIDs {
+			if served[id] != 2 {
+				t.Errorf("Expected each creative served twice, got %v", served)
+				break
+			}
+		}
+	})
+
+	t.Run("optimized", func(t *testing.T) {
+		_, creativeIDs := seedRotation(t, RotationOptimized)
+		redisClient.SetCreativePerformance(ctx, creativeIDs[0], 2000, 500)
+		redisClient.SetCreativePerformance(ctx, creativeIDs[1], 2000, 1900)
+		redisClient.SetCreativePerformance(ctx, creativeIDs[2], 2000, 1000)
+
+		service := NewAdService(redisClient, testConfig())
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if adResp.CreativeID != creativeIDs[1] {
+			t.Errorf("Expected best performing creative %s, got %s", creativeIDs[1], adResp.CreativeID)
+		}
+	})
+
+	t.Run("sequential", func(t *testing.T) {
+		_, creativeIDs := seedRotation(t, RotationSequential)
+		sorted := append([]string(nil), creativeIDs...)
+		sort.Strings(sorted)
+
+		service := NewAdService(redisClient, testConfig())
+		for i := 0; i < 6; i++ {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if adResp.CreativeID != sorted[i%3] {
+				t.Errorf("Selection %d: expected %s, got %s", i, sorted[i%3], adResp.CreativeID)
+			}
+		}
+	})
+}
+
+func TestHasBudgetForImpression_CountsPendingSpend(t *testing.T) {
+	service := &AdService{
+		baseCurrency: defaultBaseCurrency,
+		spendBuffer:  newSpendBuffer(),
+	}
+	campaign := map[string]string{
+		"budget_total": "100",
+		"budget_spent": "99",
+		"cpm":          "100", // $0.10 per impression
+	}
+
+	if !service.hasBudgetForImpression("campaign-1", campaign) {
+		t.Fatal("Expected campaign with $1 remaining to be eligible")
+	}
+
+	// Buffered but unflushed spend must still close the budget
+	service.spendBuffer.add("campaign-1", charge{adID: "ad-1", amount: 0.95})
+	if service.hasBudgetForImpression("campaign-1", campaign) {
+		t.Error("Expected buffered spend to exhaust the remaining budget")
+	}
+}
+
+func TestTrackImpression_MakeGoodOnExhaustion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		100.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	bucket := "test-" + uuid.New().String()
+	t.Setenv("MAKEGOOD_BUCKET", bucket)
+	service := NewAdService(redisClient, testConfig())
+
+	// The ad was selected while the campaign had budget; other traffic then
+	// exhausts it before the impression arrives
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"budget_spent": 100.0}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	result, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !result.MakeGood {
+		t.Error("Expected the impression to be flagged as a make-good")
+	}
+
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	if campaign["budget_spent"] != "100" {
+		t.Errorf("Expected exhausted campaign not to be charged, budget_spent is %s", campaign["budget_spent"])
+	}
+
+	diverted, err := redisClient.GetMakeGoodImpressions(ctx, bucket, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get make-good impressions: %v", err)
+	}
+	if diverted != 1 {
+		t.Errorf("Expected 1 impression in the make-good bucket, got %d", diverted)
+	}
+}
+
+func TestTrackImpression_MakeGoodPartialCharge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		100.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	bucket := "test-" + uuid.New().String()
+	t.Setenv("MAKEGOOD_BUCKET", bucket)
+	service := NewAdService(redisClient, testConfig())
+
+	// Only half of the 0.02 impression is left when it arrives
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20, "budget_spent": 99.99}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	result, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !result.MakeGood {
+		t.Error("Expected the uncovered part to be flagged as a make-good")
+	}
+
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	if spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64); math.Abs(spent-100) > 1e-9 {
+		t.Errorf("Expected the covered part to be charged up to the budget, budget_spent is %s", campaign["budget_spent"])
+	}
+
+	diverted, err := redisClient.GetMakeGoodImpressions(ctx, bucket, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get make-good impressions: %v", err)
+	}
+	if diverted != 1 {
+		t.Errorf("Expected the uncovered impression in the make-good bucket, got %d", diverted)
+	}
+}
+
+func TestSpendBuffering(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	seedSpendCampaign := func(t *testing.T) string {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			0.0,
+		)
+		t.Cleanup(func() { cleanupTestData(t, redisClient, campaignID, creativeID) })
+
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		return campaignID
+	}
+
+	budgetSpent := func(t *testing.T, campaignID string) float64 {
+		campaign, err := redisClient.GetCampaign(ctx, campaignID)
+		if err != nil {
+			t.Fatalf("Failed to get campaign: %v", err)
+		}
+		spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+		return spent
+	}
+
+	t.Run("flushed by ticker", func(t *testing.T) {
+		campaignID := seedSpendCampaign(t)
+		t.Setenv("SPEND_FLUSH_INTERVAL_MS", "50")
+		service := NewAdService(redisClient, testConfig())
+
+		flusherCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		service.StartSpendFlusher(flusherCtx)
+
+		for i := 0; i < 5; i++ {
+			service.TrackImpression(ctx, &models.ImpressionRequest{CampaignID: campaignID, DeviceID: "device-123"})
+		}
+		if spent := budgetSpent(t, campaignID); spent != 0 {
+			t.Errorf("Expected spend to be buffered, got budget_spent %v", spent)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for budgetSpent(t, campaignID) == 0 && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if spent := budgetSpent(t, campaignID); math.Abs(spent-0.1) > 1e-9 {
+			t.Errorf("Expected budget_spent 0.1 after flush, got %v", spent)
+		}
+	})
+
+	t.Run("flushed on threshold", func(t *testing.T) {
+		campaignID := seedSpendCampaign(t)
+		t.Setenv("SPEND_FLUSH_INTERVAL_MS", "3600000")
+		t.Setenv("SPEND_FLUSH_THRESHOLD", "3")
+		service := NewAdService(redisClient, testConfig())
+
+		for i := 0; i < 3; i++ {
+			service.TrackImpression(ctx, &models.ImpressionRequest{CampaignID: campaignID, DeviceID: "device-123"})
+		}
+		if spent := budgetSpent(t, campaignID); math.Abs(spent-0.06) > 1e-9 {
+			t.Errorf("Expected budget_spent 0.06 at threshold, got %v", spent)
+		}
+	})
+
+	t.Run("flushed on shutdown", func(t *testing.T) {
+		campaignID := seedSpendCampaign(t)
+		t.Setenv("SPEND_FLUSH_INTERVAL_MS", "3600000")
+		service := NewAdService(redisClient, testConfig())
+
+		flusherCtx, cancel := context.WithCancel(context.Background())
+		service.StartSpendFlusher(flusherCtx)
+		service.TrackImpression(ctx, &models.ImpressionRequest{CampaignID: campaignID, DeviceID: "device-123"})
+		cancel()
+
+		if err := service.FlushSpend(ctx); err != nil {
+			t.Fatalf("Failed to flush spend: %v", err)
+		}
+		if spent := budgetSpent(t, campaignID); math.Abs(spent-0.02) > 1e-9 {
+			t.Errorf("Expected budget_spent 0.02 after shutdown flush, got %v", spent)
+		}
+	})
+}
+
+// recordingLedgerSink captures emitted ledger events, failing every emit
+// when fail is set
+type recordingLedgerSink struct {
+	events chan models.LedgerEvent
+	fail   bool
+}
+
+func newRecordingLedgerSink() *recordingLedgerSink {
+	return &recordingLedgerSink{events: make(chan models.LedgerEvent, 100)}
+}
+
+func (r *recordingLedgerSink) Emit(event models.LedgerEvent) error {
+	if r.fail {
+		return errors.New("sink unavailable")
+	}
+	r.events <- event
+	return nil
+}
+
+// next waits for the next emitted event
+func (r *recordingLedgerSink) next(t *testing.T) models.LedgerEvent {
+	t.Helper()
+	select {
+	case event := <-r.events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for ledger event")
+		return models.LedgerEvent{}
+	}
+}
+
+func TestEmitLedger_RunningSpent(t *testing.T) {
+	sink := newRecordingLedgerSink()
+	service := &AdService{ledgerSink: sink}
+
+	// A flushed batch of three charges that brought spend to 1.06
+	service.emitLedger("campaign-1", []charge{
+		{adID: "ad-1", amount: 0.02},
+		{adID: "ad-2", amount: 0.02},
+		{adID: "ad-3", amount: 0.02},
+	}, 1.06)
+
+	for i, wantSpent := range []float64{1.02, 1.04, 1.06} {
+		event := sink.next(t)
+		if event.CampaignID != "campaign-1" || event.AdID != fmt.Sprintf("ad-%d", i+1) {
+			t.Errorf("Event %d: got campaign %q ad %q", i, event.CampaignID, event.AdID)
+		}
+		if event.Amount != 0.02 {
+			t.Errorf("Event %d: expected amount 0.02, got %v", i, event.Amount)
+		}
+		if math.Abs(event.NewSpent-wantSpent) > 1e-9 {
+			t.Errorf("Event %d: expected new_spent %v, got %v", i, wantSpent, event.NewSpent)
+		}
+		if event.Timestamp.IsZero() {
+			t.Errorf("Event %d: expected a timestamp", i)
+		}
+	}
+}
+
+func TestEmitLedger_CurrencyUnits(t *testing.T) {
+	sink := newRecordingLedgerSink()
+	service := &AdService{ledgerSink: sink}
+
+	// A cents campaign charged 2 cents, bringing budget_spent to 106 cents
+	service.emitLedger("campaign-1", []charge{{adID: "ad-1", amount: 2, scale: 100}}, 106)
+
+	event := sink.next(t)
+	if math.Abs(event.Amount-0.02) > 1e-9 || math.Abs(event.NewSpent-1.06) > 1e-9 {
+		t.Errorf("Expected amount 0.02 and new_spent 1.06 in dollars, got %v and %v", event.Amount, event.NewSpent)
+	}
+}
+
+func TestTrackImpression_EmitsLedgerEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	t.Run("event per decrement", func(t *testing.T) {
+		sink := newRecordingLedgerSink()
+		service := NewAdService(redisClient, testConfig())
+		service.SetLedgerSink(sink)
+
+		for i := 1; i <= 3; i++ {
+			adID := fmt.Sprintf("ad-%d", i)
+			service.TrackImpression(ctx, &models.ImpressionRequest{AdID: adID, CampaignID: campaignID, DeviceID: "device-123"})
+
+			event := sink.next(t)
+			if event.CampaignID != campaignID || event.AdID != adID {
+				t.Errorf("Expected event for %s/%s, got %s/%s", campaignID, adID, event.CampaignID, event.AdID)
+			}
+			if math.Abs(event.Amount-0.02) > 1e-9 {
+				t.Errorf("Expected amount 0.02, got %v", event.Amount)
+			}
+			if want := 0.02 * float64(i); math.Abs(event.NewSpent-want) > 1e-9 {
+				t.Errorf("Expected new_spent %v, got %v", want, event.NewSpent)
+			}
+		}
+	})
+
+	t.Run("dead-lettered when sink fails", func(t *testing.T) {
+		sink := newRecordingLedgerSink()
+		sink.fail = true
+		service := NewAdService(redisClient, testConfig())
+		service.SetLedgerSink(sink)
+
+		adID := uuid.New().String()
+		service.TrackImpression(ctx, &models.ImpressionRequest{AdID: adID, CampaignID: campaignID, DeviceID: "device-123"})
+
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			payloads, err := redisClient.GetDeadLetters(ctx, ledgerDeadLetterQueue)
+			if err != nil {
+				t.Fatalf("Failed to get dead letters: %v", err)
+			}
+			for _, payload := range payloads {
+				var event models.LedgerEvent
+				if json.Unmarshal([]byte(payload), &event) == nil && event.AdID == adID {
+					if math.Abs(event.Amount-0.02) > 1e-9 {
+						t.Errorf("Expected dead-lettered amount 0.02, got %v", event.Amount)
+					}
+					return
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Error("Expected failed ledger event to be dead-lettered")
+	})
+}
+
+func TestMatchesDeal(t *testing.T) {
+	openCampaign := map[string]string{}
+	dealCampaign := map[string]string{"deal_id": "deal-1"}
+
+	tests := []struct {
+		name     string
+		deals    []string
+		campaign map[string]string
+		want     bool
+	}{
+		{"open request, open campaign", nil, openCampaign, true},
+		{"open request, deal campaign", nil, dealCampaign, false},
+		{"deal request, matching campaign", []string{"deal-2", "deal-1"}, dealCampaign, true},
+		{"deal request, other deal", []string{"deal-2"}, dealCampaign, false},
+		{"deal request, open campaign", []string{"deal-1"}, openCampaign, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesDeal(tt.deals, tt.campaign); got != tt.want {
+				t.Errorf("matchesDeal(%v) = %v, want %v", tt.deals, got, tt.want)
+			}
+		})
+	}
+}
+
+// stubGeoResolver resolves IPs from a fixed map
+type stubGeoResolver map[string]string
+
+func (r stubGeoResolver) Country(ip string) (string, error) {
+	if country, ok := r[ip]; ok {
+		return country, nil
+	}
+	return "", ErrGeoUnresolved
+}
+
+func TestGeoEligibility_FallbackModes(t *testing.T) {
+	usCampaign := map[string]string{"geo_targets": "US,CA"}
+	deCampaign := map[string]string{"geo_targets": "DE"}
+	untargeted := map[string]string{}
+
+	tests := []struct {
+		name           string
+		fallback       string
+		defaultCountry string
+		ip             string
+		wantErr        error
+		wantEligible   []bool // usCampaign, deCampaign, untargeted
+	}{
+		{"resolved", GeoFallbackUntargeted, "", "203.0.113.7", nil, []bool{true, false, true}},
+		{"untargeted_only", GeoFallbackUntargeted, "", "10.0.0.1", nil, []bool{false, false, true}},
+		{"default_country", GeoFallbackDefaultCountry, "DE", "10.0.0.1", nil, []bool{false, true, true}},
+		{"default_country unset", GeoFallbackDefaultCountry, "", "", nil, []bool{false, false, true}},
+		{"no_fill", GeoFallbackNoFill, "", "10.0.0.1", ErrGeoUnresolved, nil},
+		{"no_fill resolved", GeoFallbackNoFill, "", "203.0.113.7", nil, []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &AdService{
+				geoResolver:       stubGeoResolver{"203.0.113.7": "us"},
+				geoFallback:       tt.fallback,
+				geoDefaultCountry: tt.defaultCountry,
+			}
+
+			country, err := service.resolveCountry("", tt.ip)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolveCountry(%q) error = %v, want %v", tt.ip, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			for i, campaign := range []map[string]string{usCampaign, deCampaign, untargeted} {
+				if got := matchesGeo(country, campaign); got != tt.wantEligible[i] {
+					t.Errorf("matchesGeo(%q, %v) = %v, want %v", country, campaign, got, tt.wantEligible[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectAd_GeoTargets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	usCampaignID, usCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, usCampaignID, usCreativeID)
+
+	if err := redisClient.SetCampaign(ctx, usCampaignID, map[string]interface{}{"geo_targets": "US, CA"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	service.SetGeoResolver(GeoLookupFunc(func(ip string) (string, error) {
+		if ip == "203.0.113.7" {
+			return "CA", nil
+		}
+		return "", ErrGeoUnresolved
+	}))
+
+	t.Run("matching country", func(t *testing.T) {
+		for _, req := range []*models.AdRequest{
+			{DeviceID: "device-123", LocationCountry: "us"},
+			{DeviceID: "device-123", IPAddress: "203.0.113.7"},
+		} {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if adResp.CampaignID != usCampaignID {
+				t.Errorf("Expected geo-targeted campaign %s, got %s", usCampaignID, adResp.CampaignID)
+			}
+		}
+	})
+
+	t.Run("non-matching country", func(t *testing.T) {
+		req := &models.AdRequest{DeviceID: "device-123", LocationCountry: "DE"}
+		if adResp, err := service.SelectAd(ctx, req); err == nil && adResp.CampaignID == usCampaignID {
+			t.Error("Expected geo-targeted campaign to be skipped for DE")
+		}
+	})
+
+	t.Run("empty targets", func(t *testing.T) {
+		openCampaignID, openCreativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, openCampaignID, openCreativeID)
+
+		req := &models.AdRequest{DeviceID: "device-123", LocationCountry: "DE"}
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected untargeted campaign to serve DE, got: %v", err)
+		}
+		if adResp.CampaignID != openCampaignID {
+			t.Errorf("Expected untargeted campaign %s, got %s", openCampaignID, adResp.CampaignID)
+		}
+	})
+}
+
+func TestResolveCountry_RequestCountryOverridesIP(t *testing.T) {
+	service := &AdService{
+		geoResolver: stubGeoResolver{"203.0.113.7": "US"},
+		geoFallback: GeoFallbackNoFill,
+	}
+
+	country, err := service.resolveCountry("ca", "203.0.113.7")
+	if err != nil || country != "CA" {
+		t.Errorf("Expected CA from location_country, got %q (err %v)", country, err)
+	}
+}
+
+func TestCIDRGeoResolver(t *testing.T) {
+	resolver := parseGeoRanges("203.0.113.0/24=us, 198.51.100.0/24=CA, bogus, 2001:db8::/32=DE")
+
+	tests := []struct {
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{"203.0.113.7", "US", false},
+		{"198.51.100.1", "CA", false},
+		{"2001:db8::1", "DE", false},
+		{"192.0.2.1", "", true}, // Unlisted
+		{"10.1.2.3", "", true},  // Private
+		{"127.0.0.1", "", true}, // Loopback
+		{"not-an-ip", "", true}, // Malformed
+		{"", "", true},          // Missing
+	}
+
+	for _, tt := range tests {
+		got, err := resolver.Country(tt.ip)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Country(%q) = %q, %v; want %q (error %v)", tt.ip, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchesDeviceType(t *testing.T) {
+	ctvOnly := map[string]string{"device_types": "ctv"}
+	ctvMobile := map[string]string{"device_types": "CTV, mobile"}
+	untargeted := map[string]string{}
+
+	tests := []struct {
+		name       string
+		deviceType string
+		campaign   map[string]string
+		want       bool
+	}{
+		{"ctv on ctv-only", "ctv", ctvOnly, true},
+		{"mobile on ctv-only", "mobile", ctvOnly, false},
+		{"web on ctv-only", "web", ctvOnly, false},
+		{"missing type on ctv-only", "", ctvOnly, false},
+		{"mobile on ctv+mobile", "mobile", ctvMobile, true},
+		{"ctv on ctv+mobile", "ctv", ctvMobile, true},
+		{"mobile on untargeted", "mobile", untargeted, true},
+		{"missing type on untargeted", "", untargeted, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesDeviceType(tt.deviceType, tt.campaign); got != tt.want {
+				t.Errorf("matchesDeviceType(%q) = %v, want %v", tt.deviceType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAd_DeviceTypeTargeting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"device_types": "ctv"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", DeviceType: "ctv"})
+	if err != nil {
+		t.Fatalf("Expected ctv request to be served, got: %v", err)
+	}
+	if adResp.CampaignID != campaignID {
+		t.Errorf("Expected campaign_id %s, got %s", campaignID, adResp.CampaignID)
+	}
+
+	adResp, err = service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", DeviceType: "mobile"})
+	if err == nil && adResp.CampaignID == campaignID {
+		t.Error("Expected ctv-only campaign to be filtered out for a mobile request")
+	}
+}
+
+func TestIsAppBlocked(t *testing.T) {
+	blocking := map[string]string{"blocked_apps": "app-123, app-456"}
+	unblocked := map[string]string{}
+
+	tests := []struct {
+		name     string
+		appID    string
+		campaign map[string]string
+		want     bool
+	}{
+		{"listed app", "app-456", blocking, true},
+		{"first listed app", "app-123", blocking, true},
+		{"other app", "app-789", blocking, false},
+		{"prefix of a listed app", "app-45", blocking, false},
+		{"missing app", "", blocking, false},
+		{"empty blocklist", "app-456", unblocked, false},
+		{"blank blocklist", "app-456", map[string]string{"blocked_apps": " , "}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAppBlocked(tt.appID, tt.campaign); got != tt.want {
+				t.Errorf("isAppBlocked(%q) = %v, want %v", tt.appID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAd_BlockedApps(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"blocked_apps": "app-123,app-456"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", AppID: "app-789"})
+	if err != nil {
+		t.Fatalf("Expected request from app-789 to be served, got: %v", err)
+	}
+	if adResp.CampaignID != campaignID {
+		t.Errorf("Expected campaign_id %s, got %s", campaignID, adResp.CampaignID)
+	}
+
+	adResp, err = service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", AppID: "app-456"})
+	if err == nil && adResp.CampaignID == campaignID {
+		t.Error("Expected campaign blocking app-456 to be filtered out for a request from it")
+	}
+}
+
+func TestSelectAd_PMPDeals(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	dealCampaignID, dealCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, dealCampaignID, dealCreativeID)
+
+	openCampaignID, openCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, openCampaignID, openCreativeID)
+
+	dealID := "deal-" + uuid.New().String()
+	if err := redisClient.SetCampaign(ctx, dealCampaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	t.Run("deal_ids", func(t *testing.T) {
+		req := &models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}}
+		for i := 0; i < 10; i++ {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if adResp.CampaignID != dealCampaignID {
+				t.Errorf("Expected deal campaign %s, got %s", dealCampaignID, adResp.CampaignID)
+			}
+		}
+	})
+
+	t.Run("pmp.deals", func(t *testing.T) {
+		req := &models.AdRequest{
+			DeviceID: "device-123",
+			PMP:      &models.PMP{Deals: []models.Deal{{ID: dealID}}},
+		}
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if adResp.CampaignID != dealCampaignID {
+			t.Errorf("Expected deal campaign %s, got %s", dealCampaignID, adResp.CampaignID)
+		}
+	})
+
+	t.Run("unknown deal", func(t *testing.T) {
+		req := &models.AdRequest{DeviceID: "device-123", DealIDs: []string{"deal-unknown"}}
+		if _, err := service.SelectAd(ctx, req); err == nil {
+			t.Error("Expected no fill for a deal no campaign carries")
+		}
+	})
+
+	t.Run("open auction", func(t *testing.T) {
+		req := &models.AdRequest{DeviceID: "device-123"}
+		for i := 0; i < 10; i++ {
+			adResp, err := service.SelectAd(ctx, req)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if adResp.CampaignID == dealCampaignID {
+				t.Error("Expected deal campaign to be excluded from open auction")
+			}
+		}
+	})
+}
+
+func TestComputePacing(t *testing.T) {
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC) // Half the day elapsed
+	campaign := map[string]string{
+		"budget_total": "10000",
+		"budget_spent": "4500", // 4000 before today + 500 today
+		"end_date":     time.Date(2025, 10, 4, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		"cpm":          "20",
+	}
+
+	pacing := computePacing(campaign, 25000, 500, 1, now)
+
+	// 6000 remaining at start of day over 4 days (Oct 1-4)
+	if pacing.DailyTarget != 1500 {
+		t.Errorf("Expected daily target 1500, got %v", pacing.DailyTarget)
+	}
+	if pacing.DailyImpressionTarget != 75000 {
+		t.Errorf("Expected daily impression target 75000, got %d", pacing.DailyImpressionTarget)
+	}
+	if pacing.ExpectedToDate != 750 {
+		t.Errorf("Expected 750 expected to date, got %v", pacing.ExpectedToDate)
+	}
+	if math.Abs(pacing.PaceRatio-500.0/750.0) > 1e-9 {
+		t.Errorf("Expected pace ratio %v, got %v", 500.0/750.0, pacing.PaceRatio)
+	}
+	if pacing.Date != "2025-10-01" || pacing.ImpressionsToday != 25000 || pacing.SpendToday != 500 {
+		t.Errorf("Unexpected delivery fields: %+v", pacing)
+	}
+
+	// Ended campaigns have no target
+	campaign["end_date"] = now.Add(-48 * time.Hour).Format(time.RFC3339)
+	if pacing := computePacing(campaign, 0, 0, 1, now); pacing.DailyTarget != 0 || pacing.PaceRatio != 0 {
+		t.Errorf("Expected no target for ended campaign, got %+v", pacing)
+	}
+
+	// The same budget stored in cents paces identically
+	campaign = map[string]string{
+		"budget_total": "1000000",
+		"budget_spent": "450000",
+		"end_date":     time.Date(2025, 10, 4, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		"cpm":          "20",
+	}
+	cents := computePacing(campaign, 25000, 50000, 100, now)
+	if cents.DailyTarget != 1500 || cents.DailyImpressionTarget != 75000 || cents.SpendToday != 500 {
+		t.Errorf("Expected cents budget to pace like dollars, got %+v", cents)
+	}
+}
+
+func TestGetCampaignPacing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// Seed today's counters
+	for i := 0; i < 3; i++ {
+		if err := redisClient.IncrementCampaignDailyImpressions(ctx, campaignID); err != nil {
+			t.Fatalf("Failed to increment daily impressions: %v", err)
+		}
+	}
+	if _, _, err := redisClient.SpendBudget(ctx, campaignID, 100); err != nil {
+		t.Fatalf("Failed to increment spend: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	pacing, err := service.GetCampaignPacing(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if pacing.ImpressionsToday != 3 || pacing.SpendToday != 100 {
+		t.Errorf("Expected 3 impressions and 100 spend today, got %d and %v", pacing.ImpressionsToday, pacing.SpendToday)
+	}
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	want := computePacing(campaign, 3, 100, 1, time.Now())
+	if math.Abs(pacing.DailyTarget-want.DailyTarget) > 1e-6 {
+		t.Errorf("Expected daily target %v, got %v", want.DailyTarget, pacing.DailyTarget)
+	}
+	if pacing.PaceRatio <= 0 {
+		t.Errorf("Expected positive pace ratio, got %v", pacing.PaceRatio)
+	}
+
+	if _, err := service.GetCampaignPacing(ctx, "missing-campaign"); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing campaign, got: %v", err)
+	}
+}
+
+func TestGetCampaignAndCreativeStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// Seed the current hour's buckets
+	if err := redisClient.IncrementCampaignRequestsBy(ctx, campaignID, 5); err != nil {
+		t.Fatalf("Failed to increment requests: %v", err)
+	}
+	if err := redisClient.IncrementCreativeImpressionsBy(ctx, creativeID, 3); err != nil {
+		t.Fatalf("Failed to increment impressions: %v", err)
+	}
+	if err := redisClient.IncrementCreativeClicks(ctx, creativeID); err != nil {
+		t.Fatalf("Failed to increment clicks: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	campaignStats, err := service.GetCampaignStats(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if campaignStats.Requests != 5 || campaignStats.WindowHours != 24 {
+		t.Errorf("Expected 5 requests over 24 hours, got %+v", campaignStats)
+	}
+
+	creativeStats, err := service.GetCreativeStats(ctx, creativeID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if creativeStats.Impressions != 3 || creativeStats.Clicks != 1 || creativeStats.CampaignID != campaignID {
+		t.Errorf("Expected 3 impressions and 1 click for campaign %s, got %+v", campaignID, creativeStats)
+	}
+
+	if _, err := service.GetCampaignStats(ctx, "missing-campaign"); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing campaign, got: %v", err)
+	}
+	if _, err := service.GetCreativeStats(ctx, "missing-creative"); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing creative, got: %v", err)
+	}
+}
+
+func TestIsOverspent(t *testing.T) {
+	tests := []struct {
+		spent string
+		want  bool
+	}{
+		{"500", false},
+		{"1000", false}, // Exhausted, not anomalous
+		{"1000.01", true},
+		{"5000", true},
+	}
+
+	for _, tt := range tests {
+		campaign := map[string]string{"budget_total": "1000", "budget_spent": tt.spent}
+		if got := isOverspent(campaign); got != tt.want {
+			t.Errorf("isOverspent(spent=%s) = %v, want %v", tt.spent, got, tt.want)
+		}
+	}
+}
+
+func TestSelectAd_OverspentCampaign(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	overspentID, overspentCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		1000.0,
+		5000.0, // Spent more than the budget
+	)
+	defer cleanupTestData(t, redisClient, overspentID, overspentCreativeID)
+
+	healthyID, healthyCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, healthyID, healthyCreativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{DeviceID: "device-123"}
+	for i := 0; i < 10; i++ {
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if adResp.CampaignID == overspentID {
+			t.Fatal("Expected over-spent campaign to be excluded")
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond) // Removal is async
+	activeCampaigns, err := redisClient.GetActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get active campaigns: %v", err)
+	}
+	for _, id := range activeCampaigns {
+		if id == overspentID {
+			t.Error("Expected over-spent campaign to be removed from active set")
+		}
+	}
+
+	// Spend exhausting a campaign's budget also removes it
+	if _, _, err := redisClient.SpendBudget(ctx, healthyID, 9500); err != nil {
+		t.Fatalf("Failed to increment spend: %v", err)
+	}
+	activeCampaigns, err = redisClient.GetActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get active campaigns: %v", err)
+	}
+	for _, id := range activeCampaigns {
+		if id == healthyID {
+			t.Error("Expected campaign spent past its budget to be removed from active set")
+		}
+	}
+}
+
+func TestValidatePosterURL(t *testing.T) {
+	schemes := []string{"https"}
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://cdn.example.com/poster.jpg", false},
+		{"https://cdn.example.com/poster.PNG?v=2", false},
+		{"https://cdn.example.com/poster.webp", false},
+		{"https://cdn.example.com/ad.mp4", true},
+		{"https://cdn.example.com/poster", true},
+		{"http://cdn.example.com/poster.jpg", true},
+		{"file:///etc/poster.jpg", true},
+	}
+
+	for _, tt := range tests {
+		err := validatePosterURL(tt.url, schemes)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePosterURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+
+	// Invalid posters are rejected on write, absent ones are fine
+	campaign := map[string]string{}
+	creative := map[string]string{
+		"video_url":  "https://cdn.example.com/ad.mp4",
+		"duration":   "30",
+		"format":     "mp4",
+		"status":     "active",
+		"poster_url": "https://cdn.example.com/ad.mp4",
+	}
+	if err := validateCreative(campaign, creative, schemes); !errors.Is(err, ErrInvalidCreative) {
+		t.Errorf("Expected ErrInvalidCreative for non-image poster, got: %v", err)
+	}
+	delete(creative, "poster_url")
+	if err := validateCreative(campaign, creative, schemes); err != nil {
+		t.Errorf("Expected creative without poster to be valid, got: %v", err)
+	}
+}
+
+func TestAdResponse_PosterJSON(t *testing.T) {
+	resp := models.AdResponse{AdID: "ad-1", PosterURL: "https://cdn.example.com/poster.jpg"}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+
+	var decoded models.AdResponse
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if decoded.PosterURL != resp.PosterURL {
+		t.Errorf("Expected poster_url %s to round-trip, got %s", resp.PosterURL, decoded.PosterURL)
+	}
+
+	body, _ = json.Marshal(models.AdResponse{AdID: "ad-1"})
+	if strings.Contains(string(body), "poster_url") {
+		t.Errorf("Expected poster_url omitted when absent, got: %s", body)
+	}
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	service := &AdService{
+		redis:           redisClient,
+		deviceRateLimit: 3,
+		ipRateLimit:     10,
+		rateLimitWindow: time.Hour,
+	}
+
+	// Device limit trips first for a single device
+	deviceID := uuid.New().String()
+	ip := "ip-" + uuid.New().String()
+	for i := 0; i < 3; i++ {
+		if err := service.CheckRateLimit(ctx, deviceID, ip); err != nil {
+			t.Fatalf("Request %d: expected no error, got: %v", i+1, err)
+		}
+	}
+	if err := service.CheckRateLimit(ctx, deviceID, ip); err != ErrRateLimited {
+		t.Errorf("Expected device limit to trip, got: %v", err)
+	}
+
+	// Rotating device IDs from one IP trips the IP limit
+	ip = "ip-" + uuid.New().String()
+	limited := 0
+	for i := 0; i < 15; i++ {
+		if err := service.CheckRateLimit(ctx, uuid.New().String(), ip); err == ErrRateLimited {
+			limited++
+		}
+	}
+	if limited != 5 {
+		t.Errorf("Expected 5 of 15 requests over the IP limit of 10, got %d", limited)
+	}
+}
+
+func TestSelectAd_BudgetReservations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// $0.10 left at a $20 CPM covers exactly 5 impressions
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		100.0,
+		99.9,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	t.Setenv("BUDGET_RESERVATIONS", "true")
+	service := NewAdService(redisClient, testConfig())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var served []string
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()})
+			if err == nil && adResp.CampaignID == campaignID {
+				mu.Lock()
+				served = append(served, adResp.AdID)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(served) != 5 {
+		t.Fatalf("Expected reservations to cap selections at 5, got %d", len(served))
+	}
+
+	// Confirming an impression releases its reservation and charges the spend,
+	// so the campaign is still full
+	if _, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+		AdID:       served[0],
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-123",
+	}); err != nil {
+		t.Fatalf("Failed to track impression: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()})
+		if err == nil && adResp.CampaignID == campaignID {
+			t.Fatal("Expected no further selections while reservations and spend fill the budget")
+		}
+	}
+}
+
+func TestSelectAd_BudgetReservationsExpire(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		100.0,
+		99.96, // Two impressions at a $20 CPM
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 20}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := &AdService{redis: redisClient, budgetReservations: true, reservationTTL: 100 * time.Millisecond}
+	campaign, _ := redisClient.GetCampaign(ctx, campaignID)
+
+	for _, adID := range []string{"ad-1", "ad-2"} {
+		if !service.reserveBudget(ctx, adID, campaignID, campaign) {
+			t.Fatalf("Expected reservation for %s to succeed", adID)
+		}
+	}
+	if service.reserveBudget(ctx, "ad-3", campaignID, campaign) {
+		t.Fatal("Expected third reservation to exceed the budget")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if !service.reserveBudget(ctx, "ad-4", campaignID, campaign) {
+		t.Error("Expected expired reservation to be released")
+	}
+}
+
+func TestSelectAd_QuarantinedCreative(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, brokenCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	creativeIDs := seedCreatives(t, redisClient, campaignID, 1)
+	defer func() {
+		redisClient.DeleteCreative(ctx, creativeIDs[0], campaignID)
+		cleanupTestData(t, redisClient, campaignID, brokenCreativeID)
+	}()
+
+	service := &AdService{
+		redis:                  redisClient,
+		rng:                    newLockedRand(1),
+		creativeSampleSize:     defaultCreativeSampleSize,
+		creativeURLSchemes:     defaultCreativeURLSchemes,
+		quarantineTTL:          time.Second,
+		creativeErrorThreshold: 2,
+		creativeErrorWindow:    time.Minute,
+	}
+
+	// One reporter, however often it reports, isn't enough
+	for i := 0; i < 3; i++ {
+		quarantined, err := service.ReportCreativeError(ctx, brokenCreativeID, "203.0.113.1", "405")
+		if err != nil {
+			t.Fatalf("Failed to report creative error: %v", err)
+		}
+		if quarantined {
+			t.Fatal("Expected a single reporter not to quarantine the creative")
+		}
+	}
+	if quarantined, err := service.ReportCreativeError(ctx, brokenCreativeID, "203.0.113.2", "405"); err != nil || !quarantined {
+		t.Fatalf("Expected a second reporter to quarantine the creative, got %v, %v", quarantined, err)
+	}
+
+	// Creatives that don't exist can't be reported
+	if _, err := service.ReportCreativeError(ctx, uuid.New().String(), "203.0.113.1", "405"); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown creative, got %v", err)
+	}
+
+	quarantined, err := redisClient.GetQuarantinedCreatives(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get quarantined creatives: %v", err)
+	}
+	if !quarantined[brokenCreativeID] {
+		t.Fatal("Expected reported creative to be quarantined")
+	}
+
+	for i := 0; i < 10; i++ {
+		creativeID, _, err := service.selectCreative(ctx, campaignID, RotationWeighted, &models.AdRequest{}, nil, service.rng)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if creativeID == brokenCreativeID {
+			t.Fatal("Expected quarantined creative to be excluded")
+		}
+	}
+
+	// Served again once the quarantine expires
+	time.Sleep(1100 * time.Millisecond)
+	quarantined, err = redisClient.GetQuarantinedCreatives(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get quarantined creatives: %v", err)
+	}
+	if quarantined[brokenCreativeID] {
+		t.Error("Expected quarantine to expire after the TTL")
+	}
+}
+
+func TestSelectCreative_ExcludeCreatives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, failedCreativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	creativeIDs := seedCreatives(t, redisClient, campaignID, 1)
+	defer func() {
+		redisClient.DeleteCreative(ctx, creativeIDs[0], campaignID)
+		cleanupTestData(t, redisClient, campaignID, failedCreativeID)
+	}()
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{DeviceID: "device-123", ExcludeCreatives: []string{failedCreativeID}}
+
+	for i := 0; i < 10; i++ {
+		creativeID, _, err := service.selectCreative(ctx, campaignID, RotationWeighted, req, nil, service.rng)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if creativeID == failedCreativeID {
+			t.Fatal("Expected the excluded creative not to be served on the retry")
+		}
+	}
+
+	// Only this request excludes it
+	quarantined, err := redisClient.GetQuarantinedCreatives(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get quarantined creatives: %v", err)
+	}
+	if quarantined[failedCreativeID] {
+		t.Error("Expected exclude_creatives not to quarantine the creative")
+	}
+}
+
+func TestNoFillReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrInvalidTraffic, "invalid_traffic"},
+		{ErrNoActiveCampaigns, "no_active_campaigns"},
+		{ErrNoEligibleCampaigns, "no_eligible_campaigns"},
+		{fmt.Errorf("failed to get active campaigns: %w", errors.New("timeout")), "error"},
+	}
+
+	for _, tt := range tests {
+		if got := NoFillReason(tt.err); got != tt.want {
+			t.Errorf("NoFillReason(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		locale   string
+		want     string
+	}{
+		{1234.5, "EUR", "de-DE", "1.234,50 €"},
+		{1234.5, "EUR", "en-US", "€1,234.50"},
+		{1234.5, "JPY", "ja", "¥1,235"}, // No minor digits
+		{1234567.891, "USD", "en", "$1,234,567.89"},
+		{1234.5, "CHF", "fr", "CHF 1 234,50"},
+		{-42, "GBP", "xx", "-£42.00"}, // Unknown locales fall back to en
+		{0, "JPY", "en", "¥0"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatMoney(tt.amount, tt.currency, tt.locale); got != tt.want {
+			t.Errorf("FormatMoney(%v, %s, %s) = %q, want %q", tt.amount, tt.currency, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizePacing(t *testing.T) {
+	eur := &models.CampaignPacing{Currency: "EUR", SpendToday: 1234.5}
+	jpy := &models.CampaignPacing{Currency: "JPY", SpendToday: 1234.5}
+	raw := &models.CampaignPacing{Currency: "EUR", SpendToday: 1234.5}
+
+	LocalizePacing(eur, PreferredLocale("de-DE,de;q=0.9,en;q=0.8"))
+	LocalizePacing(jpy, PreferredLocale("en-US"))
+
+	if eur.Formatted["spend_today"] != "1.234,50 €" {
+		t.Errorf("Expected EUR spend 1.234,50 €, got %q", eur.Formatted["spend_today"])
+	}
+	if jpy.Formatted["spend_today"] != "¥1,235" {
+		t.Errorf("Expected JPY spend ¥1,235, got %q", jpy.Formatted["spend_today"])
+	}
+
+	// Raw mode keeps plain numbers with no formatted block
+	body, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("Failed to marshal pacing: %v", err)
+	}
+	if !strings.Contains(string(body), `"spend_today":1234.5`) || strings.Contains(string(body), "formatted") {
+		t.Errorf("Expected raw numeric output, got: %s", body)
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	service := &AdService{maxPodDuration: 300, maxPodAds: 10}
+
+	tests := []struct {
+		name        string
+		podDuration int
+		maxAds      int
+		wantAds     int
+		wantErr     bool
+	}{
+		{"within limits", 120, 4, 4, false},
+		{"max_ads defaults to cap", 120, 0, 10, false},
+		{"oversized duration", 10000, 4, 0, true},
+		{"oversized max_ads", 120, 10000, 0, true},
+		{"missing duration", 0, 4, 0, true},
+		{"negative max_ads", 120, -1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &models.AdPodRequest{PodDuration: tt.podDuration, MaxAds: tt.maxAds}
+			maxAds, err := service.ValidatePod(req)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPod) {
+					t.Errorf("Expected ErrInvalidPod, got: %v", err)
+				}
+				return
+			}
+			if err != nil || maxAds != tt.wantAds {
+				t.Errorf("Expected %d ads and no error, got %d and %v", tt.wantAds, maxAds, err)
+			}
+		})
+	}
+}
+
+func TestSelectAdPod_InventoryExhausted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Two deal campaigns with one 30s creative each; the deal isolates them
+	// from any other data in the test Redis
+	dealID := "deal-" + uuid.New().String()
+	for i := 0; i < 2; i++ {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdPodRequest{
+		AdRequest:   models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}},
+		PodDuration: 300,
+		MaxAds:      10,
+	}
+
+	done := make(chan struct{})
+	var pod *models.AdPodResponse
+	var err error
+	go func() {
+		pod, err = service.SelectAdPod(ctx, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pod building did not terminate when inventory ran out")
+	}
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(pod.Ads) != 2 {
+		t.Fatalf("Expected the 2 available ads, got %d", len(pod.Ads))
+	}
+	if pod.Ads[0].CampaignID == pod.Ads[1].CampaignID {
+		t.Error("Expected each pod ad from a different campaign")
+	}
+	if pod.TotalDuration != 60 {
+		t.Errorf("Expected total duration 60, got %d", pod.TotalDuration)
+	}
+}
+
+func TestPodSlotFits(t *testing.T) {
+	slot := &podSlot{brands: map[string]bool{"acme": true}, maxDuration: 30}
+
+	tests := []struct {
+		name     string
+		creative map[string]string
+		want     bool
+	}{
+		{"fits", map[string]string{"duration": "30", "brand_id": "globex"}, true},
+		{"no brand", map[string]string{"duration": "15"}, true},
+		{"too long", map[string]string{"duration": "45", "brand_id": "globex"}, false},
+		{"brand already in pod", map[string]string{"duration": "15", "brand_id": "acme"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slot.fits(tt.creative); got != tt.want {
+				t.Errorf("fits(%v) = %v, want %v", tt.creative, got, tt.want)
+			}
+		})
+	}
+
+	var standalone *podSlot
+	if !standalone.fits(map[string]string{"duration": "600", "brand_id": "acme"}) {
+		t.Error("Expected a nil slot to fit any creative")
+	}
+}
+
+func TestSelectAdPod_BrandExclusivity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Two campaigns for the same brand and one for another, isolated by deal
+	dealID := "deal-" + uuid.New().String()
+	for _, brand := range []string{"acme", "acme", "globex"} {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		if err := redisClient.SetCreative(ctx, creativeID, campaignID, map[string]interface{}{"brand_id": brand}); err != nil {
+			t.Fatalf("Failed to set creative: %v", err)
+		}
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdPodRequest{
+		AdRequest:   models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}},
+		PodDuration: 300,
+		MaxAds:      3,
+	}
+
+	for i := 0; i < 20; i++ {
+		pod, err := service.SelectAdPod(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		brands := make(map[string]int)
+		for _, ad := range pod.Ads {
+			brands[ad.BrandID]++
+		}
+		if brands["acme"] != 1 || brands["globex"] != 1 || len(pod.Ads) != 2 {
+			t.Fatalf("Expected one acme and one globex ad, got %v", brands)
+		}
+	}
+}
+
+func TestSelectAdPod_CompetitiveSeparation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Two automakers and a restaurant chain, isolated by deal
+	dealID := "deal-" + uuid.New().String()
+	categories := make(map[string]string)
+	for _, category := range []string{"auto", " Auto", "food"} {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+			"deal_id":              dealID,
+			"competitive_category": category,
+		}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		categories[campaignID] = strings.ToLower(strings.TrimSpace(category))
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdPodRequest{
+		AdRequest:   models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}},
+		PodDuration: 300,
+		MaxAds:      3,
+	}
+
+	for i := 0; i < 20; i++ {
+		pod, err := service.SelectAdPod(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		counts := make(map[string]int)
+		for _, ad := range pod.Ads {
+			counts[categories[ad.CampaignID]]++
+		}
+		if counts["auto"] != 1 || counts["food"] != 1 || len(pod.Ads) != 2 {
+			t.Fatalf("Expected one auto and one food ad, got %v", counts)
+		}
+	}
+}
+
+func TestSelectAd_CompetitiveSeparationWindow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	dealID := "deal-" + uuid.New().String()
+	for i := 0; i < 2; i++ {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+			"deal_id":              dealID,
+			"competitive_category": "auto",
+		}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+
+	t.Setenv("COMPETITIVE_SEPARATION_SECONDS", "60")
+	service := NewAdService(redisClient, testConfig())
+	deviceID := "device-" + uuid.New().String()
+	req := &models.AdRequest{DeviceID: deviceID, DealIDs: []string{dealID}}
+
+	first, err := service.SelectAd(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected the first request to fill, got: %v", err)
+	}
+
+	// The category is recorded asynchronously
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		served, err := redisClient.GetDeviceCategories(ctx, deviceID)
+		if err != nil {
+			t.Fatalf("Failed to get device categories: %v", err)
+		}
+		if _, ok := served["auto"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the served category to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Within the window only the campaign that served the category may repeat
+	for i := 0; i < 10; i++ {
+		adResp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected the serving campaign to fill again, got: %v", err)
+		}
+		if adResp.CampaignID != first.CampaignID {
+			t.Fatalf("Expected only campaign %s within the window, got %s", first.CampaignID, adResp.CampaignID)
+		}
+	}
+
+	// Other devices are unaffected
+	other := &models.AdRequest{DeviceID: "device-" + uuid.New().String(), DealIDs: []string{dealID}}
+	if _, err := service.SelectAd(ctx, other); err != nil {
+		t.Errorf("Expected another device to fill, got: %v", err)
+	}
+}
+
+func TestSeparatedFrom(t *testing.T) {
+	recent := map[string]string{"auto": "campaign-1", "food": ""}
+	tests := []struct {
+		name       string
+		campaignID string
+		category   string
+		expected   bool
+	}{
+		{"campaign that served the category", "campaign-1", "auto", false},
+		{"competitor in the category", "campaign-2", "auto", true},
+		{"unknown server blocks everyone", "campaign-1", "food", true},
+		{"category not served", "campaign-2", "travel", false},
+		{"no category", "campaign-2", "", false},
+	}
+
+	for _, tt := range tests {
+		campaign := map[string]string{"competitive_category": tt.category}
+		if got := separatedFrom(recent, tt.campaignID, campaign); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestEligibleSet_RemoveCategory(t *testing.T) {
+	eligible := &eligibleSet{
+		ids:     []string{"a", "b", "c", "d"},
+		weights: []int64{1, 2, 3, 4},
+		bids:    make([]auctionBid, 4),
+		campaigns: map[string]map[string]string{
+			"a": {"competitive_category": "auto"},
+			"b": {"competitive_category": "food"},
+			"c": {"competitive_category": "AUTO "},
+			"d": {},
+		},
+	}
+
+	eligible.removeCategory("auto")
+	if got := strings.Join(eligible.ids, ","); got != "b,d" {
+		t.Errorf("Expected b,d to remain, got %s", got)
+	}
+	if eligible.weights[0] != 2 || eligible.weights[1] != 4 {
+		t.Errorf("Expected weights to stay aligned, got %v", eligible.weights)
+	}
+
+	// Uncategorized campaigns never separate
+	eligible.removeCategory("")
+	if len(eligible.ids) != 2 {
+		t.Errorf("Expected an empty category to remove nothing, got %v", eligible.ids)
+	}
+}
+
+func TestNoFillBackoff(t *testing.T) {
+	base, max := time.Second, 10*time.Second
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, w := range want {
+		if got := noFillBackoff(int64(i+1), base, max); got != w*time.Second {
+			t.Errorf("No-fill %d: expected %v, got %v", i+1, w*time.Second, got)
+		}
+	}
+}
+
+func TestPreviewCreative_BypassesEligibility(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Paused and expired: never live-eligible
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-48*time.Hour,
+		-24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"status": "paused"}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	adResp, err := service.PreviewCreative(ctx, creativeID, "device-123")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if adResp.CreativeID != creativeID || adResp.CampaignID != campaignID {
+		t.Errorf("Expected creative %s of campaign %s, got %s of %s", creativeID, campaignID, adResp.CreativeID, adResp.CampaignID)
+	}
+	if adResp.VideoURL == "" || adResp.Duration != 30 {
+		t.Errorf("Expected the creative's video and duration, got %+v", adResp)
+	}
+
+	// Nothing counted
+	time.Sleep(50 * time.Millisecond)
+	hour := time.Now().Format("2006010215")
+	requests, err := redisClient.GetCounter(ctx, fmt.Sprintf("campaign:%s:requests:%s", campaignID, hour))
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected preview to increment no request counters, got %d", requests)
+	}
+
+	if _, err := service.PreviewCreative(ctx, "missing-creative", ""); !errors.Is(err, redis.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing creative, got: %v", err)
+	}
+}
+
+func TestRedisReplicaReads(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		1000.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	testURL := os.Getenv("REDIS_TEST_URL")
+	if testURL == "" {
+		testURL = "localhost:6380"
+	}
+	const unreachable = "127.0.0.1:1"
+
+	t.Run("reads from replica while primary is down", func(t *testing.T) {
+		client := redis.New(unreachable)
+		client.SetReplica(testURL, "")
+		defer client.Close()
+
+		if err := client.Ping(ctx); err != nil {
+			t.Errorf("Expected a reachable replica to pass Ping, got: %v", err)
+		}
+		if _, err := client.GetCampaign(ctx, campaignID); err != nil {
+			t.Fatalf("Expected campaign read from replica, got: %v", err)
+		}
+		if _, err := client.GetCreative(ctx, creativeID); err != nil {
+			t.Fatalf("Expected creative read from replica, got: %v", err)
+		}
+
+		// Serving continues on replica reads alone
+		service := NewAdService(client, testConfig())
+		adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()})
+		if err != nil {
+			t.Fatalf("Expected an ad served from the replica, got: %v", err)
+		}
+		if adResp.CampaignID != campaignID {
+			t.Errorf("Expected campaign_id %s, got %s", campaignID, adResp.CampaignID)
+		}
+	})
+
+	t.Run("writes go to primary", func(t *testing.T) {
+		client := redis.New(unreachable)
+		client.SetReplica(testURL, "")
+		defer client.Close()
+
+		if _, _, err := client.SpendBudget(ctx, campaignID, 5); err == nil {
+			t.Error("Expected spend write to fail against the unreachable primary")
+		}
+
+		campaign, err := redisClient.GetCampaign(ctx, campaignID)
+		if err != nil {
+			t.Fatalf("Failed to get campaign: %v", err)
+		}
+		if campaign["budget_spent"] != "1000" {
+			t.Errorf("Expected the replica to be untouched by writes, got budget_spent %s", campaign["budget_spent"])
+		}
+	})
+
+	t.Run("falls back to primary when replica fails", func(t *testing.T) {
+		client := redis.New(testURL)
+		client.SetReplica(unreachable, "")
+		defer client.Close()
+
+		if _, err := client.GetCampaign(ctx, campaignID); err != nil {
+			t.Errorf("Expected campaign read to fall back to primary, got: %v", err)
+		}
+	})
+}
+
+func TestSelectionRand_Deterministic(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 30, 0, time.UTC)
+	newService := func(seed int64) *AdService {
+		return &AdService{
+			rng:                    newLockedRand(seed),
+			deterministicSelection: true,
+			deterministicBucket:    time.Minute,
+		}
+	}
+	weights := []int64{1, 2, 3, 4, 5}
+	draws := func(service *AdService, req *models.AdRequest, at time.Time) []int {
+		rng := service.selectionRand(req, at)
+		var picks []int
+		for i := 0; i < 20; i++ {
+			picks = append(picks, drawWeighted(rng, weights))
+		}
+		return picks
+	}
+
+	// Instances with different shared sources draw identically per request
+	req := &models.AdRequest{DeviceID: "device-123", AppID: "app-456"}
+	a, b := newService(1), newService(2)
+	if got, want := draws(a, req, now), draws(b, req, now); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected identical draws across instances, got %v and %v", got, want)
+	}
+	if got, want := draws(a, req, now.Add(20*time.Second)), draws(b, req, now); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected identical draws within a time bucket, got %v and %v", got, want)
+	}
+
+	if selectionSeed("device-123", "app-456", 1) == selectionSeed("device-124", "app-456", 1) {
+		t.Error("Expected different devices to get different seeds")
+	}
+	if selectionSeed("device-123", "app-456", 1) == selectionSeed("device-123", "app-456", 2) {
+		t.Error("Expected different time buckets to get different seeds")
+	}
+
+	// Off by default: the shared source is used
+	shared := &AdService{rng: newLockedRand(1)}
+	if shared.selectionRand(req, now) != shared.rng {
+		t.Error("Expected the shared rand source without deterministic selection")
+	}
+}
+
+func TestSampleIDs_IgnoresInputOrder(t *testing.T) {
+	ids := []string{"c", "a", "e", "b", "d", "f"}
+	reversed := []string{"f", "d", "b", "e", "a", "c"}
+
+	got := sampleIDs(newLockedRand(7), ids, 3)
+	want := sampleIDs(newLockedRand(7), reversed, 3)
+	if len(got) != 3 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected the same 3-ID sample regardless of input order, got %v and %v", got, want)
+	}
+
+	if all := sampleIDs(newLockedRand(7), ids, 10); fmt.Sprint(all) != "[a b c d e f]" {
+		t.Errorf("Expected the full sorted set when n exceeds it, got %v", all)
+	}
+}
+
+func TestSelectAd_DeterministicAcrossInstances(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	for i := 0; i < 3; i++ {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		seedCreatives(t, redisClient, campaignID, 4)
+	}
+
+	t.Setenv("DETERMINISTIC_SELECTION", "true")
+	baseline := NewAdService(redisClient, testConfig())
+	canary := NewAdService(redisClient, testConfig())
+
+	for i := 0; i < 20; i++ {
+		req := &models.AdRequest{DeviceID: fmt.Sprintf("device-%d", i), AppID: "app-456"}
+		want, err := baseline.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Baseline failed to select: %v", err)
+		}
+		got, err := canary.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Canary failed to select: %v", err)
+		}
+		if got.CampaignID != want.CampaignID || got.CreativeID != want.CreativeID {
+			t.Errorf("Request %d: canary chose %s/%s, baseline %s/%s",
+				i, got.CampaignID, got.CreativeID, want.CampaignID, want.CreativeID)
+		}
+	}
+}
+
+func TestRunAuction(t *testing.T) {
+	tests := []struct {
+		name          string
+		bids          []auctionBid
+		expectWinner  int
+		expectCleared float64
+	}{
+		{"highest bid wins", []auctionBid{{3, 100}, {5, 100}, {4, 100}}, 1, 4},
+		{"pays second price", []auctionBid{{10, 100}, {2, 100}}, 0, 2},
+		{"tie broken by remaining budget", []auctionBid{{5, 100}, {5, 900}, {1, 100}}, 1, 5},
+		{"single bidder pays own bid", []auctionBid{{7, 100}}, 0, 7},
+		{"no bidders", nil, -1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winner, cleared := runAuction(tt.bids)
+			if winner != tt.expectWinner || cleared != tt.expectCleared {
+				t.Errorf("runAuction() = (%d, %v), want (%d, %v)", winner, cleared, tt.expectWinner, tt.expectCleared)
+			}
+		})
+	}
+}
+
+func TestCampaignBid(t *testing.T) {
+	if got := campaignBid(map[string]string{"bid_cpm": "6.5", "cpm": "4"}); got != 6.5 {
+		t.Errorf("Expected bid_cpm 6.5, got %v", got)
+	}
+	if got := campaignBid(map[string]string{"cpm": "4"}); got != 4 {
+		t.Errorf("Expected fallback to cpm 4, got %v", got)
+	}
+}
+
+func TestSelectAd_SecondPriceAuction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	lowID, lowCreativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, lowID, lowCreativeID)
+	highID, highCreativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, highID, highCreativeID)
+
+	if err := redisClient.SetCampaign(ctx, lowID, map[string]interface{}{"bid_cpm": "3.5"}); err != nil {
+		t.Fatalf("Failed to set bid: %v", err)
+	}
+	if err := redisClient.SetCampaign(ctx, highID, map[string]interface{}{"bid_cpm": "8"}); err != nil {
+		t.Fatalf("Failed to set bid: %v", err)
+	}
+
+	t.Setenv("SELECTION_STRATEGY", "second_price_auction")
+	service := NewAdService(redisClient, testConfig())
+
+	adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: uuid.New().String()})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if adResp.CampaignID != highID {
+		t.Errorf("Expected highest bidder %s, got %s", highID, adResp.CampaignID)
+	}
+	if adResp.ClearedCPM != 3.5 {
+		t.Errorf("Expected clearing price 3.5, got %v", adResp.ClearedCPM)
+	}
+	if adResp.Decision.Strategy != StrategyAuction {
+		t.Errorf("Expected strategy %s, got %s", StrategyAuction, adResp.Decision.Strategy)
+	}
+}
+
+func TestMissingCampaignFields(t *testing.T) {
+	complete := map[string]string{
+		"status":       "active",
+		"start_date":   "2025-01-01T00:00:00Z",
+		"end_date":     "2025-12-31T00:00:00Z",
+		"budget_total": "1000",
+	}
+	if missing := missingCampaignFields(complete); len(missing) != 0 {
+		t.Errorf("Expected no missing fields, got %v", missing)
+	}
+
+	incomplete := map[string]string{"status": "active", "end_date": "2025-12-31T00:00:00Z", "budget_total": " "}
+	missing := missingCampaignFields(incomplete)
+	if strings.Join(missing, ",") != "start_date,budget_total" {
+		t.Errorf("Expected start_date and budget_total missing, got %v", missing)
+	}
+}
+
+func TestSelectAd_IncompleteCampaignExcluded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		omit    string
+		message string
+	}{
+		{"missing budget_total", "budget_total", "missing required fields budget_total"},
+		{"missing start_date", "start_date", "missing required fields start_date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaignID := uuid.New().String()
+			dealID := "deal-" + uuid.New().String()
+			campaignData := map[string]interface{}{
+				"status":       "active",
+				"budget_total": "10000",
+				"budget_spent": "0",
+				"start_date":   now.Add(-24 * time.Hour).Format(time.RFC3339),
+				"end_date":     now.Add(24 * time.Hour).Format(time.RFC3339),
+				"deal_id":      dealID,
+			}
+			delete(campaignData, tt.omit)
+			if err := redisClient.SetCampaign(ctx, campaignID, campaignData); err != nil {
+				t.Fatalf("Failed to set campaign: %v", err)
+			}
+			if err := redisClient.AddActiveCampaign(ctx, campaignID, 10000); err != nil {
+				t.Fatalf("Failed to add active campaign: %v", err)
+			}
+			defer cleanupTestData(t, redisClient, campaignID, "")
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			service := NewAdService(redisClient, testConfig())
+			_, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}})
+			if !errors.Is(err, ErrNoEligibleCampaigns) {
+				t.Fatalf("Expected ErrNoEligibleCampaigns, got: %v", err)
+			}
+
+			expected := fmt.Sprintf("Campaign %s excluded: %s", campaignID, tt.message)
+			if !strings.Contains(logs.String(), expected) {
+				t.Errorf("Expected log %q, got: %s", expected, logs.String())
+			}
+		})
+	}
+}
+
+func TestMatchesAudio(t *testing.T) {
+	audio := map[string]string{"audio_required": "true"}
+	silent := map[string]string{}
+
+	if matchesAudio(true, audio) {
+		t.Error("Expected an audio-required creative excluded when muted")
+	}
+	if !matchesAudio(true, silent) {
+		t.Error("Expected a sound-optional creative to play muted")
+	}
+	if !matchesAudio(false, audio) {
+		t.Error("Expected an audio-required creative to play with sound on")
+	}
+}
+
+func TestSelectAd_AudioRequiredCreative(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	dealID := "deal-" + uuid.New().String()
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+	if err := redisClient.SetCreative(ctx, creativeID, campaignID, map[string]interface{}{"audio_required": "true"}); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	soundOff, soundOn := false, true
+
+	_, err := service.SelectAd(ctx, &models.AdRequest{
+		DeviceID:   uuid.New().String(),
+		DeviceType: "web",
+		DealIDs:    []string{dealID},
+		SoundOn:    &soundOff,
+	})
+	if err == nil {
+		t.Error("Expected no fill for a muted web request with only an audio-required creative")
+	}
+
+	adResp, err := service.SelectAd(ctx, &models.AdRequest{
+		DeviceID:   uuid.New().String(),
+		DeviceType: "ctv",
+		DealIDs:    []string{dealID},
+		SoundOn:    &soundOn,
+	})
+	if err != nil {
+		t.Fatalf("Expected a fill for a CTV request with sound on, got: %v", err)
+	}
+	if adResp.CreativeID != creativeID {
+		t.Errorf("Expected creative %s, got %s", creativeID, adResp.CreativeID)
+	}
+}
+
+func TestSelectAd_MutedSkipsAudioOnlyCampaign(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	audioCampaignID, audioCreativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, audioCampaignID, audioCreativeID)
+	silentCampaignID, silentCreativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, silentCampaignID, silentCreativeID)
+
+	dealID := "deal-" + uuid.New().String()
+	for _, campaignID := range []string{audioCampaignID, silentCampaignID} {
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+	if err := redisClient.SetCreative(ctx, audioCreativeID, audioCampaignID, map[string]interface{}{"audio_required": "true"}); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+
+	// Drawing the audio-only campaign must fall through to the silent one
+	service := NewAdService(redisClient, testConfig())
+	soundOff := false
+	for i := 0; i < 20; i++ {
+		adResp, err := service.SelectAd(ctx, &models.AdRequest{
+			DeviceID:   uuid.New().String(),
+			DeviceType: "web",
+			DealIDs:    []string{dealID},
+			SoundOn:    &soundOff,
+		})
+		if err != nil {
+			t.Fatalf("Expected the silent campaign to fill a muted request, got: %v", err)
+		}
+		if adResp.CampaignID != silentCampaignID {
+			t.Fatalf("Expected campaign %s, got %s", silentCampaignID, adResp.CampaignID)
+		}
+	}
+}
+
+func TestCapCharges(t *testing.T) {
+	charges := []charge{{adID: "ad-1", amount: 0.5}, {adID: "ad-2", amount: 0.5}, {adID: "ad-3", amount: 0.5}}
+
+	capped := capCharges(charges, 0.75)
+	if len(capped) != 2 || capped[0].amount != 0.5 || capped[1].amount != 0.25 || capped[1].adID != "ad-2" {
+		t.Errorf("Expected ad-1 at 0.5 and ad-2 at 0.25, got %+v", capped)
+	}
+	if capped := capCharges(charges, 0); len(capped) != 0 {
+		t.Errorf("Expected no charges within a zero limit, got %+v", capped)
+	}
+}
+
+func TestTrackImpression_ConcurrentSpendNeverExceedsBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// $1 budget at $100 CPM covers exactly 10 impressions
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 1.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+	if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"cpm": 100}); err != nil {
+		t.Fatalf("Failed to set campaign: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.TrackImpression(ctx, &models.ImpressionRequest{
+				AdID:       uuid.New().String(),
+				CampaignID: campaignID,
+				CreativeID: creativeID,
+				DeviceID:   uuid.New().String(),
+			})
+		}()
+	}
+	wg.Wait()
+
+	campaign, err := redisClient.GetCampaign(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("Failed to get campaign: %v", err)
+	}
+	spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	if spent > 1.0+1e-9 {
+		t.Errorf("Expected budget_spent to stay within budget_total 1.0, got %v", spent)
+	}
+	if math.Abs(spent-1.0) > 1e-9 {
+		t.Errorf("Expected the budget to be fully spent, got %v", spent)
+	}
+
+	activeCampaigns, err := redisClient.GetActiveCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get active campaigns: %v", err)
+	}
+	for _, id := range activeCampaigns {
+		if id == campaignID {
+			t.Error("Expected the exhausted campaign to be removed from the active set")
+		}
+	}
+}
+
+// seedPodCampaigns seeds n active campaigns, each with one 30s creative, under
+// a fresh deal that isolates them from other test data, and returns the deal
+func seedPodCampaigns(tb testing.TB, redisClient *redis.Client, n int) string {
+	dealID := "deal-" + uuid.New().String()
+	for i := 0; i < n; i++ {
+		campaignID, creativeID := seedTestCampaign(tb, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, float64(i*100))
+		tb.Cleanup(func() { cleanupTestData(tb, redisClient, campaignID, creativeID) })
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"deal_id": dealID}); err != nil {
+			tb.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+	return dealID
+}
+
+// selectAdPodPerSlot builds a pod the way it was built before eligibility was
+// shared: recomputing the eligible set for every slot
+func selectAdPodPerSlot(s *AdService, req *models.AdPodRequest) (*models.AdPodResponse, error) {
+	maxAds, err := s.ValidatePod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &models.AdPodResponse{Ads: []models.AdResponse{}}
+	used := make(map[string]bool)
+	brands := make(map[string]bool)
+	for len(pod.Ads) < maxAds && pod.TotalDuration < req.PodDuration {
+		eligible, err := s.eligibleCampaigns(ctx, &req.AdRequest)
+		if err == nil {
+			for id := range used {
+				eligible.remove(id)
+			}
+		}
+		var ad *models.AdResponse
+		if err == nil {
+			slot := &podSlot{brands: brands, maxDuration: req.PodDuration - pod.TotalDuration}
+			ad, err = s.selectFromEligible(ctx, &req.AdRequest, eligible, slot)
+		}
+		if errors.Is(err, errSlotUnfilled) {
+			continue
+		}
+		if err != nil {
+			if len(pod.Ads) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		used[ad.CampaignID] = true
+		pod.Ads = append(pod.Ads, *ad)
+		pod.TotalDuration += ad.Duration
+	}
+	return pod, nil
+}
+
+func TestSelectAdPod_SharedEligibilityUnchanged(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	dealID := seedPodCampaigns(t, redisClient, 6)
+
+	// Deterministic draws make the two builds comparable
+	t.Setenv("DETERMINISTIC_SELECTION", "true")
+	service := NewAdService(redisClient, testConfig())
+
+	req := &models.AdPodRequest{
+		AdRequest:   models.AdRequest{DeviceID: "device-123", AppID: "app-456", DealIDs: []string{dealID}},
+		PodDuration: 120,
+		MaxAds:      4,
+	}
+
+	want, err := selectAdPodPerSlot(service, req)
+	if err != nil {
+		t.Fatalf("Per-slot pod failed: %v", err)
+	}
+	got, err := service.SelectAdPod(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(got.Ads) != len(want.Ads) || got.TotalDuration != want.TotalDuration {
+		t.Fatalf("Expected %d ads totalling %ds, got %d totalling %ds",
+			len(want.Ads), want.TotalDuration, len(got.Ads), got.TotalDuration)
+	}
+	for i := range want.Ads {
+		if got.Ads[i].CampaignID != want.Ads[i].CampaignID || got.Ads[i].CreativeID != want.Ads[i].CreativeID {
+			t.Errorf("Slot %d: expected %s/%s, got %s/%s", i,
+				want.Ads[i].CampaignID, want.Ads[i].CreativeID, got.Ads[i].CampaignID, got.Ads[i].CreativeID)
+		}
+	}
+}
+
+func BenchmarkSelectAdPod(b *testing.B) {
+	redisClient := setupTestRedis(b)
+	defer redisClient.Close()
+
+	dealID := seedPodCampaigns(b, redisClient, 50)
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdPodRequest{
+		AdRequest:   models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}},
+		PodDuration: 300,
+		MaxAds:      10,
+	}
+
+	b.Run("per_slot_recompute", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := selectAdPodPerSlot(service, req); err != nil {
+				b.Fatalf("Pod selection failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("once_per_pod", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := service.SelectAdPod(ctx, req); err != nil {
+				b.Fatalf("Pod selection failed: %v", err)
+			}
+		}
+	})
+}
+
+func TestEligibleCampaigns_ConsistentUnderConcurrentSpend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{DeviceID: "device-snapshot", DeviceType: "ctv", AppID: "app-456"}
+
+	// Charge the campaign continuously while selections snapshot it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			if _, _, err := redisClient.SpendBudget(ctx, campaignID, 0.5); err != nil {
+				t.Errorf("Failed to spend budget: %v", err)
+				return
+			}
+		}
+	}()
+
+	for checked := 0; ; checked++ {
+		select {
+		case <-done:
+			if checked == 0 {
+				t.Fatal("Expected at least one selection during concurrent spend")
+			}
+			return
+		default:
+		}
+
+		eligible, err := service.eligibleCampaigns(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for i, id := range eligible.ids {
+			if id != campaignID {
+				continue
+			}
+			campaign := eligible.campaigns[id]
+			total, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+			spent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+			if math.Abs(eligible.bids[i].remaining-(total-spent)) > 1e-6 {
+				t.Fatalf("Selection weighted by score %.2f but hash has %.2f remaining", eligible.bids[i].remaining, total-spent)
+			}
+		}
+	}
+}
+
+func TestGetCampaigns_SkipsMissing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	missingID := uuid.New().String()
+	campaigns, err := redisClient.GetCampaigns(ctx, []string{campaignID, missingID})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(campaigns) != 1 || campaigns[campaignID]["status"] != "active" {
+		t.Errorf("Expected only the seeded campaign, got %v", campaigns)
+	}
+	if _, ok := campaigns[missingID]; ok {
+		t.Error("Expected the missing campaign to be omitted")
+	}
+}
+
+func BenchmarkGetCampaigns(b *testing.B) {
+	redisClient := setupTestRedis(b)
+	defer redisClient.Close()
+
+	campaignIDs := make([]string, 500)
+	for i := range campaignIDs {
+		campaignIDs[i] = uuid.New().String()
+		if err := redisClient.SetCampaign(ctx, campaignIDs[i], map[string]interface{}{
+			"name":         "Benchmark Campaign",
+			"status":       "active",
+			"budget_total": 10000.0,
+			"budget_spent": 0.0,
+		}); err != nil {
+			b.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+	defer func() {
+		for _, id := range campaignIDs {
+			redisClient.DeleteCampaign(ctx, id)
+		}
+	}()
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, id := range campaignIDs {
+				if _, err := redisClient.GetCampaign(ctx, id); err != nil {
+					b.Fatalf("GetCampaign failed: %v", err)
+				}
+			}
+		}
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := redisClient.GetCampaigns(ctx, campaignIDs); err != nil {
+				b.Fatalf("GetCampaigns failed: %v", err)
+			}
+		}
+	})
+}
+
+func TestCampaignCache_Expiry(t *testing.T) {
+	now := time.Now()
+	cache := newCampaignCache(5 * time.Second)
+	cache.now = func() time.Time { return now }
+
+	cache.put("campaign-1", map[string]string{"status": "active"})
+	if fields, ok := cache.get("campaign-1"); !ok || fields["status"] != "active" {
+		t.Fatalf("Expected a cache hit, got %v %v", fields, ok)
+	}
+
+	now = now.Add(5 * time.Second)
+	if _, ok := cache.get("campaign-1"); ok {
+		t.Error("Expected the entry to expire after the TTL")
+	}
+	if _, ok := cache.get("campaign-2"); ok {
+		t.Error("Expected a miss for an uncached campaign")
+	}
+
+	// Expired entries are swept on a later put, even if never read again
+	cache.put("campaign-2", map[string]string{"status": "active"})
+	if _, ok := cache.entries["campaign-1"]; ok || len(cache.entries) != 1 {
+		t.Errorf("Expected the expired entry to be swept, got %v", cache.entries)
+	}
+}
+
+func TestGetCampaigns_Cache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	setName := func(name string) {
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{"name": name}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+	}
+	name := func(service *AdService) string {
+		campaigns, err := service.getCampaigns(ctx, []string{campaignID})
+		if err != nil {
+			t.Fatalf("Failed to get campaigns: %v", err)
+		}
+		return campaigns[campaignID]["name"]
+	}
+
+	t.Run("stale entries refreshed after TTL", func(t *testing.T) {
+		setName("before")
+		t.Setenv("CAMPAIGN_CACHE_TTL_MS", "5000")
+		service := NewAdService(redisClient, testConfig())
+		now := time.Now()
+		service.campaignCache.now = func() time.Time { return now }
+
+		if got := name(service); got != "before" {
+			t.Fatalf("Expected before, got %s", got)
+		}
+		setName("after")
+		if got := name(service); got != "before" {
+			t.Errorf("Expected the cached value within the TTL, got %s", got)
+		}
+
+		now = now.Add(5 * time.Second)
+		if got := name(service); got != "after" {
+			t.Errorf("Expected a refresh after the TTL, got %s", got)
+		}
+	})
+
+	t.Run("zero TTL reads through", func(t *testing.T) {
+		setName("before")
+		service := NewAdService(redisClient, testConfig())
+
+		if got := name(service); got != "before" {
+			t.Fatalf("Expected before, got %s", got)
+		}
+		setName("after")
+		if got := name(service); got != "after" {
+			t.Errorf("Expected every read to go to Redis, got %s", got)
+		}
+	})
+}
+
+func TestForwardToGateway_BreakerOpensAfterThreshold(t *testing.T) {
+	var calls atomic.Int64
+	var healthy atomic.Bool
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if healthy.Load() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer gateway.Close()
+
+	now := time.Now()
+	breaker := newCircuitBreaker("API Gateway", 3, time.Minute)
+	breaker.now = func() time.Time { return now }
+	service := &AdService{
+		httpClient:     gateway.Client(),
+		apiGatewayURL:  gateway.URL,
+		gatewayBreaker: breaker,
+	}
+	forward := func() { service.forwardToGateway("/api/v1/track-impression", "impression", "ad-123", []byte(`{}`)) }
+
+	for i := 0; i < 2; i++ {
+		forward()
+	}
+	if service.GatewayBreakerOpen() {
+		t.Fatal("Expected the breaker to stay closed below the threshold")
+	}
+
+	forward()
+	if !service.GatewayBreakerOpen() {
+		t.Fatal("Expected the breaker to open after 3 consecutive failures")
+	}
+
+	// Open: forwards are skipped without touching the gateway
+	for i := 0; i < 10; i++ {
+		forward()
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected 3 gateway calls while open, got %d", got)
+	}
+
+	// After the cool-down a failed probe reopens it
+	now = now.Add(time.Minute)
+	forward()
+	forward()
+	if got := calls.Load(); got != 4 {
+		t.Errorf("Expected a single probe after the cool-down, got %d calls", got)
+	}
+	if !service.GatewayBreakerOpen() {
+		t.Error("Expected a failed probe to reopen the breaker")
+	}
+
+	// A successful probe closes it
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	forward()
+	if service.GatewayBreakerOpen() {
+		t.Error("Expected a successful probe to close the breaker")
+	}
+	forward()
+	if got := calls.Load(); got != 6 {
+		t.Errorf("Expected forwards to resume once closed, got %d calls", got)
+	}
+}
+
+func TestForwardToGateway_Retries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // Responses in order; the last repeats
+		want     int64 // Expected gateway calls
+	}{
+		{"recovers after transient 5xx", []int{500, 503, 202}, 3},
+		{"gives up after max attempts", []int{500}, 4},
+		{"no retry on 4xx", []int{400}, 1},
+		{"no retry on success", []int{202}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+			}))
+			defer gateway.Close()
+
+			service := &AdService{
+				httpClient:     gateway.Client(),
+				apiGatewayURL:  gateway.URL,
+				gatewayBreaker: newCircuitBreaker("API Gateway", 5, time.Minute),
+				gatewayRetry:   retryPolicy{maxAttempts: 4, baseDelay: time.Millisecond, jitter: 0.5},
+			}
+			service.forwardToGateway("/api/v1/track-impression", "impression", "ad-123", []byte(`{}`))
+
+			if got := calls.Load(); got != tt.want {
+				t.Errorf("Expected %d attempts, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 5, baseDelay: 100 * time.Millisecond}
+	for retry, want := range []time.Duration{100, 200, 400, 800} {
+		if got := policy.delay(retry + 1); got != want*time.Millisecond {
+			t.Errorf("Retry %d: expected %v, got %v", retry+1, want*time.Millisecond, got)
+		}
+	}
+
+	policy.jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(2); got < 160*time.Millisecond || got > 240*time.Millisecond {
+			t.Fatalf("Expected a jittered delay within 20%% of 200ms, got %v", got)
+		}
+	}
+
+	if got := (retryPolicy{}).attempts(); got != 1 {
+		t.Errorf("Expected an unset policy to make 1 attempt, got %d", got)
+	}
+}
+
+func TestSelectAd_RedisDownIsBackendUnavailable(t *testing.T) {
+	client := redis.New("127.0.0.1:1")
+	defer client.Close()
+	service := NewAdService(client, testConfig())
+
+	_, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123"})
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("Expected ErrBackendUnavailable, got: %v", err)
+	}
+	if errors.Is(err, ErrNoActiveCampaigns) || errors.Is(err, ErrNoEligibleCampaigns) {
+		t.Errorf("Expected an outage not to look like a no-fill, got: %v", err)
+	}
+	if reason := NoFillReason(err); reason != "backend_unavailable" {
+		t.Errorf("Expected reason backend_unavailable, got %s", reason)
+	}
+}
+
+func TestMeetsViewability(t *testing.T) {
+	placement := func(rate float64, known bool) func() (float64, bool) {
+		return func() (float64, bool) { return rate, known }
+	}
+	tests := []struct {
+		name      string
+		min       string
+		rate      float64
+		known     bool
+		wantMeets bool
+	}{
+		{"no threshold", "", 0.1, true, true},
+		{"above threshold", "0.7", 0.8, true, true},
+		{"at threshold", "0.7", 0.7, true, true},
+		{"below threshold", "0.7", 0.5, true, false},
+		{"unknown placement", "0.7", 0, false, true},
+		{"invalid threshold", "high", 0.1, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaign := map[string]string{"min_viewability": tt.min}
+			if got := meetsViewability(campaign, placement(tt.rate, tt.known)); got != tt.wantMeets {
+				t.Errorf("Expected %v, got %v", tt.wantMeets, got)
+			}
+		})
+	}
+
+	// Campaigns without a threshold never trigger the placement lookup
+	meetsViewability(map[string]string{}, func() (float64, bool) {
+		t.Error("Expected no viewability lookup without min_viewability")
+		return 0, false
+	})
+}
+
+func TestPlacement(t *testing.T) {
+	if got := models.Placement("app-1", "preroll"); got != "app-1:preroll" {
+		t.Errorf("Expected app-1:preroll, got %s", got)
+	}
+	if got := models.Placement("app-1", ""); got != "app-1" {
+		t.Errorf("Expected app-1, got %s", got)
+	}
+	if got := models.Placement("", "preroll"); got != "" {
+		t.Errorf("Expected no placement without an app, got %s", got)
+	}
+}
+
+func TestEligibleCampaigns_MinViewability(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// A performance campaign requiring 70% viewability and an open one
+	dealID := "deal-" + uuid.New().String()
+	var strictID, openID string
+	for _, minViewability := range []string{"0.7", ""} {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+			"deal_id":         dealID,
+			"min_viewability": minViewability,
+		}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		if minViewability != "" {
+			strictID = campaignID
+		} else {
+			openID = campaignID
+		}
+	}
+
+	// Placements measured at 30% and 90% viewable, and one barely measured
+	t.Setenv("MIN_VIEWABILITY_SAMPLE", "10")
+	lowApp, highApp, newApp := uuid.New().String(), uuid.New().String(), uuid.New().String()
+	record := func(appID string, measured, viewable int) {
+		for i := 0; i < measured; i++ {
+			if err := redisClient.RecordPlacementViewability(ctx, models.Placement(appID, "preroll"), i < viewable); err != nil {
+				t.Fatalf("Failed to record viewability: %v", err)
+			}
+		}
+	}
+	record(lowApp, 10, 3)
+	record(highApp, 10, 9)
+	record(newApp, 5, 0)
+
+	service := NewAdService(redisClient, testConfig())
+	eligibleIDs := func(appID string) map[string]bool {
+		eligible, err := service.eligibleCampaigns(ctx, &models.AdRequest{
+			DeviceID:    "device-" + uuid.New().String(),
+			AppID:       appID,
+			PlacementID: "preroll",
+			DealIDs:     []string{dealID},
+		})
+		if err != nil {
+			t.Fatalf("Expected eligible campaigns, got: %v", err)
+		}
+		ids := make(map[string]bool)
+		for _, id := range eligible.ids {
+			ids[id] = true
+		}
+		return ids
+	}
+
+	if ids := eligibleIDs(lowApp); ids[strictID] || !ids[openID] {
+		t.Errorf("Expected only the open campaign on a low-viewability placement, got %v", ids)
+	}
+	if ids := eligibleIDs(highApp); !ids[strictID] || !ids[openID] {
+		t.Errorf("Expected both campaigns on a high-viewability placement, got %v", ids)
+	}
+	if ids := eligibleIDs(newApp); !ids[strictID] {
+		t.Errorf("Expected an under-sampled placement not to exclude, got %v", ids)
+	}
+}
+
+func TestTrackImpression_RecordsViewability(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient,
+		-24*time.Hour,
+		24*time.Hour,
+		10000.0,
+		0.0,
+	)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	service := NewAdService(redisClient, testConfig())
+	appID := uuid.New().String()
+	inView, outOfView := true, false
+	for _, viewable := range []*bool{&inView, &outOfView, nil} {
+		if _, err := service.TrackImpression(ctx, &models.ImpressionRequest{
+			AdID:        uuid.New().String(),
+			CampaignID:  campaignID,
+			CreativeID:  creativeID,
+			DeviceID:    "device-123",
+			AppID:       appID,
+			PlacementID: "midroll",
+			Viewable:    viewable,
+		}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+
+	// Counters are written by the impression workers
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		measured, viewable, err := redisClient.GetPlacementViewability(ctx, models.Placement(appID, "midroll"))
+		if err != nil {
+			t.Fatalf("Failed to get viewability: %v", err)
+		}
+		if measured == 2 && viewable == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 measured and 1 viewable (unmeasured skipped), got %d and %d", measured, viewable)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithinDailyBudget(t *testing.T) {
+	service := &AdService{}
+	tests := []struct {
+		name       string
+		daily      string
+		spentToday float64
+		want       bool
+	}{
+		{"no daily budget", "", 1000, true},
+		{"under daily budget", "50", 49.99, true},
+		{"at daily budget", "50", 50, false},
+		{"over daily budget", "50", 51, false},
+		{"invalid daily budget", "lots", 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaign := map[string]string{"budget_daily": tt.daily}
+			if got := service.withinDailyBudget("campaign-1", campaign, tt.spentToday); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEligibleCampaigns_DailyBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Both spend 50 today with plenty of lifetime budget left; one is capped
+	// at 50 a day, the other at 100
+	dealID := "deal-" + uuid.New().String()
+	var cappedID, openID string
+	for _, daily := range []string{"50", "100"} {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			0.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+			"deal_id":      dealID,
+			"budget_daily": daily,
+		}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		if _, _, err := redisClient.SpendBudget(ctx, campaignID, 50); err != nil {
+			t.Fatalf("Failed to spend budget: %v", err)
+		}
+		if daily == "50" {
+			cappedID = campaignID
+		} else {
+			openID = campaignID
+		}
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	eligible, err := service.eligibleCampaigns(ctx, &models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}})
+	if err != nil {
+		t.Fatalf("Expected eligible campaigns, got: %v", err)
+	}
+	if len(eligible.ids) != 1 || eligible.ids[0] != openID {
+		t.Errorf("Expected only %s (under its daily budget), got %v; %s is at its daily cap", openID, eligible.ids, cappedID)
+	}
+}
+
+func TestLossEvents(t *testing.T) {
+	now := time.Now()
+	ids := []string{"a", "b", "c"}
+	bids := []auctionBid{{bidCPM: 10}, {bidCPM: 15}, {bidCPM: 12}}
+
+	t.Run("weighted random", func(t *testing.T) {
+		events := lossEvents("ad-1", ids, bids, 1, StrategyWeightedRandom, 0, now)
+		if len(events) != 2 || events[0].CampaignID != "a" || events[1].CampaignID != "c" {
+			t.Fatalf("Expected losses for a and c, got %+v", events)
+		}
+		for _, event := range events {
+			if event.Reason != LossReasonLowerPriority || event.WinningCampaignID != "b" || event.AdID != "ad-1" {
+				t.Errorf("Expected a lower_priority loss to b on ad-1, got %+v", event)
+			}
+			if event.BidCPM != 0 || event.ClearingCPM != 0 {
+				t.Errorf("Expected no prices outside an auction, got %+v", event)
+			}
+		}
+	})
+
+	t.Run("auction", func(t *testing.T) {
+		events := lossEvents("ad-1", ids, bids, 1, StrategyAuction, 12, now)
+		if len(events) != 2 {
+			t.Fatalf("Expected 2 losses, got %+v", events)
+		}
+		for i, wantBid := range []float64{10, 12} {
+			if events[i].Reason != LossReasonOutbid || events[i].BidCPM != wantBid || events[i].ClearingCPM != 12 {
+				t.Errorf("Loss %d: expected outbid at %v clearing 12, got %+v", i, wantBid, events[i])
+			}
+		}
+	})
+
+	t.Run("sole bidder", func(t *testing.T) {
+		if events := lossEvents("ad-1", []string{"a"}, bids[:1], 0, StrategyAuction, 10, now); len(events) != 0 {
+			t.Errorf("Expected no losses without competition, got %+v", events)
+		}
+	})
+}
+
+// recordingLossSink captures emitted loss events and counts the batches
+type recordingLossSink struct {
+	events  chan models.LossEvent
+	batches atomic.Int32
+}
+
+func (r *recordingLossSink) Emit(events []models.LossEvent) error {
+	r.batches.Add(1)
+	for _, event := range events {
+		r.events <- event
+	}
+	return nil
+}
+
+func TestSelectAd_EmitsLossEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Setup
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// Three campaigns competing for one deal, bidding 10, 20 and 30
+	dealID := "deal-" + uuid.New().String()
+	var campaignIDs []string
+	for i := 1; i <= 3; i++ {
+		campaignID, creativeID := seedTestCampaign(t, redisClient,
+			-24*time.Hour,
+			24*time.Hour,
+			10000.0,
+			1000.0,
+		)
+		defer cleanupTestData(t, redisClient, campaignID, creativeID)
+		if err := redisClient.SetCampaign(ctx, campaignID, map[string]interface{}{
+			"deal_id": dealID,
+			"bid_cpm": 10 * i,
+		}); err != nil {
+			t.Fatalf("Failed to set campaign: %v", err)
+		}
+		campaignIDs = append(campaignIDs, campaignID)
+	}
+
+	for _, tt := range []struct {
+		strategy string
+		reason   string
+	}{
+		{StrategyWeightedRandom, LossReasonLowerPriority},
+		{StrategyAuction, LossReasonOutbid},
+	} {
+		t.Run(tt.strategy, func(t *testing.T) {
+			t.Setenv("SELECTION_STRATEGY", tt.strategy)
+			t.Setenv("LOSS_SAMPLE_RATE", "1")
+			service := NewAdService(redisClient, testConfig())
+			sink := &recordingLossSink{events: make(chan models.LossEvent, 10)}
+			service.SetLossSink(sink)
+
+			adResp, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}})
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if tt.strategy == StrategyAuction && adResp.CampaignID != campaignIDs[2] {
+				t.Fatalf("Expected the highest bidder %s to win, got %s", campaignIDs[2], adResp.CampaignID)
+			}
+
+			losers := make(map[string]bool)
+			for i := 0; i < 2; i++ {
+				select {
+				case event := <-sink.events:
+					if event.Reason != tt.reason || event.WinningCampaignID != adResp.CampaignID || event.AdID != adResp.AdID {
+						t.Errorf("Expected a %s loss to %s, got %+v", tt.reason, adResp.CampaignID, event)
+					}
+					losers[event.CampaignID] = true
+				case <-time.After(2 * time.Second):
+					t.Fatal("Timed out waiting for loss events")
+				}
+			}
+			for _, campaignID := range campaignIDs {
+				if losers[campaignID] == (campaignID == adResp.CampaignID) {
+					t.Errorf("Expected a loss event for every campaign but the winner; campaign %s: %v", campaignID, losers[campaignID])
+				}
+			}
+			if batches := sink.batches.Load(); batches != 1 {
+				t.Errorf("Expected the selection's losses in one batch, got %d", batches)
+			}
+		})
+	}
+
+	t.Run("unsampled", func(t *testing.T) {
+		service := NewAdService(redisClient, testConfig())
+		sink := &recordingLossSink{events: make(chan models.LossEvent, 10)}
+		service.SetLossSink(sink)
+
+		if _, err := service.SelectAd(ctx, &models.AdRequest{DeviceID: "device-123", DealIDs: []string{dealID}}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		select {
+		case event := <-sink.events:
+			t.Errorf("Expected no loss events with sampling off, got %+v", event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestPaceProbability(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed float64
+		spent   float64
+		want    float64
+	}{
+		{"start, nothing spent", 0, 0, 1},
+		{"start, within tolerance", 0, 0.01, 1},
+		{"start, far ahead", 0, 0.1, 0.1},
+		{"halfway, behind", 0.5, 0.3, 1},
+		{"halfway, on pace", 0.5, 0.5, 1},
+		{"halfway, ahead", 0.5, 0.755, 0.51 / 0.755},
+		{"halfway, spent out", 0.5, 1, 0.51},
+		{"end, spent out", 1, 1, 1},
+		{"end, underspent", 1, 0.4, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paceProbability(tt.elapsed, tt.spent); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFlightElapsed(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * 24 * time.Hour)
+	tests := []struct {
+		now  time.Time
+		want float64
+	}{
+		{start.Add(-time.Hour), 0},
+		{start, 0},
+		{start.Add(5 * 24 * time.Hour), 0.5},
+		{end, 1},
+		{end.Add(time.Hour), 1},
+	}
+	for _, tt := range tests {
+		if got := flightElapsed(start, end, tt.now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("At %v: expected %v, got %v", tt.now, tt.want, got)
+		}
+	}
+}
+
+func TestOnPace(t *testing.T) {
+	service := &AdService{}
+	start := time.Now().Add(-24 * time.Hour)
+	end := time.Now().Add(24 * time.Hour)
+	rng := newLockedRand(1)
+
+	served := func(spent string) int {
+		campaign := map[string]string{"budget_total": "1000", "budget_spent": spent, "pacing": "even"}
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if service.onPace("campaign-1", campaign, start, end, time.Now(), rng) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Halfway through the flight
+	if n := served("400"); n != 1000 {
+		t.Errorf("Expected a campaign behind schedule to always serve, got %d/1000", n)
+	}
+	if n := served("1000"); n < 400 || n > 620 {
+		t.Errorf("Expected a campaign at full spend halfway through to serve about half the time, got %d/1000", n)
+	}
+}
+
+func TestPacingMode(t *testing.T) {
+	for value, want := range map[string]string{"": PacingASAP, "asap": PacingASAP, "EVEN": PacingEven, " even ": PacingEven, "fast": PacingASAP} {
+		if got := pacingMode(map[string]string{"pacing": value}); got != want {
+			t.Errorf("pacing %q: expected %s, got %s", value, want, got)
+		}
+	}
+}
+
+func TestValidateCampaign(t *testing.T) {
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	valid := func() *models.Campaign {
+		return &models.Campaign{
+			Name:        "Campaign",
+			Status:      "active",
+			BudgetTotal: 1000,
+			StartDate:   start,
+			EndDate:     start.Add(30 * 24 * time.Hour),
+		}
+	}
+
+	if err := validateCampaign(valid()); err != nil {
+		t.Errorf("Expected valid campaign, got: %v", err)
+	}
+
+	tests := map[string]func(*models.Campaign){
+		"missing name":     func(c *models.Campaign) { c.Name = " " },
+		"deleted status":   func(c *models.Campaign) { c.Status = "deleted" },
+		"zero budget":      func(c *models.Campaign) { c.BudgetTotal = 0 },
+		"negative daily":   func(c *models.Campaign) { c.BudgetDaily = -1 },
+		"negative cpm":     func(c *models.Campaign) { c.CPM = -1 },
+		"missing end":      func(c *models.Campaign) { c.EndDate = time.Time{} },
+		"end before start": func(c *models.Campaign) { c.EndDate = start.Add(-time.Hour) },
+		"bad budget unit":  func(c *models.Campaign) { c.BudgetUnit = "pennies" },
+		"bad pacing":       func(c *models.Campaign) { c.Pacing = "fast" },
+	}
+	for name, mutate := range tests {
+		campaign := valid()
+		mutate(campaign)
+		if err := validateCampaign(campaign); !errors.Is(err, ErrInvalidCampaign) {
+			t.Errorf("%s: expected ErrInvalidCampaign, got %v", name, err)
+		}
+	}
+}
+
+func TestCampaignFieldsRoundTrip(t *testing.T) {
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	campaign := &models.Campaign{
+		Name:        "Campaign",
+		Status:      "paused",
+		BudgetTotal: 1000.5,
+		BudgetDaily: 100,
+		BudgetUnit:  BudgetUnitCents,
+		Currency:    "eur",
+		CPM:         12.5,
+		Pacing:      PacingEven,
+		StartDate:   start,
+		EndDate:     start.Add(24 * time.Hour),
+	}
+
+	fields := campaignFields(campaign)
+	if fields["budget_total"] != "1000.5" || fields["currency"] != "EUR" || fields["start_date"] != "2025-10-01T00:00:00Z" {
+		t.Errorf("Unexpected fields: %v", fields)
+	}
+
+	got := campaignFromFields("campaign-1", fields)
+	campaign.ID = "campaign-1"
+	campaign.Currency = "EUR"
+	if *got != *campaign {
+		t.Errorf("Expected %+v, got %+v", campaign, got)
+	}
+
+	// Unset optional settings are cleared
+	campaign.CPM = 0
+	campaign.BudgetDaily = 0
+	if fields := campaignFields(campaign); fields["cpm"] != "" || fields["budget_daily"] != "" {
+		t.Errorf("Expected cleared cpm and budget_daily, got %v", fields)
+	}
+}
+
+func TestFormatAllowed(t *testing.T) {
+	for format, want := range map[string]bool{"mp4": true, " WebM ": true, "mov": false, "": false} {
+		if got := formatAllowed(format, defaultCreativeFormats); got != want {
+			t.Errorf("format %q: expected %v, got %v", format, want, got)
+		}
+	}
+}
+
+func TestGetWeightedCreative_ObservedSplitMatchesWeights(t *testing.T) {
+	service := &AdService{}
+	creativeIDs := []string{"creative-a", "creative-b"}
+
+	// Share of draws won by creative-a
+	share := func(creatives map[string]map[string]string) float64 {
+		rng := newLockedRand(42)
+		wins := 0
+		for i := 0; i < 10000; i++ {
+			if service.getWeightedCreative(creativeIDs, creatives, rng) == "creative-a" {
+				wins++
+			}
+		}
+		return float64(wins) / 10000
+	}
+
+	weighted := map[string]map[string]string{"creative-a": {"weight": "70"}, "creative-b": {"weight": "30"}}
+	if got := share(weighted); math.Abs(got-0.7) > 0.02 {
+		t.Errorf("Expected a 70/30 split, got %.3f for creative-a", got)
+	}
+
+	// Without weights, and with every weight zero, the rotation is uniform
+	unweighted := map[string]map[string]string{"creative-a": {}, "creative-b": {}}
+	if got := share(unweighted); math.Abs(got-0.5) > 0.02 {
+		t.Errorf("Expected an even split without weights, got %.3f for creative-a", got)
+	}
+	zeroed := map[string]map[string]string{"creative-a": {"weight": "0"}, "creative-b": {"weight": "0"}}
+	if got := share(zeroed); math.Abs(got-0.5) > 0.02 {
+		t.Errorf("Expected an even split with zero weights, got %.3f for creative-a", got)
+	}
+}
+
+func TestCreativeFields_Weight(t *testing.T) {
+	zero := 0.0
+	tests := []struct {
+		name   string
+		weight *float64
+		stored string
+	}{
+		{"unset", nil, ""},
+		{"explicit zero", &zero, "0"},
+	}
+
+	for _, tt := range tests {
+		fields := creativeFields(&models.Creative{Weight: tt.weight})
+		if fields["weight"] != tt.stored {
+			t.Errorf("%s: expected stored weight %q, got %q", tt.name, tt.stored, fields["weight"])
+		}
+		read := creativeFromFields("creative-1", fields).Weight
+		if (read == nil) != (tt.weight == nil) || (read != nil && *read != *tt.weight) {
+			t.Errorf("%s: expected weight %v to round-trip, got %v", tt.name, tt.weight, read)
+		}
+	}
+}
+
+func TestSelectCreative_WeightedSplit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeA := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeA)
+
+	creativeB := uuid.New().String()
+	defer redisClient.DeleteCreative(ctx, creativeB, campaignID)
+	paused := uuid.New().String()
+	defer redisClient.DeleteCreative(ctx, paused, campaignID)
+	for creativeID, fields := range map[string]map[string]interface{}{
+		creativeA: {"weight": "70"},
+		creativeB: {"weight": "30", "status": "active"},
+		paused:    {"weight": "1000", "status": "paused"},
+	} {
+		fields["campaign_id"] = campaignID
+		fields["video_url"] = "https://example.com/test-video.mp4"
+		fields["duration"] = "30"
+		fields["format"] = "mp4"
+		if err := redisClient.SetCreative(ctx, creativeID, campaignID, fields); err != nil {
+			t.Fatalf("Failed to set creative: %v", err)
+		}
+	}
+
+	// Weighted rotation draws over the whole set, so a one-creative sample
+	// can't skew the split or land only on the paused creative
+	service := NewAdService(redisClient, testConfig())
+	service.rng = newLockedRand(42)
+	service.creativeSampleSize = 1
+
+	draws := 2000
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		creativeID, creative, err := service.selectCreative(ctx, campaignID, RotationWeighted, &models.AdRequest{}, nil, service.rng)
+		if err != nil {
+			t.Fatalf("Expected a creative, got: %v", err)
+		}
+		if creative["status"] != "active" {
+			t.Fatalf("Expected only active creatives, got %s with status %q", creativeID, creative["status"])
+		}
+		counts[creativeID]++
+	}
+
+	if counts[paused] != 0 {
+		t.Errorf("Expected the paused creative never to be drawn, got %d", counts[paused])
+	}
+	if share := float64(counts[creativeA]) / float64(draws); math.Abs(share-0.7) > 0.04 {
+		t.Errorf("Expected creative A to win ~70%%, got %.3f", share)
+	}
+}
+
+func TestSlotDuration(t *testing.T) {
+	tests := []struct {
+		left, maxDuration, expected int
+	}{
+		{60, 0, 60},  // No max_duration: the time left in the pod
+		{60, 15, 15}, // Capped by max_duration
+		{10, 15, 10}, // Less time left than max_duration
+	}
+
+	for _, tt := range tests {
+		if got := slotDuration(tt.left, tt.maxDuration); got != tt.expected {
+			t.Errorf("slotDuration(%d, %d) = %d, want %d", tt.left, tt.maxDuration, got, tt.expected)
+		}
+	}
+
+	slot := &podSlot{maxDuration: 15}
+	if slot.fits(map[string]string{"duration": "30"}) {
+		t.Error("Expected a 30s creative not to fit a 15s slot")
+	}
+	if !slot.fits(map[string]string{"duration": "15"}) {
+		t.Error("Expected a 15s creative to fit a 15s slot")
+	}
+}
+
+func TestSelectAd_MaxDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	// The seeded creative runs 30s; add a 15s one to the same campaign
+	campaignID, longID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, longID)
+
+	shortID := uuid.New().String()
+	defer redisClient.DeleteCreative(ctx, shortID, campaignID)
+	if err := redisClient.SetCreative(ctx, shortID, campaignID, map[string]interface{}{
+		"campaign_id": campaignID,
+		"video_url":   "https://example.com/test-video-15.mp4",
+		"duration":    "15",
+		"format":      "mp4",
+		"status":      "active",
+	}); err != nil {
+		t.Fatalf("Failed to set creative: %v", err)
+	}
+
+	service := NewAdService(redisClient, testConfig())
+	req := &models.AdRequest{DeviceID: "device-max-duration", DeviceType: "ctv", MaxDuration: 15}
+	for i := 0; i < 20; i++ {
+		resp, err := service.SelectAd(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected an ad, got: %v", err)
+		}
+		if resp.CampaignID != campaignID {
+			continue // Another test's campaign
+		}
+		if resp.CreativeID == longID || resp.Duration > 15 {
+			t.Fatalf("Expected only the 15s creative with max_duration=15, got %s (%ds)", resp.CreativeID, resp.Duration)
+		}
+	}
+
+	// Without the short creative nothing fits, so the campaign can't fill
+	if err := redisClient.DeleteCreative(ctx, shortID, campaignID); err != nil {
+		t.Fatalf("Failed to delete creative: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		resp, err := service.SelectAd(ctx, req)
+		if err == nil && resp.CampaignID == campaignID {
+			t.Fatalf("Expected the campaign's 30s creative never to fill a 15s request, got %s", resp.CreativeID)
+		}
+		if err != nil && !errors.Is(err, ErrNoEligibleCampaigns) {
+			t.Fatalf("Expected a no-fill, got: %v", err)
+		}
+	}
+}
+
+func TestSelectFromEligible_RedisDownIsNotSlotUnfilled(t *testing.T) {
+	// Nothing listens on port 1, so reading creatives fails
+	service := NewAdService(redis.New("127.0.0.1:1"), testConfig())
+	eligible := &eligibleSet{
+		ids:       []string{"campaign-1"},
+		weights:   []int64{1},
+		bids:      []auctionBid{{}},
+		campaigns: map[string]map[string]string{"campaign-1": {"status": "active"}},
+		now:       time.Now(),
+	}
+
+	_, err := service.selectFromEligible(ctx, &models.AdRequest{DeviceID: "device-1"}, eligible, &podSlot{maxDuration: 15})
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Expected ErrBackendUnavailable, got %v", err)
+	}
+	if errors.Is(err, errSlotUnfilled) {
+		t.Error("Expected a Redis failure not to be reported as an unfilled slot")
+	}
+	if len(eligible.ids) != 1 {
+		t.Errorf("Expected the campaign to stay eligible, got %v", eligible.ids)
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	window := time.Minute
+	start := time.Unix(1_700_000_040, 0) // On a minute boundary
+
+	if got := rateLimitReset(start, window); got != window {
+		t.Errorf("Expected a full window at its start, got %v", got)
+	}
+	if got := rateLimitReset(start.Add(45*time.Second), window); got != 15*time.Second {
+		t.Errorf("Expected 15s left, got %v", got)
+	}
+}
+
+func TestImpressionStreamFields(t *testing.T) {
+	viewable := false
+	req := &models.ImpressionRequest{
+		AdID:       "ad-1",
+		CampaignID: "campaign-1",
+		CreativeID: "creative-1",
+		DeviceID:   "device-1",
+		Timestamp:  time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC),
+		Duration:   30,
+		Completed:  true,
+		Viewable:   &viewable,
+	}
+
+	fields := impressionStreamFields(req)
+	want := map[string]interface{}{
+		"ad_id":     "ad-1",
+		"device_id": "device-1",
+		"timestamp": "2025-10-01T12:00:00Z",
+		"duration":  "30",
+		"completed": "true",
+		"viewable":  "false",
+		"app_id":    "",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, fields[key])
+		}
+	}
+
+	// Unmeasured impressions leave viewable empty
+	req.Viewable = nil
+	if got := impressionStreamFields(req)["viewable"]; got != "" {
+		t.Errorf("Expected empty viewable when unmeasured, got %q", got)
+	}
+}
+
+func TestTrackImpression_PublishesToStream(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	redisClient := setupTestRedis(t)
+	defer redisClient.Close()
+
+	campaignID, creativeID := seedTestCampaign(t, redisClient, -24*time.Hour, 24*time.Hour, 10000.0, 0)
+	defer cleanupTestData(t, redisClient, campaignID, creativeID)
+
+	// Entries added from now on; other tests may publish alongside
+	start := fmt.Sprintf("%d-0", time.Now().UnixMilli())
+
+	service := NewAdService(redisClient, testConfig())
+	req := models.ImpressionRequest{
+		AdID:       uuid.New().String(),
+		CampaignID: campaignID,
+		CreativeID: creativeID,
+		DeviceID:   "device-stream",
+		Timestamp:  time.Now(),
+		Duration:   30,
+		Completed:  true,
+	}
+	if _, err := service.TrackImpression(ctx, &req); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	entries, err := redisClient.ReadImpressions(ctx, start, 1000)
+	if err != nil {
+		t.Fatalf("Failed to read impressions: %v", err)
+	}
+	var found map[string]string
+	for _, entry := range entries {
+		if entry.Fields["ad_id"] == req.AdID {
+			found = entry.Fields
+		}
+	}
+	if found == nil {
+		t.Fatalf("Expected impression %s in the stream, got %d other entries", req.AdID, len(entries))
+	}
+	if found["campaign_id"] != campaignID || found["creative_id"] != creativeID || found["device_id"] != "device-stream" {
+		t.Errorf("Unexpected stream entry: %v", found)
+	}
+	if found["duration"] != "30" || found["completed"] != "true" {
+		t.Errorf("Expected duration and completion in the stream entry, got %v", found)
+	}
+}
+
+func TestHouseAdFallback(t *testing.T) {
+	t.Setenv("HOUSE_AD_VIDEO_URL", "https://cdn.example.com/house.mp4")
+	t.Setenv("HOUSE_AD_DURATION", "15")
+	service := NewAdService(redis.New("127.0.0.1:1"), testConfig())
+	req := &models.AdRequest{DeviceID: "device-1"}
+
+	for _, err := range []error{ErrNoActiveCampaigns, ErrNoEligibleCampaigns} {
+		ad, got := service.houseAdFallback(req, nil, err)
+		if got != nil {
+			t.Fatalf("Expected the house ad for %v, got error %v", err, got)
+		}
+		if ad.CampaignID != HouseCampaignID || ad.CreativeID != HouseCampaignID {
+			t.Errorf("Expected campaign and creative %q, got %s/%s", HouseCampaignID, ad.CampaignID, ad.CreativeID)
+		}
+		if ad.VideoURL != "https://cdn.example.com/house.mp4" || ad.Duration != 15 || ad.Format != "mp4" {
+			t.Errorf("Unexpected house ad: %+v", ad)
+		}
+		if ad.AdID == "" || ad.TrackingURL == "" {
+			t.Errorf("Expected the house ad to be trackable, got %+v", ad)
+		}
+	}
+
+	// Outages and invalid traffic are not no-fills
+	for _, err := range []error{ErrBackendUnavailable, ErrInvalidTraffic} {
+		if _, got := service.houseAdFallback(req, nil, err); got != err {
+			t.Errorf("Expected %v to be returned unchanged, got %v", err, got)
+		}
+	}
+
+	// A house ad longer than max_duration doesn't fit
+	if _, got := service.houseAdFallback(req, &podSlot{maxDuration: 10}, ErrNoEligibleCampaigns); got != ErrNoEligibleCampaigns {
+		t.Errorf("Expected a no-fill when the house ad is too long, got %v", got)
+	}
+
+	// Disabled, a no-fill stays a no-fill
+	t.Setenv("HOUSE_AD_ENABLED", "false")
+	disabled := NewAdService(redis.New("127.0.0.1:1"), testConfig())
+	if _, got := disabled.houseAdFallback(req, nil, ErrNoEligibleCampaigns); got != ErrNoEligibleCampaigns {
+		t.Errorf("Expected a no-fill with the house ad disabled, got %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Campaign selection strategies, set by SELECTION_STRATEGY
+const (
+	StrategyWeightedRandom = "weighted_random"      // Draw weighted by remaining budget (default)
+	StrategyAuction        = "second_price_auction" // Highest bid wins, pays the second-highest
+)
+
+// selectionStrategy returns the configured strategy, defaulting to weighted
+// random for missing or unknown values
+func selectionStrategy(value string) string {
+	if strings.EqualFold(strings.TrimSpace(value), StrategyAuction) {
+		return StrategyAuction
+	}
+	return StrategyWeightedRandom
+}
+
+// auctionBid is one eligible campaign's entry in the auction
+type auctionBid struct {
+	bidCPM    float64 // In the base currency
+	remaining float64 // Remaining budget, breaks ties
+}
+
+// campaignBid returns the campaign's bid_cpm, falling back to its cpm
+func campaignBid(campaign map[string]string) float64 {
+	if bid, err := strconv.ParseFloat(campaign["bid_cpm"], 64); err == nil && bid >= 0 {
+		return bid
+	}
+	cpm, _ := strconv.ParseFloat(campaign["cpm"], 64)
+	return cpm
+}
+
+// runAuction runs a second-price auction: the highest bid wins, ties going to
+// the bidder with more remaining budget (then the earlier bid), and the winner
+// clears at the highest losing bid. A sole bidder clears at its own bid.
+func runAuction(bids []auctionBid) (winner int, clearingCPM float64) {
+	if len(bids) == 0 {
+		return -1, 0
+	}
+
+	for i := 1; i < len(bids); i++ {
+		b, w := bids[i], bids[winner]
+		if b.bidCPM > w.bidCPM || (b.bidCPM == w.bidCPM && b.remaining > w.remaining) {
+			winner = i
+		}
+	}
+
+	if len(bids) == 1 {
+		return winner, bids[winner].bidCPM
+	}
+	clearingCPM = -1
+	for i, b := range bids {
+		if i != winner && b.bidCPM > clearingCPM {
+			clearingCPM = b.bidCPM
+		}
+	}
+	return winner, clearingCPM
+}
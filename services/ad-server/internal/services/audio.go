@@ -0,0 +1,14 @@
+package services
+
+import "strconv"
+
+// matchesAudio reports whether a creative can play in a placement. Creatives
+// flagged audio_required depend on sound and are skipped for muted requests
+// (e.g. autoplay-muted web); everything plays when sound is on or unknown.
+func matchesAudio(muted bool, creative map[string]string) bool {
+	if !muted {
+		return true
+	}
+	audioRequired, _ := strconv.ParseBool(creative["audio_required"])
+	return !audioRequired
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Default no-fill backoff: 1s, 2s, 4s... up to 5 minutes
+const (
+	defaultNoFillBackoffBaseSeconds = 1
+	defaultNoFillBackoffMaxSeconds  = 300
+)
+
+// RecordNoFill extends the device's no-fill streak and returns how long the
+// client should wait before retrying. Counter errors return the base delay.
+func (s *AdService) RecordNoFill(ctx context.Context, deviceID string) time.Duration {
+	// The streak is forgotten once the device has been quiet for the max delay
+	count, err := s.redis.IncrementNoFills(ctx, deviceID, 2*s.noFillBackoffMax)
+	if err != nil {
+		log.Printf("Failed to record no-fill for device %s: %v", deviceID, err)
+		count = 1
+	}
+	return noFillBackoff(count, s.noFillBackoffBase, s.noFillBackoffMax)
+}
+
+// ResetNoFills ends the device's no-fill streak after a fill
+func (s *AdService) ResetNoFills(ctx context.Context, deviceID string) {
+	if err := s.redis.ResetNoFills(ctx, deviceID); err != nil {
+		log.Printf("Failed to reset no-fills for device %s: %v", deviceID, err)
+	}
+}
+
+// noFillBackoff doubles the delay with each consecutive no-fill, capped at max
+func noFillBackoff(count int64, base, max time.Duration) time.Duration {
+	if count < 1 {
+		count = 1
+	}
+	delay := base
+	for i := int64(1); i < count; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return min(delay, max)
+}
@@ -0,0 +1,18 @@
+package services
+
+import "strings"
+
+// isAppBlocked reports whether a campaign's blocked_apps (comma-separated app
+// IDs) lists the request's app. Campaigns without blocked_apps, and requests
+// without an app_id, are never blocked.
+func isAppBlocked(appID string, campaign map[string]string) bool {
+	if appID == "" {
+		return false
+	}
+	for _, blocked := range strings.Split(campaign["blocked_apps"], ",") {
+		if strings.TrimSpace(blocked) == appID {
+			return true
+		}
+	}
+	return false
+}
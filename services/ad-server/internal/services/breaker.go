@@ -0,0 +1,90 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// API Gateway circuit breaker defaults
+const (
+	defaultGatewayBreakerThreshold       = 5
+	defaultGatewayBreakerCooldownSeconds = 30
+)
+
+// circuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens and rejects calls for cooldown; then a single
+// probe is let through, closing the breaker on success or reopening it on
+// failure.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a call may go ahead
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record reports the outcome of an allowed call. The breaker logs once when
+// it opens and once when it closes, not per rejected call.
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		if b.failures >= b.threshold {
+			log.Printf("%s reachable again, closing circuit", b.name)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("WARNING: %s failed %d times in a row, skipping calls for %s", b.name, b.failures, b.cooldown)
+		}
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// isOpen reports whether calls are currently being short-circuited
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+// GatewayBreakerOpen reports whether tracking forwards to the API Gateway are
+// being skipped because it kept failing
+func (s *AdService) GatewayBreakerOpen() bool {
+	return s.gatewayBreaker.isOpen()
+}
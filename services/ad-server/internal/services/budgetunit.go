@@ -0,0 +1,46 @@
+package services
+
+import "strings"
+
+// Units budget_total, budget_spent and budget_daily may be stored in. Some
+// upstream systems write whole currency units, others integer cents.
+const (
+	BudgetUnitDollars = "dollars"
+	BudgetUnitCents   = "cents"
+)
+
+// parseBudgetUnit returns the configured unit, defaulting to dollars for missing
+// or unknown values
+func parseBudgetUnit(value string) string {
+	if strings.EqualFold(strings.TrimSpace(value), BudgetUnitCents) {
+		return BudgetUnitCents
+	}
+	return BudgetUnitDollars
+}
+
+// budgetScale returns how many stored budget units make one unit of the
+// campaign's currency: its budget_unit if set, else the configured default.
+// Budget fields and spend stay in stored units; amounts are scaled where
+// they meet CPM-derived costs.
+func (s *AdService) budgetScale(campaign map[string]string) float64 {
+	unit := s.budgetUnit
+	if campaign["budget_unit"] != "" {
+		unit = parseBudgetUnit(campaign["budget_unit"])
+	}
+	if unit == BudgetUnitCents {
+		return 100
+	}
+	return 1
+}
+
+// toCurrencyUnits converts a stored budget amount into the campaign's
+// currency units, so "1000" is 10.00 for a cents campaign
+func (s *AdService) toCurrencyUnits(amount float64, campaign map[string]string) float64 {
+	return amount / s.budgetScale(campaign)
+}
+
+// toBudgetUnits converts an amount in the campaign's currency units into its
+// stored budget unit
+func (s *AdService) toBudgetUnits(amount float64, campaign map[string]string) float64 {
+	return amount * s.budgetScale(campaign)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// campaignCache holds campaign hashes in process for a short TTL so hot
+// selection paths don't re-read every campaign from Redis. Entries are
+// refreshed lazily on the first read after they expire, and swept at most
+// once per TTL so campaigns that are no longer read don't stay cached.
+type campaignCache struct {
+	ttl time.Duration
+	now func() time.Time // Replaced in tests
+
+	mu        sync.RWMutex
+	entries   map[string]cachedCampaign
+	lastSweep time.Time
+}
+
+type cachedCampaign struct {
+	fields  map[string]string
+	expires time.Time
+}
+
+func newCampaignCache(ttl time.Duration) *campaignCache {
+	return &campaignCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cachedCampaign),
+	}
+}
+
+// get returns a campaign's cached fields if present and unexpired
+func (c *campaignCache) get(campaignID string) (map[string]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[campaignID]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.fields, true
+}
+
+// put caches a campaign's fields for the TTL, first dropping expired entries
+// if a TTL has passed since the last sweep
+func (c *campaignCache) put(campaignID string, fields map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for id, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[campaignID] = cachedCampaign{fields: fields, expires: now.Add(c.ttl)}
+}
+
+// getCampaigns returns campaign hashes from the cache, fetching misses and
+// expired entries from Redis in one round trip. A zero TTL disables the
+// cache and always reads through. Missing campaigns are omitted.
+func (s *AdService) getCampaigns(ctx context.Context, campaignIDs []string) (map[string]map[string]string, error) {
+	if s.campaignCache == nil || s.campaignCache.ttl <= 0 {
+		return s.redis.GetCampaigns(ctx, campaignIDs)
+	}
+
+	campaigns := make(map[string]map[string]string, len(campaignIDs))
+	var misses []string
+	for _, campaignID := range campaignIDs {
+		if fields, ok := s.campaignCache.get(campaignID); ok {
+			campaigns[campaignID] = fields
+		} else {
+			misses = append(misses, campaignID)
+		}
+	}
+	if len(misses) == 0 {
+		return campaigns, nil
+	}
+
+	fetched, err := s.redis.GetCampaigns(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+	for campaignID, fields := range fetched {
+		s.campaignCache.put(campaignID, fields)
+		campaigns[campaignID] = fields
+	}
+	return campaigns, nil
+}
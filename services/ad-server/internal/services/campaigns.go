@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/redis"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCampaign is wrapped by campaign validation failures so callers
+// can distinguish bad input from backend errors
+var ErrInvalidCampaign = errors.New("invalid campaign")
+
+// writableCampaignStatuses are the statuses a campaign may be saved with;
+// deleted is only set by DeleteCampaign
+var writableCampaignStatuses = map[string]bool{"active": true, "paused": true}
+
+// CreateCampaign validates a campaign and writes it under a new ID, owned by
+// tenantID when set. It joins active_campaigns if it is active.
+func (s *AdService) CreateCampaign(ctx context.Context, tenantID string, campaign *models.Campaign) (*models.Campaign, error) {
+	if err := validateCampaign(campaign); err != nil {
+		return nil, err
+	}
+
+	campaignID := uuid.New().String()
+	fields := campaignFields(campaign)
+	fields["budget_spent"] = "0"
+	if tenantID != "" {
+		fields["tenant_id"] = tenantID
+	}
+	if _, err := s.redis.SaveCampaign(ctx, campaignID, fields, false); err != nil {
+		return nil, err
+	}
+	return s.GetCampaign(ctx, campaignID)
+}
+
+// GetCampaign returns a campaign. Soft-deleted campaigns are not found.
+func (s *AdService) GetCampaign(ctx context.Context, campaignID string) (*models.Campaign, error) {
+	fields, err := s.redis.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if fields["status"] == "deleted" {
+		return nil, fmt.Errorf("campaign %w: %s", redis.ErrNotFound, campaignID)
+	}
+	return campaignFromFields(campaignID, fields), nil
+}
+
+// UpdateCampaign validates a campaign and replaces the stored settings of an
+// existing one. Spend and ownership are kept; pausing it or exhausting its
+// budget takes it out of active_campaigns, reactivating puts it back.
+func (s *AdService) UpdateCampaign(ctx context.Context, campaignID string, campaign *models.Campaign) (*models.Campaign, error) {
+	if _, err := s.GetCampaign(ctx, campaignID); err != nil {
+		return nil, err
+	}
+	if err := validateCampaign(campaign); err != nil {
+		return nil, err
+	}
+
+	// The save re-checks the hash, as the campaign may be deleted meanwhile
+	if _, err := s.redis.SaveCampaign(ctx, campaignID, campaignFields(campaign), true); err != nil {
+		return nil, err
+	}
+	return s.GetCampaign(ctx, campaignID)
+}
+
+// validateCampaign checks a campaign written through the API
+func validateCampaign(campaign *models.Campaign) error {
+	if strings.TrimSpace(campaign.Name) == "" {
+		return fmt.Errorf("%w: missing required field name", ErrInvalidCampaign)
+	}
+	if !writableCampaignStatuses[campaign.Status] {
+		return fmt.Errorf("%w: status must be active or paused", ErrInvalidCampaign)
+	}
+	if campaign.BudgetTotal <= 0 {
+		return fmt.Errorf("%w: budget_total must be positive", ErrInvalidCampaign)
+	}
+	if campaign.BudgetDaily < 0 {
+		return fmt.Errorf("%w: budget_daily must not be negative", ErrInvalidCampaign)
+	}
+	if campaign.CPM < 0 {
+		return fmt.Errorf("%w: cpm must not be negative", ErrInvalidCampaign)
+	}
+	if campaign.StartDate.IsZero() || campaign.EndDate.IsZero() {
+		return fmt.Errorf("%w: start_date and end_date are required", ErrInvalidCampaign)
+	}
+	if !campaign.EndDate.After(campaign.StartDate) {
+		return fmt.Errorf("%w: end_date must be after start_date", ErrInvalidCampaign)
+	}
+	if unit := campaign.BudgetUnit; unit != "" && unit != BudgetUnitDollars && unit != BudgetUnitCents {
+		return fmt.Errorf("%w: budget_unit must be dollars or cents", ErrInvalidCampaign)
+	}
+	if pacing := campaign.Pacing; pacing != "" && pacing != PacingASAP && pacing != PacingEven {
+		return fmt.Errorf("%w: pacing must be asap or even", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+// campaignFields returns the hash fields a campaign write sets. Unset
+// optional settings are written empty, so an update clears them.
+func campaignFields(campaign *models.Campaign) map[string]string {
+	fields := map[string]string{
+		"name":         campaign.Name,
+		"status":       campaign.Status,
+		"budget_total": formatAmount(campaign.BudgetTotal),
+		"start_date":   campaign.StartDate.Format(time.RFC3339),
+		"end_date":     campaign.EndDate.Format(time.RFC3339),
+		"budget_daily": "",
+		"budget_unit":  campaign.BudgetUnit,
+		"currency":     strings.ToUpper(campaign.Currency),
+		"cpm":          "",
+		"pacing":       campaign.Pacing,
+	}
+	if campaign.BudgetDaily > 0 {
+		fields["budget_daily"] = formatAmount(campaign.BudgetDaily)
+	}
+	if campaign.CPM > 0 {
+		fields["cpm"] = formatAmount(campaign.CPM)
+	}
+	return fields
+}
+
+// campaignFromFields reads a campaign hash into the API model
+func campaignFromFields(campaignID string, fields map[string]string) *models.Campaign {
+	campaign := &models.Campaign{
+		ID:         campaignID,
+		Name:       fields["name"],
+		Status:     fields["status"],
+		BudgetUnit: fields["budget_unit"],
+		Currency:   fields["currency"],
+		Pacing:     fields["pacing"],
+		TenantID:   fields["tenant_id"],
+	}
+	campaign.BudgetTotal, _ = strconv.ParseFloat(fields["budget_total"], 64)
+	campaign.BudgetSpent, _ = strconv.ParseFloat(fields["budget_spent"], 64)
+	campaign.BudgetDaily, _ = strconv.ParseFloat(fields["budget_daily"], 64)
+	campaign.CPM, _ = strconv.ParseFloat(fields["cpm"], 64)
+	campaign.StartDate, _ = time.Parse(time.RFC3339, fields["start_date"])
+	campaign.EndDate, _ = time.Parse(time.RFC3339, fields["end_date"])
+	return campaign
+}
+
+// formatAmount writes a budget or CPM amount without trailing zeros
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fanwu/ad-server/internal/redis"
+)
+
+// ErrInvalidCreative is wrapped by write-path validation failures so callers
+// can distinguish bad input from backend errors
+var ErrInvalidCreative = errors.New("invalid creative")
+
+// requiredCreativeFields must be present on every creative written to Redis
+var requiredCreativeFields = []string{"video_url", "duration", "format", "status"}
+
+// defaultCreativeURLSchemes is the video_url scheme allowlist when none is
+// configured
+var defaultCreativeURLSchemes = []string{"https"}
+
+// defaultCreativeFormats is the format allowlist when none is configured
+var defaultCreativeFormats = []string{"mp4", "webm"}
+
+// permittedCreativeURLSchemes bounds what the allowlist may be configured to,
+// so file://, gopher:// and friends can never be served
+var permittedCreativeURLSchemes = map[string]bool{"http": true, "https": true}
+
+// SaveCreative validates a creative against its campaign and the supported
+// formats and writes it to Redis, so malformed creatives never reach the
+// serving path
+func (s *AdService) SaveCreative(ctx context.Context, creativeID, campaignID string, creative map[string]string) error {
+	// A missing format is reported by validateCreative
+	if format := creative["format"]; format != "" && !formatAllowed(format, s.creativeFormats) {
+		return fmt.Errorf("%w: format %q not supported", ErrInvalidCreative, format)
+	}
+
+	campaign, err := s.redis.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+	// Soft-deleted campaigns answer 404 everywhere else in the admin API
+	if campaign["status"] == "deleted" {
+		return fmt.Errorf("campaign %w: %s", redis.ErrNotFound, campaignID)
+	}
+
+	if err := validateCreative(campaign, creative, s.creativeURLSchemes); err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{}, len(creative))
+	for k, v := range creative {
+		data[k] = v
+	}
+	data["format"] = normalizeFormat(creative["format"])
+
+	return s.redis.SetCreative(ctx, creativeID, campaignID, data)
+}
+
+// validateCreative checks required fields, the video_url scheme, and that the
+// creative's format is within the campaign's allowed_formats (when the
+// campaign declares any)
+func validateCreative(campaign, creative map[string]string, allowedSchemes []string) error {
+	for _, field := range requiredCreativeFields {
+		if creative[field] == "" {
+			return fmt.Errorf("%w: missing required field %s", ErrInvalidCreative, field)
+		}
+	}
+
+	if err := validateCreativeURL(creative["video_url"], allowedSchemes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCreative, err)
+	}
+
+	if duration, err := strconv.Atoi(creative["duration"]); err != nil || duration <= 0 {
+		return fmt.Errorf("%w: duration must be a positive integer", ErrInvalidCreative)
+	}
+
+	if poster := creative["poster_url"]; poster != "" {
+		if err := validatePosterURL(poster, allowedSchemes); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCreative, err)
+		}
+	}
+
+	if weight := creative["weight"]; weight != "" {
+		if w, err := strconv.ParseFloat(weight, 64); err != nil || !(w >= 0 && w <= maxCreativeWeight) {
+			return fmt.Errorf("%w: weight must be a number from 0 to %d", ErrInvalidCreative, maxCreativeWeight)
+		}
+	}
+
+	allowedFormats := splitList(campaign["allowed_formats"])
+	if len(allowedFormats) == 0 || formatAllowed(creative["format"], allowedFormats) {
+		return nil
+	}
+	return fmt.Errorf("%w: format %s not allowed by campaign", ErrInvalidCreative, creative["format"])
+}
+
+// formatAllowed reports whether format is one of allowed, ignoring case
+func formatAllowed(format string, allowed []string) bool {
+	for _, f := range allowed {
+		if normalizeFormat(format) == normalizeFormat(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFormat canonicalizes a creative format so "MP4", "Mp4" and "mp4"
+// are treated as the same container
+func normalizeFormat(format string) string {
+	return strings.ToLower(strings.TrimSpace(format))
+}
+
+// validateCreativeURL checks that a creative URL is absolute and uses one of
+// the allowed schemes. Schemes other than http/https are always rejected.
+func validateCreativeURL(rawURL string, allowedSchemes []string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if !permittedCreativeURLSchemes[scheme] {
+		return fmt.Errorf("url scheme %q not permitted", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("url has no host")
+	}
+
+	for _, allowed := range allowedSchemes {
+		if strings.EqualFold(scheme, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("url scheme %q not allowed", parsed.Scheme)
+}
+
+// posterImageExtensions are the image types accepted for poster_url
+var posterImageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// validatePosterURL checks a poster URL like a video URL and additionally
+// requires an image file extension
+func validatePosterURL(rawURL string, allowedSchemes []string) error {
+	if err := validateCreativeURL(rawURL, allowedSchemes); err != nil {
+		return err
+	}
+
+	parsed, _ := url.Parse(rawURL)
+	if !posterImageExtensions[strings.ToLower(path.Ext(parsed.Path))] {
+		return fmt.Errorf("poster url is not an image")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package services
+
+import "strings"
+
+// requiredCampaignFields are the campaign hash fields selection can't do
+// without. A campaign missing any of them is excluded with a logged reason
+// instead of silently failing a later check.
+var requiredCampaignFields = []string{"status", "start_date", "end_date", "budget_total"}
+
+// missingCampaignFields returns the required fields absent (or blank) in a
+// campaign hash, in requiredCampaignFields order
+func missingCampaignFields(campaign map[string]string) []string {
+	var missing []string
+	for _, field := range requiredCampaignFields {
+		if strings.TrimSpace(campaign[field]) == "" {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
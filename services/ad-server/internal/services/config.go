@@ -0,0 +1,22 @@
+package services
+
+import "strings"
+
+// defaultCreativeSampleSize is how many creative IDs are sampled per selection
+const defaultCreativeSampleSize = 10
+
+// defaultMinPerformanceSample is the number of impressions a creative needs
+// before its completion rate is trusted for exclusion
+const defaultMinPerformanceSample = 1000
+
+// splitList splits a comma-separated string, trimming whitespace and
+// dropping empty entries
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/google/uuid"
+)
+
+// CreateCreative validates a creative and writes it under a new ID, adding
+// it to its campaign's creative set
+func (s *AdService) CreateCreative(ctx context.Context, creative *models.Creative) (*models.Creative, error) {
+	if creative.CampaignID == "" {
+		return nil, fmt.Errorf("%w: missing required field campaign_id", ErrInvalidCreative)
+	}
+
+	creativeID := uuid.New().String()
+	if err := s.SaveCreative(ctx, creativeID, creative.CampaignID, creativeFields(creative)); err != nil {
+		return nil, err
+	}
+	return s.GetCreative(ctx, creativeID)
+}
+
+// GetCreative returns a creative
+func (s *AdService) GetCreative(ctx context.Context, creativeID string) (*models.Creative, error) {
+	fields, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return nil, err
+	}
+	return creativeFromFields(creativeID, fields), nil
+}
+
+// UpdateCreative validates a creative and replaces the stored settings of an
+// existing one. It stays with the campaign it was created under.
+func (s *AdService) UpdateCreative(ctx context.Context, creativeID string, creative *models.Creative) (*models.Creative, error) {
+	existing, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return nil, err
+	}
+
+	campaignID := existing["campaign_id"]
+	if creative.CampaignID != "" && creative.CampaignID != campaignID {
+		return nil, fmt.Errorf("%w: campaign_id can't be changed", ErrInvalidCreative)
+	}
+	creative.CampaignID = campaignID
+	if err := s.SaveCreative(ctx, creativeID, campaignID, creativeFields(creative)); err != nil {
+		return nil, err
+	}
+	return s.GetCreative(ctx, creativeID)
+}
+
+// DeleteCreative removes a creative and drops it from its campaign's
+// creative set, so it is never selected again
+func (s *AdService) DeleteCreative(ctx context.Context, creativeID string) error {
+	creative, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return err
+	}
+	return s.redis.DeleteCreative(ctx, creativeID, creative["campaign_id"])
+}
+
+// creativeFields returns the hash fields a creative write sets. Unset
+// optional settings are written empty, so an update clears them.
+func creativeFields(creative *models.Creative) map[string]string {
+	fields := map[string]string{
+		"campaign_id": creative.CampaignID,
+		"name":        creative.Name,
+		"video_url":   creative.VideoURL,
+		"duration":    strconv.Itoa(creative.Duration),
+		"format":      creative.Format,
+		"status":      creative.Status,
+		"click_url":   creative.ClickURL,
+		"poster_url":  creative.PosterURL,
+		"title":       creative.Title,
+		"advertiser":  creative.Advertiser,
+		"weight":      "",
+	}
+	if creative.Weight != nil {
+		fields["weight"] = formatAmount(*creative.Weight)
+	}
+	return fields
+}
+
+// creativeFromFields reads a creative hash into the API model
+func creativeFromFields(creativeID string, fields map[string]string) *models.Creative {
+	creative := &models.Creative{
+		ID:         creativeID,
+		CampaignID: fields["campaign_id"],
+		Name:       fields["name"],
+		VideoURL:   fields["video_url"],
+		Format:     fields["format"],
+		Status:     fields["status"],
+		ClickURL:   fields["click_url"],
+		PosterURL:  fields["poster_url"],
+		Title:      fields["title"],
+		Advertiser: fields["advertiser"],
+	}
+	creative.Duration, _ = strconv.Atoi(fields["duration"])
+	if weight, err := strconv.ParseFloat(fields["weight"], 64); err == nil {
+		creative.Weight = &weight
+	}
+	return creative
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// defaultBaseCurrency is the currency CPMs are quoted in
+const defaultBaseCurrency = "USD"
+
+// fxTable maps a currency code to its rate into the base currency
+// (1 unit of currency = rate units of base currency)
+type fxTable map[string]float64
+
+// parseFXRates parses "EUR:1.08,GBP:1.27" into an fxTable, skipping
+// malformed entries
+func parseFXRates(value string) fxTable {
+	rates := make(fxTable)
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate <= 0 {
+			continue
+		}
+		rates[strings.ToUpper(strings.TrimSpace(parts[0]))] = rate
+	}
+	return rates
+}
+
+// toBase converts an amount in currency to the base currency. Amounts in the
+// base currency (or with no currency set) pass through unchanged. When no
+// rate is configured the amount is returned unconverted with ok=false.
+func (s *AdService) toBase(amount float64, currency string) (float64, bool) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == s.baseCurrency {
+		return amount, true
+	}
+	rate, ok := s.fxRates[currency]
+	if !ok {
+		return amount, false
+	}
+	return amount * rate, true
+}
+
+// fromBase converts an amount in the base currency into currency, the
+// inverse of toBase
+func (s *AdService) fromBase(amount float64, currency string) (float64, bool) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == s.baseCurrency {
+		return amount, true
+	}
+	rate, ok := s.fxRates[currency]
+	if !ok {
+		return amount, false
+	}
+	return amount / rate, true
+}
+
+// isOverspent reports whether stored data has budget_spent above
+// budget_total, which makes the remaining budget (and active_campaigns score)
+// negative
+func isOverspent(campaign map[string]string) bool {
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	return budgetSpent > budgetTotal
+}
+
+// hasBudgetForImpression reports whether the campaign's remaining budget,
+// converted from its budget unit and normalized to the base currency, covers
+// at least one impression at its CPM
+func (s *AdService) hasBudgetForImpression(campaignID string, campaign map[string]string) bool {
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	budgetSpent += s.pendingSpend(campaignID)
+	if budgetSpent >= budgetTotal {
+		return false
+	}
+
+	cpm, _ := strconv.ParseFloat(campaign["cpm"], 64)
+	if cpm <= 0 {
+		return true
+	}
+
+	remaining, ok := s.toBase(s.toCurrencyUnits(budgetTotal-budgetSpent, campaign), campaign["currency"])
+	if !ok {
+		log.Printf("No FX rate for campaign %s currency %s, skipping normalization", campaignID, campaign["currency"])
+	}
+	return remaining >= cpm/1000
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// campaignDailyBudget returns the campaign's budget_daily, or 0 when it has
+// no daily cap
+func campaignDailyBudget(campaign map[string]string) float64 {
+	budgetDaily, err := strconv.ParseFloat(campaign["budget_daily"], 64)
+	if err != nil || budgetDaily <= 0 {
+		return 0
+	}
+	return budgetDaily
+}
+
+// dailySpend fetches today's spend for the candidates with a daily budget, in
+// one round trip (none when no candidate has one). Lookup failures are logged
+// and daily caps skipped for the request; the lifetime budget still applies.
+func (s *AdService) dailySpend(ctx context.Context, campaignIDs []string, campaigns map[string]map[string]string, now time.Time) map[string]float64 {
+	var capped []string
+	for _, campaignID := range campaignIDs {
+		if campaignDailyBudget(campaigns[campaignID]) > 0 {
+			capped = append(capped, campaignID)
+		}
+	}
+	if len(capped) == 0 {
+		return nil
+	}
+
+	spend, err := s.redis.GetCampaignsDailySpend(ctx, capped, now)
+	if err != nil {
+		log.Printf("Failed to get daily spend, skipping daily budgets: %v", err)
+		return nil
+	}
+	return spend
+}
+
+// withinDailyBudget reports whether a campaign's spend today, including
+// buffered spend not yet flushed, is below its budget_daily
+func (s *AdService) withinDailyBudget(campaignID string, campaign map[string]string, spentToday float64) bool {
+	budgetDaily := campaignDailyBudget(campaign)
+	if budgetDaily == 0 {
+		return true
+	}
+	return spentToday+s.pendingSpend(campaignID) < budgetDaily
+}
@@ -0,0 +1,18 @@
+package services
+
+// matchesDeal reports whether a campaign may fill a request carrying the
+// given PMP deal IDs. Deal requests only fill from campaigns whose deal_id
+// matches one of them; open-auction requests only fill from campaigns with
+// no deal_id.
+func matchesDeal(deals []string, campaign map[string]string) bool {
+	dealID := campaign["deal_id"]
+	if len(deals) == 0 {
+		return dealID == ""
+	}
+	for _, deal := range deals {
+		if deal == dealID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// impressionSequenceTTL is how long a device's impression sequence survives
+// without impressions before restarting at 1
+const impressionSequenceTTL = 30 * 24 * time.Hour
+
+// impressionDedupBuffer is how long past the creative's length a retried
+// impression POST is still recognised as a duplicate
+const impressionDedupBuffer = 5 * time.Minute
+
+// claimImpression records the impression's ad ID and reports whether it is
+// the first report of it, and otherwise the sequence the first report was
+// assigned (0 if not yet). The claim lasts the creative's length (creative
+// may be nil) plus impressionDedupBuffer. Impressions without an ad ID, and
+// Redis failures, count as first so tracking fails open.
+func (s *AdService) claimImpression(ctx context.Context, adID string, creative map[string]string) (bool, int64) {
+	if adID == "" {
+		return true, 0
+	}
+
+	ttl := impressionDedupBuffer
+	if duration, err := strconv.Atoi(creative["duration"]); err == nil && duration > 0 {
+		ttl += time.Duration(duration) * time.Second
+	}
+
+	first, seq, err := s.redis.MarkImpressionSeen(ctx, adID, ttl)
+	if err != nil {
+		log.Printf("Failed to check impression %s for duplicates: %v", adID, err)
+		return true, 0
+	}
+	return first, seq
+}
+
+// releaseImpression drops an ad ID's claim after the impression failed to be
+// charged, so the client's retry is counted rather than taken as a duplicate
+func (s *AdService) releaseImpression(ctx context.Context, adID string) {
+	if adID == "" {
+		return
+	}
+	if err := s.redis.ClearImpressionSeen(ctx, adID); err != nil {
+		log.Printf("Failed to release impression %s: %v", adID, err)
+	}
+}
+
+// nextImpressionSequence assigns the device's next impression confirmation
+// number, so clients can spot dropped or repeated confirmations, and stores
+// it with the ad ID's claim so a retry gets the same number back. It returns
+// 0 when the sequence can't be assigned.
+func (s *AdService) nextImpressionSequence(ctx context.Context, deviceID, adID string) int64 {
+	seq, err := s.redis.NextImpressionSequence(ctx, deviceID, impressionSequenceTTL)
+	if err != nil {
+		log.Printf("Failed to assign impression sequence for device %s: %v", deviceID, err)
+		return 0
+	}
+	if adID != "" {
+		if err := s.redis.SetImpressionSequence(ctx, adID, seq); err != nil {
+			log.Printf("Failed to store impression sequence for ad %s: %v", adID, err)
+		}
+	}
+	return seq
+}
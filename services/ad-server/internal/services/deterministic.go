@@ -0,0 +1,56 @@
+package services
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// defaultDeterministicBucketSeconds is how long deterministic selection keeps
+// giving a device and app the same draws
+const defaultDeterministicBucketSeconds = 60
+
+// selectionRand returns the rand source for a request's draws: the shared
+// source, or in deterministic mode a source seeded from the request so
+// instances reading the same Redis data select the same ad
+func (s *AdService) selectionRand(req *models.AdRequest, now time.Time) *lockedRand {
+	if !s.deterministicSelection {
+		return s.rng
+	}
+	bucket := now.Unix()
+	if seconds := int64(s.deterministicBucket / time.Second); seconds > 0 {
+		bucket /= seconds
+	}
+	return newLockedRand(selectionSeed(req.DeviceID, req.AppID, bucket))
+}
+
+// selectionSeed hashes the request attributes deterministic selection keys on
+func selectionSeed(deviceID, appID string, bucket int64) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+	h.Write([]byte{0})
+	h.Write([]byte(appID))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(bucket, 10)))
+	return int64(h.Sum64())
+}
+
+// sampleIDs returns up to n IDs drawn with rng. IDs are sorted first so the
+// sample doesn't depend on the order Redis returned them in.
+func sampleIDs(rng *lockedRand, ids []string, n int) []string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	if n <= 0 || len(sorted) <= n {
+		return sorted
+	}
+
+	// Partial Fisher-Yates shuffle of the first n positions
+	for i := 0; i < n; i++ {
+		j := i + rng.Intn(len(sorted)-i)
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted[:n]
+}
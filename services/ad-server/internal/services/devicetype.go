@@ -0,0 +1,19 @@
+package services
+
+import "strings"
+
+// matchesDeviceType reports whether a campaign may serve a request from the
+// given device type (ctv, mobile, web). Campaigns without device_types serve
+// every device; targeted campaigns skip requests with no device type.
+func matchesDeviceType(deviceType string, campaign map[string]string) bool {
+	targets := strings.TrimSpace(campaign["device_types"])
+	if targets == "" {
+		return true
+	}
+	for _, target := range strings.Split(targets, ",") {
+		if strings.EqualFold(strings.TrimSpace(target), deviceType) && deviceType != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// defaultDurationToleranceSeconds is how far a reported watch duration may
+// exceed the creative's length (player rounding, buffering) before it is
+// treated as implausible
+const defaultDurationToleranceSeconds = 1
+
+// Reasons an impression's reported duration is flagged
+const (
+	DurationExceedsCreative = "exceeds_creative"
+	DurationNegative        = "negative"
+)
+
+// validateImpressionDuration compares the impression's reported watch
+// duration with the served creative's length, read by the caller (nil when
+// it isn't found). Implausible values are clamped
+// into [0, creative duration] in req and counted as anomalies.
+func (s *AdService) validateImpressionDuration(ctx context.Context, req *models.ImpressionRequest, creative map[string]string) (*models.DurationCheck, error) {
+	if creative == nil {
+		return nil, fmt.Errorf("creative %s not found", req.CreativeID)
+	}
+
+	creativeDuration, err := strconv.Atoi(creative["duration"])
+	if err != nil || creativeDuration <= 0 {
+		return nil, fmt.Errorf("creative %s has no valid duration", req.CreativeID)
+	}
+
+	check := checkDuration(req.Duration, creativeDuration, s.durationTolerance)
+	if check.Flagged {
+		log.Printf("Impression %s reported %ds on %ds creative %s (%s), clamping to %ds",
+			req.AdID, check.Reported, creativeDuration, req.CreativeID, check.Reason, check.Accepted)
+		go s.redis.IncrementImpressionAnomaly(context.WithoutCancel(ctx), check.Reason)
+		req.Duration = check.Accepted
+	}
+	return check, nil
+}
+
+// checkDuration validates a reported duration against a creative's length,
+// allowing tolerance seconds of overrun
+func checkDuration(reported, creativeDuration, tolerance int) *models.DurationCheck {
+	check := &models.DurationCheck{
+		Reported:         reported,
+		CreativeDuration: creativeDuration,
+		Accepted:         reported,
+	}
+
+	switch {
+	case reported < 0:
+		check.Flagged = true
+		check.Reason = DurationNegative
+		check.Accepted = 0
+	case reported > creativeDuration+tolerance:
+		check.Flagged = true
+		check.Reason = DurationExceedsCreative
+		check.Accepted = creativeDuration
+	}
+	return check
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// eligibleSet is the campaigns eligible to serve a request, with each one's
+// selection weight and auction bid at the same index. A pod computes it once
+// and draws every slot from it.
+type eligibleSet struct {
+	ids       []string
+	weights   []int64
+	bids      []auctionBid
+	campaigns map[string]map[string]string
+
+	candidateCount int       // Active campaigns considered
+	now            time.Time // When eligibility was evaluated
+}
+
+// remove drops a campaign from the set, once it is used in a pod or can't
+// serve
+func (e *eligibleSet) remove(campaignID string) {
+	for i, id := range e.ids {
+		if id == campaignID {
+			e.ids = append(e.ids[:i], e.ids[i+1:]...)
+			e.weights = append(e.weights[:i], e.weights[i+1:]...)
+			e.bids = append(e.bids[:i], e.bids[i+1:]...)
+			delete(e.campaigns, campaignID)
+			return
+		}
+	}
+}
+
+// activeCampaigns returns the active campaign IDs with their remaining-budget
+// scores and campaign hashes, wrapping read failures in
+// ErrBackendUnavailable. Without the campaign cache all three come from one
+// atomic snapshot, so a selection is never weighted by a score that
+// disagrees with the budget_spent it was filtered on. The cache already
+// trades that consistency for fewer reads, so with it the scores are read
+// alone and the hashes come from the cache.
+func (s *AdService) activeCampaigns(ctx context.Context) ([]string, map[string]float64, map[string]map[string]string, error) {
+	if s.campaignCache == nil || s.campaignCache.ttl <= 0 {
+		campaignIDs, budgets, campaigns, err := s.redis.GetActiveCampaignSnapshot(ctx)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: failed to get active campaigns: %w", ErrBackendUnavailable, err)
+		}
+		return campaignIDs, budgets, campaigns, nil
+	}
+
+	campaignIDs, budgets, err := s.redis.GetActiveCampaignBudgets(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to get active campaigns: %w", ErrBackendUnavailable, err)
+	}
+	if len(campaignIDs) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	// Fetch every candidate in (at most) one round trip
+	campaigns, err := s.getCampaigns(ctx, campaignIDs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	return campaignIDs, budgets, campaigns, nil
+}
+
+// eligibleCampaigns fetches the active campaigns and filters them down to
+// those that may serve req
+func (s *AdService) eligibleCampaigns(ctx context.Context, req *models.AdRequest) (*eligibleSet, error) {
+	// Get all active campaigns, with their remaining budgets and hashes
+	campaignIDs, budgets, campaigns, err := s.activeCampaigns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(campaignIDs) == 0 {
+		return nil, ErrNoActiveCampaigns
+	}
+
+	country, err := s.resolveCountry(req.LocationCountry, req.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deals := req.RequestedDeals()
+	recentCategories := s.recentCategories(ctx, req.DeviceID, now)
+	dailySpend := s.dailySpend(ctx, campaignIDs, campaigns, now)
+	rng := s.selectionRand(req, now)
+	// Only looked up once a campaign with min_viewability is considered
+	placementViewability := sync.OnceValues(func() (float64, bool) {
+		return s.placementViewability(ctx, req)
+	})
+
+	// Filter campaigns by date and budget
+	eligible := &eligibleSet{
+		candidateCount: len(campaignIDs),
+		campaigns:      make(map[string]map[string]string),
+		now:            now,
+	}
+	for _, campaignID := range campaignIDs {
+		campaign, ok := campaigns[campaignID]
+		if !ok {
+			continue // Skip campaigns whose hash is gone
+		}
+
+		// Misconfigured campaigns are excluded loudly so they can be diagnosed
+		if missing := missingCampaignFields(campaign); len(missing) > 0 {
+			log.Printf("Campaign %s excluded: missing required fields %s", campaignID, strings.Join(missing, ", "))
+			continue
+		}
+
+		// Check status
+		if campaign["status"] != "active" {
+			continue
+		}
+
+		// Check PMP deal
+		if !matchesDeal(deals, campaign) {
+			continue
+		}
+
+		// Check geo targeting
+		if !matchesGeo(country, campaign) {
+			continue
+		}
+
+		// Check device type targeting
+		if !matchesDeviceType(req.DeviceType, campaign) {
+			continue
+		}
+
+		// Check the advertiser's app blocklist
+		if isAppBlocked(req.AppID, campaign) {
+			continue
+		}
+
+		// Check the placement's historical viewability
+		if !meetsViewability(campaign, placementViewability) {
+			continue
+		}
+
+		// Check competitive separation against the device's recent serves
+		if separatedFrom(recentCategories, campaignID, campaign) {
+			continue
+		}
+
+		// Check date range
+		startDate, err := time.Parse(time.RFC3339, campaign["start_date"])
+		if err != nil || now.Before(startDate) {
+			continue
+		}
+
+		endDate, err := time.Parse(time.RFC3339, campaign["end_date"])
+		if err != nil || now.After(endDate) {
+			continue
+		}
+
+		// Over-spent data is an anomaly: drop the campaign from the active set
+		// rather than let a negative remaining budget skew selection
+		if isOverspent(campaign) {
+			log.Printf("Campaign %s budget_spent %s exceeds budget_total %s, removing from active set",
+				campaignID, campaign["budget_spent"], campaign["budget_total"])
+			go s.redis.RemoveActiveCampaign(context.WithoutCancel(ctx), campaignID)
+			continue
+		}
+
+		// Check lifetime and daily budget
+		if !s.hasBudgetForImpression(campaignID, campaign) {
+			continue
+		}
+		if !s.withinDailyBudget(campaignID, campaign, dailySpend[campaignID]) {
+			continue
+		}
+
+		// Throttle even-paced campaigns running ahead of schedule
+		if pacingMode(campaign) == PacingEven && !s.onPace(campaignID, campaign, startDate, endDate, now, rng) {
+			continue
+		}
+
+		// Check frequency cap
+		if s.isFrequencyCapped(ctx, req, campaignID, campaign) {
+			continue
+		}
+
+		// Campaigns with more budget left are drawn more often, for natural
+		// pacing; new campaigns are down-weighted while ramping up
+		remaining, _ := s.toBase(s.toCurrencyUnits(budgets[campaignID], campaign), campaign["currency"])
+		eligible.ids = append(eligible.ids, campaignID)
+		eligible.campaigns[campaignID] = campaign
+		eligible.weights = append(eligible.weights, budgetWeight(remaining, rampUpFactor(now.Sub(startDate), s.rampUpWindow, s.rampUpMinFraction)))
+		eligible.bids = append(eligible.bids, auctionBid{bidCPM: campaignBid(campaign), remaining: remaining})
+	}
+
+	if len(eligible.ids) == 0 {
+		return nil, ErrNoEligibleCampaigns
+	}
+	return eligible, nil
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// ErrInvalidEvent is returned for playback events outside the VAST quartile
+// allowlist
+var ErrInvalidEvent = errors.New("invalid event")
+
+// trackableEvents are the VAST linear progress events players report
+var trackableEvents = map[string]bool{
+	"start":         true,
+	"firstQuartile": true,
+	"midpoint":      true,
+	"thirdQuartile": true,
+	"complete":      true,
+}
+
+// TrackEvent counts a playback event against the creative's hourly event
+// counter, so completion rates can be computed per quartile. Names are
+// matched exactly as VAST spells them.
+func (s *AdService) TrackEvent(ctx context.Context, req *models.TrackEventRequest) error {
+	if !trackableEvents[req.Event] {
+		return fmt.Errorf("%w: %s", ErrInvalidEvent, req.Event)
+	}
+
+	// The event happened whether or not the client is still connected
+	return s.redis.IncrementCreativeEvent(context.WithoutCancel(ctx), req.CreativeID, req.Event)
+}
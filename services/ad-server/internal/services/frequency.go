@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/redis"
+)
+
+// frequencySubject returns the key prefix frequency caps are counted under:
+// the household when the request carries one, otherwise the device
+func frequencySubject(deviceID, householdID string) string {
+	if householdID != "" {
+		return "household:" + householdID
+	}
+	return "device:" + deviceID
+}
+
+// frequencyWindow returns the window the campaign's frequency_cap applies
+// to, defaulting to an hour for missing or unknown values
+func frequencyWindow(campaign map[string]string) string {
+	if strings.EqualFold(campaign["frequency_window"], redis.FrequencyWindowDay) {
+		return redis.FrequencyWindowDay
+	}
+	return redis.FrequencyWindowHour
+}
+
+// isFrequencyCapped reports whether the request's device (or household) has
+// already reached the campaign's frequency_cap within its frequency_window
+func (s *AdService) isFrequencyCapped(ctx context.Context, req *models.AdRequest, campaignID string, campaign map[string]string) bool {
+	frequencyCap, _ := strconv.ParseInt(campaign["frequency_cap"], 10, 64)
+	if frequencyCap <= 0 {
+		return false
+	}
+
+	count, err := s.redis.GetFrequencyCount(ctx, frequencySubject(req.DeviceID, req.HouseholdID), campaignID, frequencyWindow(campaign))
+	if err != nil {
+		return false // Fail open: a counter read error shouldn't block serving
+	}
+	return count >= frequencyCap
+}
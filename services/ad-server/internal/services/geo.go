@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+)
+
+// ErrGeoUnresolved is returned when a request's country can't be determined,
+// and by SelectAd under the no_fill geo fallback
+var ErrGeoUnresolved = errors.New("geo unresolved")
+
+// Geo fallbacks, applied when a request's country can't be resolved
+const (
+	GeoFallbackUntargeted     = "untargeted_only" // Serve only campaigns without geo_targets (default)
+	GeoFallbackDefaultCountry = "default_country" // Treat the request as coming from GEO_DEFAULT_COUNTRY
+	GeoFallbackNoFill         = "no_fill"         // Don't serve the request at all
+)
+
+// GeoResolver maps a client IP to an ISO country code
+type GeoResolver interface {
+	Country(ip string) (string, error)
+}
+
+// GeoLookupFunc adapts a plain lookup function, such as a GeoIP database
+// query, to a GeoResolver
+type GeoLookupFunc func(ip string) (string, error)
+
+// Country calls f(ip)
+func (f GeoLookupFunc) Country(ip string) (string, error) {
+	return f(ip)
+}
+
+// geoRange maps a network to a country
+type geoRange struct {
+	network *net.IPNet
+	country string
+}
+
+// cidrGeoResolver resolves countries from a static CIDR table
+type cidrGeoResolver struct {
+	ranges []geoRange
+}
+
+// parseGeoRanges parses "203.0.113.0/24=US,198.51.100.0/24=CA" into a
+// resolver, skipping malformed entries
+func parseGeoRanges(value string) *cidrGeoResolver {
+	resolver := &cidrGeoResolver{}
+	for _, entry := range strings.Split(value, ",") {
+		cidr, country, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if err != nil || country == "" {
+			log.Printf("Ignoring malformed geo range %q", entry)
+			continue
+		}
+		resolver.ranges = append(resolver.ranges, geoRange{network: network, country: country})
+	}
+	return resolver
+}
+
+// Country resolves an IP to a country. Missing, malformed, private and
+// unlisted addresses are unresolved.
+func (r *cidrGeoResolver) Country(ip string) (string, error) {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil || addr.IsPrivate() || addr.IsLoopback() || addr.IsUnspecified() {
+		return "", ErrGeoUnresolved
+	}
+	for _, r := range r.ranges {
+		if r.network.Contains(addr) {
+			return r.country, nil
+		}
+	}
+	return "", ErrGeoUnresolved
+}
+
+// SetGeoResolver replaces the resolver used for geo targeting
+func (s *AdService) SetGeoResolver(resolver GeoResolver) {
+	s.geoResolver = resolver
+}
+
+// geoFallbackMode returns the configured fallback, defaulting to
+// untargeted_only for missing or unknown values
+func geoFallbackMode(value string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case GeoFallbackDefaultCountry, GeoFallbackNoFill:
+		return mode
+	default:
+		return GeoFallbackUntargeted
+	}
+}
+
+// resolveCountry returns the request's country: the client-supplied
+// location_country, else the resolver's answer for its IP, else the
+// configured fallback. An empty country means only untargeted campaigns
+// are eligible.
+func (s *AdService) resolveCountry(country, ip string) (string, error) {
+	if country != "" {
+		return strings.ToUpper(country), nil
+	}
+	if s.geoResolver != nil {
+		if resolved, err := s.geoResolver.Country(ip); err == nil && resolved != "" {
+			return strings.ToUpper(resolved), nil
+		}
+	}
+
+	switch s.geoFallback {
+	case GeoFallbackDefaultCountry:
+		return s.geoDefaultCountry, nil
+	case GeoFallbackNoFill:
+		return "", ErrGeoUnresolved
+	default:
+		return "", nil
+	}
+}
+
+// matchesGeo reports whether a campaign may serve a request from country.
+// Campaigns without geo_targets serve everywhere; geo-targeted
+// campaigns never serve a request with an unknown country.
+func matchesGeo(country string, campaign map[string]string) bool {
+	targets := strings.TrimSpace(campaign["geo_targets"])
+	if targets == "" {
+		return true
+	}
+	if country == "" {
+		return false
+	}
+	for _, target := range strings.Split(targets, ",") {
+		if strings.EqualFold(strings.TrimSpace(target), country) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/fanwu/ad-server/internal/redis"
+)
+
+// Per-device served history bounds: enough recent serves to explain
+// repetition complaints without growing per device
+const (
+	deviceHistoryLimit = 50
+	deviceHistoryTTL   = 7 * 24 * time.Hour
+)
+
+// recordServe appends a served ad to the device's history. Failures are
+// logged only; history is for support and must not affect serving.
+func (s *AdService) recordServe(ctx context.Context, deviceID string, served models.ServedAd) {
+	if deviceID == "" {
+		return
+	}
+	payload, err := json.Marshal(served)
+	if err != nil {
+		return
+	}
+	if err := s.redis.PushDeviceServe(ctx, deviceID, payload, deviceHistoryLimit, deviceHistoryTTL); err != nil {
+		log.Printf("Failed to record serve for device %s: %v", deviceID, err)
+	}
+}
+
+// DeviceHistory returns a device's recent serves, newest first, with its
+// current hourly and daily impression counts per served campaign. A tenant
+// only sees its own campaigns; the operator (tenantID "") sees all.
+func (s *AdService) DeviceHistory(ctx context.Context, tenantID, deviceID string) (*models.DeviceHistory, error) {
+	payloads, err := s.redis.GetDeviceServes(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &models.DeviceHistory{
+		DeviceID:    deviceID,
+		Served:      []models.ServedAd{},
+		Impressions: []models.DeviceImpressions{},
+	}
+
+	var campaignIDs []string
+	seen := make(map[string]bool)
+	for _, payload := range payloads {
+		var served models.ServedAd
+		if err := json.Unmarshal([]byte(payload), &served); err != nil {
+			continue
+		}
+		history.Served = append(history.Served, served)
+		if !seen[served.CampaignID] {
+			seen[served.CampaignID] = true
+			campaignIDs = append(campaignIDs, served.CampaignID)
+		}
+	}
+
+	if tenantID != "" {
+		campaignIDs, err = s.tenantCampaigns(ctx, tenantID, campaignIDs)
+		if err != nil {
+			return nil, err
+		}
+		owned := make(map[string]bool, len(campaignIDs))
+		for _, id := range campaignIDs {
+			owned[id] = true
+		}
+		served := history.Served[:0]
+		for _, ad := range history.Served {
+			if owned[ad.CampaignID] {
+				served = append(served, ad)
+			}
+		}
+		history.Served = served
+	}
+
+	subject := frequencySubject(deviceID, "")
+	for _, campaignID := range campaignIDs {
+		hour, err := s.redis.GetFrequencyCount(ctx, subject, campaignID, redis.FrequencyWindowHour)
+		if err != nil {
+			return nil, err
+		}
+		day, err := s.redis.GetFrequencyCount(ctx, subject, campaignID, redis.FrequencyWindowDay)
+		if err != nil {
+			return nil, err
+		}
+		history.Impressions = append(history.Impressions, models.DeviceImpressions{
+			CampaignID: campaignID,
+			Hour:       hour,
+			Day:        day,
+		})
+	}
+
+	return history, nil
+}
+
+// tenantCampaigns filters campaignIDs to those owned by tenantID. Campaigns
+// that no longer exist are dropped.
+func (s *AdService) tenantCampaigns(ctx context.Context, tenantID string, campaignIDs []string) ([]string, error) {
+	campaigns, err := s.redis.GetCampaigns(ctx, campaignIDs)
+	if err != nil {
+		return nil, err
+	}
+	var owned []string
+	for _, id := range campaignIDs {
+		if campaign, ok := campaigns[id]; ok && campaign["tenant_id"] == tenantID {
+			owned = append(owned, id)
+		}
+	}
+	return owned, nil
+}
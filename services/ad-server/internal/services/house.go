@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/config"
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/google/uuid"
+)
+
+// HouseCampaignID is the campaign and creative ID of house ads, which fill
+// requests no campaign can and are never billed
+const HouseCampaignID = "house"
+
+// defaultHouseAdDuration is the house ad's length when HOUSE_AD_DURATION is
+// unset, in seconds
+const defaultHouseAdDuration = 30
+
+// houseAd is the configured fallback creative
+type houseAd struct {
+	videoURL string
+	duration int
+	format   string
+}
+
+// newHouseAd returns the house ad configured by HOUSE_AD_VIDEO_URL, or nil
+// when none is set or HOUSE_AD_ENABLED is false
+func newHouseAd(cfg *config.Config) *houseAd {
+	videoURL := cfg.Get("HOUSE_AD_VIDEO_URL")
+	if videoURL == "" || !cfg.Bool("HOUSE_AD_ENABLED", true) {
+		return nil
+	}
+	return &houseAd{
+		videoURL: videoURL,
+		duration: cfg.Int("HOUSE_AD_DURATION", defaultHouseAdDuration),
+		format:   normalizeFormat(cfg.String("HOUSE_AD_FORMAT", "mp4")),
+	}
+}
+
+// creative returns the house ad as creative fields for buildResponse
+func (h *houseAd) creative() map[string]string {
+	return map[string]string{
+		"video_url": h.videoURL,
+		"duration":  strconv.Itoa(h.duration),
+		"format":    h.format,
+	}
+}
+
+// houseAdFallback replaces a no-fill with the house ad. Other errors, such as
+// a Redis outage or invalid traffic, are returned unchanged, as is a no-fill
+// when no house ad is configured or it doesn't fit the slot.
+func (s *AdService) houseAdFallback(req *models.AdRequest, slot *podSlot, err error) (*models.AdResponse, error) {
+	if s.houseAd == nil || !(errors.Is(err, ErrNoActiveCampaigns) || errors.Is(err, ErrNoEligibleCampaigns)) {
+		return nil, err
+	}
+	creative := s.houseAd.creative()
+	if !slot.fits(creative) {
+		return nil, err
+	}
+
+	response := s.buildResponse(uuid.New().String(), HouseCampaignID, HouseCampaignID, creative, req.DeviceID, time.Now())
+	response.Warnings = []string{"no campaign filled, served house ad"}
+	return response, nil
+}
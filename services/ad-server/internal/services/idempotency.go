@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// defaultIdempotencyTTLSeconds is how long an ad request's Idempotency-Key
+// replays its original response: long enough to cover client retries
+const defaultIdempotencyTTLSeconds = 60
+
+// CachedAdResponse returns the ad previously selected for a device's
+// idempotency key, if it is still cached. Lookup failures are logged and
+// treated as a miss, so the request is served fresh.
+func (s *AdService) CachedAdResponse(ctx context.Context, deviceID, key string) (*models.AdResponse, bool) {
+	payload, err := s.redis.GetIdempotentResponse(ctx, deviceID, key)
+	if err != nil {
+		log.Printf("Failed to look up idempotency key for device %s: %v", deviceID, err)
+		return nil, false
+	}
+	if payload == "" {
+		return nil, false
+	}
+
+	var response models.AdResponse
+	if err := json.Unmarshal([]byte(payload), &response); err != nil {
+		log.Printf("Discarding unreadable idempotent response for device %s: %v", deviceID, err)
+		return nil, false
+	}
+	return &response, true
+}
+
+// CacheAdResponse stores a selected ad under the device's idempotency key so
+// a retry of the request gets the same ad
+func (s *AdService) CacheAdResponse(ctx context.Context, deviceID, key string, response *models.AdResponse) {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	if err := s.redis.SetIdempotentResponse(ctx, deviceID, key, payload, s.idempotencyTTL); err != nil {
+		log.Printf("Failed to store idempotent response for device %s: %v", deviceID, err)
+	}
+}
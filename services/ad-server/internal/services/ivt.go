@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// ErrInvalidTraffic is returned by SelectAd when a request is identified as
+// invalid traffic (IVT) and should receive a no-fill
+var ErrInvalidTraffic = errors.New("invalid traffic")
+
+// ErrMalformedDeviceID is returned for device IDs that are known-bad
+// sentinels rather than real identifiers
+var ErrMalformedDeviceID = errors.New("malformed device id")
+
+// defaultDeviceIDSentinels are placeholder values SDKs send when they have no
+// real device ID
+var defaultDeviceIDSentinels = []string{
+	"null",
+	"(null)",
+	"nil",
+	"none",
+	"undefined",
+	"unknown",
+	"nan",
+}
+
+// defaultBotSignatures are user-agent substrings that identify obvious
+// crawlers, scripts and headless browsers
+var defaultBotSignatures = []string{
+	"bot",
+	"crawler",
+	"spider",
+	"slurp",
+	"curl",
+	"wget",
+	"python-requests",
+	"go-http-client",
+	"headlesschrome",
+	"phantomjs",
+}
+
+// isBotUserAgent reports whether the user agent matches any of the
+// (case-insensitive) bot signatures
+func isBotUserAgent(userAgent string, signatures []string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return false
+	}
+	for _, signature := range signatures {
+		if strings.Contains(ua, strings.ToLower(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectBot returns ErrInvalidTraffic, and counts it, for requests from
+// obvious bots, so they are turned away before touching campaigns or budget
+func (s *AdService) rejectBot(ctx context.Context, req *models.AdRequest) error {
+	if s.botFilterEnabled && isBotUserAgent(req.UserAgent, s.botSignatures) {
+		go s.redis.IncrementInvalidTraffic(context.WithoutCancel(ctx), "bot_ua")
+		return ErrInvalidTraffic
+	}
+	return nil
+}
+
+// ValidateDeviceID rejects known-bad device ID sentinels when device ID
+// validation is enabled
+func (s *AdService) ValidateDeviceID(deviceID string) error {
+	if !s.deviceIDValidation {
+		return nil
+	}
+	if isMalformedDeviceID(deviceID, s.deviceIDSentinels) {
+		return ErrMalformedDeviceID
+	}
+	return nil
+}
+
+// isMalformedDeviceID reports whether a device ID is a sentinel
+// (case-insensitive) or consists only of zeros and separators
+func isMalformedDeviceID(deviceID string, sentinels []string) bool {
+	id := strings.TrimSpace(deviceID)
+	for _, sentinel := range sentinels {
+		if strings.EqualFold(id, sentinel) {
+			return true
+		}
+	}
+	return strings.Trim(id, "0-") == ""
+}
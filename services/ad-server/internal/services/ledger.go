@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// ledgerDeadLetterQueue holds ledger events the sink couldn't accept
+const ledgerDeadLetterQueue = "ledger"
+
+// ledgerEmitAttempts is how many times an event is offered to the sink
+// before it is dead-lettered
+const ledgerEmitAttempts = 3
+
+// LedgerSink receives a ledger event for every budget decrement
+type LedgerSink interface {
+	Emit(event models.LedgerEvent) error
+}
+
+// httpLedgerSink posts ledger events to the API Gateway alongside impressions
+type httpLedgerSink struct {
+	client *http.Client
+	url    string
+}
+
+func (h *httpLedgerSink) Emit(event models.LedgerEvent) error {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger event: %w", err)
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to post ledger event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ledger sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SetLedgerSink replaces the sink ledger events are emitted to
+func (s *AdService) SetLedgerSink(sink LedgerSink) {
+	s.ledgerSink = sink
+}
+
+// emitLedger emits one event per charge in a batch that brought the
+// campaign's spend to newSpent, in its budget unit. Each event's new_spent is
+// the running total after that charge; both it and the amount are reported
+// in currency units, so cents campaigns don't read 100x high downstream.
+func (s *AdService) emitLedger(campaignID string, charges []charge, newSpent float64) {
+	now := time.Now()
+	spent := newSpent - sumCharges(charges)
+	events := make([]models.LedgerEvent, 0, len(charges))
+	for _, c := range charges {
+		spent += c.amount
+		events = append(events, models.LedgerEvent{
+			CampaignID: campaignID,
+			AdID:       c.adID,
+			Amount:     c.inCurrency(c.amount),
+			NewSpent:   c.inCurrency(spent),
+			Timestamp:  now,
+		})
+	}
+
+	// Emit off the impression path; delivery failures are dead-lettered
+	go func() {
+		for _, event := range events {
+			s.deliverLedgerEvent(event)
+		}
+	}()
+}
+
+// deliverLedgerEvent offers the event to the sink, retrying with a short
+// backoff, and dead-letters it in Redis if every attempt fails
+func (s *AdService) deliverLedgerEvent(event models.LedgerEvent) {
+	var err error
+	for attempt := 0; attempt < ledgerEmitAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = s.ledgerSink.Emit(event); err == nil {
+			return
+		}
+	}
+
+	log.Printf("Failed to emit ledger event for campaign %s ad %s, dead-lettering: %v", event.CampaignID, event.AdID, err)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal ledger event for dead letter: %v", err)
+		return
+	}
+	// Delivery runs detached from any request, so it has no caller context
+	if err := s.redis.PushDeadLetter(context.Background(), ledgerDeadLetterQueue, payload); err != nil {
+		log.Printf("Failed to dead-letter ledger event for campaign %s: %v", event.CampaignID, err)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// Why an eligible campaign lost a selection
+const (
+	LossReasonOutbid        = "outbid"         // Lower bid in a second-price auction
+	LossReasonLowerPriority = "lower_priority" // Not drawn in the weighted random selection
+)
+
+// Loss delivery pool size. Loss reporting is best effort, so a full queue
+// drops the selection's batch rather than waiting.
+const (
+	lossWorkers   = 4
+	lossQueueSize = 1000
+)
+
+// LossSink receives, per sampled selection, a batch with a loss event for
+// each eligible campaign that didn't win
+type LossSink interface {
+	Emit(events []models.LossEvent) error
+}
+
+// httpLossSink posts loss events to the API Gateway for reporting
+type httpLossSink struct {
+	client *http.Client
+	url    string
+}
+
+// Emit posts the batch as one JSON array
+func (h *httpLossSink) Emit(events []models.LossEvent) error {
+	jsonData, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal loss events: %w", err)
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to post loss events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("loss sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SetLossSink replaces the sink loss events are emitted to
+func (s *AdService) SetLossSink(sink LossSink) {
+	s.lossSink = sink
+}
+
+// lossEvents builds a loss event for every eligible campaign except the
+// winner at index winner
+func lossEvents(adID string, ids []string, bids []auctionBid, winner int, strategy string, clearingCPM float64, now time.Time) []models.LossEvent {
+	events := make([]models.LossEvent, 0, len(ids)-1)
+	for i, campaignID := range ids {
+		if i == winner {
+			continue
+		}
+		event := models.LossEvent{
+			AdID:              adID,
+			CampaignID:        campaignID,
+			WinningCampaignID: ids[winner],
+			Reason:            LossReasonLowerPriority,
+			Timestamp:         now,
+		}
+		if strategy == StrategyAuction {
+			event.Reason = LossReasonOutbid
+			event.BidCPM = bids[i].bidCPM
+			event.ClearingCPM = clearingCPM
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// sampleLosses decides whether a selection's losses are reported, for
+// lossSampleRate of selections
+func (s *AdService) sampleLosses() bool {
+	return s.lossSampleRate > 0 && s.rng.Float64() < s.lossSampleRate
+}
+
+// emitLosses sends a selection's loss events as one batch on the bounded
+// loss pool, off the request path. Loss reporting is best effort: failures
+// are logged, not retried, and batches are dropped when the queue is full.
+func (s *AdService) emitLosses(events []models.LossEvent) {
+	if len(events) == 0 {
+		return
+	}
+	queued := s.lossPool.submit(func() {
+		if err := s.lossSink.Emit(events); err != nil {
+			log.Printf("Failed to emit %d loss events for ad %s: %v", len(events), events[0].AdID, err)
+		}
+	})
+	if !queued {
+		log.Printf("Loss queue full, dropped %d loss events for ad %s", len(events), events[0].AdID)
+	}
+}
@@ -0,0 +1,16 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// recordMakeGood records an impression the campaign can no longer (fully) pay
+// for, because other traffic exhausted it after this ad was selected, against
+// the make-good bucket with the uncovered cost. That part is not charged, so
+// the campaign never overspends.
+func (s *AdService) recordMakeGood(ctx context.Context, campaignID, adID string, cost float64) error {
+	log.Printf("Campaign %s exhausted since selection: impression %s (%.4f) diverted to make-good bucket %s",
+		campaignID, adID, cost, s.makeGoodBucket)
+	return s.redis.RecordMakeGood(ctx, s.makeGoodBucket, campaignID, cost)
+}
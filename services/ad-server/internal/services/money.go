@@ -0,0 +1,113 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// currencyDecimals lists currencies without the usual two minor digits
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"CLP": 0,
+	"ISK": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// currencySymbols are shown instead of the ISO code where well known
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// numberFormat is how a locale writes money amounts
+type numberFormat struct {
+	decimal     string
+	group       string
+	symbolAfter bool
+}
+
+// localeFormats is keyed by primary language tag; unknown languages use en
+var localeFormats = map[string]numberFormat{
+	"en": {decimal: ".", group: ",", symbolAfter: false},
+	"ja": {decimal: ".", group: ",", symbolAfter: false},
+	"de": {decimal: ",", group: ".", symbolAfter: true},
+	"es": {decimal: ",", group: ".", symbolAfter: true},
+	"it": {decimal: ",", group: ".", symbolAfter: true},
+	"fr": {decimal: ",", group: " ", symbolAfter: true},
+}
+
+// FormatMoney renders an amount in currency for a locale (a language tag
+// such as "de" or "en-US"), using the currency's minor digits
+func FormatMoney(amount float64, currency, locale string) string {
+	currency = strings.ToUpper(currency)
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		decimals = 2
+	}
+
+	format, ok := localeFormats[primaryLanguage(locale)]
+	if !ok {
+		format = localeFormats["en"]
+	}
+
+	// Round half away from zero, as money is conventionally rounded
+	scale := math.Pow(10, float64(decimals))
+	digits := strconv.FormatFloat(math.Round(math.Abs(amount)*scale)/scale, 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+	number := groupThousands(whole, format.group)
+	if fraction != "" {
+		number += format.decimal + fraction
+	}
+
+	sign := ""
+	if amount < 0 && strings.Trim(digits, "0.") != "" {
+		sign = "-"
+	}
+
+	symbol, ok := currencySymbols[currency]
+	switch {
+	case !ok:
+		return sign + currency + " " + number
+	case format.symbolAfter:
+		return sign + number + " " + symbol
+	default:
+		return sign + symbol + number
+	}
+}
+
+// PreferredLocale returns the first language in an Accept-Language header
+func PreferredLocale(acceptLanguage string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ := strings.Cut(first, ";")
+	return strings.TrimSpace(tag)
+}
+
+// primaryLanguage returns the lowercased language subtag of a locale
+func primaryLanguage(locale string) string {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+// groupThousands inserts sep between groups of three digits
+func groupThousands(digits, sep string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
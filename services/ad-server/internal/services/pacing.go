@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// GetCampaignPacing reports a campaign's delivery today against its daily
+// target
+func (s *AdService) GetCampaignPacing(ctx context.Context, campaignID string) (*models.CampaignPacing, error) {
+	campaign, err := s.redis.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+
+	now := time.Now()
+	impressions, spend, err := s.redis.GetCampaignDailyDelivery(ctx, campaignID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	pacing := computePacing(campaign, impressions, spend, s.budgetScale(campaign), now)
+	pacing.CampaignID = campaignID
+	pacing.Currency = strings.ToUpper(campaign["currency"])
+	if pacing.Currency == "" {
+		pacing.Currency = s.baseCurrency
+	}
+	return pacing, nil
+}
+
+// LocalizePacing adds the pacing's money amounts formatted for locale
+func LocalizePacing(pacing *models.CampaignPacing, locale string) {
+	pacing.Formatted = map[string]string{
+		"spend_today":      FormatMoney(pacing.SpendToday, pacing.Currency, locale),
+		"daily_target":     FormatMoney(pacing.DailyTarget, pacing.Currency, locale),
+		"expected_to_date": FormatMoney(pacing.ExpectedToDate, pacing.Currency, locale),
+	}
+}
+
+// computePacing derives the daily target from the budget remaining at the
+// start of today spread evenly over the days left in the flight (today
+// included), then compares today's spend with that target prorated to now.
+// Stored budget amounts are divided by scale into currency units.
+func computePacing(campaign map[string]string, impressions int64, spend, scale float64, now time.Time) *models.CampaignPacing {
+	spend /= scale
+	pacing := &models.CampaignPacing{
+		Date:             now.Format("2006-01-02"),
+		ImpressionsToday: impressions,
+		SpendToday:       spend,
+	}
+
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	budgetTotal /= scale
+	budgetSpent /= scale
+	endDate, err := time.Parse(time.RFC3339, campaign["end_date"])
+	if err != nil {
+		return pacing
+	}
+
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	daysLeft := math.Ceil(endDate.Sub(startOfDay).Hours() / 24)
+	if daysLeft < 1 {
+		return pacing
+	}
+
+	remaining := math.Max(budgetTotal-(budgetSpent-spend), 0)
+	pacing.DailyTarget = remaining / daysLeft
+
+	if cpm, _ := strconv.ParseFloat(campaign["cpm"], 64); cpm > 0 {
+		pacing.DailyImpressionTarget = int64(pacing.DailyTarget / cpm * 1000)
+	}
+
+	dayElapsed := now.Sub(startOfDay).Hours() / 24
+	pacing.ExpectedToDate = pacing.DailyTarget * dayElapsed
+	if pacing.ExpectedToDate > 0 {
+		pacing.PaceRatio = spend / pacing.ExpectedToDate
+	}
+	return pacing
+}
+
+// Budget pacing modes, set per campaign via its pacing field
+const (
+	PacingASAP = "asap" // Spend as fast as traffic allows (default)
+	PacingEven = "even" // Spread spend evenly from start_date to end_date
+)
+
+// evenPacingTolerance is how far ahead of the even schedule, as a fraction of
+// the budget, a campaign may get before it is throttled, so it can deliver
+// from the moment it starts
+const evenPacingTolerance = 0.01
+
+// pacingMode returns the campaign's pacing mode, defaulting to asap for
+// missing or unknown values
+func pacingMode(campaign map[string]string) string {
+	if strings.EqualFold(strings.TrimSpace(campaign["pacing"]), PacingEven) {
+		return PacingEven
+	}
+	return PacingASAP
+}
+
+// flightElapsed returns the fraction of the flight from start to end that
+// has passed at now, clamped to [0, 1]
+func flightElapsed(start, end, now time.Time) float64 {
+	flight := end.Sub(start)
+	if flight <= 0 {
+		return 1
+	}
+	return math.Min(math.Max(float64(now.Sub(start))/float64(flight), 0), 1)
+}
+
+// paceProbability returns the chance an even-paced campaign may serve, given
+// the fractions of its flight elapsed and its budget spent: 1 while spend is
+// on or behind schedule, falling in proportion as it gets further ahead
+func paceProbability(elapsed, spent float64) float64 {
+	allowed := elapsed + evenPacingTolerance
+	if spent <= allowed {
+		return 1
+	}
+	return allowed / spent
+}
+
+// onPace decides whether an even-paced campaign serves this request, skipping
+// it with a probability that grows the further it is ahead of schedule.
+// Buffered spend not yet flushed counts toward the spend.
+func (s *AdService) onPace(campaignID string, campaign map[string]string, start, end, now time.Time, rng *lockedRand) bool {
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	if budgetTotal <= 0 {
+		return true
+	}
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	spent := (budgetSpent + s.pendingSpend(campaignID)) / budgetTotal
+
+	p := paceProbability(flightElapsed(start, end, now), spent)
+	return p >= 1 || rng.Float64() < p
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// Default pod request limits
+const (
+	defaultMaxPodDuration = 300 // seconds
+	defaultMaxPodAds      = 10
+)
+
+// ErrInvalidPod is wrapped by pod requests with out-of-range parameters
+var ErrInvalidPod = errors.New("invalid pod request")
+
+// errSlotUnfilled means the drawn campaign had no creative that fits the
+// slot or request; the campaign has been removed from the eligible set and
+// the selection can be retried
+var errSlotUnfilled = errors.New("campaign cannot fill slot")
+
+// podSlot constrains selection for one slot of a pod, or for an ad request
+// with a max_duration
+type podSlot struct {
+	brands      map[string]bool // Brands already in the pod
+	maxDuration int             // Longest creative that fits, in seconds
+}
+
+// slotDuration is the longest creative a pod slot takes: the time left in the
+// pod, capped by the request's max_duration when set
+func slotDuration(left, maxDuration int) int {
+	if maxDuration > 0 && maxDuration < left {
+		return maxDuration
+	}
+	return left
+}
+
+// fits reports whether a creative may fill the slot: no longer than its
+// maximum duration and not from a brand already in the pod. A nil slot (a
+// standalone ad request without max_duration) fits everything.
+func (slot *podSlot) fits(creative map[string]string) bool {
+	if slot == nil {
+		return true
+	}
+	if duration, _ := strconv.Atoi(creative["duration"]); slot.maxDuration > 0 && duration > slot.maxDuration {
+		return false
+	}
+	if brand := creative["brand_id"]; brand != "" && slot.brands[brand] {
+		return false
+	}
+	return true
+}
+
+// ValidatePod checks pod parameters against the configured maxima, returning
+// the number of ads to fill (max_ads, defaulting to the cap when unset)
+func (s *AdService) ValidatePod(req *models.AdPodRequest) (int, error) {
+	if req.PodDuration <= 0 || req.PodDuration > s.maxPodDuration {
+		return 0, fmt.Errorf("%w: pod_duration must be between 1 and %d", ErrInvalidPod, s.maxPodDuration)
+	}
+	if req.MaxAds < 0 || req.MaxAds > s.maxPodAds {
+		return 0, fmt.Errorf("%w: max_ads must be between 1 and %d", ErrInvalidPod, s.maxPodAds)
+	}
+	if req.MaxAds == 0 {
+		return s.maxPodAds, nil
+	}
+	return req.MaxAds, nil
+}
+
+// SelectAdPod fills an ad break with up to max_ads ads, each from a different
+// campaign, brand and competitive category, whose durations fit within
+// pod_duration. Eligibility is computed once for the pod, and every slot
+// attempt either fills the slot or removes a campaign from the eligible set,
+// so building stops once eligible inventory is exhausted.
+func (s *AdService) SelectAdPod(ctx context.Context, req *models.AdPodRequest) (*models.AdPodResponse, error) {
+	maxAds, err := s.ValidatePod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.rejectBot(ctx, &req.AdRequest); err != nil {
+		return nil, err
+	}
+	eligible, err := s.eligibleCampaigns(ctx, &req.AdRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &models.AdPodResponse{Ads: []models.AdResponse{}}
+	brands := make(map[string]bool)
+	for len(pod.Ads) < maxAds && pod.TotalDuration < req.PodDuration {
+		slot := &podSlot{brands: brands, maxDuration: slotDuration(req.PodDuration-pod.TotalDuration, req.MaxDuration)}
+		ad, err := s.selectFromEligible(ctx, &req.AdRequest, eligible, slot)
+		if errors.Is(err, errSlotUnfilled) {
+			continue
+		}
+		if err != nil {
+			if len(pod.Ads) == 0 {
+				return nil, err
+			}
+			break // Inventory exhausted: return the partial pod
+		}
+
+		category := competitiveCategory(eligible.campaigns[ad.CampaignID])
+		eligible.remove(ad.CampaignID)
+		eligible.removeCategory(category)
+		if ad.BrandID != "" {
+			brands[ad.BrandID] = true
+		}
+		pod.Ads = append(pod.Ads, *ad)
+		pod.TotalDuration += ad.Duration
+	}
+	return pod, nil
+}
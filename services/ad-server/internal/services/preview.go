@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+	"github.com/google/uuid"
+)
+
+// PreviewCreative builds the response a creative would be served with,
+// bypassing campaign status, date, budget and targeting checks. Nothing is
+// counted, reserved or charged.
+func (s *AdService) PreviewCreative(ctx context.Context, creativeID, deviceID string) (*models.AdResponse, error) {
+	creative, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch creative: %w", err)
+	}
+
+	return s.buildResponse(uuid.New().String(), creative["campaign_id"], creativeID, creative, deviceID, time.Now()), nil
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// defaultQuarantineSeconds is how long a creative reported as failing to
+// play is excluded from selection
+const defaultQuarantineSeconds = 300
+
+// Defaults for the reports needed before a creative is quarantined: distinct
+// reporters within the window, and reports per reporter per rate limit window
+const (
+	defaultCreativeErrorThreshold     = 3
+	defaultCreativeErrorWindowSeconds = 300
+	defaultCreativeErrorRateLimit     = 10
+)
+
+// ReportCreativeError records that a client failed to play a creative. Once
+// creativeErrorThreshold distinct reporters (client IPs) have reported it
+// within creativeErrorWindow, the creative is quarantined so other devices
+// don't hit the same broken creative while it's investigated. It reports
+// whether the creative is now quarantined. Unknown creatives return
+// redis.ErrNotFound and reporters over their limit ErrRateLimited.
+func (s *AdService) ReportCreativeError(ctx context.Context, creativeID, reporter, errorCode string) (bool, error) {
+	if s.exceedsRateLimit(ctx, "creative_error", reporter, s.creativeErrorRateLimit) {
+		return false, ErrRateLimited
+	}
+	if _, err := s.redis.GetCreative(ctx, creativeID); err != nil {
+		return false, err
+	}
+
+	reporters, err := s.redis.RecordCreativeErrorReport(ctx, creativeID, reporter, s.creativeErrorWindow)
+	if err != nil {
+		return false, err
+	}
+	if reporters < int64(s.creativeErrorThreshold) {
+		log.Printf("Creative %s reported failing (code %q), %d of %d reporters", creativeID, errorCode, reporters, s.creativeErrorThreshold)
+		return false, nil
+	}
+
+	log.Printf("Creative %s reported failing (code %q) by %d reporters, quarantining for %s", creativeID, errorCode, reporters, s.quarantineTTL)
+	if err := s.redis.QuarantineCreative(ctx, creativeID, s.quarantineTTL); err != nil {
+		return false, err
+	}
+	return true, nil
+}
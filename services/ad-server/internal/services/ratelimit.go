@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrRateLimited is returned when a device or client IP has exceeded its
+// request limit for the current window
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// defaultRateLimitWindowSeconds is the rate limit window when none is
+// configured
+const defaultRateLimitWindowSeconds = 60
+
+// CheckRateLimit counts the request against the per-device and per-IP
+// limits. The IP limit stops a single source rotating device IDs; either
+// limit tripping blocks the request. Counter errors fail open.
+func (s *AdService) CheckRateLimit(ctx context.Context, deviceID, ipAddress string) error {
+	if s.exceedsRateLimit(ctx, "device", deviceID, s.deviceRateLimit) {
+		return ErrRateLimited
+	}
+	if s.exceedsRateLimit(ctx, "ip", ipAddress, s.ipRateLimit) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// CheckClientRateLimit counts an API request from a client IP against the
+// API-wide limit. Over the limit it returns ErrRateLimited and how long until
+// the window resets. Counter errors fail open.
+func (s *AdService) CheckClientRateLimit(ctx context.Context, ipAddress string) (time.Duration, error) {
+	return s.checkWindowLimit(ctx, "client", ipAddress, s.clientRateLimit)
+}
+
+// CheckTrackingRateLimit is CheckClientRateLimit for tracking beacons, which
+// have their own limit: they are billable, and many players can share one IP
+// (server-side ad insertion, households behind NAT)
+func (s *AdService) CheckTrackingRateLimit(ctx context.Context, ipAddress string) (time.Duration, error) {
+	return s.checkWindowLimit(ctx, "tracking", ipAddress, s.trackingRateLimit)
+}
+
+// checkWindowLimit counts a request against a per-IP limit, returning
+// ErrRateLimited and the time left in the window when it is exceeded
+func (s *AdService) checkWindowLimit(ctx context.Context, scope, ipAddress string, limit int64) (time.Duration, error) {
+	if !s.exceedsRateLimit(ctx, scope, ipAddress, limit) {
+		return 0, nil
+	}
+	return rateLimitReset(time.Now(), s.rateLimitWindow), ErrRateLimited
+}
+
+// rateLimitReset returns how long until the fixed window containing now ends
+func rateLimitReset(now time.Time, window time.Duration) time.Duration {
+	return window - time.Duration(now.UnixNano()%int64(window))
+}
+
+// exceedsRateLimit increments the scope's counter and reports whether it is
+// now over limit
+func (s *AdService) exceedsRateLimit(ctx context.Context, scope, id string, limit int64) bool {
+	if limit <= 0 || id == "" {
+		return false
+	}
+
+	count, err := s.redis.IncrementRateLimit(ctx, scope, id, s.rateLimitWindow)
+	if err != nil {
+		log.Printf("Rate limit check failed for %s %s: %v", scope, id, err)
+		return false
+	}
+	return count > limit
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// canonicalCampaignID maps an active_campaigns member to the campaign ID it
+// names. Misconfigured writers have added IDs with stray whitespace or a
+// "campaign:" key prefix alongside the bare ID.
+func canonicalCampaignID(member string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(member), "campaign:"))
+}
+
+// ReconcileActiveCampaigns makes each campaign appear in active_campaigns
+// exactly once, under its bare ID, scored by its remaining budget. Variant
+// members are removed, so a campaign is never weighted twice, and exhausted,
+// paused or deleted campaigns leave the set. Members whose campaign hash is
+// gone are left for the tombstone reaper. It returns the campaign IDs it
+// corrected.
+func (s *AdService) ReconcileActiveCampaigns(ctx context.Context) ([]string, error) {
+	members, scores, err := s.redis.GetActiveCampaignBudgets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var campaignIDs []string
+	membersByID := make(map[string][]string)
+	for _, member := range members {
+		id := canonicalCampaignID(member)
+		if _, seen := membersByID[id]; !seen {
+			campaignIDs = append(campaignIDs, id)
+		}
+		membersByID[id] = append(membersByID[id], member)
+	}
+
+	campaigns, err := s.redis.GetCampaigns(ctx, campaignIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrected []string
+	for _, id := range campaignIDs {
+		campaign, ok := campaigns[id]
+		if !ok {
+			continue
+		}
+
+		var stale []string
+		for _, member := range membersByID[id] {
+			if member != id {
+				stale = append(stale, member)
+			}
+		}
+		score, hasCanonical := scores[id]
+		if len(stale) == 0 && membershipCurrent(campaign, score, hasCanonical) {
+			continue
+		}
+
+		// The correction re-reads the hash in the same script, so spend or a
+		// status change since GetCampaigns isn't overwritten with this read
+		active, remaining, err := s.redis.ReconcileActiveCampaign(ctx, id, stale)
+		if err != nil {
+			return corrected, err
+		}
+		log.Printf("Reconciled active campaign %s: members %q, score %.4f -> %.4f (active %t)", id, membersByID[id], score, remaining, active)
+		corrected = append(corrected, id)
+	}
+	return corrected, nil
+}
+
+// membershipCurrent reports whether a campaign's canonical active_campaigns
+// membership matches its hash: present with its remaining budget as score
+// when active with budget left, absent otherwise
+func membershipCurrent(campaign map[string]string, score float64, hasCanonical bool) bool {
+	budgetTotal, _ := strconv.ParseFloat(campaign["budget_total"], 64)
+	budgetSpent, _ := strconv.ParseFloat(campaign["budget_spent"], 64)
+	remaining := budgetTotal - budgetSpent
+	if campaign["status"] != "active" || remaining <= 1e-9 {
+		return !hasCanonical
+	}
+	return hasCanonical && math.Abs(score-remaining) < 1e-9
+}
+
+// StartActiveCampaignReconciler periodically reconciles active_campaigns
+// until ctx is cancelled
+func (s *AdService) StartActiveCampaignReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				corrected, err := s.ReconcileActiveCampaigns(ctx)
+				if err != nil {
+					log.Printf("Failed to reconcile active campaigns: %v", err)
+					continue
+				}
+				if len(corrected) > 0 {
+					log.Printf("Reconciled %d active campaigns", len(corrected))
+				}
+			}
+		}
+	}()
+}
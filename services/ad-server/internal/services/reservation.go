@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+)
+
+// defaultReservationTTLSeconds is how long a budget reservation is held for
+// an impression that hasn't arrived
+const defaultReservationTTLSeconds = 30
+
+// reserveBudget holds one impression's cost for adID when reservations are
+// enabled and the campaign is near its budget limit, so concurrent requests
+// can't all select it before spend lands. It reports whether the campaign may
+// serve the ad. Reservation errors fail open.
+func (s *AdService) reserveBudget(ctx context.Context, adID, campaignID string, campaign map[string]string) bool {
+	if !s.budgetReservations || !isBudgetNearlyExhausted(campaign) {
+		return true
+	}
+
+	cpm, _ := strconv.ParseFloat(campaign["cpm"], 64)
+	if cpm <= 0 {
+		return true
+	}
+	cost, _ := s.fromBase(cpm/1000, campaign["currency"])
+	cost = s.toBudgetUnits(cost, campaign)
+
+	reserved, err := s.redis.ReserveBudget(ctx, campaignID, adID, cost, s.pendingSpend(campaignID), s.reservationTTL)
+	if err != nil {
+		log.Printf("Failed to reserve budget for campaign %s: %v", campaignID, err)
+		return true
+	}
+	return reserved
+}
+
+// releaseReservation drops any reservation held for adID
+func (s *AdService) releaseReservation(ctx context.Context, adID, campaignID string) {
+	if !s.budgetReservations {
+		return
+	}
+	if err := s.redis.ReleaseReservation(ctx, campaignID, adID); err != nil {
+		log.Printf("Failed to release reservation for ad %s: %v", adID, err)
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Gateway forward retry defaults: 3 attempts, waiting about 200ms then 400ms
+const (
+	defaultGatewayRetryAttempts = 3
+	defaultGatewayRetryBaseMS   = 200
+	defaultGatewayRetryJitter   = 0.2
+)
+
+// retryPolicy bounds retries of a failed call with exponential backoff. The
+// wait before retry n is baseDelay * 2^(n-1), randomly adjusted by up to
+// ±jitter of itself so retries from many workers don't arrive in lockstep.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	jitter      float64
+}
+
+// attempts returns how many times a call is made, at least once
+func (p retryPolicy) attempts() int {
+	if p.maxAttempts < 1 {
+		return 1
+	}
+	return p.maxAttempts
+}
+
+// delay returns the wait before the given retry (1 for the first retry)
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := float64(p.baseDelay) * float64(uint64(1)<<min(retry-1, 30))
+	if p.jitter > 0 {
+		delay *= 1 + p.jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
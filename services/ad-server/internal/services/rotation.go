@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Creative rotation modes, set per campaign via its rotation_mode field
+const (
+	RotationWeighted   = "weighted"   // Weighted random draw (default)
+	RotationEven       = "even"       // Least-served creative first
+	RotationOptimized  = "optimized"  // Highest completion rate first
+	RotationSequential = "sequential" // Fixed order, one after another
+)
+
+// rotationMode returns the campaign's creative rotation mode, defaulting to
+// weighted for missing or unknown values
+func rotationMode(campaign map[string]string) string {
+	switch mode := strings.ToLower(campaign["rotation_mode"]); mode {
+	case RotationEven, RotationOptimized, RotationSequential:
+		return mode
+	default:
+		return RotationWeighted
+	}
+}
+
+// pickCreative dispatches to the selection logic for the rotation mode
+func (s *AdService) pickCreative(ctx context.Context, campaignID, mode string, creativeIDs []string, creatives map[string]map[string]string, rng *lockedRand) (string, error) {
+	switch mode {
+	case RotationEven:
+		return s.getLeastServedCreative(ctx, campaignID, creativeIDs)
+	case RotationOptimized:
+		return s.getOptimizedCreative(ctx, creativeIDs)
+	case RotationSequential:
+		return s.getSequentialCreative(ctx, campaignID, creativeIDs)
+	default:
+		return s.getWeightedCreative(creativeIDs, creatives, rng), nil
+	}
+}
+
+// getWeightedCreative draws by creative weight; fresh creatives get a
+// temporary exposure boost. When every weight is zero the draw is uniform.
+func (s *AdService) getWeightedCreative(creativeIDs []string, creatives map[string]map[string]string, rng *lockedRand) string {
+	now := time.Now()
+	weights := make([]int64, len(creativeIDs))
+	for i, creativeID := range creativeIDs {
+		weights[i] = weightUnits(s.creativeWeight(creatives[creativeID], now))
+	}
+	if totalWeight(weights) == 0 {
+		return creativeIDs[rng.Intn(len(creativeIDs))]
+	}
+	return creativeIDs[drawWeighted(rng, weights)]
+}
+
+// getLeastServedCreative picks the creative this campaign has served least
+func (s *AdService) getLeastServedCreative(ctx context.Context, campaignID string, creativeIDs []string) (string, error) {
+	counts, err := s.redis.GetCreativeServeCounts(ctx, campaignID, creativeIDs)
+	if err != nil {
+		return "", fmt.Errorf("failed to get creative serve counts: %w", err)
+	}
+	return leastServed(creativeIDs, counts), nil
+}
+
+// getOptimizedCreative picks the creative with the best completion rate
+func (s *AdService) getOptimizedCreative(ctx context.Context, creativeIDs []string) (string, error) {
+	performance, err := s.redis.GetCreativesPerformance(ctx, creativeIDs)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch creative performance: %w", err)
+	}
+	return bestPerforming(creativeIDs, performance, s.minPerformanceSample), nil
+}
+
+// getSequentialCreative advances the campaign's rotation sequence and picks
+// the next creative in ID order
+func (s *AdService) getSequentialCreative(ctx context.Context, campaignID string, creativeIDs []string) (string, error) {
+	sequence, err := s.redis.NextCreativeSequence(ctx, campaignID)
+	if err != nil {
+		return "", fmt.Errorf("failed to advance creative sequence: %w", err)
+	}
+	return sequentialPick(creativeIDs, sequence), nil
+}
+
+// leastServed returns the creative with the lowest serve count, breaking ties
+// by input order
+func leastServed(creativeIDs []string, counts map[string]int64) string {
+	best := creativeIDs[0]
+	for _, creativeID := range creativeIDs[1:] {
+		if counts[creativeID] < counts[best] {
+			best = creativeID
+		}
+	}
+	return best
+}
+
+// bestPerforming returns the creative with the highest completion rate.
+// Creatives with fewer than minSample impressions are treated as perfect so
+// new creatives still get explored.
+func bestPerforming(creativeIDs []string, performance map[string]map[string]string, minSample int64) string {
+	best := creativeIDs[0]
+	bestRate := -1.0
+	for _, creativeID := range creativeIDs {
+		rate := 1.0
+		impressions, _ := strconv.ParseInt(performance[creativeID]["impressions"], 10, 64)
+		if impressions > 0 && impressions >= minSample {
+			completions, _ := strconv.ParseInt(performance[creativeID]["completions"], 10, 64)
+			rate = float64(completions) / float64(impressions)
+		}
+		if rate > bestRate {
+			best, bestRate = creativeID, rate
+		}
+	}
+	return best
+}
+
+// sequentialPick returns the creative at position sequence (1-based) in
+// sorted ID order, wrapping around
+func sequentialPick(creativeIDs []string, sequence int64) string {
+	sorted := append([]string(nil), creativeIDs...)
+	sort.Strings(sorted)
+	index := (sequence - 1) % int64(len(sorted))
+	if index < 0 {
+		index += int64(len(sorted))
+	}
+	return sorted[index]
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+)
+
+// defaultRampUpMinFraction is the selection weight a campaign starts at when
+// ramp-up is enabled
+const defaultRampUpMinFraction = 0.1
+
+// defaultFreshnessBoost is the weight multiplier a brand new creative gets
+// when the freshness boost is enabled
+const defaultFreshnessBoost = 3.0
+
+// weightScale converts fractional weights (ramp-up, freshness) to integer
+// weight units, so cumulative sums and draws are exact integer arithmetic
+// with no float drift however many campaigns are summed
+const weightScale = 1_000_000
+
+// maxCreativeWeight is the largest weight a creative may be given. Scaled to
+// weight units it leaves room to sum millions of creatives in an int64.
+const maxCreativeWeight = 1_000_000
+
+// weightUnits converts a fractional weight to integer units. Negative
+// weights become 0 and weights too large for an int64 saturate.
+func weightUnits(w float64) int64 {
+	if w <= 0 || math.IsNaN(w) {
+		return 0
+	}
+	units := math.Round(w * weightScale)
+	if units >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(units)
+}
+
+// addWeights adds two non-negative weights, saturating at math.MaxInt64
+// rather than overflowing
+func addWeights(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// budgetWeight is a campaign's selection weight: its remaining budget in
+// cents scaled by its ramp-up factor, in integer arithmetic. Campaigns with
+// any budget left keep a weight of at least 1 so they stay drawable, and
+// budgets too large for an int64 saturate.
+func budgetWeight(remaining, rampFactor float64) int64 {
+	rounded := math.Round(remaining * 100)
+	if !(rounded > 0) {
+		return 0
+	}
+	cents := int64(math.MaxInt64)
+	if rounded < math.MaxInt64 {
+		cents = int64(rounded)
+	}
+	weight := scaleWeight(cents, weightUnits(rampFactor))
+	if weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// scaleWeight returns weight * units / weightScale for non-negative inputs,
+// computing the product in 128 bits and saturating at math.MaxInt64 rather
+// than overflowing
+func scaleWeight(weight, units int64) int64 {
+	hi, lo := bits.Mul64(uint64(weight), uint64(units))
+	if hi >= weightScale {
+		return math.MaxInt64
+	}
+	q, _ := bits.Div64(hi, lo, weightScale)
+	if q > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(q)
+}
+
+// totalWeight sums the positive weights, saturating at math.MaxInt64
+func totalWeight(weights []int64) int64 {
+	var total int64
+	for _, w := range weights {
+		if w > 0 {
+			total = addWeights(total, w)
+		}
+	}
+	return total
+}
+
+// weightedPick returns the index chosen by a draw in [0, totalWeight).
+// Non-positive weights are never chosen unless every weight is non-positive,
+// in which case the first index is returned.
+func weightedPick(weights []int64, draw int64) int {
+	if totalWeight(weights) <= 0 {
+		return 0
+	}
+
+	var cumulative int64
+	last := 0
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		cumulative = addWeights(cumulative, w)
+		if draw < cumulative {
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+// drawWeighted picks an index with probability proportional to its weight
+func drawWeighted(rng *lockedRand, weights []int64) int {
+	total := totalWeight(weights)
+	if total <= 0 {
+		return 0
+	}
+	return weightedPick(weights, rng.Int63n(total))
+}
+
+// selectionShare returns weights[i] as a fraction of the total weight
+func selectionShare(weights []int64, i int) float64 {
+	total := totalWeight(weights)
+	if total == 0 {
+		return 1
+	}
+	return float64(weights[i]) / float64(total)
+}
+
+// rampUpFactor scales a campaign's selection weight linearly from minFraction
+// at launch to 1 once window has elapsed since its start. A zero window
+// disables ramp-up.
+func rampUpFactor(sinceStart, window time.Duration, minFraction float64) float64 {
+	if window <= 0 || sinceStart >= window {
+		return 1
+	}
+	if sinceStart < 0 {
+		return minFraction
+	}
+	return minFraction + (1-minFraction)*float64(sinceStart)/float64(window)
+}
+
+// creativeWeight combines a creative's base weight (default 1) with its
+// freshness boost
+func (s *AdService) creativeWeight(creative map[string]string, now time.Time) float64 {
+	weight := 1.0
+	if w, err := strconv.ParseFloat(creative["weight"], 64); err == nil && w >= 0 {
+		weight = w
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, creative["created_at"])
+	if err != nil {
+		return weight
+	}
+	return weight * freshnessFactor(now.Sub(createdAt), s.freshnessWindow, s.freshnessBoost)
+}
+
+// freshnessFactor is boost for a brand new creative, decaying linearly to 1
+// once window has elapsed since creation. A zero window disables the boost.
+func freshnessFactor(age, window time.Duration, boost float64) float64 {
+	if window <= 0 || age >= window || boost <= 1 {
+		return 1
+	}
+	if age < 0 {
+		return boost
+	}
+	return boost - (boost-1)*float64(age)/float64(window)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// competitiveCategory returns a campaign's normalized competitive_category,
+// or "" when it has none
+func competitiveCategory(campaign map[string]string) string {
+	return strings.ToLower(strings.TrimSpace(campaign["competitive_category"]))
+}
+
+// removeCategory drops every campaign in a competitive category from the
+// set, once the category has filled a pod slot
+func (e *eligibleSet) removeCategory(category string) {
+	if category == "" {
+		return
+	}
+	for _, id := range append([]string(nil), e.ids...) {
+		if competitiveCategory(e.campaigns[id]) == category {
+			e.remove(id)
+		}
+	}
+}
+
+// recentCategories returns the competitive categories served to a device
+// within the separation window, mapped to the campaign that served each ("" if
+// unknown). Lookup failures are logged and separation skipped, so a Redis
+// hiccup doesn't cause no-fills.
+func (s *AdService) recentCategories(ctx context.Context, deviceID string, now time.Time) map[string]string {
+	if s.competitiveSeparationWindow <= 0 || deviceID == "" {
+		return nil
+	}
+	served, err := s.redis.GetDeviceCategories(ctx, deviceID)
+	if err != nil {
+		log.Printf("Failed to get competitive categories for device %s: %v", deviceID, err)
+		return nil
+	}
+	recent := make(map[string]string, len(served))
+	for category, serve := range served {
+		if now.Sub(serve.At) < s.competitiveSeparationWindow {
+			recent[category] = serve.CampaignID
+		}
+	}
+	return recent
+}
+
+// separatedFrom reports whether a campaign is kept out by a competitor's
+// recent serve of its category. The campaign that served it may repeat.
+func separatedFrom(recent map[string]string, campaignID string, campaign map[string]string) bool {
+	servedBy, ok := recent[competitiveCategory(campaign)]
+	return ok && servedBy != campaignID
+}
+
+// recordCategory notes that a campaign served a device its competitive
+// category, for session separation
+func (s *AdService) recordCategory(ctx context.Context, deviceID, campaignID, category string, now time.Time) {
+	if s.competitiveSeparationWindow <= 0 || deviceID == "" || category == "" {
+		return
+	}
+	if err := s.redis.MarkDeviceCategory(ctx, deviceID, category, campaignID, now, s.competitiveSeparationWindow); err != nil {
+		log.Printf("Failed to record competitive category for device %s: %v", deviceID, err)
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultSpendFlushThreshold is how many buffered impressions trigger an
+// early flush when spend buffering is enabled
+const defaultSpendFlushThreshold = 100
+
+// charge is one impression's spend against a campaign, in its stored budget
+// unit. scale is the campaign's budget units per currency unit when charged.
+type charge struct {
+	adID   string
+	amount float64
+	scale  float64
+}
+
+// inCurrency converts an amount in the charge's budget unit into currency
+// units, for ledger events and make-good values; a zero scale means 1
+func (c charge) inCurrency(amount float64) float64 {
+	if c.scale <= 0 {
+		return amount
+	}
+	return amount / c.scale
+}
+
+// spendBuffer accumulates per-campaign impression charges between flushes
+type spendBuffer struct {
+	mu      sync.Mutex
+	pending map[string][]charge
+	count   int
+}
+
+func newSpendBuffer() *spendBuffer {
+	return &spendBuffer{pending: make(map[string][]charge)}
+}
+
+// add buffers charges and returns the number of buffered impressions
+func (b *spendBuffer) add(campaignID string, charges ...charge) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[campaignID] = append(b.pending[campaignID], charges...)
+	b.count += len(charges)
+	return b.count
+}
+
+// get returns the unflushed spend for a campaign
+func (b *spendBuffer) get(campaignID string) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sumCharges(b.pending[campaignID])
+}
+
+// drain empties the buffer and returns what it held
+func (b *spendBuffer) drain() map[string][]charge {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := b.pending
+	b.pending = make(map[string][]charge)
+	b.count = 0
+	return pending
+}
+
+// sumCharges totals the charges' amounts
+func sumCharges(charges []charge) float64 {
+	var total float64
+	for _, c := range charges {
+		total += c.amount
+	}
+	return total
+}
+
+// chargeImpression charges one impression at the creative's CPM override or
+// else the campaign's CPM, converted from the base currency into the
+// campaign's currency and budget unit. It reports whether the impression was
+// diverted to the make-good bucket instead; buffered spend is only checked
+// against the budget when it is flushed, so it is never reported. A nil
+// creative is charged at the campaign rate.
+func (s *AdService) chargeImpression(ctx context.Context, campaignID, adID string, campaign, creative map[string]string) (bool, error) {
+	cpm := impressionCPM(campaign, creative)
+	if cpm <= 0 {
+		return false, nil
+	}
+
+	cost, ok := s.fromBase(cpm/1000, campaign["currency"])
+	if !ok {
+		log.Printf("No FX rate for campaign %s currency %s, charging unconverted", campaignID, campaign["currency"])
+	}
+
+	// Spend is kept in the campaign's budget unit, like its budget
+	amount := s.toBudgetUnits(cost, campaign)
+	return s.recordSpend(ctx, campaignID, charge{adID: adID, amount: amount, scale: s.budgetScale(campaign)})
+}
+
+// impressionCPM returns the rate an impression is charged at: the
+// creative's cpm override when it has one, so premium creatives deplete the
+// budget faster, otherwise the campaign's cpm
+func impressionCPM(campaign, creative map[string]string) float64 {
+	if cpm, err := strconv.ParseFloat(creative["cpm"], 64); err == nil && cpm > 0 {
+		return cpm
+	}
+	cpm, _ := strconv.ParseFloat(campaign["cpm"], 64)
+	return cpm
+}
+
+// recordSpend writes spend straight to Redis, or buffers it when a flush
+// interval is configured. It reports whether a write-through charge was
+// diverted to the make-good bucket.
+func (s *AdService) recordSpend(ctx context.Context, campaignID string, c charge) (bool, error) {
+	if s.spendFlushInterval <= 0 {
+		return s.applyCharges(ctx, campaignID, []charge{c})
+	}
+
+	if s.spendBuffer.add(campaignID, c) >= s.spendFlushThreshold {
+		return false, s.FlushSpend(ctx)
+	}
+	return false, nil
+}
+
+// applyCharges atomically decrements the campaign's budget by the charges'
+// total and emits a ledger event per charge. Spend beyond the budget is not
+// charged; with a make-good bucket configured it is recorded there instead,
+// and applyCharges reports that it was.
+func (s *AdService) applyCharges(ctx context.Context, campaignID string, charges []charge) (bool, error) {
+	total := sumCharges(charges)
+	newSpent, charged, err := s.redis.SpendBudget(ctx, campaignID, total)
+	if err != nil {
+		return false, err
+	}
+
+	var diverted bool
+	if charged < total {
+		capped := capCharges(charges, charged)
+		if s.makeGoodBucket != "" {
+			diverted = s.divertUncovered(ctx, campaignID, charges, capped)
+		} else {
+			log.Printf("Campaign %s budget exhausted: charged %.4f of %.4f", campaignID, charged, total)
+		}
+		charges = capped
+	}
+	s.emitLedger(campaignID, charges, newSpent)
+	return diverted, nil
+}
+
+// divertUncovered records the part of each charge the budget didn't cover
+// (capped holds the covered parts, in order) in the make-good bucket, in
+// currency units like the ledger. The
+// budget is already charged, so failures are logged rather than returned,
+// which would re-buffer and double-charge the batch.
+func (s *AdService) divertUncovered(ctx context.Context, campaignID string, charges, capped []charge) bool {
+	var diverted bool
+	for i, c := range charges {
+		uncovered := c.amount
+		if i < len(capped) {
+			uncovered -= capped[i].amount
+		}
+		if uncovered <= 1e-9 {
+			continue
+		}
+		if err := s.recordMakeGood(ctx, campaignID, c.adID, c.inCurrency(uncovered)); err != nil {
+			log.Printf("Failed to record make-good for campaign %s ad %s: %v", campaignID, c.adID, err)
+			continue
+		}
+		diverted = true
+	}
+	return diverted
+}
+
+// capCharges trims charges, in order, to those covered by limit; the charge
+// that crosses it is reduced to the remainder and later ones are dropped
+func capCharges(charges []charge, limit float64) []charge {
+	capped := make([]charge, 0, len(charges))
+	for _, c := range charges {
+		if limit <= 0 {
+			break
+		}
+		c.amount = math.Min(c.amount, limit)
+		limit -= c.amount
+		capped = append(capped, c)
+	}
+	return capped
+}
+
+// pendingSpend returns spend buffered but not yet written to Redis, so
+// eligibility checks still see it
+func (s *AdService) pendingSpend(campaignID string) float64 {
+	if s.spendBuffer == nil {
+		return 0
+	}
+	return s.spendBuffer.get(campaignID)
+}
+
+// FlushSpend writes all buffered spend to Redis. Spend that fails to write
+// is put back in the buffer for the next flush.
+func (s *AdService) FlushSpend(ctx context.Context) error {
+	var firstErr error
+	for campaignID, charges := range s.spendBuffer.drain() {
+		if _, err := s.applyCharges(ctx, campaignID, charges); err != nil {
+			s.spendBuffer.add(campaignID, charges...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// StartSpendFlusher flushes buffered spend every spendFlushInterval until ctx
+// is done. Callers must call FlushSpend on shutdown to drain the remainder.
+func (s *AdService) StartSpendFlusher(ctx context.Context) {
+	if s.spendFlushInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.spendFlushInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.FlushSpend(ctx); err != nil {
+					log.Printf("Failed to flush campaign spend: %v", err)
+				}
+			}
+		}
+	}()
+}
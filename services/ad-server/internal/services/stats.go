@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// statsWindowHours is how far back the stats endpoints sum hourly counters.
+// The counters expire after 25 hours, so this is all that is kept.
+const statsWindowHours = 24
+
+// GetCampaignStats sums a campaign's hourly request counters over the last
+// day
+func (s *AdService) GetCampaignStats(ctx context.Context, campaignID string) (*models.CampaignStats, error) {
+	if _, err := s.redis.GetCampaign(ctx, campaignID); err != nil {
+		return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+
+	requests, err := s.redis.GetCampaignRequestCount(ctx, campaignID, statsWindowHours, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &models.CampaignStats{
+		CampaignID:  campaignID,
+		WindowHours: statsWindowHours,
+		Requests:    requests,
+	}, nil
+}
+
+// GetCreativeStats sums a creative's hourly impression and click counters
+// over the last day
+func (s *AdService) GetCreativeStats(ctx context.Context, creativeID string) (*models.CreativeStats, error) {
+	creative, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch creative: %w", err)
+	}
+
+	now := time.Now()
+	impressions, err := s.redis.GetCreativeImpressionCount(ctx, creativeID, statsWindowHours, now)
+	if err != nil {
+		return nil, err
+	}
+	clicks, err := s.redis.GetCreativeClickCount(ctx, creativeID, statsWindowHours, now)
+	if err != nil {
+		return nil, err
+	}
+	return &models.CreativeStats{
+		CreativeID:  creativeID,
+		CampaignID:  creative["campaign_id"],
+		WindowHours: statsWindowHours,
+		Impressions: impressions,
+		Clicks:      clicks,
+	}, nil
+}
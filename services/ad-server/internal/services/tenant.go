@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fanwu/ad-server/internal/redis"
+)
+
+// AuthorizeCampaign checks that tenantID owns the campaign via its
+// tenant_id field. Campaigns belonging to another tenant are reported as not
+// found so their existence isn't leaked. An empty tenantID is the operator,
+// who may access every campaign.
+func (s *AdService) AuthorizeCampaign(ctx context.Context, tenantID, campaignID string) error {
+	if tenantID == "" {
+		return nil
+	}
+
+	campaign, err := s.redis.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return err
+	}
+	if campaign["tenant_id"] != tenantID {
+		return fmt.Errorf("campaign %w: %s", redis.ErrNotFound, campaignID)
+	}
+	return nil
+}
+
+// AuthorizeCreative checks that tenantID owns the campaign the creative
+// belongs to
+func (s *AdService) AuthorizeCreative(ctx context.Context, tenantID, creativeID string) error {
+	if tenantID == "" {
+		return nil
+	}
+
+	creative, err := s.redis.GetCreative(ctx, creativeID)
+	if err != nil {
+		return err
+	}
+	if err := s.AuthorizeCampaign(ctx, tenantID, creative["campaign_id"]); err != nil {
+		return fmt.Errorf("creative %w: %s", redis.ErrNotFound, creativeID)
+	}
+	return nil
+}
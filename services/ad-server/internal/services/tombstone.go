@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// defaultTombstoneRetentionHours is how long soft-deleted campaigns keep their
+// data before the reaper removes it
+const defaultTombstoneRetentionHours = 168
+
+// DeleteCampaign soft-deletes a campaign: it stops serving immediately but its
+// hash and counters are kept until the tombstone retention window ends
+func (s *AdService) DeleteCampaign(ctx context.Context, campaignID string) error {
+	return s.redis.SoftDeleteCampaign(ctx, campaignID, s.tombstoneRetention)
+}
+
+// ReapTombstones purges soft-deleted campaigns past their retention window
+func (s *AdService) ReapTombstones(ctx context.Context) ([]string, error) {
+	return s.redis.ReapTombstones(ctx, time.Now())
+}
+
+// StartTombstoneReaper runs ReapTombstones every interval until ctx is done
+func (s *AdService) StartTombstoneReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reaped, err := s.ReapTombstones(ctx)
+				if err != nil {
+					log.Printf("Failed to reap campaign tombstones: %v", err)
+					continue
+				}
+				if len(reaped) > 0 {
+					log.Printf("Reaped %d deleted campaigns", len(reaped))
+				}
+			}
+		}
+	}()
+}
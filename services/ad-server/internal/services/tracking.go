@@ -0,0 +1,20 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultTrackingURL is where clients POST impressions when a creative has
+// no tracking_base of its own
+const defaultTrackingURL = "/api/v1/impression"
+
+// expandTrackingMacros replaces {name} macros in a tracking URL template with
+// URL-escaped values. Unknown macros are left untouched.
+func expandTrackingMacros(template string, macros map[string]string) string {
+	pairs := make([]string, 0, len(macros)*2)
+	for name, value := range macros {
+		pairs = append(pairs, "{"+name+"}", url.QueryEscape(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
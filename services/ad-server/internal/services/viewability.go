@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// defaultMinViewabilitySample is the number of measured impressions a
+// placement needs before its viewability rate is trusted for exclusion
+const defaultMinViewabilitySample = 100
+
+// placementViewability returns the request placement's viewable rate, and
+// false when it is unknown: no placement, too few measured impressions, or a
+// failed lookup (logged). Unknown placements are never excluded.
+func (s *AdService) placementViewability(ctx context.Context, req *models.AdRequest) (float64, bool) {
+	placement := models.Placement(req.AppID, req.PlacementID)
+	if placement == "" {
+		return 0, false
+	}
+	measured, viewable, err := s.redis.GetPlacementViewability(ctx, placement)
+	if err != nil {
+		log.Printf("Failed to get viewability for placement %s: %v", placement, err)
+		return 0, false
+	}
+	if measured == 0 || measured < s.minViewabilitySample {
+		return 0, false
+	}
+	return float64(viewable) / float64(measured), true
+}
+
+// meetsViewability reports whether the request placement's viewable rate,
+// from placementViewability, satisfies the campaign's min_viewability (a
+// fraction, e.g. 0.7). Campaigns without one, and placements with unknown
+// viewability, always pass.
+func meetsViewability(campaign map[string]string, placementViewability func() (float64, bool)) bool {
+	minViewability, err := strconv.ParseFloat(campaign["min_viewability"], 64)
+	if err != nil || minViewability <= 0 {
+		return true
+	}
+	rate, known := placementViewability()
+	return !known || rate >= minViewability
+}
+
+// recordViewability adds a measured impression to its placement's viewable
+// rate. Impressions without a measurement or a placement are skipped.
+func (s *AdService) recordViewability(ctx context.Context, req *models.ImpressionRequest) {
+	placement := models.Placement(req.AppID, req.PlacementID)
+	if req.Viewable == nil || placement == "" {
+		return
+	}
+	if err := s.redis.RecordPlacementViewability(ctx, placement, *req.Viewable); err != nil {
+		log.Printf("Failed to record viewability for placement %s: %v", placement, err)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Impression side-effect pool defaults
+const (
+	defaultImpressionWorkers   = 16
+	defaultImpressionQueueSize = 1000
+)
+
+// Queue-full policies for the impression pool
+const (
+	QueuePolicyDrop  = "drop"
+	QueuePolicyBlock = "block"
+)
+
+// queuePolicy normalizes a configured queue-full policy, defaulting to drop
+// so a traffic spike can't stall impression requests
+func queuePolicy(value string) string {
+	if strings.EqualFold(value, QueuePolicyBlock) {
+		return QueuePolicyBlock
+	}
+	return QueuePolicyDrop
+}
+
+// workerPool runs jobs on a fixed number of goroutines fed by a bounded
+// queue, so bursts can't spawn unbounded goroutines. Workers start on the
+// first submit and run until Close.
+type workerPool struct {
+	size    int
+	jobs    chan func()
+	block   bool
+	start   sync.Once
+	workers sync.WaitGroup
+	dropped atomic.Int64
+
+	// Guards closing jobs against concurrent submits
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newWorkerPool(size, queueSize int, policy string) *workerPool {
+	return &workerPool{
+		size:  size,
+		jobs:  make(chan func(), queueSize),
+		block: queuePolicy(policy) == QueuePolicyBlock,
+	}
+}
+
+// submit queues a job. When the queue is full it waits for space under the
+// block policy, and otherwise drops the job and returns false.
+// Jobs submitted after Close are dropped.
+func (p *workerPool) submit(job func()) bool {
+	p.start.Do(p.startWorkers)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+
+	if p.block {
+		p.jobs <- job
+		return true
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+func (p *workerPool) startWorkers() {
+	p.workers.Add(p.size)
+	for i := 0; i < p.size; i++ {
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish
+func (p *workerPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	p.workers.Wait()
+}
+
+// DrainImpressions finishes the queued impression work (creative counters and
+// gateway forwards) and stops the impression workers; call on shutdown once
+// no more impressions arrive
+func (s *AdService) DrainImpressions() {
+	s.impressionPool.Close()
+}
+
+// DroppedImpressions returns how many impressions had their creative counters
+// and gateway forward dropped because the impression queue was full
+func (s *AdService) DroppedImpressions() int64 {
+	return s.impressionPool.dropped.Load()
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Span is a unit of traced work carrying queryable attributes
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// Tracer starts spans. Adapters for a tracing backend implement this; the
+// default is Noop.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Noop returns a tracer whose spans discard everything
+func Noop() Tracer {
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End()                                       {}
+
+// RecordedSpan is a finished span captured by a Recorder
+type RecordedSpan struct {
+	Name       string
+	Attributes map[string]interface{}
+	Start      time.Time
+	End        time.Time
+}
+
+// Recorder is an in-memory tracer that keeps finished spans, for tests
+type Recorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// NewRecorder returns an empty in-memory tracer
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start begins a span that is recorded when it ends
+func (r *Recorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &recorderSpan{
+		recorder: r,
+		span: RecordedSpan{
+			Name:       name,
+			Attributes: make(map[string]interface{}),
+			Start:      time.Now(),
+		},
+	}
+}
+
+// Spans returns the finished spans in the order they ended
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+type recorderSpan struct {
+	mu       sync.Mutex
+	recorder *Recorder
+	span     RecordedSpan
+}
+
+func (s *recorderSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span.Attributes[key] = value
+}
+
+func (s *recorderSpan) End() {
+	s.mu.Lock()
+	s.span.End = time.Now()
+	span := s.span
+	s.mu.Unlock()
+
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.spans = append(s.recorder.spans, span)
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+
+	_, span := recorder.Start(context.Background(), "ad-request")
+	span.SetAttribute("ad.eligible_count", 3)
+	if len(recorder.Spans()) != 0 {
+		t.Fatal("Expected span to be recorded only when it ends")
+	}
+	span.End()
+
+	spans := recorder.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name != "ad-request" || spans[0].Attributes["ad.eligible_count"] != 3 {
+		t.Errorf("Unexpected span: %+v", spans[0])
+	}
+	if spans[0].End.Before(spans[0].Start) {
+		t.Errorf("Expected end after start, got %+v", spans[0])
+	}
+}
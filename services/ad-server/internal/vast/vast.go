@@ -0,0 +1,179 @@
+package vast
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+// Version is the VAST spec version emitted by the ad server
+const Version = "4.0"
+
+// ContentType is the response content type for VAST documents
+const ContentType = "application/xml; charset=utf-8"
+
+// adSystem identifies this server in <AdSystem>
+const adSystem = "ad-server"
+
+// VAST is the root element of a VAST document
+type VAST struct {
+	XMLName xml.Name `xml:"VAST"`
+	Version string   `xml:"version,attr"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Ads     []Ad     `xml:"Ad,omitempty"`
+}
+
+// Ad is a single <Ad> in a VAST document
+type Ad struct {
+	ID     string  `xml:"id,attr"`
+	InLine *InLine `xml:"InLine"`
+}
+
+// InLine carries everything the player needs to play the ad
+type InLine struct {
+	AdSystem    string       `xml:"AdSystem"`
+	AdTitle     string       `xml:"AdTitle"`
+	AdServingID string       `xml:"AdServingId"`
+	Advertiser  string       `xml:"Advertiser,omitempty"`
+	Impressions []Impression `xml:"Impression"`
+	Creatives   []Creative   `xml:"Creatives>Creative"`
+}
+
+// Impression is an impression tracking URL
+type Impression struct {
+	ID  string `xml:"id,attr,omitempty"`
+	URL string `xml:",cdata"`
+}
+
+// Creative is a linear video or a set of companions
+type Creative struct {
+	ID           string        `xml:"id,attr,omitempty"`
+	Linear       *Linear       `xml:"Linear,omitempty"`
+	CompanionAds *CompanionAds `xml:"CompanionAds,omitempty"`
+}
+
+// Linear is a linear video creative
+type Linear struct {
+	Duration   string      `xml:"Duration"`
+	MediaFiles []MediaFile `xml:"MediaFiles>MediaFile"`
+}
+
+// MediaFile points at the creative's video
+type MediaFile struct {
+	Delivery string `xml:"delivery,attr"`
+	Type     string `xml:"type,attr"`
+	Width    int    `xml:"width,attr"`
+	Height   int    `xml:"height,attr"`
+	URL      string `xml:",cdata"`
+}
+
+// CompanionAds wraps a creative's companions
+type CompanionAds struct {
+	Companions []Companion `xml:"Companion"`
+}
+
+// Companion is a static companion, used for the poster frame
+type Companion struct {
+	StaticResource StaticResource `xml:"StaticResource"`
+}
+
+// StaticResource is an image resource
+type StaticResource struct {
+	CreativeType string `xml:"creativeType,attr"`
+	URL          string `xml:",cdata"`
+}
+
+// Empty returns a VAST document with no <Ad>, which the spec defines as the
+// way to signal a no-fill without triggering player errors
+func Empty() *VAST {
+	return &VAST{
+		Version: Version,
+		XMLNS:   "http://www.iab.com/VAST",
+	}
+}
+
+// FromAdResponse builds an inline VAST document for a selected ad. The poster
+// frame, when present, is included as a companion image.
+func FromAdResponse(resp *models.AdResponse) *VAST {
+	creatives := []Creative{{
+		ID: resp.CreativeID,
+		Linear: &Linear{
+			Duration: formatDuration(resp.Duration),
+			MediaFiles: []MediaFile{{
+				Delivery: "progressive",
+				Type:     "video/" + strings.ToLower(resp.Format),
+				Width:    1920,
+				Height:   1080,
+				URL:      resp.VideoURL,
+			}},
+		},
+	}}
+
+	if resp.PosterURL != "" {
+		creatives = append(creatives, Creative{
+			ID: resp.CreativeID + "-poster",
+			CompanionAds: &CompanionAds{
+				Companions: []Companion{{
+					StaticResource: StaticResource{
+						CreativeType: imageType(resp.PosterURL),
+						URL:          resp.PosterURL,
+					},
+				}},
+			},
+		})
+	}
+
+	// Creatives without a title fall back to the campaign ID
+	title := resp.Title
+	if title == "" {
+		title = resp.CampaignID
+	}
+
+	doc := Empty()
+	doc.Ads = []Ad{{
+		ID: resp.AdID,
+		InLine: &InLine{
+			AdSystem:    adSystem,
+			AdTitle:     title,
+			AdServingID: resp.AdID,
+			Advertiser:  resp.Advertiser,
+			Impressions: []Impression{{URL: resp.TrackingURL}},
+			Creatives:   creatives,
+		},
+	}}
+	return doc
+}
+
+// formatDuration renders seconds as the VAST HH:MM:SS duration
+func formatDuration(seconds int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// imageType maps an image URL's extension to its MIME type
+func imageType(rawURL string) string {
+	ext := strings.ToLower(path.Ext(strings.SplitN(rawURL, "?", 2)[0]))
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/*"
+	}
+}
+
+// Marshal renders a VAST document including the XML declaration
+func Marshal(v *VAST) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,172 @@
+package vast
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/fanwu/ad-server/internal/models"
+)
+
+func TestMarshal_Empty(t *testing.T) {
+	body, err := Marshal(Empty())
+	if err != nil {
+		t.Fatalf("Failed to marshal VAST: %v", err)
+	}
+
+	if !strings.HasPrefix(string(body), "<?xml") {
+		t.Errorf("Expected XML declaration, got: %s", body)
+	}
+
+	if strings.Contains(string(body), "<Ad") {
+		t.Errorf("Empty VAST should not contain an <Ad>, got: %s", body)
+	}
+
+	var doc VAST
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v", err)
+	}
+
+	if doc.Version != Version {
+		t.Errorf("Expected version %s, got %s", Version, doc.Version)
+	}
+}
+
+func TestFromAdResponse(t *testing.T) {
+	resp := &models.AdResponse{
+		AdID:        "ad-1",
+		CampaignID:  "campaign-1",
+		CreativeID:  "creative-1",
+		VideoURL:    "https://cdn.example.com/ad.mp4",
+		Duration:    95,
+		Format:      "mp4",
+		TrackingURL: "https://ads.example.com/api/v1/impression?ad_id=ad-1",
+	}
+
+	body, err := Marshal(FromAdResponse(resp))
+	if err != nil {
+		t.Fatalf("Failed to marshal VAST: %v", err)
+	}
+
+	// Walk the raw structure rather than the package's own types
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Ads     []struct {
+			ID         string `xml:"id,attr"`
+			Impression string `xml:"InLine>Impression"`
+			Creatives  []struct {
+				Duration  string `xml:"Linear>Duration"`
+				MediaFile struct {
+					Type string `xml:"type,attr"`
+					URL  string `xml:",chardata"`
+				} `xml:"Linear>MediaFiles>MediaFile"`
+			} `xml:"InLine>Creatives>Creative"`
+		} `xml:"Ad"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v", err)
+	}
+
+	if doc.Version != "4.0" {
+		t.Errorf("Expected VAST 4.0, got %s", doc.Version)
+	}
+	if len(doc.Ads) != 1 || len(doc.Ads[0].Creatives) != 1 {
+		t.Fatalf("Expected one ad with one creative, got: %s", body)
+	}
+
+	ad := doc.Ads[0]
+	if ad.ID != resp.AdID {
+		t.Errorf("Expected ad id %s, got %s", resp.AdID, ad.ID)
+	}
+	if ad.Impression != resp.TrackingURL {
+		t.Errorf("Expected impression %s, got %s", resp.TrackingURL, ad.Impression)
+	}
+	if got := ad.Creatives[0].Duration; got != "00:01:35" {
+		t.Errorf("Expected duration 00:01:35, got %s", got)
+	}
+	if got := ad.Creatives[0].MediaFile; got.URL != resp.VideoURL || got.Type != "video/mp4" {
+		t.Errorf("Expected video/mp4 media file %s, got %s %s", resp.VideoURL, got.Type, got.URL)
+	}
+}
+
+func TestFromAdResponse_Metadata(t *testing.T) {
+	resp := &models.AdResponse{
+		AdID:        "ad-1",
+		CampaignID:  "campaign-1",
+		VideoURL:    "https://cdn.example.com/ad.mp4",
+		Duration:    30,
+		Format:      "mp4",
+		TrackingURL: "/api/v1/impression",
+	}
+
+	// Without metadata the title is the campaign ID and there's no advertiser
+	inline := FromAdResponse(resp).Ads[0].InLine
+	if inline.AdTitle != "campaign-1" || inline.Advertiser != "" {
+		t.Errorf("Expected campaign ID title and no advertiser, got %q %q", inline.AdTitle, inline.Advertiser)
+	}
+
+	resp.Title = "Spring Sale"
+	resp.Advertiser = "Acme"
+	body, err := Marshal(FromAdResponse(resp))
+	if err != nil {
+		t.Fatalf("Failed to marshal VAST: %v", err)
+	}
+	var doc struct {
+		Title      string `xml:"Ad>InLine>AdTitle"`
+		Advertiser string `xml:"Ad>InLine>Advertiser"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v", err)
+	}
+	if doc.Title != "Spring Sale" || doc.Advertiser != "Acme" {
+		t.Errorf("Expected title and advertiser, got: %s", body)
+	}
+}
+
+func TestFromAdResponse_Poster(t *testing.T) {
+	resp := &models.AdResponse{
+		AdID:        "ad-1",
+		CampaignID:  "campaign-1",
+		CreativeID:  "creative-1",
+		VideoURL:    "https://cdn.example.com/ad.mp4",
+		Duration:    30,
+		Format:      "mp4",
+		TrackingURL: "/api/v1/impression",
+		PosterURL:   "https://cdn.example.com/poster.jpg",
+	}
+
+	body, err := Marshal(FromAdResponse(resp))
+	if err != nil {
+		t.Fatalf("Failed to marshal VAST: %v", err)
+	}
+
+	var doc VAST
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Failed to parse VAST: %v", err)
+	}
+	if len(doc.Ads) != 1 || len(doc.Ads[0].InLine.Creatives) != 2 {
+		t.Fatalf("Expected one ad with linear and companion creatives, got: %s", body)
+	}
+
+	companionAds := doc.Ads[0].InLine.Creatives[1].CompanionAds
+	if companionAds == nil || len(companionAds.Companions) != 1 {
+		t.Fatalf("Expected one poster companion, got: %s", body)
+	}
+	companion := companionAds.Companions
+	if len(companion) != 1 || companion[0].StaticResource.URL != resp.PosterURL {
+		t.Errorf("Expected poster companion %s, got: %s", resp.PosterURL, body)
+	}
+	if companion[0].StaticResource.CreativeType != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", companion[0].StaticResource.CreativeType)
+	}
+
+	// Omitted when absent
+	resp.PosterURL = ""
+	body, err = Marshal(FromAdResponse(resp))
+	if err != nil {
+		t.Fatalf("Failed to marshal VAST: %v", err)
+	}
+	if strings.Contains(string(body), "CompanionAds") {
+		t.Errorf("Expected no companion without a poster, got: %s", body)
+	}
+}